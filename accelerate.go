@@ -0,0 +1,57 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.uber.org/zap"
+)
+
+// With use_accelerate, object requests to the bucket go through S3 Transfer Acceleration
+// (<bucket>.s3-accelerate.amazonaws.com), which routes them over the AWS edge network, e.g. for
+// instances far from the bucket's region. The clients of fallback regions and of the replica
+// keep using the regional endpoints.
+
+// validateAccelerate checks that use_accelerate is used with a bucket that can be accelerated.
+func (s *S3Storage) validateAccelerate() error {
+	if !s.UseAccelerate {
+		return nil
+	}
+	switch {
+	case s.Endpoint != "" || s.isGCS():
+		return errors.New("use_accelerate cannot be used with a custom endpoint")
+	case isMultiRegionAccessPoint(s.Bucket):
+		return errors.New("use_accelerate is not supported by multi-region access points")
+	case s.UseFIPSEndpoint:
+		return errors.New("use_accelerate cannot be used with use_fips_endpoint")
+	case strings.Contains(s.Bucket, "."):
+		return fmt.Errorf("use_accelerate: bucket name %s contains dots, which Transfer Acceleration doesn't support", s.Bucket)
+	}
+	return nil
+}
+
+// accelerateClientOptions makes the client use the accelerate endpoint.
+func (s *S3Storage) accelerateClientOptions(o *awss3.Options) {
+	o.UseAccelerate = s.UseAccelerate
+}
+
+// checkAccelerate ensures that Transfer Acceleration is enabled on the bucket, which requests to
+// the accelerate endpoint otherwise fail.
+func (s *S3Storage) checkAccelerate(ctx context.Context) error {
+	ctx, cancel := s.opContext(ctx)
+	defer cancel()
+	out, err := s.Client.GetBucketAccelerateConfiguration(ctx, &awss3.GetBucketAccelerateConfigurationInput{Bucket: aws.String(s.Bucket)})
+	if err != nil {
+		return fmt.Errorf("use_accelerate: reading accelerate configuration of bucket %s: %w", s.Bucket, s3Error(err))
+	}
+	if out.Status != types.BucketAccelerateStatusEnabled {
+		return fmt.Errorf("use_accelerate: transfer acceleration is not enabled on bucket %s", s.Bucket)
+	}
+	s.logger.Info("using S3 Transfer Acceleration", zap.Bool("dualstack", s.UseDualStackEndpoint))
+	return nil
+}
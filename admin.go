@@ -0,0 +1,355 @@
+package s3
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(adminAPI{})
+}
+
+// adminAPI exposes maintenance endpoints of the S3 storage on Caddy's admin API:
+//
+//	GET    /storage/s3/locks[?key=<key>]              list lock objects with age, content and owner, or the
+//	                                                  lock of one key
+//	DELETE /storage/s3/locks?key=<key>[&storage=<id>] force-release the lock of a CertMagic key
+//	GET    /storage/s3/locks/gc                       lock janitor statistics (runs, reaped locks, errors)
+//	GET    /storage/s3/locks/stats                    lock contention (waits, takeovers, timeouts)
+//	POST   /storage/s3/locks/gc[?storage=<id>]        delete expired lock objects now
+//	GET    /storage/s3/cache                          read cache statistics (hits, misses, evictions)
+//	GET    /storage/s3/requests                       S3 requests sent since provisioning, by operation, and queueing
+//	GET    /storage/s3/versions?key=<key>             versions of a value in a versioned bucket
+//	POST   /storage/s3/versions?key=<key>&version=<id> restore a version as the current value
+//	GET    /storage/s3/certificates[?expiring_within=<duration>] stored certificates, soonest expiring first
+//	GET    /storage/s3/usage[?max_age=<duration>]     object counts and bytes by subtree (acme, certificates, ocsp);
+//	                                                  a result younger than max_age is served from memory
+//
+// The storage parameter (bucket/prefix) is only needed when several S3 storages are active.
+type adminAPI struct{}
+
+// CaddyModule returns the Caddy module information.
+func (adminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.storage_s3",
+		New: func() caddy.Module { return new(adminAPI) },
+	}
+}
+
+// Routes returns the admin routes of the S3 storage.
+func (a adminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{Pattern: "/storage/s3/locks", Handler: caddy.AdminHandlerFunc(a.handleLocks)},
+		{Pattern: "/storage/s3/locks/gc", Handler: caddy.AdminHandlerFunc(a.handleLockGC)},
+		{Pattern: "/storage/s3/locks/stats", Handler: caddy.AdminHandlerFunc(a.handleLockStats)},
+		{Pattern: "/storage/s3/cache", Handler: caddy.AdminHandlerFunc(a.handleCache)},
+		{Pattern: "/storage/s3/requests", Handler: caddy.AdminHandlerFunc(a.handleRequests)},
+		{Pattern: "/storage/s3/versions", Handler: caddy.AdminHandlerFunc(a.handleVersions)},
+		{Pattern: "/storage/s3/certificates", Handler: caddy.AdminHandlerFunc(a.handleCertificates)},
+		{Pattern: "/storage/s3/usage", Handler: caddy.AdminHandlerFunc(a.handleUsage)},
+	}
+}
+
+// adminLocks is the response of GET /storage/s3/locks for one storage.
+type adminLocks struct {
+	Storage string     `json:"storage"`
+	Locks   []LockInfo `json:"locks"`
+}
+
+func (a adminAPI) handleLocks(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet:
+		if key := r.URL.Query().Get("key"); key != "" {
+			return a.handleLockInfo(w, r, key)
+		}
+		var resp []adminLocks
+		for _, s := range activeInstances() {
+			locks, err := s.ListLocks(r.Context())
+			if err != nil {
+				return caddy.APIError{HTTPStatus: http.StatusBadGateway, Err: err}
+			}
+			if locks == nil {
+				locks = []LockInfo{}
+			}
+			resp = append(resp, adminLocks{Storage: s.instanceID(), Locks: locks})
+		}
+		sort.Slice(resp, func(i, j int) bool { return resp[i].Storage < resp[j].Storage })
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(resp)
+
+	case http.MethodDelete:
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: errors.New("key parameter is required")}
+		}
+		s, err := findInstance(r.URL.Query().Get("storage"))
+		if err != nil {
+			return err
+		}
+		if s.ReadOnly {
+			return caddy.APIError{HTTPStatus: http.StatusForbidden, Err: ErrReadOnly}
+		}
+		if err := s.Unlock(r.Context(), key); err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadGateway, Err: err}
+		}
+		s.logger.Warn("lock force-released via admin API", zap.String("key", key))
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+
+	default:
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method %s not allowed", r.Method)}
+	}
+}
+
+// handleLockInfo responds with the lock of one key.
+func (a adminAPI) handleLockInfo(w http.ResponseWriter, r *http.Request, key string) error {
+	s, err := findInstance(r.URL.Query().Get("storage"))
+	if err != nil {
+		return err
+	}
+	li, err := s.LockInfo(r.Context(), key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return caddy.APIError{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("%s is not locked", key)}
+	}
+	if err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusBadGateway, Err: err}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(li)
+}
+
+// adminLockStats is the response of GET /storage/s3/locks/stats for one storage.
+type adminLockStats struct {
+	Storage string    `json:"storage"`
+	Locks   LockStats `json:"locks"`
+}
+
+func (a adminAPI) handleLockStats(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method %s not allowed", r.Method)}
+	}
+	resp := []adminLockStats{}
+	for _, s := range activeInstances() {
+		resp = append(resp, adminLockStats{Storage: s.instanceID(), Locks: s.LockStats()})
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Storage < resp[j].Storage })
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// adminLockGC is the response of GET /storage/s3/locks/gc for one storage with a lock janitor.
+type adminLockGC struct {
+	Storage string      `json:"storage"`
+	LockGC  LockGCStats `json:"lock_gc"`
+}
+
+func (a adminAPI) handleLockGC(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet:
+		resp := []adminLockGC{}
+		for _, s := range activeInstances() {
+			if stats, ok := s.LockGCStats(); ok {
+				resp = append(resp, adminLockGC{Storage: s.instanceID(), LockGC: stats})
+			}
+		}
+		sort.Slice(resp, func(i, j int) bool { return resp[i].Storage < resp[j].Storage })
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(resp)
+
+	case http.MethodPost:
+		s, err := findInstance(r.URL.Query().Get("storage"))
+		if err != nil {
+			return err
+		}
+		reaped, err := s.ReapExpiredLocks(r.Context())
+		if err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadGateway, Err: err}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(map[string]int{"reaped": reaped})
+
+	default:
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method %s not allowed", r.Method)}
+	}
+}
+
+// adminCache is the response of GET /storage/s3/cache for one storage with a read cache.
+type adminCache struct {
+	Storage string     `json:"storage"`
+	Cache   CacheStats `json:"cache"`
+}
+
+func (a adminAPI) handleCache(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method %s not allowed", r.Method)}
+	}
+	resp := []adminCache{}
+	for _, s := range activeInstances() {
+		if stats, ok := s.CacheStats(); ok {
+			resp = append(resp, adminCache{Storage: s.instanceID(), Cache: stats})
+		}
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Storage < resp[j].Storage })
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// findInstance returns the active storage with the given id, or the only active storage if id is empty.
+func findInstance(id string) (*S3Storage, error) {
+	list := activeInstances()
+	if id == "" {
+		switch len(list) {
+		case 0:
+			return nil, caddy.APIError{HTTPStatus: http.StatusNotFound, Err: errors.New("no s3 storage is active")}
+		case 1:
+			return list[0], nil
+		default:
+			return nil, caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: errors.New("several s3 storages are active; specify the storage parameter")}
+		}
+	}
+	for _, s := range list {
+		if s.instanceID() == id {
+			return s, nil
+		}
+	}
+	return nil, caddy.APIError{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("no active s3 storage '%s'", id)}
+}
+
+// Interface guards
+var (
+	_ caddy.Module      = (*adminAPI)(nil)
+	_ caddy.AdminRouter = (*adminAPI)(nil)
+)
+
+// adminRequests is the response of GET /storage/s3/requests for one storage.
+type adminRequests struct {
+	Storage     string            `json:"storage"`
+	Requests    RequestStats      `json:"requests"`
+	Concurrency *ConcurrencyStats `json:"concurrency,omitempty"` // With max_concurrent_requests
+}
+
+func (a adminAPI) handleRequests(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method %s not allowed", r.Method)}
+	}
+	resp := []adminRequests{}
+	for _, s := range activeInstances() {
+		entry := adminRequests{Storage: s.instanceID(), Requests: s.RequestStats()}
+		if stats, ok := s.ConcurrencyStats(); ok {
+			entry.Concurrency = &stats
+		}
+		resp = append(resp, entry)
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Storage < resp[j].Storage })
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(resp)
+}
+
+func (a adminAPI) handleVersions(w http.ResponseWriter, r *http.Request) error {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: errors.New("key parameter is required")}
+	}
+	s, err := findInstance(r.URL.Query().Get("storage"))
+	if err != nil {
+		return err
+	}
+	switch r.Method {
+	case http.MethodGet:
+		versions, err := s.ListVersions(r.Context(), key)
+		if err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadGateway, Err: err}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(versions)
+
+	case http.MethodPost:
+		version := r.URL.Query().Get("version")
+		if version == "" {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: errors.New("version parameter is required")}
+		}
+		if err := s.Restore(r.Context(), key, version); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return caddy.APIError{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("no version %s of %s", version, key)}
+			}
+			return caddy.APIError{HTTPStatus: http.StatusBadGateway, Err: err}
+		}
+		s.logger.Warn("version restored via admin API", zap.String("key", key), zap.String("version_id", version))
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+
+	default:
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method %s not allowed", r.Method)}
+	}
+}
+
+// adminCertificates is the response of GET /storage/s3/certificates for one storage.
+type adminCertificates struct {
+	Storage      string            `json:"storage"`
+	Certificates []CertificateInfo `json:"certificates"`
+}
+
+func (a adminAPI) handleCertificates(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method %s not allowed", r.Method)}
+	}
+	var within time.Duration
+	if value := r.URL.Query().Get("expiring_within"); value != "" {
+		var err error
+		if within, err = caddy.ParseDuration(value); err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("invalid expiring_within '%s': %v", value, err)}
+		}
+	}
+	resp := []adminCertificates{}
+	for _, s := range activeInstances() {
+		certs, err := s.Certificates(r.Context())
+		if err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadGateway, Err: err}
+		}
+		if within > 0 {
+			certs = expiringBefore(certs, time.Now().Add(within))
+		}
+		resp = append(resp, adminCertificates{Storage: s.instanceID(), Certificates: certs})
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Storage < resp[j].Storage })
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// adminUsage is the response of GET /storage/s3/usage for one storage.
+type adminUsage struct {
+	Storage string     `json:"storage"`
+	Usage   UsageStats `json:"usage"`
+}
+
+func (a adminAPI) handleUsage(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method %s not allowed", r.Method)}
+	}
+	var maxAge time.Duration
+	if value := r.URL.Query().Get("max_age"); value != "" {
+		var err error
+		if maxAge, err = caddy.ParseDuration(value); err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("invalid max_age '%s': %v", value, err)}
+		}
+	}
+	resp := []adminUsage{}
+	for _, s := range activeInstances() {
+		usage, err := s.Usage(r.Context(), maxAge)
+		if err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadGateway, Err: err}
+		}
+		resp = append(resp, adminUsage{Storage: s.instanceID(), Usage: usage})
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Storage < resp[j].Storage })
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(resp)
+}
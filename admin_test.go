@@ -0,0 +1,87 @@
+package s3_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	s3 "github.com/cvhome-saas/certmagic-s3"
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+// adminRoute returns the handler of an admin API route of the storage module.
+func adminRoute(t *testing.T, pattern string) caddy.AdminHandler {
+	t.Helper()
+	info, err := caddy.GetModule("admin.api.storage_s3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range info.New().(caddy.AdminRouter).Routes() {
+		if route.Pattern == pattern {
+			return route.Handler
+		}
+	}
+	t.Fatalf("no admin route %s", pattern)
+	return nil
+}
+
+// serveAdmin runs a request against an admin handler and returns the recorded response and the
+// HTTP status of a returned caddy.APIError, if any.
+func serveAdmin(t *testing.T, h caddy.AdminHandler, method, target string) (*httptest.ResponseRecorder, int) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	err := h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	var apiErr caddy.APIError
+	if errors.As(err, &apiErr) {
+		return rec, apiErr.HTTPStatus
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec, rec.Code
+}
+
+func TestStorageAdminLocks(t *testing.T) {
+	storage, _ := s3test.NewFakeStorage(t)
+	ctx := context.Background()
+	const key = "issue_cert_example.com"
+	if err := storage.Lock(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	locks := adminRoute(t, "/storage/s3/locks")
+
+	rec, status := serveAdmin(t, locks, http.MethodGet, "/storage/s3/locks")
+	var listed []struct {
+		Storage string        `json:"storage"`
+		Locks   []s3.LockInfo `json:"locks"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); status != http.StatusOK || err != nil {
+		t.Fatalf("GET locks = %d, %v", status, err)
+	}
+	if len(listed) != 1 || listed[0].Storage != s3test.DefaultBucket+"/certmagic" || len(listed[0].Locks) != 1 || listed[0].Locks[0].Key != key {
+		t.Errorf("unexpected locks %+v", listed)
+	}
+
+	rec, status = serveAdmin(t, locks, http.MethodGet, "/storage/s3/locks?key="+key)
+	var li s3.LockInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &li); status != http.StatusOK || err != nil || li.Key != key || li.Owner == nil {
+		t.Errorf("GET lock of the key = %d, %+v, %v", status, li, err)
+	}
+	if _, status := serveAdmin(t, locks, http.MethodGet, "/storage/s3/locks?key=issue_cert_other.com"); status != http.StatusNotFound {
+		t.Errorf("GET lock of an unlocked key = %d, want 404", status)
+	}
+
+	if _, status := serveAdmin(t, locks, http.MethodDelete, "/storage/s3/locks"); status != http.StatusBadRequest {
+		t.Errorf("DELETE without key = %d, want 400", status)
+	}
+	if _, status := serveAdmin(t, locks, http.MethodDelete, "/storage/s3/locks?key="+key); status != http.StatusNoContent {
+		t.Errorf("DELETE = %d, want 204", status)
+	}
+	if remaining, err := storage.ListLocks(ctx); err != nil || len(remaining) != 0 {
+		t.Errorf("locks after force release = %+v, %v", remaining, err)
+	}
+}
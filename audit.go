@@ -0,0 +1,265 @@
+package s3
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// With audit, every Store, Delete, Lock and Unlock is recorded with its key, size, outcome and the
+// identity of the instance, for compliance records of who changed key material. Events go to one
+// sink:
+//
+//	log   the logger named "audit" below the storage's logger, routable with Caddy's log config
+//	file  JSON lines appended to a local file
+//	s3    JSON lines objects in the bucket, one per flush, below a prefix outside the storage
+//	      prefix (by default "<prefix>-audit/"), so they are never listed or exported
+//
+// Failing to record an event is logged but doesn't fail the operation.
+
+// Supported audit sinks.
+const (
+	auditSinkLog  = "log"
+	auditSinkFile = "file"
+	auditSinkS3   = "s3"
+
+	auditFlushInterval = time.Minute
+	auditMaxBuffered   = 1000 // Events buffered before an early flush to S3
+)
+
+// AuditConfig configures the audit log.
+type AuditConfig struct {
+	Sink   string `json:"sink,omitempty"`   // "log" (default), "file" or "s3"
+	Path   string `json:"path,omitempty"`   // File of the file sink
+	Prefix string `json:"prefix,omitempty"` // Object key prefix of the s3 sink
+}
+
+// AuditEvent is one recorded operation.
+type AuditEvent struct {
+	Time          time.Time `json:"time"`
+	Op            string    `json:"op"` // store, delete, lock or unlock
+	Key           string    `json:"key"`
+	Size          int64     `json:"size,omitempty"` // Stored bytes, -1 for streams of unknown size
+	Outcome       string    `json:"outcome"`        // ok or error
+	Error         string    `json:"error,omitempty"`
+	Instance      string    `json:"instance"` // hostname/pid of the Caddy process
+	Storage       string    `json:"storage"`  // bucket/prefix
+	CorrelationID string    `json:"correlation_id,omitempty"`
+}
+
+// auditLog writes audit events to the configured sink.
+type auditLog struct {
+	sink     string
+	instance string
+	logger   *zap.Logger // Sink "log", and errors of the other sinks
+
+	mu      sync.Mutex
+	file    *os.File
+	pending [][]byte // Sink "s3": encoded events not yet written
+	write   func(key string, body []byte) error
+	prefix  string
+	stop    chan struct{}
+	stopped chan struct{}
+
+	closeOnce sync.Once
+}
+
+// provisionAudit sets up the audit sink.
+func (s *S3Storage) provisionAudit() error {
+	hostname, _ := os.Hostname()
+	a := &auditLog{
+		sink:     s.Audit.Sink,
+		instance: fmt.Sprintf("%s/%d", hostname, os.Getpid()),
+		logger:   s.logger.Named("audit"),
+	}
+	switch a.sink {
+	case "", auditSinkLog:
+		a.sink = auditSinkLog
+	case auditSinkFile:
+		path := s.Audit.Path
+		if path == "" {
+			return errors.New("audit: path must be specified for the file sink")
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return fmt.Errorf("audit: opening %s: %w", path, err)
+		}
+		a.file = f
+	case auditSinkS3:
+		if s.ReadOnly {
+			return errors.New("audit sink s3 cannot be used with read_only")
+		}
+		a.prefix = s.Audit.Prefix
+		if a.prefix == "" {
+			a.prefix = cmp.Or(strings.Trim(s.Prefix, "/"), "certmagic") + "-audit"
+		}
+		a.prefix = strings.Trim(a.prefix, "/") + "/"
+		if storagePrefix := strings.Trim(s.Prefix, "/"); storagePrefix != "" && strings.HasPrefix(a.prefix, storagePrefix+"/") {
+			return errors.New("audit: prefix must be outside the storage prefix")
+		}
+		a.write = func(key string, body []byte) error {
+			ctx, cancel := s.opContext(context.Background())
+			defer cancel()
+			_, err := s.Client.PutObject(ctx, &awss3.PutObjectInput{
+				Bucket:      aws.String(s.Bucket),
+				Key:         aws.String(key),
+				Body:        bytes.NewReader(body),
+				ContentType: aws.String("application/x-ndjson"),
+				Tagging:     s.objectTagging(),
+				Metadata:    s.objectMetadata(nil),
+			})
+			return err
+		}
+		a.stop, a.stopped = make(chan struct{}), make(chan struct{})
+		go a.flushLoop()
+	default:
+		return fmt.Errorf("unsupported audit sink '%s' (expected %s, %s or %s)", s.Audit.Sink, auditSinkLog, auditSinkFile, auditSinkS3)
+	}
+	s.audit = a
+	s.logger.Info("audit log active", zap.String("sink", a.sink))
+	return nil
+}
+
+// auditOp records an operation, if the audit log is enabled.
+func (s *S3Storage) auditOp(ctx context.Context, op, key string, size int64, err error) {
+	if s.audit == nil {
+		return
+	}
+	ev := AuditEvent{
+		Time:          time.Now().UTC(),
+		Op:            op,
+		Key:           key,
+		Size:          size,
+		Outcome:       "ok",
+		Instance:      s.audit.instance,
+		Storage:       s.instanceID(),
+		CorrelationID: s.correlationID(ctx, key),
+	}
+	if err != nil {
+		ev.Outcome, ev.Error = "error", err.Error()
+	}
+	s.audit.record(ev)
+}
+
+// record writes an event to the sink.
+func (a *auditLog) record(ev AuditEvent) {
+	if a.sink == auditSinkLog {
+		a.logger.Info("storage operation",
+			zap.String("op", ev.Op), zap.String("key", ev.Key), zap.Int64("size", ev.Size),
+			zap.String("outcome", ev.Outcome), zap.String("error", ev.Error),
+			zap.String("instance", ev.Instance), zap.String("storage", ev.Storage),
+			zap.String("correlation_id", ev.CorrelationID))
+		return
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		a.logger.Error("encoding audit event failed", zap.Error(err))
+		return
+	}
+	line = append(line, '\n')
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch a.sink {
+	case auditSinkFile:
+		if _, err := a.file.Write(line); err != nil {
+			a.logger.Error("writing audit event failed", zap.String("key", ev.Key), zap.Error(err))
+		}
+	case auditSinkS3:
+		a.pending = append(a.pending, line)
+		if len(a.pending) >= auditMaxBuffered {
+			a.flushLocked()
+		}
+	}
+}
+
+// flushLoop writes buffered events to S3 every auditFlushInterval until stopped.
+func (a *auditLog) flushLoop() {
+	defer close(a.stopped)
+	ticker := time.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+		a.mu.Lock()
+		a.flushLocked()
+		a.mu.Unlock()
+	}
+}
+
+// flushLocked writes the pending events to one object named by date, time and instance. Events
+// of a failed write are kept for the next flush.
+func (a *auditLog) flushLocked() {
+	if len(a.pending) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	key := fmt.Sprintf("%s%s/%s-%s.jsonl", a.prefix, now.Format("2006-01-02"), now.Format("150405.000000000"),
+		strings.ReplaceAll(a.instance, "/", "-"))
+	if err := a.write(key, bytes.Join(a.pending, nil)); err != nil {
+		a.logger.Error("writing audit events failed", zap.Int("events", len(a.pending)), zap.Error(err))
+		return
+	}
+	a.pending = nil
+}
+
+// close flushes and releases the sink; later calls do nothing.
+func (a *auditLog) close() error {
+	var err error
+	a.closeOnce.Do(func() {
+		if a.stop != nil {
+			close(a.stop)
+			<-a.stopped
+		}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		switch a.sink {
+		case auditSinkFile:
+			err = a.file.Close()
+		case auditSinkS3:
+			a.flushLocked()
+		}
+	})
+	return err
+}
+
+// unmarshalAudit parses the audit block:
+//
+//	audit s3 {
+//		prefix compliance/certmagic
+//	}
+func (s *S3Storage) unmarshalAudit(d *caddyfile.Dispenser) error {
+	cfg := new(AuditConfig)
+	d.Args(&cfg.Sink)
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		key := d.Val()
+		var value string
+		if !d.AllArgs(&value) {
+			return d.ArgErr()
+		}
+		switch key {
+		case "path":
+			cfg.Path = value
+		case "prefix":
+			cfg.Prefix = value
+		default:
+			return d.Errf("unrecognized audit subdirective '%s'", key)
+		}
+	}
+	s.Audit = cfg
+	return nil
+}
@@ -0,0 +1,41 @@
+package s3
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	defaultLockPollInterval    = 250 * time.Millisecond
+	defaultLockPollMaxInterval = 10 * time.Second
+)
+
+// lockBackoff spaces out the polls of a contended lock: the interval doubles with every attempt
+// up to max, and each wait is randomized to between half and all of it, so instances waiting for
+// the same lock don't poll in lockstep.
+type lockBackoff struct {
+	base, max time.Duration
+}
+
+// delay returns how long to wait before the given retry, counting from 0.
+func (b lockBackoff) delay(attempt int) time.Duration {
+	interval := b.max
+	if attempt < 30 && b.base<<attempt < b.max {
+		interval = b.base << attempt
+	}
+	half := interval / 2
+	return half + rand.N(interval-half+1)
+}
+
+// wait sleeps before the given retry, returning early with the context's error when it is done.
+func (b lockBackoff) wait(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(b.delay(attempt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
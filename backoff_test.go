@@ -0,0 +1,29 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLockBackoff(t *testing.T) {
+	b := lockBackoff{base: 100 * time.Millisecond, max: time.Second}
+	for attempt, interval := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		interval *= time.Millisecond
+		for range 50 {
+			if d := b.delay(attempt); d < interval/2 || d > interval {
+				t.Fatalf("attempt %d: delay %v outside [%v, %v]", attempt, d, interval/2, interval)
+			}
+		}
+	}
+	if d := b.delay(100); d > time.Second {
+		t.Errorf("delay of late attempt %v exceeds max", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := (lockBackoff{base: time.Hour, max: time.Hour}).wait(ctx, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected wait to end with the context, got %v", err)
+	}
+}
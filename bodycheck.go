@@ -0,0 +1,96 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// Some gateways and proxies transform object bodies (charset conversion, compression, line
+// endings) unless they are explicitly marked as binary. All objects are therefore written with
+// an explicit Content-Type, and the first write of each instance is read back to detect a
+// transforming proxy before it silently corrupts certificates and keys.
+
+const defaultContentType = "application/octet-stream"
+
+// contentType returns the Content-Type for written objects.
+func (s *S3Storage) contentType() *string {
+	if s.ContentType != "" {
+		return aws.String(s.ContentType)
+	}
+	return aws.String(defaultContentType)
+}
+
+// checkRoundTrip reads a just written object back and compares it with the written body, until
+// one comparison succeeds. A mismatch fails the write, unless reading back the version with the
+// written ETag once more shows that another instance overwrote the object in between.
+func (s *S3Storage) checkRoundTrip(ctx context.Context, key, s3Key string, written []byte, etag string) error {
+	if s.bodyChecked.Load() {
+		return nil
+	}
+	mismatch, err := s.readBack(ctx, s3Key, written, "")
+	if err == nil && mismatch != nil && etag != "" {
+		mismatch, err = s.readBack(ctx, s3Key, written, etag)
+	}
+	if err != nil {
+		s.logger.Debug("round-trip check skipped", zap.String("key", key), zap.Error(err))
+		return nil // Inconclusive; try again with the next write
+	}
+	if mismatch != nil {
+		return fmt.Errorf("round-trip check for %s failed: %w; a proxy or gateway between Caddy and S3 appears "+
+			"to transform object bodies, check its configuration or set content_type to a type it leaves alone", key, mismatch)
+	}
+	s.bodyChecked.Store(true)
+	return nil
+}
+
+// readBack reads an object, if etag is set only as long as it has that ETag, and returns how its
+// body differs from the written one.
+func (s *S3Storage) readBack(ctx context.Context, s3Key string, written []byte, etag string) (mismatch, err error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+	input := &awss3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s3Key),
+	}
+	if etag != "" {
+		input.IfMatch = aws.String(etag)
+	}
+	out, err := s.Client.GetObject(ctx, input)
+	if err == nil {
+		defer out.Body.Close()
+		var read []byte
+		if read, err = io.ReadAll(out.Body); err == nil && !bytes.Equal(read, written) {
+			return fmt.Errorf("wrote %d bytes but read back %d different bytes", len(written), len(read)), nil
+		}
+	}
+	if isChecksumMismatch(err) {
+		return err, nil
+	}
+	return nil, err
+}
+
+// isChecksumMismatch reports whether err is the SDK's response checksum validation failure. Its
+// type is not exported, so it is matched by package and name.
+func isChecksumMismatch(err error) bool {
+	if err == nil {
+		return false
+	}
+	if t := reflect.TypeOf(err); t.PkgPath() == "github.com/aws/aws-sdk-go-v2/service/internal/checksum" && t.Name() == "validationError" {
+		return true
+	}
+	switch wrapped := err.(type) {
+	case interface{ Unwrap() error }:
+		return isChecksumMismatch(wrapped.Unwrap())
+	case interface{ Unwrap() []error }:
+		return slices.ContainsFunc(wrapped.Unwrap(), isChecksumMismatch)
+	}
+	return false
+}
@@ -0,0 +1,456 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// With cache_invalidation, the read cache drops a key as soon as S3 reports that an object was
+// written or deleted, instead of serving the stale value until read_cache_ttl passes. Events are
+// S3 bucket notifications, received from one source:
+//
+//	webhook  an HTTP endpoint for SNS HTTP(S) subscriptions or MinIO webhook targets; requests
+//	         must carry the token as bearer token or "token" query parameter. SNS subscriptions
+//	         are confirmed automatically.
+//	sqs      long polling of an SQS queue receiving the notifications, directly or through SNS.
+//	         Every instance needs its own queue, as a message is consumed by one reader.
+//	minio    MinIO's bucket listen API on the endpoint, which needs no further setup
+//
+// Events of other buckets and of keys outside the prefix are ignored. A lost event only delays
+// the update until the entry expires.
+
+// Supported cache invalidation sources.
+const (
+	invalidationWebhook = "webhook"
+	invalidationSQS     = "sqs"
+	invalidationMinIO   = "minio"
+
+	// maxNotificationSize bounds webhook requests and SQS messages; notifications are small.
+	maxNotificationSize = 1 << 20
+)
+
+// CacheInvalidationConfig configures the source of bucket notifications invalidating the read cache.
+type CacheInvalidationConfig struct {
+	Source   string `json:"source,omitempty"`    // "webhook", "sqs" or "minio"
+	Listen   string `json:"listen,omitempty"`    // Address of the webhook endpoint
+	Token    string `json:"token,omitempty"`     // Token webhook requests must present
+	QueueURL string `json:"queue_url,omitempty"` // SQS queue
+}
+
+// s3Notification is an S3 event notification, as sent by AWS and MinIO.
+type s3Notification struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"` // URL-encoded
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// snsMessage is the envelope of a message delivered by SNS.
+type snsMessage struct {
+	Type         string `json:"Type"`
+	Message      string `json:"Message"`
+	SubscribeURL string `json:"SubscribeURL"`
+	TopicArn     string `json:"TopicArn"`
+}
+
+// startCacheInvalidation validates the configuration and starts receiving notifications until
+// ctx is done.
+func (s *S3Storage) startCacheInvalidation(ctx caddy.Context) error {
+	cfg := s.CacheInvalidation
+	if s.cache == nil {
+		return errors.New("cache_invalidation requires read_cache_size")
+	}
+	switch cfg.Source {
+	case invalidationWebhook:
+		return s.startInvalidationWebhook(ctx)
+	case invalidationSQS:
+		if cfg.QueueURL == "" {
+			return errors.New("cache_invalidation: queue_url must be specified for sqs")
+		}
+		awsCfg, err := s.loadAWSConfig(sqsRegion(cfg.QueueURL, s.Region), s.AccessKeyID, s.SecretAccessKey, s.SessionToken)
+		if err != nil {
+			return fmt.Errorf("cache_invalidation: %w", err)
+		}
+		go s.pollSQS(ctx, awsCfg, cfg.QueueURL, s.handleNotification)
+	case invalidationMinIO:
+		if s.Endpoint == "" {
+			return errors.New("cache_invalidation: minio requires endpoint")
+		}
+		awsCfg, err := s.loadAWSConfig(s.Region, s.AccessKeyID, s.SecretAccessKey, s.SessionToken)
+		if err != nil {
+			return fmt.Errorf("cache_invalidation: %w", err)
+		}
+		go s.listenMinIO(ctx, awsCfg)
+	default:
+		return fmt.Errorf("unsupported cache_invalidation source '%s' (expected %s, %s or %s)",
+			cfg.Source, invalidationWebhook, invalidationSQS, invalidationMinIO)
+	}
+	s.logger.Info("cache invalidation active", zap.String("source", cfg.Source))
+	return nil
+}
+
+// handleNotification invalidates the keys of a notification, which may be wrapped by SNS.
+func (s *S3Storage) handleNotification(ctx context.Context, body []byte) error {
+	var msg snsMessage
+	if json.Unmarshal(body, &msg) == nil && msg.Type != "" {
+		switch msg.Type {
+		case "Notification":
+			body = []byte(msg.Message)
+		case "SubscriptionConfirmation":
+			return s.confirmSNSSubscription(ctx, msg)
+		default:
+			return nil
+		}
+	}
+	var n s3Notification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return fmt.Errorf("decoding notification: %w", err)
+	}
+	for _, r := range n.Records {
+		// Behind a multi-region access point, notifications name the bucket in each region
+		if r.S3.Bucket.Name != s.Bucket && !isMultiRegionAccessPoint(s.Bucket) {
+			continue
+		}
+		s3Key, err := url.QueryUnescape(r.S3.Object.Key)
+		if err != nil {
+			continue
+		}
+		if s.Prefix != "" && !strings.HasPrefix(s3Key, s.Prefix+"/") {
+			continue
+		}
+		key := s.certMagicKey(s3Key)
+		s.cache.invalidate(key)
+		if s.existsCache != nil {
+			s.existsCache.remove(key)
+		}
+		s.forgetFlights(s3Key)
+		s.logger.Debug("cache entry invalidated", zap.String("key", key), zap.String("event", r.EventName))
+	}
+	return nil
+}
+
+// confirmSNSSubscription visits the subscribe URL of an SNS subscription, if it points to AWS.
+func (s *S3Storage) confirmSNSSubscription(ctx context.Context, msg snsMessage) error {
+	u, err := url.Parse(msg.SubscribeURL)
+	if err != nil || u.Scheme != "https" || !strings.HasSuffix(u.Hostname(), ".amazonaws.com") {
+		return fmt.Errorf("refusing to confirm SNS subscription with subscribe URL '%s'", msg.SubscribeURL)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("confirming SNS subscription: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("confirming SNS subscription: %s", resp.Status)
+	}
+	s.logger.Info("confirmed SNS subscription", zap.String("topic", msg.TopicArn))
+	return nil
+}
+
+// startInvalidationWebhook serves the webhook endpoint.
+func (s *S3Storage) startInvalidationWebhook(ctx caddy.Context) error {
+	cfg := s.CacheInvalidation
+	if cfg.Listen == "" || cfg.Token == "" {
+		return errors.New("cache_invalidation: listen and token must be specified for webhook")
+	}
+	addr, err := caddy.ParseNetworkAddress(cfg.Listen)
+	if err != nil {
+		return fmt.Errorf("invalid cache_invalidation listen address '%s': %w", cfg.Listen, err)
+	}
+	lnAny, err := addr.Listen(ctx, 0, net.ListenConfig{})
+	if err != nil {
+		return fmt.Errorf("listening for cache invalidation on %s: %w", cfg.Listen, err)
+	}
+	ln, ok := lnAny.(net.Listener)
+	if !ok {
+		return fmt.Errorf("cache_invalidation listen address '%s' is not a stream address", cfg.Listen)
+	}
+
+	s.invalidationServer = &http.Server{
+		Handler:           s.invalidationHandler(cfg.Token),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := s.invalidationServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("cache invalidation endpoint stopped", zap.Error(err))
+		}
+	}()
+	s.logger.Info("cache invalidation endpoint listening", zap.String("address", ln.Addr().String()))
+	return nil
+}
+
+// invalidationHandler accepts notifications POSTed to any path.
+func (s *S3Storage) invalidationHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		given := r.URL.Query().Get("token")
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			given = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxNotificationSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.handleNotification(r.Context(), body); err != nil {
+			s.logger.Warn("invalid bucket notification", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// stopInvalidationWebhook shuts the webhook endpoint down if it is running.
+func (s *S3Storage) stopInvalidationWebhook() error {
+	if s.invalidationServer == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.invalidationServer.Shutdown(ctx)
+	s.invalidationServer = nil
+	return err
+}
+
+// sqsRegion returns the region of a queue URL like https://sqs.eu-west-1.amazonaws.com/123/name.
+func sqsRegion(queueURL, fallback string) string {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return fallback
+	}
+	parts := strings.Split(u.Hostname(), ".")
+	if len(parts) >= 4 && parts[0] == "sqs" {
+		return parts[1]
+	}
+	return fallback
+}
+
+// pollSQS receives messages from the queue and passes their bodies to handle until ctx is done.
+// Messages are deleted once handled; undecodable ones as well, so that they don't come back.
+func (s *S3Storage) pollSQS(ctx context.Context, cfg aws.Config, queueURL string, handle func(context.Context, []byte) error) {
+	backoff := lockBackoff{base: time.Second, max: time.Minute}
+	for failures := 0; ctx.Err() == nil; {
+		var out struct {
+			Messages []struct {
+				Body          string `json:"Body"`
+				ReceiptHandle string `json:"ReceiptHandle"`
+			} `json:"Messages"`
+		}
+		err := s.sqsCall(ctx, cfg, queueURL, "ReceiveMessage", map[string]any{
+			"QueueUrl":            queueURL,
+			"MaxNumberOfMessages": 10,
+			"WaitTimeSeconds":     20,
+		}, &out)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.Warn("receiving messages from SQS failed", zap.String("queue_url", queueURL), zap.Error(err))
+			_ = backoff.wait(ctx, failures)
+			failures++
+			continue
+		}
+		failures = 0
+		if len(out.Messages) == 0 {
+			continue
+		}
+		entries := make([]map[string]string, 0, len(out.Messages))
+		for i, m := range out.Messages {
+			if err := handle(ctx, []byte(m.Body)); err != nil {
+				s.logger.Warn("invalid SQS message", zap.String("queue_url", queueURL), zap.Error(err))
+			}
+			entries = append(entries, map[string]string{"Id": fmt.Sprint(i), "ReceiptHandle": m.ReceiptHandle})
+		}
+		if err := s.sqsCall(ctx, cfg, queueURL, "DeleteMessageBatch", map[string]any{
+			"QueueUrl": queueURL,
+			"Entries":  entries,
+		}, nil); err != nil && ctx.Err() == nil {
+			s.logger.Warn("deleting messages from SQS failed", zap.String("queue_url", queueURL), zap.Error(err))
+		}
+	}
+}
+
+// sqsCall sends a request of the SQS JSON protocol to the queue URL.
+func (s *S3Storage) sqsCall(ctx context.Context, cfg aws.Config, queueURL, action string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, queueURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "AmazonSQS."+action)
+	sum := sha256.Sum256(body)
+	if err := signRequest(ctx, cfg, req, hex.EncodeToString(sum[:]), "sqs"); err != nil {
+		return err
+	}
+	resp, err := cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 10*maxNotificationSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s: %s", action, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// signRequest signs req with the credentials of cfg, unless they are anonymous.
+func signRequest(ctx context.Context, cfg aws.Config, req *http.Request, payloadHash, service string) error {
+	if cfg.Credentials == nil {
+		return nil
+	}
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("retrieving credentials: %w", err)
+	}
+	return v4.NewSigner().SignHTTP(ctx, creds, req, payloadHash, service, cfg.Region, time.Now())
+}
+
+// emptyPayloadHash is the SHA-256 digest of an empty body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// listenMinIO streams notifications from MinIO's listen API until ctx is done, reconnecting
+// after errors.
+func (s *S3Storage) listenMinIO(ctx context.Context, cfg aws.Config) {
+	query := url.Values{
+		"events": {"s3:ObjectCreated:*", "s3:ObjectRemoved:*"},
+		"ping":   {"10"},
+	}
+	if s.Prefix != "" {
+		query.Set("prefix", s.Prefix+"/")
+	}
+	listenURL := strings.TrimSuffix(s.Endpoint, "/") + "/" + url.PathEscape(s.Bucket) + "?" + query.Encode()
+	backoff := lockBackoff{base: time.Second, max: time.Minute}
+	for failures := 0; ctx.Err() == nil; {
+		received, err := s.listenMinIOOnce(ctx, cfg, listenURL)
+		if ctx.Err() != nil {
+			return
+		}
+		if received {
+			failures = 0
+		}
+		s.logger.Warn("MinIO bucket notification stream ended, reconnecting", zap.Error(err))
+		_ = backoff.wait(ctx, failures)
+		failures++
+	}
+}
+
+// listenMinIOOnce reads one notification stream; received reports whether it was established.
+func (s *S3Storage) listenMinIOOnce(ctx context.Context, cfg aws.Config, listenURL string) (received bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, listenURL, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if err := signRequest(ctx, cfg, req, emptyPayloadHash, "s3"); err != nil {
+		return false, err
+	}
+	resp, err := cfg.HTTPClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return false, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, maxNotificationSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 { // Keep-alive ping
+			continue
+		}
+		if err := s.handleNotification(ctx, line); err != nil {
+			s.logger.Warn("invalid bucket notification", zap.Error(err))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	return true, io.EOF
+}
+
+// unmarshalCacheInvalidation parses the cache_invalidation block:
+//
+//	cache_invalidation webhook {
+//		listen :9798
+//		token {env.CACHE_INVALIDATION_TOKEN}
+//	}
+func (s *S3Storage) unmarshalCacheInvalidation(d *caddyfile.Dispenser) error {
+	cfg := new(CacheInvalidationConfig)
+	if !d.Args(&cfg.Source) {
+		return d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		key := d.Val()
+		var value string
+		if !d.AllArgs(&value) {
+			return d.ArgErr()
+		}
+		switch key {
+		case "listen":
+			cfg.Listen = value
+		case "token":
+			cfg.Token = value
+		case "queue_url":
+			cfg.QueueURL = value
+		default:
+			return d.Errf("unrecognized cache_invalidation subdirective '%s'", key)
+		}
+	}
+	s.CacheInvalidation = cfg
+	return nil
+}
@@ -0,0 +1,184 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"go.uber.org/zap"
+)
+
+func newInvalidationTestStorage() *S3Storage {
+	s := &S3Storage{
+		Bucket:            "certs",
+		Prefix:            "certmagic",
+		CacheInvalidation: &CacheInvalidationConfig{},
+		logger:            zap.NewNop(),
+		cache:             newReadCache(1<<20, time.Hour, zap.NewNop()),
+	}
+	for _, key := range []string{"a.crt", "b.crt", "c d.crt"} {
+		s.cache.put("certificates/"+key, []byte("cached"), s.cache.generation())
+	}
+	return s
+}
+
+func notification(bucket, key string) string {
+	return fmt.Sprintf(`{"Records":[{"eventName":"ObjectCreated:Put","s3":{"bucket":{"name":%q},"object":{"key":%q}}}]}`, bucket, key)
+}
+
+func TestInvalidationWebhook(t *testing.T) {
+	s := newInvalidationTestStorage()
+	srv := httptest.NewServer(s.invalidationHandler("secret"))
+	defer srv.Close()
+
+	post := func(url, auth, body string) int {
+		req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := post(srv.URL, "Bearer wrong", notification("certs", "certmagic/certificates/a.crt")); code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d", code)
+	}
+	if code := post(srv.URL, "Bearer secret", notification("certs", "certmagic/certificates/a.crt")); code != http.StatusNoContent {
+		t.Errorf("raw notification: status %d", code)
+	}
+	// SNS wraps the notification; keys are URL-encoded
+	sns, _ := json.Marshal(snsMessage{Type: "Notification", Message: notification("certs", "certmagic/certificates/c+d.crt")})
+	if code := post(srv.URL+"?token=secret", "", string(sns)); code != http.StatusNoContent {
+		t.Errorf("SNS notification: status %d", code)
+	}
+	// Other buckets and prefixes are ignored
+	post(srv.URL, "secret", notification("other", "certmagic/certificates/b.crt"))
+	post(srv.URL, "secret", notification("certs", "other/certificates/b.crt"))
+	if code := post(srv.URL, "secret", "not json"); code != http.StatusBadRequest {
+		t.Errorf("invalid notification: status %d", code)
+	}
+
+	for key, want := range map[string]bool{"a.crt": false, "b.crt": true, "c d.crt": false} {
+		if _, ok := s.cache.get("certificates/" + key); ok != want {
+			t.Errorf("%s cached = %v, want %v", key, ok, want)
+		}
+	}
+	if stats := s.cache.snapshot(); stats.Invalidations != 2 {
+		t.Errorf("invalidations = %d, want 2", stats.Invalidations)
+	}
+}
+
+func TestInvalidationSQS(t *testing.T) {
+	var deleted atomic.Int32
+	delivered := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+			http.Error(w, "unsigned", http.StatusForbidden)
+			return
+		}
+		var in map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&in)
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSQS.ReceiveMessage":
+			if delivered {
+				select { // Long poll
+				case <-r.Context().Done():
+				case <-time.After(time.Second):
+				}
+				_, _ = io.WriteString(w, `{}`)
+				return
+			}
+			delivered = true
+			body, _ := json.Marshal(notification("certs", "certmagic/certificates/a.crt"))
+			fmt.Fprintf(w, `{"Messages":[{"Body":%s,"ReceiptHandle":"r1"}]}`, body)
+		case "AmazonSQS.DeleteMessageBatch":
+			deleted.Add(int32(len(in["Entries"].([]any))))
+			_, _ = io.WriteString(w, `{"Successful":[{"Id":"0"}]}`)
+		}
+	}))
+	defer srv.Close()
+
+	s := newInvalidationTestStorage()
+	s.CacheInvalidation.QueueURL = srv.URL + "/123456789012/certmagic"
+	cfg := aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		HTTPClient:  srv.Client(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.pollSQS(ctx, cfg, s.CacheInvalidation.QueueURL, s.handleNotification)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for deleted.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if deleted.Load() != 1 {
+		t.Fatal("message was not deleted")
+	}
+	if _, ok := s.cache.get("certificates/a.crt"); ok {
+		t.Error("a.crt still cached")
+	}
+	if _, ok := s.cache.get("certificates/b.crt"); !ok {
+		t.Error("b.crt was invalidated")
+	}
+}
+
+func TestInvalidationMinIO(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/certs" || r.URL.Query().Get("prefix") != "certmagic/" || len(r.URL.Query()["events"]) != 2 {
+			http.Error(w, "unexpected request "+r.URL.String(), http.StatusBadRequest)
+			return
+		}
+		_, _ = io.WriteString(w, " \n"+notification("certs", "certmagic/certificates/b.crt")+"\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	s := newInvalidationTestStorage()
+	s.Endpoint = srv.URL
+	cfg := aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		HTTPClient:  srv.Client(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.listenMinIO(ctx, cfg)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.cache.snapshot().Invalidations == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := s.cache.get("certificates/b.crt"); ok {
+		t.Error("b.crt still cached")
+	}
+}
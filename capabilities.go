@@ -0,0 +1,106 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.uber.org/zap"
+)
+
+// capabilitiesProbeKey is the CertMagic key used to test conditional writes; it is removed afterwards.
+const capabilitiesProbeKey = ".capabilities-probe"
+
+// capabilitiesProbeTimeout bounds probing, so that an unreachable backend delays Provision only briefly.
+const capabilitiesProbeTimeout = 5 * time.Second
+
+// Capabilities describes what the configured backend and storage configuration support,
+// so embedding applications and other modules can adapt their behavior.
+type Capabilities struct {
+	// SupportsConditionalPut is true if the backend honors If-None-Match on PutObject.
+	SupportsConditionalPut bool `json:"supports_conditional_put"`
+	// SupportsVersioning is true if versioning is enabled on the bucket.
+	SupportsVersioning bool `json:"supports_versioning"`
+	// EncryptionEnabled is true if values are encrypted client-side.
+	EncryptionEnabled bool `json:"encryption_enabled"`
+	// CacheEnabled is true if a local cache of the stored values is active.
+	CacheEnabled bool `json:"cache_enabled"`
+}
+
+// Capabilities returns the capabilities of the storage. The backend is probed by Provision, except
+// for commands; probe failures are logged and reported as unsupported.
+func (s *S3Storage) Capabilities() Capabilities {
+	caps := s.caps
+	caps.EncryptionEnabled = !isCleartext(s.iowrap)
+	caps.CacheEnabled = s.mirror != nil || s.cache != nil
+	return caps
+}
+
+// probeCapabilities probes the backend for the capabilities reported by Capabilities.
+func (s *S3Storage) probeCapabilities(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, capabilitiesProbeTimeout)
+	defer cancel()
+	s.caps.SupportsConditionalPut = !s.isGCS() && !s.ReadOnly && s.probeConditionalPut(ctx)
+	s.caps.SupportsVersioning = s.probeVersioning(ctx)
+}
+
+// probeConditionalPut writes a probe object twice with If-None-Match: *. A backend honoring the
+// header rejects the second write with 412 Precondition Failed. The versions written are deleted
+// again, so that probing at every start leaves nothing behind in a versioned bucket.
+func (s *S3Storage) probeConditionalPut(ctx context.Context) bool {
+	probeKey := s.s3ObjectKey(capabilitiesProbeKey)
+	var versions []*string
+	put := func() error {
+		ctx, cancel := s.opContext(ctx)
+		defer cancel()
+		out, err := s.Client.PutObject(ctx, &awss3.PutObjectInput{
+			Bucket:      aws.String(s.Bucket),
+			Key:         aws.String(probeKey),
+			Body:        bytes.NewReader(nil),
+			IfNoneMatch: aws.String("*"),
+			Tagging:     s.objectTagging(),
+			Metadata:    s.objectMetadata(nil),
+		})
+		if err == nil {
+			versions = append(versions, out.VersionId)
+		}
+		return err
+	}
+	defer func() {
+		ctx, cancel := s.opContext(ctx)
+		defer cancel()
+		for _, version := range versions {
+			_, _ = s.Client.DeleteObject(ctx, &awss3.DeleteObjectInput{Bucket: aws.String(s.Bucket), Key: aws.String(probeKey), VersionId: version})
+		}
+	}()
+
+	if err := put(); err != nil && !isPreconditionFailed(err) {
+		s.logger.Debug("conditional put probe failed", zap.Error(err))
+		return false
+	}
+	return isPreconditionFailed(put())
+}
+
+// probeVersioning reports whether versioning is enabled on the bucket.
+func (s *S3Storage) probeVersioning(ctx context.Context) bool {
+	ctx, cancel := s.opContext(ctx)
+	defer cancel()
+	out, err := s.Client.GetBucketVersioning(ctx, &awss3.GetBucketVersioningInput{Bucket: aws.String(s.Bucket)})
+	if err != nil {
+		s.logger.Debug("versioning probe failed", zap.Error(err))
+		return false
+	}
+	return out.Status == types.BucketVersioningStatusEnabled
+}
+
+// isPreconditionFailed reports whether err is an HTTP 412 response.
+func isPreconditionFailed(err error) bool {
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusPreconditionFailed
+}
@@ -0,0 +1,33 @@
+package s3_test
+
+import (
+	"maps"
+	"testing"
+
+	s3 "github.com/cvhome-saas/certmagic-s3"
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+func TestStorageCapabilities(t *testing.T) {
+	storage, fake := s3test.NewFakeStorage(t)
+	caps := storage.Capabilities()
+	if !caps.SupportsConditionalPut || caps.SupportsVersioning || caps.EncryptionEnabled || caps.CacheEnabled {
+		t.Errorf("unexpected capabilities %+v", caps)
+	}
+	if keys := fake.Keys(s3test.DefaultBucket); len(keys) != 0 {
+		t.Errorf("probing left objects behind: %v", keys)
+	}
+
+	readOnly, _ := s3test.NewFakeStorage(t, func(s *s3.S3Storage) { s.ReadOnly = true })
+	if readOnly.Capabilities().SupportsConditionalPut {
+		t.Error("a read-only storage must not probe with writes")
+	}
+
+	// The backend is probed by Provision; Capabilities sends no requests.
+	probed := s3test.NewStorage(t)
+	before := probed.RequestStats().Requests
+	probed.Capabilities()
+	if after := probed.RequestStats().Requests; !maps.Equal(before, after) {
+		t.Errorf("Capabilities sent requests: %v, before %v", after, before)
+	}
+}
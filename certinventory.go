@@ -0,0 +1,223 @@
+package s3
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+)
+
+// CertificateInfo describes a certificate stored in the bucket, for auditing expirations across
+// all instances sharing it.
+type CertificateInfo struct {
+	Key       string    `json:"key"`        // CertMagic key of the .crt object
+	IssuerKey string    `json:"issuer_key"` // CertMagic issuer directory, e.g. acme-v02.api.letsencrypt.org-directory
+	Subject   string    `json:"subject"`
+	SANs      []string  `json:"sans"`
+	Issuer    string    `json:"issuer"`
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	Error     string    `json:"error,omitempty"` // Why the certificate could not be loaded or parsed
+}
+
+// Certificates returns the site certificates stored below the prefix, soonest expiring first.
+// Certificates which cannot be loaded or parsed are included with Error set, at the end.
+func (s *S3Storage) Certificates(ctx context.Context) ([]CertificateInfo, error) {
+	var certKeys []string
+	err := s.walkObjects(ctx, func(obj types.Object) error {
+		if key := s.certMagicKey(aws.ToString(obj.Key)); isCertificateKey(key) {
+			certKeys = append(certKeys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	certs := make([]CertificateInfo, 0, len(certKeys))
+	for _, key := range certKeys {
+		value, err := s.Load(ctx, key)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			certs = append(certs, CertificateInfo{Key: key, IssuerKey: certIssuerKey(key), Error: err.Error()})
+			continue
+		}
+		certs = append(certs, parseCertificateInfo(key, value))
+	}
+	sortCertificates(certs)
+	return certs, nil
+}
+
+// parseCertificateInfo describes the leaf, i.e. first, certificate of a PEM bundle.
+func parseCertificateInfo(key string, certPEM []byte) CertificateInfo {
+	info := CertificateInfo{Key: key, IssuerKey: certIssuerKey(key)}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		info.Error = errors.New("no PEM data").Error()
+		return info
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.Subject = cert.Subject.CommonName
+	info.SANs = append(info.SANs, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		info.SANs = append(info.SANs, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		info.SANs = append(info.SANs, uri.String())
+	}
+	info.SANs = append(info.SANs, cert.EmailAddresses...)
+	info.Issuer = cert.Issuer.String()
+	info.Serial = hex.EncodeToString(cert.SerialNumber.Bytes())
+	info.NotBefore = cert.NotBefore
+	info.NotAfter = cert.NotAfter
+	return info
+}
+
+// certIssuerKey returns the issuer directory of a key in CertMagic's layout,
+// certificates/<issuer>/<name>/<name>.crt.
+func certIssuerKey(key string) string {
+	parts := strings.Split(key, "/")
+	if len(parts) != 4 {
+		return ""
+	}
+	return parts[1]
+}
+
+// certDomain returns the site name of a key in CertMagic's layout.
+func certDomain(key string) string {
+	parts := strings.Split(key, "/")
+	if len(parts) != 4 {
+		return ""
+	}
+	return parts[2]
+}
+
+// sortCertificates orders by expiration, with unparsable certificates last.
+func sortCertificates(certs []CertificateInfo) {
+	sort.SliceStable(certs, func(i, j int) bool {
+		if (certs[i].Error == "") != (certs[j].Error == "") {
+			return certs[i].Error == ""
+		}
+		if !certs[i].NotAfter.Equal(certs[j].NotAfter) {
+			return certs[i].NotAfter.Before(certs[j].NotAfter)
+		}
+		return certs[i].Key < certs[j].Key
+	})
+}
+
+// expiringBefore returns the certificates expiring before deadline, and those with errors.
+func expiringBefore(certs []CertificateInfo, deadline time.Time) []CertificateInfo {
+	filtered := []CertificateInfo{}
+	for _, c := range certs {
+		if c.Error != "" || c.NotAfter.Before(deadline) {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
+
+func listCertsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list-certs --config <path> [--expiring-within <duration>] [--json]",
+		Short: "Lists the stored certificates with their expiry and SANs",
+		Long: `
+Reads every site certificate below the configured prefix, parses its PEM and
+prints its domain, SANs, issuer, expiry and the days remaining, soonest
+expiring first, e.g. to audit the expirations of a fleet sharing the bucket.
+Certificates which cannot be loaded or parsed are listed last with the error.
+`,
+		RunE: caddycmd.WrapCommandFuncForCobra(cmdListCerts),
+	}
+	addConfigFlags(cmd)
+	cmd.Flags().Duration("expiring-within", 0, "Only list certificates expiring within this duration")
+	cmd.Flags().Bool("json", false, "Print JSON instead of a table")
+	return cmd
+}
+
+func cmdListCerts(fl caddycmd.Flags) (int, error) {
+	s, cancel, err := loadStorageFromConfig(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	defer cancel()
+
+	certs, err := s.Certificates(context.Background())
+	if err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	now := time.Now()
+	if within := fl.Duration("expiring-within"); within > 0 {
+		certs = expiringBefore(certs, now.Add(within))
+	}
+	if fl.Bool("json") {
+		err = printCertificatesJSON(os.Stdout, certs, now)
+	} else {
+		err = printCertificates(os.Stdout, certs, now)
+	}
+	if err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+// listedCertificate is a certificate as printed by list-certs --json.
+type listedCertificate struct {
+	Domain        string `json:"domain"`
+	DaysRemaining int    `json:"days_remaining"` // Negative once expired
+	CertificateInfo
+}
+
+// daysRemaining returns the whole days until a certificate expires.
+func daysRemaining(c CertificateInfo, now time.Time) int {
+	return int(math.Floor(c.NotAfter.Sub(now).Hours() / 24))
+}
+
+func printCertificatesJSON(w io.Writer, certs []CertificateInfo, now time.Time) error {
+	listed := make([]listedCertificate, 0, len(certs))
+	for _, c := range certs {
+		l := listedCertificate{Domain: certDomain(c.Key), CertificateInfo: c}
+		if c.Error == "" {
+			l.DaysRemaining = daysRemaining(c, now)
+		}
+		listed = append(listed, l)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(listed)
+}
+
+func printCertificates(w io.Writer, certs []CertificateInfo, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "domain\tsans\tissuer\tnot after\tdays left\t")
+	for _, c := range certs {
+		if c.Error != "" {
+			fmt.Fprintf(tw, "%s\t\t\t\terror: %s\t\n", certDomain(c.Key), c.Error)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t\n", certDomain(c.Key), strings.Join(c.SANs, ","), c.Issuer,
+			c.NotAfter.UTC().Format(time.RFC3339), daysRemaining(c, now))
+	}
+	return tw.Flush()
+}
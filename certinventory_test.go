@@ -0,0 +1,87 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCertificateInventory(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var certs []CertificateInfo
+	for domain, validity := range map[string]time.Duration{"late.com": 90 * 24 * time.Hour, "soon.com": 7 * 24 * time.Hour} {
+		res, err := selfSignedResource(domain, "", from, from.Add(validity))
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, parseCertificateInfo("certificates/acme/"+domain+"/"+domain+".crt", res.CertificatePEM))
+	}
+	certs = append(certs, parseCertificateInfo("certificates/acme/broken.com/broken.com.crt", []byte("garbage")))
+	sortCertificates(certs)
+
+	var keys []string
+	for _, c := range certs {
+		keys = append(keys, c.Key)
+	}
+	want := []string{
+		"certificates/acme/soon.com/soon.com.crt",
+		"certificates/acme/late.com/late.com.crt",
+		"certificates/acme/broken.com/broken.com.crt",
+	}
+	if !slices.Equal(keys, want) {
+		t.Fatalf("order = %v, want %v", keys, want)
+	}
+	soon := certs[0]
+	if soon.IssuerKey != "acme" || !slices.Contains(soon.SANs, "soon.com") || soon.Serial == "" || soon.Issuer == "" {
+		t.Errorf("unexpected certificate info %+v", soon)
+	}
+	if !soon.NotAfter.Equal(from.Add(7 * 24 * time.Hour)) {
+		t.Errorf("not after = %v", soon.NotAfter)
+	}
+	if certs[2].Error == "" {
+		t.Error("expected an error for an unparsable certificate")
+	}
+
+	expiring := expiringBefore(certs, from.Add(30*24*time.Hour))
+	if len(expiring) != 2 || expiring[0].Key != want[0] || expiring[1].Key != want[2] {
+		t.Errorf("expiring = %+v", expiring)
+	}
+}
+
+func TestPrintCertificates(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	res, err := selfSignedResource("soon.com", "", from, from.Add(7*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := []CertificateInfo{
+		parseCertificateInfo("certificates/acme/soon.com/soon.com.crt", res.CertificatePEM),
+		parseCertificateInfo("certificates/acme/broken.com/broken.com.crt", []byte("garbage")),
+	}
+	now := from.Add(36 * time.Hour)
+
+	var table bytes.Buffer
+	if err := printCertificates(&table, certs, now); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "soon.com ") || !strings.Contains(lines[1], "2024-01-08T00:00:00Z") ||
+		!strings.HasSuffix(strings.TrimSpace(lines[1]), " 5") || !strings.Contains(lines[2], "error: no PEM data") {
+		t.Errorf("unexpected table:\n%s", table.String())
+	}
+
+	var out bytes.Buffer
+	if err := printCertificatesJSON(&out, certs, now); err != nil {
+		t.Fatal(err)
+	}
+	var listed []listedCertificate
+	if err := json.Unmarshal(out.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 2 || listed[0].Domain != "soon.com" || listed[0].DaysRemaining != 5 || listed[0].Issuer == "" {
+		t.Errorf("unexpected JSON: %s", out.String())
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"go.uber.org/zap"
 	"io"
 	"io/fs"
+	"path"
+	"slices"
 	"strings"
 	"time"
 )
@@ -22,183 +24,753 @@ func (s *S3Storage) CertMagicStorage() (certmagic.Storage, error) {
 
 // Lock attempts to acquire a lock for the given CertMagic key.
 func (s *S3Storage) Lock(ctx context.Context, key string) error {
+	err := s.lock(ctx, key)
+	s.auditOp(ctx, "lock", key, 0, err)
+	return err
+}
+
+func (s *S3Storage) lock(ctx context.Context, key string) error {
+	if err := s.checkWritable("lock", key); err != nil {
+		return err
+	}
 	lockObjectS3Key := s.s3LockKey(key)
-	s.logger.Debug("attempting to lock", zap.String("key", key), zap.String("s3_lock_key", lockObjectS3Key))
+	correlationID, began := s.traces.begin(key, CorrelationIDFromContext(ctx))
+	logger := s.logger.With(zap.String("correlation_id", correlationID))
+	acquired := false
+	defer func() {
+		switch {
+		case acquired && !began: // The previous holder in this process has ended its trace
+			s.traces.begin(key, correlationID)
+		case !acquired && began:
+			s.traces.end(key)
+		}
+	}()
+	logger.Debug("attempting to lock", zap.String("key", key), zap.String("s3_lock_key", lockObjectS3Key))
+	if s.httpLock != nil {
+		start := time.Now()
+		if err := s.httpLock.lock(ctx, key, s.lockBackoff, s.lockTimeout); err != nil {
+			s.recordError("lock", key, err)
+			return err
+		}
+		logger.Info("lock acquired from lock service", zap.String("key", key))
+		s.lockStats.acquire(key, time.Since(start))
+		acquired = true
+		s.held.add(key)
+		return nil
+	}
 	startTime := time.Now()
-	lockContent := []byte(startTime.UTC().Format(time.RFC3339Nano)) // Content for the lock file
+	attempt := 0
+	contended := false
 
 	for {
 		// Check for context cancellation at the beginning of each attempt.
 		select {
 		case <-ctx.Done():
-			s.logger.Debug("lock attempt cancelled by context", zap.String("key", key))
+			logger.Debug("lock attempt cancelled by context", zap.String("key", key))
 			return ctx.Err()
 		default:
 		}
 
-		// Check if lock file exists and its status
-		headOut, err := s.Client.HeadObject(ctx, &awss3.HeadObjectInput{
-			Bucket: aws.String(s.Bucket),
-			Key:    aws.String(lockObjectS3Key),
-		})
-
-		if err == nil { // Lock file exists
-			if headOut.LastModified != nil && time.Since(*headOut.LastModified) < s.lockExpiration {
-				s.logger.Debug("lock exists and is active", zap.String("key", key), zap.Time("lock_modified", *headOut.LastModified))
-				if time.Since(startTime) > s.lockTimeout {
-					return fmt.Errorf("timeout acquiring lock for %s (lock held by another process)", key)
-				}
-				time.Sleep(s.lockPollInterval) // Wait before retrying
-				continue                       // Retry loop
+		// Check if a lock file exists and is still active
+		held, expired, err := s.lockHeld(ctx, logger, key, lockObjectS3Key)
+		if err == nil && !held && s.legacyLocks() {
+			var legacyExpired bool
+			held, legacyExpired, err = s.lockHeld(ctx, logger, key, s.s3LegacyLockKey(key)) // Held by an older instance
+			expired = expired || legacyExpired
+		}
+		if err != nil {
+			s.recordError("lock", key, err)
+			return fmt.Errorf("checking lock for %s: %w", key, s3Error(err)) // Unexpected error
+		}
+		if held {
+			waited := time.Since(startTime)
+			if !contended {
+				contended = true
+				s.lockStats.contended.Add(1)
+				s.emitEvent(eventLockContention, key, map[string]any{"correlation_id": correlationID})
+			}
+			if waited > s.lockTimeout {
+				s.lockStats.timeouts.Add(1)
+				s.emitEvent(eventLockTimeout, key, map[string]any{"correlation_id": correlationID, "waited": waited})
+				return fmt.Errorf("timeout acquiring lock for %s (lock held by another process)", key)
 			}
-			// Lock file exists but is expired, try to overwrite
-			s.logger.Debug("lock exists but is expired, attempting to overwrite", zap.String("key", key))
-		} else {
-			var nsk *types.NoSuchKey
-			var nf *types.NotFound // Some S3-compatibles (like MinIO) return NotFound for HeadObject
-			if !(errors.As(err, &nsk) || errors.As(err, &nf)) {
-				return fmt.Errorf("checking lock for %s: %w", key, err) // Unexpected error
+			if waited > s.lockWarnAfter && s.lockStats.warnOnce(key) {
+				logger.Warn("lock contended, another instance may be renewing the same certificate",
+					zap.String("key", key), zap.Duration("waited", waited))
 			}
-			// Lock file does not exist, try to create it
-			s.logger.Debug("lock does not exist, attempting to create", zap.String("key", key))
+			if err := s.lockBackoff.wait(ctx, attempt); err != nil {
+				return err
+			}
+			attempt++
+			continue // Retry loop
 		}
 
 		// Attempt to write/overwrite the lock file
 		// For more robust locking, consider S3 conditional Puts (If-Match/If-None-Match).
-		_, putErr := s.Client.PutObject(ctx, &awss3.PutObjectInput{
-			Bucket: aws.String(s.Bucket),
-			Key:    aws.String(lockObjectS3Key),
-			Body:   bytes.NewReader(lockContent),
-		})
-
+		lockContent := s.lockContent(time.Now(), correlationID) // Content for the lock file
+		putErr := s.putLock(ctx, lockObjectS3Key, lockContent)
 		if putErr == nil {
-			s.logger.Info("lock acquired", zap.String("key", key))
+			if s.legacyLocks() {
+				// Older instances only see locks at the legacy location.
+				if err := s.putLock(ctx, s.s3LegacyLockKey(key), lockContent); err != nil {
+					logger.Warn("writing legacy lock failed", zap.String("key", key), zap.Error(err))
+				}
+			}
+			logger.Info("lock acquired", zap.String("key", key))
+			s.countTakeover(logger, key, correlationID, expired)
+			s.lockStats.acquire(key, time.Since(startTime))
+			acquired = true
+			s.held.add(key)
 			return nil // Lock acquired
 		}
 
-		s.logger.Error("failed to put lock file, retrying", zap.String("key", key), zap.Error(putErr))
+		s.recordError("lock", key, putErr) // Retrying below
 		if time.Since(startTime) > s.lockTimeout {
-			return fmt.Errorf("timeout acquiring lock for %s after failed put: %w", key, putErr)
+			s.lockStats.timeouts.Add(1)
+			s.emitEvent(eventLockTimeout, key, map[string]any{"correlation_id": correlationID, "waited": time.Since(startTime)})
+			return fmt.Errorf("timeout acquiring lock for %s after failed put: %w", key, s3Error(putErr))
+		}
+		if err := s.lockBackoff.wait(ctx, attempt); err != nil {
+			return err
 		}
-		time.Sleep(s.lockPollInterval) // Wait before retrying
+		attempt++
+	}
+}
+
+// lockHeld reports whether the lock object at lockS3Key exists and has not expired, and whether it
+// exists but has expired.
+func (s *S3Storage) lockHeld(ctx context.Context, logger *zap.Logger, key, lockS3Key string) (held, expired bool, err error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+	headOut, err := s.Client.HeadObject(ctx, &awss3.HeadObjectInput{
+		Bucket: aws.String(s.lockBucket()),
+		Key:    aws.String(lockS3Key),
+	})
+	if err != nil {
+		if s.isNotFound(err) {
+			logger.Debug("lock does not exist", zap.String("key", key), zap.String("s3_lock_key", lockS3Key))
+			return false, false, nil
+		}
+		return false, false, err
+	}
+	if headOut.LastModified != nil && time.Since(*headOut.LastModified) < s.lockExpiration {
+		logger.Debug("lock exists and is active", zap.String("key", key), zap.String("s3_lock_key", lockS3Key),
+			zap.Time("lock_modified", *headOut.LastModified))
+		return true, false, nil
 	}
+	logger.Debug("lock exists but is expired, attempting to overwrite", zap.String("key", key), zap.String("s3_lock_key", lockS3Key))
+	return false, true, nil
+}
+
+// putLock writes a lock object; optFns may add preconditions.
+func (s *S3Storage) putLock(ctx context.Context, lockS3Key string, content []byte, optFns ...func(*awss3.PutObjectInput)) error {
+	ctx, cancel := s.opContext(ctx)
+	defer cancel()
+	input := &awss3.PutObjectInput{
+		Bucket:       aws.String(s.lockBucket()),
+		Key:          aws.String(lockS3Key),
+		Body:         bytes.NewReader(content),
+		StorageClass: types.StorageClass(s.StorageClass),
+		ContentType:  s.contentType(),
+		Tagging:      s.objectTagging(),
+		Metadata:     s.objectMetadata(nil),
+	}
+	for _, fn := range optFns {
+		fn(input)
+	}
+	_, err := s.Client.PutObject(ctx, input)
+	return err
 }
 
 // Unlock releases the lock for the given CertMagic key.
 func (s *S3Storage) Unlock(ctx context.Context, key string) error {
+	err := s.unlock(ctx, key)
+	s.auditOp(ctx, "unlock", key, 0, err)
+	return err
+}
+
+func (s *S3Storage) unlock(ctx context.Context, key string) error {
+	if s.ReadOnly {
+		return nil // Nothing was locked
+	}
 	lockObjectS3Key := s.s3LockKey(key)
-	s.logger.Debug("unlocking", zap.String("key", key), zap.String("s3_lock_key", lockObjectS3Key))
+	logger := s.opLogger(ctx, key)
+	defer s.traces.end(key)
+	defer s.held.remove(key)
+	logger.Debug("unlocking", zap.String("key", key), zap.String("s3_lock_key", lockObjectS3Key))
+	if s.httpLock != nil {
+		if err := s.httpLock.unlock(ctx, key); err != nil {
+			s.recordError("unlock", key, err)
+			return err
+		}
+		logger.Info("lock released", zap.String("key", key))
+		return nil
+	}
+	if s.legacyLocks() {
+		if err := s.deleteLock(ctx, s.s3LegacyLockKey(key)); err != nil {
+			logger.Warn("removing legacy lock failed", zap.String("key", key), zap.Error(err))
+		}
+	}
+	if err := s.deleteLock(ctx, lockObjectS3Key); err != nil {
+		s.recordError("unlock", key, err)
+		return fmt.Errorf("unlocking %s: %w", key, s3Error(err))
+	}
+	logger.Info("lock released", zap.String("key", key))
+	return nil
+}
+
+// deleteLock removes a lock object; a missing one is not an error, as it may have been released
+// already or never existed.
+func (s *S3Storage) deleteLock(ctx context.Context, lockS3Key string) error {
+	ctx, cancel := s.opContext(ctx)
+	defer cancel()
 	_, err := s.Client.DeleteObject(ctx, &awss3.DeleteObjectInput{
-		Bucket: aws.String(s.Bucket),
-		Key:    aws.String(lockObjectS3Key),
+		Bucket: aws.String(s.lockBucket()),
+		Key:    aws.String(lockS3Key),
 	})
-	if err != nil {
-		var nsk *types.NoSuchKey
-		if errors.As(err, &nsk) {
-			s.logger.Debug("lock file not found on unlock, already released or never existed", zap.String("key", key))
-			return nil // Not an error if it's already gone
-		}
-		return fmt.Errorf("unlocking %s: %w", key, err)
+	if err != nil && !s.isNotFound(err) {
+		return err
 	}
-	s.logger.Info("lock released", zap.String("key", key))
 	return nil
 }
 
 // Store stores the given value at the given CertMagic key.
 func (s *S3Storage) Store(ctx context.Context, key string, value []byte) error {
+	var err error
+	if s.journal != nil {
+		err = s.journalStore(ctx, key, value)
+	} else {
+		err = s.store(ctx, key, value)
+	}
+	s.auditOp(ctx, "store", key, int64(len(value)), err)
+	if data, ok := certEventData(key, false); ok && err == nil {
+		data["size"] = len(value)
+		s.emitEvent(eventCertStored, key, data)
+	}
+	if err == nil {
+		s.notifyCertStored(key)
+	}
+	return err
+}
+
+func (s *S3Storage) store(ctx context.Context, key string, value []byte) error {
+	if err := s.checkWritable("store", key); err != nil {
+		return err
+	}
+	s.noteWrite(key)
+	var staleOCSPBase string
+	if s.OCSPDelta && isOCSPStapleKey(key) {
+		encoded, staleBase, err := s.encodeOCSPDelta(ctx, key, value)
+		if err != nil {
+			return err
+		}
+		value, staleOCSPBase = encoded, staleBase
+	}
+
 	s3Key := s.s3ObjectKey(key)
-	s.logger.Debug("storing", zap.String("key", key), zap.String("s3_key", s3Key), zap.Int("size", len(value)))
+	s.invalidateReads(key, s3Key)
+	s.opLogger(ctx, key).Debug("storing", zap.String("key", key), zap.String("s3_key", s3Key), zap.Int("size", len(value)))
 
 	reader, length, err := s.iowrap.ByteReader(value) // Handles encryption if enabled
 	if err != nil {
 		return fmt.Errorf("preparing data for storing %s: %w", key, err)
 	}
+	var (
+		written  []byte // Kept for the round-trip check until it succeeded once
+		verify   []byte // Kept for verify_writes
+		internal map[string]string
+	)
+	if checkBody := !s.bodyChecked.Load(); checkBody || s.ChecksumAlgorithm != "" || s.VerifyWrites {
+		body, err := io.ReadAll(reader)
+		if err != nil {
+			return fmt.Errorf("preparing data for storing %s: %w", key, err)
+		}
+		reader = bytes.NewReader(body)
+		if checkBody {
+			written = body
+		}
+		if s.ChecksumAlgorithm != "" || s.VerifyWrites {
+			internal = bodyChecksumMetadata(body)
+		}
+		if s.VerifyWrites {
+			verify = body
+		}
+	}
 
-	_, err = s.Client.PutObject(ctx, &awss3.PutObjectInput{
-		Bucket:        aws.String(s.Bucket),
-		Key:           aws.String(s3Key),
-		Body:          reader,
-		ContentLength: aws.Int64(length), // Important for S3
-	})
+	defer func() {
+		if !isPreconditionFailed(err) { // Mirrored even if the primary write fails, unless it was refused
+			s.replicateStore(ctx, key, s3Key, value)
+		}
+	}()
+
+	var out *awss3.PutObjectOutput
+	if length == 0 && s.useEmptySentinel() {
+		written = nil // The sentinel body differs from the value
+		out, err = s.putEmptySentinel(ctx, s3Key)
+	} else {
+		input := &awss3.PutObjectInput{
+			Bucket:            aws.String(s.Bucket),
+			Key:               aws.String(s3Key),
+			Body:              reader,
+			ContentLength:     aws.Int64(length), // Important for S3
+			StorageClass:      types.StorageClass(s.StorageClass),
+			ContentType:       s.contentType(),
+			Tagging:           s.objectTagging(),
+			Metadata:          s.objectMetadata(internal),
+			ChecksumAlgorithm: s.checksumAlgorithm(),
+		}
+		applyPutCondition(ctx, input)
+		putCtx, cancel := s.opContext(ctx)
+		defer cancel()
+		out, err = s.Client.PutObject(putCtx, input)
+		switch {
+		case err != nil && length == 0:
+			written = nil
+			out, err = s.storeEmptyFallback(ctx, key, s3Key, err)
+		case err == nil && verify != nil:
+			out, err = s.verifyWrite(ctx, key, input, verify, out)
+		}
+	}
+	s.invalidateReads(key, s3Key) // Again, as reads may have loaded the old value during the write
 	if err != nil {
-		return fmt.Errorf("storing %s (s3://%s/%s): %w", key, s.Bucket, s3Key, err)
+		s.recordError("store", key, err)
+		return fmt.Errorf("storing %s (s3://%s/%s): %w", key, s.Bucket, s3Key, s3Error(err))
+	}
+	if written != nil {
+		if err := s.checkRoundTrip(ctx, key, s3Key, written, aws.ToString(out.ETag)); err != nil {
+			s.recordError("store", key, err)
+			return err
+		}
+	}
+	if out.VersionId != nil { // Versioned bucket; the ID allows restoring this value later
+		s.opLogger(ctx, key).Debug("stored version", zap.String("key", key), zap.String("version_id", *out.VersionId))
+	}
+	if s.existsCache != nil {
+		s.existsCache.stored(key)
+	}
+	s.storeRolloverSibling(ctx, key, s3Key, value)
+	s.manifestStore(ctx, key, length, out.ETag)
+	if s.prefetcher != nil && isCertificateKey(key) {
+		s.prefetcher.update(key, value)
+	}
+	s.mirrorStore(key, value)
+	if staleOCSPBase != "" {
+		s.deleteStaleOCSPBase(ctx, key, staleOCSPBase)
 	}
 	return nil
 }
 
 // Load retrieves the value at the given CertMagic key.
 func (s *S3Storage) Load(ctx context.Context, key string) ([]byte, error) {
+	if s.journal != nil {
+		if value, _, err := s.journalLoad(key); !errors.Is(err, fs.ErrNotExist) {
+			return value, err // Not in S3 yet
+		}
+	}
+	if s.cache != nil {
+		if data, ok := s.cache.get(key); ok {
+			return bytes.Clone(data), nil
+		}
+	}
+	data, shared, err := coalesce(ctx, &s.flights, flightLoad, s.s3ObjectKey(key), func(ctx context.Context) ([]byte, error) {
+		var gen uint64
+		if s.cache != nil {
+			gen = s.cache.generation()
+		}
+		data, err := s.load(ctx, key)
+		if err == nil && isOCSPStapleKey(key) && isDeltaEnvelope(data) {
+			data, err = s.decodeOCSPDelta(ctx, key, data)
+		}
+		if err == nil && s.cache != nil {
+			s.cache.put(key, bytes.Clone(data), gen)
+		}
+		return data, err
+	})
+	if shared && data != nil {
+		data = bytes.Clone(data)
+	}
+	return data, err
+}
+
+// load retrieves the stored (decrypted) object for the given CertMagic key.
+func (s *S3Storage) load(ctx context.Context, key string) ([]byte, error) {
 	s3Key := s.s3ObjectKey(key)
-	s.logger.Debug("loading", zap.String("key", key), zap.String("s3_key", s3Key))
+	s.opLogger(ctx, key).Debug("loading", zap.String("key", key), zap.String("s3_key", s3Key))
 
-	result, err := s.Client.GetObject(ctx, &awss3.GetObjectInput{
-		Bucket: aws.String(s.Bucket),
-		Key:    aws.String(s3Key),
+	result, cancel, err := readFrom(ctx, s, "load", key, func(ctx context.Context, client S3API, bucket string) (*awss3.GetObjectOutput, error) {
+		return client.GetObject(ctx, &awss3.GetObjectInput{
+			Bucket:       aws.String(bucket),
+			Key:          aws.String(s3Key),
+			ChecksumMode: s.checksumMode(),
+		})
 	})
+	defer cancel() // The read timeout also bounds reading the body
 	if err != nil {
-		var nsk *types.NoSuchKey
-		if errors.As(err, &nsk) {
+		if s.isNotFound(err) {
+			s.recordPrimaryRead(nil)
 			return nil, fs.ErrNotExist // CertMagic expects fs.ErrNotExist
 		}
-		return nil, fmt.Errorf("loading %s (s3://%s/%s): %w", key, s.Bucket, s3Key, err)
+		s.recordError("load", key, err)
+		s.recordPrimaryRead(err)
+		if sources := s.fallbackSources(); len(sources) > 0 {
+			data, fallbackErr := s.loadFromFallbacks(ctx, sources, key, s3Key)
+			if fallbackErr == nil || errors.Is(fallbackErr, fs.ErrNotExist) {
+				return data, fallbackErr
+			}
+		}
+		if s.mirror != nil {
+			data, mirrorErr := s.mirrorLoad(key)
+			if mirrorErr == nil {
+				s.opLogger(ctx, key).Warn("S3 unavailable, serving from local fallback cache", zap.String("key", key), zap.Error(err))
+				return data, nil
+			}
+		}
+		return nil, fmt.Errorf("loading %s (s3://%s/%s): %w", key, s.Bucket, s3Key, s3Error(err))
 	}
 	defer result.Body.Close()
+	s.recordPrimaryRead(nil)
+	if isTombstone(result.Metadata) {
+		return nil, fs.ErrNotExist
+	}
+	if isEmptySentinel(result.Metadata) {
+		return []byte{}, nil
+	}
 
-	decryptedReader := s.iowrap.WrapReader(result.Body) // Handles decryption
+	var body io.Reader = result.Body
+	if s.downloadInParts(result.ContentLength) {
+		partsCtx, cancel := s.readContext(ctx)
+		defer cancel()
+		raw, err := s.downloadParts(partsCtx, s3Key, result)
+		if err != nil {
+			s.recordError("load", key, err)
+			return nil, fmt.Errorf("loading %s in parts (s3://%s/%s): %w", key, s.Bucket, s3Key, s3Error(err))
+		}
+		body = bytes.NewReader(raw)
+	}
+	verifier := newVerifyingReader(body, result.Metadata)
+	if verifier != nil {
+		body = verifier
+	}
+	decryptedReader := s.iowrap.WrapReader(body) // Handles decryption
 	data, err := io.ReadAll(decryptedReader)
+	if verifier != nil {
+		if verifyErr := verifier.verify(); verifyErr != nil {
+			err = verifyErr
+		}
+	}
+	if isChecksumMismatch(err) {
+		err = fmt.Errorf("%w: %w", ErrIntegrity, err)
+	}
+	if errors.Is(err, ErrIntegrity) {
+		s.recordError("load", key, err)
+		return nil, fmt.Errorf("loading %s (s3://%s/%s): %w", key, s.Bucket, s3Key, err)
+	}
 	if err != nil {
-		// Check if the error came from our errorReader (e.g., decryption failed)
-		var er *errorReader
-		if errors.As(err, &er) {
-			return nil, fmt.Errorf("reading/decrypting data for %s: %w", key, er.err)
+		// Objects written during a key rollover window have a sibling encrypted with the new key.
+		if sibling, siblingErr := s.loadRolloverSibling(ctx, s3Key); siblingErr == nil {
+			s.mirrorLoaded(key, sibling, aws.ToTime(result.LastModified))
+			return sibling, nil
 		}
-		return nil, fmt.Errorf("reading data for %s: %w", key, err)
+		// Errors from the IO wrapper (e.g., decryption failed) surface here as well.
+		return nil, fmt.Errorf("reading/decrypting data for %s: %w", key, err)
 	}
+	s.mirrorLoaded(key, data, aws.ToTime(result.LastModified)) // Also mirrors objects written before the cache was enabled
 	return data, nil
 }
 
-// Delete deletes the value at the given CertMagic key.
+// Delete deletes the value at the given CertMagic key, or everything below it if there is no
+// value at the key. S3 failures are only returned with strict_delete; deleting a missing key
+// always succeeds, deleting the empty key always fails.
 func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	err := s.deleteValue(ctx, key)
+	s.auditOp(ctx, "delete", key, 0, err)
+	if data, ok := certEventData(key, true); ok && err == nil {
+		s.emitEvent(eventCertDeleted, key, data)
+	}
+	return err
+}
+
+func (s *S3Storage) deleteValue(ctx context.Context, key string) error {
+	if strings.Trim(key, "/") == "" {
+		return errors.New("refusing to delete the whole storage: empty key")
+	}
+	if err := s.checkWritable("delete", key); err != nil {
+		return err
+	}
 	s3Key := s.s3ObjectKey(key)
-	s.logger.Debug("deleting", zap.String("key", key), zap.String("s3_key", s3Key))
+	s.opLogger(ctx, key).Debug("deleting", zap.String("key", key), zap.String("s3_key", s3Key))
+	s.invalidateReads(key, s3Key)
+	s.noteWrite(key)
+	if s.journal != nil { // A pending write must not bring the value back
+		unlock := s.journal.lockUpload(key)
+		defer unlock()
+		if err := s.journal.remove(key); err != nil {
+			s.logger.Warn("removing from the write journal failed", zap.String("key", key), zap.Error(err))
+		}
+	}
 
-	_, err := s.Client.DeleteObject(ctx, &awss3.DeleteObjectInput{
+	// A key without an object may be a "directory", which CertMagic expects to be removed with
+	// everything below it. If S3 can't tell, both are deleted.
+	missing, err := s.objectMissing(ctx, s3Key)
+	if err != nil {
+		s.recordError("delete", key, err)
+		if s.StrictDelete {
+			return fmt.Errorf("deleting %s (s3://%s/%s): %w", key, s.Bucket, s3Key, s3Error(err))
+		}
+	}
+	if !missing || err != nil {
+		if err := s.deleteObject(ctx, key, s3Key); err != nil {
+			return err
+		}
+	}
+	s.replicateDelete(ctx, key, s3Key)
+	s.mirrorDelete(key)
+	s.manifestDelete(ctx, key)
+	s.invalidateReads(key, s3Key) // Again, as reads may have loaded the old value during the delete
+	if s.prefetcher != nil {
+		s.prefetcher.remove(key)
+	}
+	if s.rollover != nil {
+		s.deleteRolloverSibling(ctx, key, s3Key)
+	}
+
+	if isOCSPStapleKey(key) { // Remove the delta bases along with the staple
+		if err := s.Delete(ctx, ocspBaseKey(key)); err != nil { // The legacy base, or else the directory
+			return err
+		}
+		if _, err := s.DeleteAll(ctx, ocspBaseKey(key)); err != nil {
+			s.recordError("delete", ocspBaseKey(key), err)
+			if s.StrictDelete {
+				return err
+			}
+		}
+	}
+
+	if missing || err != nil {
+		if _, err := s.DeleteAll(ctx, key); err != nil {
+			s.recordError("delete", key, err)
+			if s.StrictDelete {
+				return err
+			}
+		}
+	}
+	return nil // Typically, CertMagic expects nil even if the object didn't exist.
+}
+
+// objectMissing reports whether the primary bucket has no object at s3Key.
+func (s *S3Storage) objectMissing(ctx context.Context, s3Key string) (bool, error) {
+	headCtx, cancel := s.readContext(ctx)
+	defer cancel()
+	_, err := s.Client.HeadObject(headCtx, &awss3.HeadObjectInput{
 		Bucket: aws.String(s.Bucket),
 		Key:    aws.String(s3Key),
 	})
 	if err != nil {
-		// CertMagic often doesn't treat "not found" on delete as an error.
-		// We log it but return nil to align with typical expectations.
-		s.logger.Warn("error deleting object from S3, may or may not be an issue depending on context",
-			zap.String("key", key), zap.String("s3_key", s3Key), zap.Error(err))
+		if s.isNotFound(err) {
+			return true, nil
+		}
+		return false, err
 	}
-	return nil // Typically, CertMagic expects nil even if the object didn't exist.
+	return false, nil
 }
 
-// Exists returns true if the given CertMagic key exists.
+// deleteObject moves the object at key to the trash, if enabled, and deletes it.
+func (s *S3Storage) deleteObject(ctx context.Context, key, s3Key string) error {
+	if err := s.moveToTrash(ctx, key); err != nil {
+		return err
+	}
+	delCtx, cancel := s.opContext(ctx)
+	_, err := s.Client.DeleteObject(delCtx, &awss3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s3Key),
+	})
+	cancel()
+	if err == nil || s.isNotFound(err) {
+		return nil
+	}
+	// Without strict_delete, failures are recorded but not returned, as CertMagic's cleanups
+	// expect; a missing key is never an error.
+	s.recordError("delete", key, err)
+	if s.objectLock != nil && classifyError(err) == errorClassAccessDenied {
+		return s.deleteDenied(ctx, key, s3Key, err)
+	}
+	if s.StrictDelete {
+		return fmt.Errorf("deleting %s (s3://%s/%s): %w", key, s.Bucket, s3Key, s3Error(err))
+	}
+	return nil
+}
+
+// Exists returns true if the given CertMagic key exists. When S3 cannot answer, the local fallback
+// cache decides if configured; otherwise the result is exists_on_error (false by default). Setting it
+// makes CertMagic go on to Load the key, which then fails visibly instead of triggering a re-issuance.
 func (s *S3Storage) Exists(ctx context.Context, key string) bool {
+	exists, err := s.ExistsErr(ctx, key)
+	if err == nil {
+		return exists
+	}
+	if s.mirror != nil {
+		_, statErr := s.mirror.stat(key)
+		return statErr == nil
+	}
+	return s.ExistsOnError
+}
+
+// ExistsErr is like Exists, but reports failures to determine whether the key exists as error.
+func (s *S3Storage) ExistsErr(ctx context.Context, key string) (bool, error) {
+	if s.journal != nil && s.journal.has(key) {
+		return true, nil
+	}
+	var gen uint64
+	if s.existsCache != nil {
+		exists, ok, g := s.existsCache.get(key)
+		if ok {
+			return exists, nil
+		}
+		gen = g
+	}
+	exists, _, err := coalesce(ctx, &s.flights, flightExists, s.s3ObjectKey(key), func(ctx context.Context) (bool, error) {
+		return s.existsErr(ctx, key)
+	})
+	if err == nil && s.existsCache != nil {
+		s.existsCache.put(key, exists, gen)
+	}
+	return exists, err
+}
+
+func (s *S3Storage) existsErr(ctx context.Context, key string) (bool, error) {
 	s3Key := s.s3ObjectKey(key)
-	s.logger.Debug("checking exists", zap.String("key", key), zap.String("s3_key", s3Key))
+	logger := s.opLogger(ctx, key)
+	logger.Debug("checking exists", zap.String("key", key), zap.String("s3_key", s3Key))
 
-	_, err := s.Client.HeadObject(ctx, &awss3.HeadObjectInput{
-		Bucket: aws.String(s.Bucket),
-		Key:    aws.String(s3Key),
+	head, cancel, err := readFrom(ctx, s, "exists", key, func(ctx context.Context, client S3API, bucket string) (*awss3.HeadObjectOutput, error) {
+		return client.HeadObject(ctx, &awss3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(s3Key),
+		})
 	})
+	defer cancel()
 	if err != nil {
-		var nsk *types.NoSuchKey
-		var nf *types.NotFound // Some S3-compatibles might return NotFound
-		if errors.As(err, &nsk) || errors.As(err, &nf) {
-			return false // Key does not exist
+		if s.isNotFound(err) {
+			s.recordPrimaryRead(nil)
+			return false, nil // Key does not exist
+		}
+		s.recordError("exists", key, err)
+		s.recordPrimaryRead(err)
+		if sources := s.fallbackSources(); len(sources) > 0 {
+			if exists, fallbackErr := s.existsInFallbacks(ctx, sources, key, s3Key); fallbackErr == nil {
+				return exists, nil
+			}
+		}
+		err = s3Error(err)
+		if class := classifyError(err); class != errorClassOther {
+			// Not knowing whether certificates exist may lead to mass re-issuance, so don't be quiet.
+			logger.Error("cannot determine whether key exists",
+				zap.String("key", key), zap.String("cause", class), zap.Error(err))
 		}
-		// For other errors, log it and conservatively return false.
-		s.logger.Error("error checking existence for key", zap.String("key", key), zap.Error(err))
-		return false
+		return false, fmt.Errorf("checking existence of %s (s3://%s/%s): %w", key, s.Bucket, s3Key, err)
 	}
-	return true // HeadObject succeeded, so key exists
+	s.recordPrimaryRead(nil)
+	return !isTombstone(head.Metadata), nil // HeadObject succeeded, so key exists unless deleted
 }
 
 // List returns a list of CertMagic keys that match the given prefix.
 func (s *S3Storage) List(ctx context.Context, listPrefix string, recursive bool) ([]string, error) {
+	if s.manifest != nil {
+		return s.listFromManifest(ctx, listPrefix, recursive)
+	}
+	var keys []string
+	err := s.listPages(ctx, listPrefix, recursive, func(page []string) error {
+		keys = append(keys, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// listPages lists like List, calling fn with the keys of each page of list_page_size objects as it
+// arrives, so callers can process large listings without holding them in memory. An error
+// returned by fn stops the listing and is returned as is.
+func (s *S3Storage) listPages(ctx context.Context, listPrefix string, recursive bool, fn func(keys []string) error) error {
+	if s.shardedListing(ctx, listPrefix) {
+		listed := make(map[string]bool) // Directories present in several shards, if not recursive
+		return s.fanOut(ctx, func(ctx context.Context) error {
+			return s.listBucketPages(ctx, listPrefix, recursive, nil, func(keys []string) error {
+				if !recursive {
+					keys = slices.DeleteFunc(keys, func(key string) bool {
+						dup := listed[key]
+						listed[key] = true
+						return dup
+					})
+				}
+				return fn(keys)
+			})
+		})
+	}
+	below := s.routesBelow(listPrefix)
+	if len(below) == 0 {
+		return s.listBucketPages(ctx, listPrefix, recursive, nil, fn)
+	}
+	listed := make(map[string]bool) // Keys listed so far, if not recursive
+	err := s.listBucketPages(ctx, listPrefix, recursive, below, func(keys []string) error {
+		if !recursive {
+			for _, key := range keys {
+				listed[key] = true
+			}
+		}
+		return fn(keys)
+	})
+	if err != nil {
+		return err
+	}
+	for _, route := range below {
+		if recursive {
+			if err := s.listPages(ctx, route, true, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		// The directory of the route at this level, unless listed already or the route is empty
+		parent := strings.Trim(listPrefix, "/")
+		first, _, _ := strings.Cut(strings.TrimPrefix(route, parent+"/"), "/")
+		dir := path.Join(parent, first)
+		if listed[dir] {
+			continue
+		}
+		empty := true
+		err := s.listPages(ctx, route, true, func(keys []string) error {
+			if len(keys) > 0 {
+				empty = false
+				return errStopList
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errStopList) {
+			return err
+		}
+		if empty {
+			continue
+		}
+		listed[dir] = true
+		if err := fn([]string{dir}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listBucketPages lists the keys of one bucket for listPages, skipping those of the given routes.
+// Recursive listings are split by first-level directory with list_concurrency.
+func (s *S3Storage) listBucketPages(ctx context.Context, listPrefix string, recursive bool, routes []string, fn func(keys []string) error) error {
+	if recursive && s.ListConcurrency > 1 {
+		return s.listParallel(ctx, listPrefix, routes, fn)
+	}
+	return s.listBucketSequential(ctx, listPrefix, recursive, routes, fn)
+}
+
+// listBucketSequential lists page after page for listBucketPages.
+func (s *S3Storage) listBucketSequential(ctx context.Context, listPrefix string, recursive bool, routes []string, fn func(keys []string) error) error {
 	// s3ObjectKey will handle adding the main storage prefix.
 	// listPrefix is the prefix *within* the CertMagic storage view.
 	s3ListPrefix := s.s3ObjectKey(listPrefix)
@@ -217,16 +789,17 @@ func (s *S3Storage) List(ctx context.Context, listPrefix string, recursive bool)
 		zap.String("s3_resolved_list_prefix", s3ListPrefix),
 		zap.Bool("recursive", recursive))
 
-	var keys []string
 	var delimiter *string
 	if !recursive {
 		delimiter = aws.String("/") // S3's way of listing one level
 	}
 
-	paginator := awss3.NewListObjectsV2Paginator(s.Client, &awss3.ListObjectsV2Input{
-		Bucket:    aws.String(s.Bucket),
+	client, bucket := s.reader()
+	paginator := awss3.NewListObjectsV2Paginator(client, &awss3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
 		Prefix:    aws.String(s3ListPrefix),
 		Delimiter: delimiter,
+		MaxKeys:   s.listPageSize(),
 	})
 
 	// This is the prefix we need to strip from full S3 keys to get back to CertMagic keys.
@@ -237,11 +810,15 @@ func (s *S3Storage) List(ctx context.Context, listPrefix string, recursive bool)
 	}
 
 	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
+		pageCtx, cancel := s.listContext(ctx) // Each page is its own S3 request
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
 		if err != nil {
-			return nil, fmt.Errorf("listing s3://%s/%s: %w", s.Bucket, s3ListPrefix, err)
+			s.recordError("list", listPrefix, err)
+			return fmt.Errorf("listing s3://%s/%s: %w", bucket, s3ListPrefix, s3Error(err))
 		}
 
+		var keys []string
 		// Add common prefixes (directories) if not recursive
 		if !recursive {
 			for _, cp := range page.CommonPrefixes {
@@ -249,7 +826,7 @@ func (s *S3Storage) List(ctx context.Context, listPrefix string, recursive bool)
 					// S3 common prefixes include the full path. Make it relative to CertMagic root.
 					key := strings.TrimPrefix(*cp.Prefix, stripPrefixFromS3Key)
 					key = strings.TrimSuffix(key, "/") // CertMagic expects dir names without trailing slash
-					if key != "" && !strings.HasSuffix(key, ".lock") {
+					if key != "" && !s.isHiddenKey(key) && !routedAway(key, routes) {
 						keys = append(keys, key)
 					}
 				}
@@ -265,36 +842,56 @@ func (s *S3Storage) List(ctx context.Context, listPrefix string, recursive bool)
 					continue
 				}
 				key := strings.TrimPrefix(*obj.Key, stripPrefixFromS3Key)
-				if key != "" && !strings.HasSuffix(key, ".lock") {
+				if key != "" && !s.isHiddenKey(key) && !routedAway(key, routes) {
 					keys = append(keys, key)
 				}
 			}
 		}
+		if err := fn(keys); err != nil {
+			return err
+		}
 	}
-	return keys, nil
+	return nil
 }
 
 // Stat returns information about the given CertMagic key.
 func (s *S3Storage) Stat(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+	ki, _, err := coalesce(ctx, &s.flights, flightStat, s.s3ObjectKey(key), func(ctx context.Context) (certmagic.KeyInfo, error) {
+		return s.stat(ctx, key)
+	})
+	return ki, err
+}
+
+func (s *S3Storage) stat(ctx context.Context, key string) (certmagic.KeyInfo, error) {
 	s3Key := s.s3ObjectKey(key)
-	s.logger.Debug("stat", zap.String("key", key), zap.String("s3_key", s3Key))
+	s.opLogger(ctx, key).Debug("stat", zap.String("key", key), zap.String("s3_key", s3Key))
 	var ki certmagic.KeyInfo
 
-	result, err := s.Client.HeadObject(ctx, &awss3.HeadObjectInput{
-		Bucket: aws.String(s.Bucket),
-		Key:    aws.String(s3Key),
+	result, cancel, err := readFrom(ctx, s, "stat", key, func(ctx context.Context, client S3API, bucket string) (*awss3.HeadObjectOutput, error) {
+		return client.HeadObject(ctx, &awss3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(s3Key),
+		})
 	})
+	defer cancel()
 	if err != nil {
-		var nsk *types.NoSuchKey
-		var nf *types.NotFound
-		if errors.As(err, &nsk) || errors.As(err, &nf) {
+		if s.isNotFound(err) {
 			return ki, fs.ErrNotExist // CertMagic expects fs.ErrNotExist
 		}
-		return ki, fmt.Errorf("stat %s (s3://%s/%s): %w", key, s.Bucket, s3Key, err)
+		s.recordError("stat", key, err)
+		if s.mirror != nil {
+			if mki, statErr := s.mirror.stat(key); statErr == nil {
+				return mki, nil
+			}
+		}
+		return ki, fmt.Errorf("stat %s (s3://%s/%s): %w", key, s.Bucket, s3Key, s3Error(err))
 	}
 
+	if isTombstone(result.Metadata) {
+		return ki, fs.ErrNotExist
+	}
 	ki.Key = key // CertMagic expects the original, unprefixed key
-	if result.ContentLength != nil {
+	if result.ContentLength != nil && !isEmptySentinel(result.Metadata) {
 		ki.Size = *result.ContentLength
 	}
 	if result.LastModified != nil {
@@ -0,0 +1,267 @@
+package s3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+// Other instances only notice a certificate renewed by one instance of a fleet when their own
+// maintenance reloads it. With cert_notifications, the instance storing a certificate publishes
+// a message to an SNS topic, and every instance consumes the messages of its own SQS queue
+// subscribed to that topic: it drops its cached copies of the site's files, emits the
+// s3_storage.cert_updated event and calls OnCertificateUpdate, which Go programs can point at
+// ReloadCertificate to swap the certificate in their CertMagic cache right away.
+//
+// Messages are published once the site's metadata (certificates/<issuer>/<name>/<name>.json),
+// the last of the files CertMagic writes for a certificate, was stored. An instance ignores its
+// own messages. Publishing is asynchronous and best effort: a lost message only delays the
+// update until the certificate is reloaded anyway.
+
+// eventCertNotification is the type of the messages published to the topic.
+const eventCertNotification = "cert_stored"
+
+// CertNotificationConfig configures propagating stored certificates to the other instances.
+type CertNotificationConfig struct {
+	TopicARN string `json:"topic_arn,omitempty"` // SNS topic stored certificates are published to
+	QueueURL string `json:"queue_url,omitempty"` // SQS queue of this instance, subscribed to the topic
+	Endpoint string `json:"endpoint,omitempty"`  // SNS endpoint, e.g. for LocalStack; derived from the ARN by default
+}
+
+// certNotification is a message published for a stored certificate.
+type certNotification struct {
+	Event     string    `json:"event"`
+	Bucket    string    `json:"bucket"`
+	Prefix    string    `json:"prefix"`
+	IssuerKey string    `json:"issuer_key"`
+	Name      string    `json:"name"`
+	Sender    string    `json:"sender"` // Instance that stored the certificate, see notificationSender
+	Time      time.Time `json:"time"`
+}
+
+// certNotifier holds the AWS config used to publish and receive notifications.
+type certNotifier struct {
+	publish aws.Config
+	sender  string
+}
+
+// notificationSender identifies this process in notifications.
+func notificationSender() string {
+	owner := processIdentity()
+	return fmt.Sprintf("%s/%d/%s", owner.Hostname, owner.PID, owner.InstanceID)
+}
+
+// startCertNotifications validates the configuration and starts consuming the queue until ctx
+// is done.
+func (s *S3Storage) startCertNotifications(ctx caddy.Context) error {
+	cfg := s.CertNotifications
+	if cfg.TopicARN == "" && cfg.QueueURL == "" {
+		return errors.New("cert_notifications: topic_arn or queue_url must be specified")
+	}
+	n := &certNotifier{sender: notificationSender()}
+	if cfg.TopicARN != "" {
+		if !strings.HasPrefix(cfg.TopicARN, "arn:") {
+			return fmt.Errorf("cert_notifications: invalid topic_arn '%s'", cfg.TopicARN)
+		}
+		awsCfg, err := s.loadAWSConfig(snsRegion(cfg.TopicARN, s.Region), s.AccessKeyID, s.SecretAccessKey, s.SessionToken)
+		if err != nil {
+			return fmt.Errorf("cert_notifications: %w", err)
+		}
+		n.publish = awsCfg
+	}
+	if cfg.QueueURL != "" {
+		awsCfg, err := s.loadAWSConfig(sqsRegion(cfg.QueueURL, s.Region), s.AccessKeyID, s.SecretAccessKey, s.SessionToken)
+		if err != nil {
+			return fmt.Errorf("cert_notifications: %w", err)
+		}
+		go s.pollSQS(ctx, awsCfg, cfg.QueueURL, s.handleCertNotification)
+	}
+	s.certNotifier = n
+	s.logger.Info("certificate notifications active", zap.String("topic_arn", cfg.TopicARN), zap.String("queue_url", cfg.QueueURL))
+	return nil
+}
+
+// snsRegion returns the region of a topic ARN like arn:aws:sns:eu-west-1:123:name.
+func snsRegion(topicARN, fallback string) string {
+	parts := strings.Split(topicARN, ":")
+	if len(parts) >= 6 && parts[3] != "" {
+		return parts[3]
+	}
+	return fallback
+}
+
+// isCertificateMetaKey reports whether key is the metadata of a site certificate, which
+// CertMagic stores last.
+func isCertificateMetaKey(key string) bool {
+	parts := strings.Split(key, "/")
+	return len(parts) == 4 && parts[0] == "certificates" && parts[3] == parts[2]+".json"
+}
+
+// notifyCertStored publishes a notification for a stored key in the background, if it completes
+// a site certificate.
+func (s *S3Storage) notifyCertStored(key string) {
+	if s.certNotifier == nil || s.CertNotifications.TopicARN == "" || !isCertificateMetaKey(key) {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(s.caddyCtx, time.Minute)
+		defer cancel()
+		if err := s.publishCertNotification(ctx, key); err != nil && ctx.Err() == nil {
+			s.logger.Warn("publishing certificate notification failed", zap.String("key", key), zap.Error(err))
+		}
+	}()
+}
+
+// publishCertNotification publishes the notification of a certificate's metadata key.
+func (s *S3Storage) publishCertNotification(ctx context.Context, key string) error {
+	parts := strings.Split(key, "/")
+	message, err := json.Marshal(certNotification{
+		Event:     eventCertNotification,
+		Bucket:    s.Bucket,
+		Prefix:    s.Prefix,
+		IssuerKey: parts[1],
+		Name:      parts[2],
+		Sender:    s.certNotifier.sender,
+		Time:      time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	cfg := s.CertNotifications
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://sns." + s.certNotifier.publish.Region + ".amazonaws.com/"
+	}
+	body := url.Values{
+		"Action":   {"Publish"},
+		"Version":  {"2010-03-31"},
+		"TopicArn": {cfg.TopicARN},
+		"Message":  {string(message)},
+	}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	sum := sha256.Sum256([]byte(body))
+	if err := signRequest(ctx, s.certNotifier.publish, req, hex.EncodeToString(sum[:]), "sns"); err != nil {
+		return err
+	}
+	resp, err := s.certNotifier.publish.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxNotificationSize))
+		return fmt.Errorf("Publish: %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	s.logger.Debug("published certificate notification", zap.String("key", key))
+	return nil
+}
+
+// handleCertNotification handles a message received from the queue, which may be wrapped by SNS.
+func (s *S3Storage) handleCertNotification(ctx context.Context, body []byte) error {
+	var msg snsMessage
+	if json.Unmarshal(body, &msg) == nil && msg.Type != "" {
+		if msg.Type != "Notification" {
+			return nil
+		}
+		body = []byte(msg.Message)
+	}
+	var n certNotification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return fmt.Errorf("decoding certificate notification: %w", err)
+	}
+	switch {
+	case n.Event != eventCertNotification || n.Bucket != s.Bucket || n.Prefix != s.Prefix:
+		return nil // Another storage sharing the topic
+	case n.IssuerKey == "" || n.Name == "" || strings.Contains(n.IssuerKey+n.Name, "/"):
+		return fmt.Errorf("invalid certificate notification for '%s/%s'", n.IssuerKey, n.Name)
+	case s.certNotifier != nil && n.Sender == s.certNotifier.sender:
+		return nil
+	}
+
+	dir := "certificates/" + n.IssuerKey + "/" + n.Name + "/" + n.Name
+	for _, key := range []string{dir + ".crt", dir + ".key", dir + ".json"} {
+		if s.cache != nil {
+			s.cache.invalidate(key)
+		}
+		if s.existsCache != nil {
+			s.existsCache.remove(key)
+		}
+		s.forgetFlights(s.s3ObjectKey(key))
+	}
+	s.logger.Info("certificate updated by another instance",
+		zap.String("issuer_key", n.IssuerKey), zap.String("name", n.Name), zap.String("sender", n.Sender))
+	s.emitEvent(eventCertUpdated, dir+".crt", map[string]any{"issuer_key": n.IssuerKey, "name": n.Name, "sender": n.Sender})
+	if s.OnCertificateUpdate != nil {
+		s.OnCertificateUpdate(ctx, n.IssuerKey, n.Name)
+	}
+	return nil
+}
+
+// ReloadCertificate replaces the managed certificate of name in cache, which cfg must use, with
+// the one in cfg's storage, e.g. from OnCertificateUpdate. The new certificate is cached before
+// the old one is removed, so handshakes are served throughout.
+func ReloadCertificate(ctx context.Context, cache *certmagic.Cache, cfg *certmagic.Config, name string) error {
+	cert, err := cfg.CacheManagedCertificate(ctx, name)
+	if err != nil {
+		return fmt.Errorf("reloading certificate of %s: %w", name, err)
+	}
+	var stale []string
+	for _, c := range cache.AllMatchingCertificates(name) {
+		if c.Hash() != cert.Hash() && slices.Contains(c.Names, name) {
+			stale = append(stale, c.Hash())
+		}
+	}
+	cache.Remove(stale)
+	return nil
+}
+
+// unmarshalCertNotifications parses the cert_notifications block:
+//
+//	cert_notifications {
+//		topic_arn arn:aws:sns:eu-west-1:123456789012:certificates
+//		queue_url https://sqs.eu-west-1.amazonaws.com/123456789012/caddy-1
+//	}
+func (s *S3Storage) unmarshalCertNotifications(d *caddyfile.Dispenser) error {
+	cfg := new(CertNotificationConfig)
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		key := d.Val()
+		var value string
+		if !d.AllArgs(&value) {
+			return d.ArgErr()
+		}
+		switch key {
+		case "topic_arn":
+			cfg.TopicARN = value
+		case "queue_url":
+			cfg.QueueURL = value
+		case "endpoint":
+			cfg.Endpoint = value
+		default:
+			return d.Errf("unrecognized cert_notifications subdirective '%s'", key)
+		}
+	}
+	s.CertNotifications = cfg
+	return nil
+}
@@ -0,0 +1,94 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+func TestCertNotifications(t *testing.T) {
+	published := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "/sns/aws4_request") {
+			http.Error(w, "unsigned", http.StatusForbidden)
+			return
+		}
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("Action") != "Publish" || r.PostForm.Get("TopicArn") != "arn:aws:sns:eu-west-1:123456789012:certs" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		published <- r.PostForm.Get("Message")
+	}))
+	defer srv.Close()
+
+	newStorage := func(sender string) *S3Storage {
+		s := newInvalidationTestStorage()
+		s.CertNotifications = &CertNotificationConfig{TopicARN: "arn:aws:sns:eu-west-1:123456789012:certs", Endpoint: srv.URL}
+		s.certNotifier = &certNotifier{
+			sender: sender,
+			publish: aws.Config{
+				Region:      snsRegion(s.CertNotifications.TopicARN, ""),
+				Credentials: credentials.NewStaticCredentialsProvider("key", "secret", ""),
+				HTTPClient:  srv.Client(),
+			},
+		}
+		return s
+	}
+	a, b := newStorage("a"), newStorage("b")
+	var updated []string
+	b.OnCertificateUpdate = func(_ context.Context, issuerKey, name string) { updated = append(updated, issuerKey+"/"+name) }
+	dir := "certificates/acme/example.com/example.com"
+	for _, key := range []string{dir + ".crt", dir + ".key", "certificates/acme/other.com/other.com.crt"} {
+		b.cache.put(key, []byte("stale"), b.cache.generation())
+	}
+
+	if isCertificateMetaKey(dir+".crt") || !isCertificateMetaKey(dir+".json") {
+		t.Fatal("only the metadata completes a certificate")
+	}
+	ctx := context.Background()
+	if err := a.publishCertNotification(ctx, dir+".json"); err != nil {
+		t.Fatal(err)
+	}
+	var message string
+	select {
+	case message = <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing published")
+	}
+
+	// Delivered by SQS in the SNS envelope
+	envelope, _ := json.Marshal(snsMessage{Type: "Notification", Message: message})
+	if err := a.handleCertNotification(ctx, envelope); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.cache.get("certificates/a.crt"); !ok {
+		t.Error("sender handled its own notification")
+	}
+	if err := b.handleCertNotification(ctx, envelope); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{dir + ".crt": false, dir + ".key": false, "certificates/acme/other.com/other.com.crt": true} {
+		if _, ok := b.cache.get(key); ok != want {
+			t.Errorf("%s cached = %v, want %v", key, ok, want)
+		}
+	}
+	if len(updated) != 1 || updated[0] != "acme/example.com" {
+		t.Errorf("OnCertificateUpdate calls = %v", updated)
+	}
+
+	// Raw message delivery, for another prefix
+	other := strings.Replace(message, `"prefix":"certmagic"`, `"prefix":"other"`, 1)
+	if err := b.handleCertNotification(ctx, []byte(other)); err != nil || len(updated) != 1 {
+		t.Errorf("notification of another prefix: %v, updates %v", err, updated)
+	}
+	if err := b.handleCertNotification(ctx, []byte("not json")); err == nil {
+		t.Error("expected an error for an undecodable message")
+	}
+}
@@ -0,0 +1,121 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// newClient creates an S3 client for the given region/endpoint, using static credentials if
+// both parts are given and the configured credential source (see credentials.go) otherwise.
+// The retry policy of the storage applies to every client. Each client gets its own config and
+// credential cache; see httpClient for the HTTP client. optFns apply after the storage's options,
+// and the APIOptions and ClientOptions of library users after those.
+func (s *S3Storage) newClient(region, endpoint, accessKeyID, secretAccessKey, sessionToken string, optFns ...func(*awss3.Options)) (*awss3.Client, error) {
+	awsCfg, err := s.loadAWSConfig(region, accessKeyID, secretAccessKey, sessionToken)
+	if err != nil {
+		return nil, err
+	}
+
+	s3ClientOpts := []func(*awss3.Options){s.providerClientOptions, s.ssecClientOptions, s.objectLockClientOptions, s.requesterPaysClientOptions, s.routerClientOptions, s.shardClientOptions, s.mrapClientOptions}
+	if s.requests != nil {
+		s3ClientOpts = append(s3ClientOpts, func(o *awss3.Options) {
+			o.APIOptions = append(o.APIOptions, s.requests.register)
+		})
+	}
+	if s.limiter != nil {
+		s3ClientOpts = append(s3ClientOpts, func(o *awss3.Options) {
+			o.APIOptions = append(o.APIOptions, s.limiter.register)
+		})
+	}
+	if endpoint != "" {
+		s3ClientOpts = append(s3ClientOpts, func(o *awss3.Options) {
+			o.BaseEndpoint = aws.String(endpoint)
+			// For many S3-compatible services, path-style addressing is needed.
+			o.UsePathStyle = true // Common for MinIO, Ceph, etc.
+		})
+		s.logger.Info("using custom S3 endpoint", zap.String("endpoint", endpoint))
+	}
+
+	s3ClientOpts = append(s3ClientOpts, optFns...)
+	return awss3.NewFromConfig(awsCfg, append(s3ClientOpts, s.userClientOptions)...), nil
+}
+
+// userClientOptions applies the APIOptions and ClientOptions set by library users.
+func (s *S3Storage) userClientOptions(o *awss3.Options) {
+	o.APIOptions = append(o.APIOptions, s.APIOptions...)
+	for _, fn := range s.ClientOptions {
+		fn(o)
+	}
+}
+
+// loadAWSConfig loads the AWS config shared by the clients of all services, with the storage's
+// retry policy, HTTP client and credentials.
+func (s *S3Storage) loadAWSConfig(region, accessKeyID, secretAccessKey, sessionToken string) (aws.Config, error) {
+	httpClient, err := s.httpClient()
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("creating HTTP client: %w", err)
+	}
+	loadOpts := []func(*awsconfig.LoadOptions) error{
+		awsconfig.WithRegion(region),
+		awsconfig.WithRetryer(s.newRetryer),
+	}
+	if s.UseFIPSEndpoint {
+		loadOpts = append(loadOpts, awsconfig.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+	if s.UseDualStackEndpoint {
+		loadOpts = append(loadOpts, awsconfig.WithUseDualStackEndpoint(aws.DualStackEndpointStateEnabled))
+	}
+	if s.Profile != "" {
+		loadOpts = append(loadOpts, awsconfig.WithSharedConfigProfile(s.Profile))
+	}
+	if len(s.SharedConfigFiles) > 0 {
+		loadOpts = append(loadOpts, awsconfig.WithSharedConfigFiles(s.SharedConfigFiles))
+	}
+	loadOpts = append(loadOpts, s.credentialLoadOptions()...)
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.TODO(), loadOpts...) // Use context.TODO() for one-time setup
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("loading AWS config: %w", err)
+	}
+	awsCfg.HTTPClient = httpClient
+	awsCfg.AppID = s.AppName
+	awsCfg.APIOptions = append(awsCfg.APIOptions, s.attributionAPIOptions()...)
+
+	if accessKeyID != "" && secretAccessKey != "" {
+		awsCfg.Credentials = aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, sessionToken))
+		s.logger.Info("using explicit AWS credentials", zap.Bool("session_token", sessionToken != ""))
+	} else if provider := s.credentialProcessProvider(); provider != nil {
+		awsCfg.Credentials = provider
+		s.logger.Info("using AWS credentials of credential_process")
+	} else if s.CredentialSource == credentialSourceSSO || s.CredentialSource == credentialSourceProcess {
+		s.logger.Info("using AWS credentials of shared config profile", zap.String("source", s.CredentialSource),
+			zap.String("profile", s.sharedProfile()))
+	} else if s.Profile != "" {
+		s.logger.Info("using AWS credentials of shared config profile", zap.String("profile", s.Profile))
+	} else {
+		s.logger.Info("using default AWS credential chain (e.g., IAM role, env vars, or shared config)")
+	}
+	return awsCfg, nil
+}
+
+// validateEndpointVariants checks that use_fips_endpoint and use_dualstack_endpoint are only used
+// with AWS endpoints, which the SDK selects by itself.
+func (s *S3Storage) validateEndpointVariants() error {
+	if !s.UseFIPSEndpoint && !s.UseDualStackEndpoint {
+		return nil
+	}
+	switch {
+	case s.Endpoint != "" || s.isGCS():
+		return errors.New("use_fips_endpoint and use_dualstack_endpoint cannot be used with a custom endpoint")
+	case isMultiRegionAccessPoint(s.Bucket):
+		return errors.New("use_fips_endpoint and use_dualstack_endpoint are not supported by multi-region access points")
+	}
+	s.logger.Info("using AWS endpoint variant", zap.Bool("fips", s.UseFIPSEndpoint), zap.Bool("dualstack", s.UseDualStackEndpoint))
+	return nil
+}
@@ -0,0 +1,140 @@
+package s3
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+func TestEndpointVariants(t *testing.T) {
+	for _, tc := range []struct {
+		fips, dualstack bool
+		host            string
+	}{
+		{false, false, "my-bucket.s3.us-east-1.amazonaws.com"},
+		{true, false, "my-bucket.s3-fips.us-east-1.amazonaws.com"},
+		{false, true, "my-bucket.s3.dualstack.us-east-1.amazonaws.com"},
+		{true, true, "my-bucket.s3-fips.dualstack.us-east-1.amazonaws.com"},
+	} {
+		s := &S3Storage{Bucket: "my-bucket", Region: "us-east-1", UseFIPSEndpoint: tc.fips, UseDualStackEndpoint: tc.dualstack, logger: zap.NewNop()}
+		if err := s.validateEndpointVariants(); err != nil {
+			t.Fatal(err)
+		}
+		client, err := s.newClient(s.Region, "", "key", "secret", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rt := &recordingTransport{}
+		_, err = client.HeadObject(context.Background(), &awss3.HeadObjectInput{Bucket: aws.String(s.Bucket), Key: aws.String("key")},
+			func(o *awss3.Options) { o.HTTPClient = &http.Client{Transport: rt} })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rt.req.URL.Host != tc.host {
+			t.Errorf("fips=%v dualstack=%v: host = %s, want %s", tc.fips, tc.dualstack, rt.req.URL.Host, tc.host)
+		}
+	}
+
+	s := &S3Storage{Bucket: "my-bucket", Endpoint: "https://minio.example.com", UseFIPSEndpoint: true, logger: zap.NewNop()}
+	if err := s.validateEndpointVariants(); err == nil {
+		t.Error("expected use_fips_endpoint with a custom endpoint to be rejected")
+	}
+}
+
+func TestAccelerateEndpoint(t *testing.T) {
+	s := &S3Storage{Bucket: "my-bucket", Region: "eu-central-1", UseAccelerate: true, logger: zap.NewNop()}
+	if err := s.validateAccelerate(); err != nil {
+		t.Fatal(err)
+	}
+	client, err := s.newClient(s.Region, "", "key", "secret", "", s.accelerateClientOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := &recordingTransport{}
+	_, err = client.HeadObject(context.Background(), &awss3.HeadObjectInput{Bucket: aws.String(s.Bucket), Key: aws.String("key")},
+		func(o *awss3.Options) { o.HTTPClient = &http.Client{Transport: rt} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host := rt.req.URL.Host; host != "my-bucket.s3-accelerate.amazonaws.com" {
+		t.Errorf("host = %s, want the accelerate endpoint", host)
+	}
+
+	for name, s := range map[string]*S3Storage{
+		"endpoint": {Bucket: "my-bucket", Endpoint: "https://minio.example.com", UseAccelerate: true},
+		"fips":     {Bucket: "my-bucket", UseFIPSEndpoint: true, UseAccelerate: true},
+		"dots":     {Bucket: "my.bucket", UseAccelerate: true},
+	} {
+		if err := s.validateAccelerate(); err == nil {
+			t.Errorf("%s: expected use_accelerate to be rejected", name)
+		}
+	}
+}
+
+// regionTransport answers every request with a status and the x-amz-bucket-region header.
+type regionTransport struct {
+	status int
+	region string
+	req    *http.Request
+}
+
+func (rt *regionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.req = req
+	header := http.Header{}
+	if rt.region != "" {
+		header.Set("X-Amz-Bucket-Region", rt.region)
+	}
+	return &http.Response{StatusCode: rt.status, Header: header, Body: http.NoBody, Request: req}, nil
+}
+
+func TestDetectRegion(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	t.Setenv("AWS_CONFIG_FILE", t.TempDir()+"/config")
+	ctx := context.Background()
+
+	s := &S3Storage{Bucket: "my-bucket", logger: zap.NewNop()}
+	if !s.shouldDetectRegion() {
+		t.Fatal("region is not detected without region and endpoint")
+	}
+	rt := &regionTransport{status: http.StatusForbidden, region: "eu-west-2"} // Denied, but the region is known
+	if err := s.detectRegion(ctx, func(o *awss3.Options) { o.HTTPClient = &http.Client{Transport: rt} }); err != nil {
+		t.Fatal(err)
+	}
+	if s.Region != "eu-west-2" {
+		t.Errorf("region = %q, want eu-west-2", s.Region)
+	}
+	if rt.req.Method != http.MethodHead || rt.req.Header.Get("Authorization") != "" {
+		t.Errorf("lookup was %s with Authorization %q, want an unsigned HEAD", rt.req.Method, rt.req.Header.Get("Authorization"))
+	}
+
+	missing := &S3Storage{Bucket: "missing", logger: zap.NewNop()}
+	err := missing.detectRegion(ctx, func(o *awss3.Options) {
+		o.HTTPClient = &http.Client{Transport: &regionTransport{status: http.StatusNotFound}}
+	})
+	if err == nil || missing.Region != "" {
+		t.Errorf("detecting the region of a missing bucket = %q, %v", missing.Region, err)
+	}
+
+	t.Setenv("AWS_REGION", "ap-south-1")
+	fallback := &S3Storage{Bucket: "missing", logger: zap.NewNop()}
+	err = fallback.detectRegion(ctx, func(o *awss3.Options) {
+		o.HTTPClient = &http.Client{Transport: &regionTransport{status: http.StatusNotFound}}
+	})
+	if err != nil || fallback.Region != "" {
+		t.Errorf("failed detection with an environment region = %q, %v, want the SDK's region", fallback.Region, err)
+	}
+
+	for name, s := range map[string]*S3Storage{
+		"region":   {Bucket: "my-bucket", Region: "us-east-1"},
+		"endpoint": {Bucket: "my-bucket", Endpoint: "https://minio.example.com"},
+	} {
+		if s.shouldDetectRegion() {
+			t.Errorf("%s: region would be detected", name)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	caddycmd.RegisterCommand(caddycmd.Command{
+		Name:  "storage-s3",
+		Usage: "<command> [--config <path> [--adapter <name>]]",
+		Short: "Maintenance commands for the S3 certificate storage",
+		Long: `
+Maintenance commands operating on the S3 storage configured in the given
+Caddy config. The storage is provisioned exactly like Caddy would, so the
+configured prefix and encryption apply to everything read or written.
+`,
+		CobraFunc: func(cmd *cobra.Command) {
+			cmd.AddCommand(
+				seedCommand(),
+				importCommand(),
+				exportCommand(),
+				reencryptCommand(),
+				costEstimateCommand(),
+				migratePrefixCommand(),
+				purgeDeletedCommand(),
+				listCertsCommand(),
+				unlockCommand(),
+				verifyEncryptionCommand(),
+			)
+		},
+	})
+}
+
+// addConfigFlags adds the flags used to locate the storage configuration to a subcommand.
+func addConfigFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("config", "c", "", "Configuration file containing the s3 storage (required)")
+	cmd.Flags().StringP("adapter", "a", "", "Name of config adapter to apply")
+}
+
+// storageConfig holds the top-level storage module of a Caddy config. The module is loaded by ID
+// rather than with caddy.Context.LoadModule, whose json.RawMessage check fails on Go versions
+// where it is an alias.
+type storageConfig struct {
+	Storage map[string]json.RawMessage `json:"storage,omitempty"`
+}
+
+// cliCtxKey marks the provisioning of a storage for a maintenance command.
+type cliCtxKey struct{}
+
+// withCLIProvisioning makes a storage provisioned with ctx skip what only a running server needs:
+// background services (lock GC, journal, renewal prefetch, warm start, notifications), listeners
+// (sidecar, cache_invalidation) and bucket changes or probes (lifecycle, validate_on_start).
+func withCLIProvisioning(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cliCtxKey{}, true)
+}
+
+// cliProvisioning reports whether ctx provisions a storage for a maintenance command.
+func cliProvisioning(ctx context.Context) bool {
+	cli, _ := ctx.Value(cliCtxKey{}).(bool)
+	return cli
+}
+
+// loadStorageFromConfig adapts the given config file and provisions its top-level storage,
+// which must be this module, for a maintenance command (see withCLIProvisioning). The returned
+// cancel function cleans the storage up again.
+func loadStorageFromConfig(fl caddycmd.Flags) (*S3Storage, context.CancelFunc, error) {
+	configFile := fl.String("config")
+	if configFile == "" {
+		return nil, nil, errors.New("--config is required")
+	}
+	cfgJSON, _, err := caddycmd.LoadConfig(configFile, fl.String("adapter"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var cfg storageConfig
+	if err := json.Unmarshal(cfgJSON, &cfg); err != nil {
+		return nil, nil, fmt.Errorf("decoding config: %w", err)
+	}
+	if cfg.Storage == nil {
+		return nil, nil, errors.New("config does not define a storage module")
+	}
+	var module string
+	if err := json.Unmarshal(cfg.Storage["module"], &module); err != nil {
+		return nil, nil, fmt.Errorf("decoding storage module name: %w", err)
+	}
+	delete(cfg.Storage, "module")
+	raw, err := json.Marshal(cfg.Storage)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: withCLIProvisioning(context.Background())})
+	val, err := ctx.LoadModuleByID("caddy.storage."+module, raw)
+	if err != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("loading storage: %w", err)
+	}
+	s, ok := val.(*S3Storage)
+	if !ok {
+		cancel()
+		return nil, nil, fmt.Errorf("configured storage is %T, not the s3 storage", val)
+	}
+	return s, cancel, nil
+}
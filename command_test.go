@@ -0,0 +1,61 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+)
+
+func TestLoadStorageFromConfig(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	config := `{"storage": {
+		"module": "s3",
+		"bucket": "certmagic-test",
+		"region": "us-east-1",
+		"endpoint": "` + srv.URL + `",
+		"access_key_id": "key",
+		"secret_access_key": "secret",
+		"validate_on_start": true,
+		"lifecycle": {"expire_ocsp_days": 7},
+		"lock_gc_interval": "1m",
+		"renewal_prefetch": "720h",
+		"sidecar": {"listen": "127.0.0.1:0", "token": "token"},
+		"journal_dir": "` + filepath.ToSlash(filepath.Join(t.TempDir(), "journal")) + `"
+	}}`
+	configFile := filepath.Join(t.TempDir(), "caddy.json")
+	if err := os.WriteFile(configFile, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cmd := &cobra.Command{}
+	addConfigFlags(cmd)
+	if err := cmd.Flags().Set("config", configFile); err != nil {
+		t.Fatal(err)
+	}
+
+	s, cancel, err := loadStorageFromConfig(caddycmd.Flags{FlagSet: cmd.Flags()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	if n := requests.Load(); n != 0 {
+		t.Errorf("provisioning for a command made %d requests", n)
+	}
+	if s.journal != nil {
+		t.Error("journal opened for a command")
+	}
+	if s.sidecarServer != nil {
+		t.Error("sidecar started for a command")
+	}
+}
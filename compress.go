@@ -0,0 +1,160 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// With compression, values are compressed before encryption. Compressed values start with a
+// 4-byte marker naming the algorithm; values without it are read as they are, so objects written
+// before compression was enabled, or after it was disabled again, keep loading. Values which do not
+// get smaller are stored uncompressed.
+
+const (
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+
+	compressionMarker  = "\x00CZ" // Followed by the algorithm byte; PEM and JSON never start with NUL
+	compressionMinSize = 256      // Smaller values rarely compress
+)
+
+var compressionAlgorithms = map[string]byte{compressionGzip: 'g', compressionZstd: 'z'}
+
+// CompressingIO compresses plaintext with Algorithm before passing it to Inner, and decompresses
+// marked values after reading them through Inner. With an empty Algorithm, it only decompresses.
+type CompressingIO struct {
+	Inner     IO
+	Algorithm string
+}
+
+// newCompressingIO wraps inner, validating the algorithm.
+func newCompressingIO(inner IO, algorithm string) (*CompressingIO, error) {
+	if _, ok := compressionAlgorithms[algorithm]; algorithm != "" && !ok {
+		return nil, fmt.Errorf("unsupported compression '%s' (expected one of %v)", algorithm, compressionNames())
+	}
+	return &CompressingIO{Inner: inner, Algorithm: algorithm}, nil
+}
+
+// ByteReader compresses the plaintext, if worthwhile, and passes it on to Inner.
+func (c *CompressingIO) ByteReader(plaintext []byte) (io.Reader, int64, error) {
+	if c.Algorithm != "" && len(plaintext) >= compressionMinSize {
+		compressed, err := compressValue(c.Algorithm, plaintext)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(compressed) < len(plaintext) {
+			plaintext = compressed
+		}
+	}
+	return c.Inner.ByteReader(plaintext)
+}
+
+// StreamReader compresses the plaintext stream on the fly; the length of the result is unknown.
+func (c *CompressingIO) StreamReader(plaintext io.Reader, size int64) (io.Reader, int64, error) {
+	if c.Algorithm == "" || (size >= 0 && size < compressionMinSize) {
+		return c.Inner.StreamReader(plaintext, size)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(compressStream(c.Algorithm, pw, plaintext))
+	}()
+	return c.Inner.StreamReader(pr, -1)
+}
+
+// WrapReader reads through Inner and decompresses marked values.
+func (c *CompressingIO) WrapReader(ciphertextReader io.Reader) io.Reader {
+	br := bufio.NewReader(c.Inner.WrapReader(ciphertextReader))
+	header, err := br.Peek(len(compressionMarker) + 1)
+	if err != nil || string(header[:len(compressionMarker)]) != compressionMarker {
+		return br // Uncompressed, or a read error surfacing on the first Read
+	}
+	br.Discard(len(header))
+	switch header[len(compressionMarker)] {
+	case compressionAlgorithms[compressionGzip]:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return &errorReader{err: fmt.Errorf("decompressing gzip: %w", err)}
+		}
+		return zr
+	case compressionAlgorithms[compressionZstd]:
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return &errorReader{err: fmt.Errorf("decompressing zstd: %w", err)}
+		}
+		return zr.IOReadCloser()
+	default:
+		return &errorReader{err: fmt.Errorf("unknown compression algorithm %q", header[len(compressionMarker)])}
+	}
+}
+
+// compressValue returns the marked compressed value.
+func compressValue(algorithm string, plaintext []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := compressStream(algorithm, &buf, bytes.NewReader(plaintext)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compressStream writes the marker and the compressed plaintext to w.
+func compressStream(algorithm string, w io.Writer, plaintext io.Reader) error {
+	marker := append([]byte(compressionMarker), compressionAlgorithms[algorithm])
+	if _, err := w.Write(marker); err != nil {
+		return err
+	}
+	var zw io.WriteCloser
+	switch algorithm {
+	case compressionGzip:
+		zw = gzip.NewWriter(w)
+	case compressionZstd:
+		enc, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		zw = enc
+	}
+	if _, err := io.Copy(zw, plaintext); err != nil {
+		zw.Close()
+		return fmt.Errorf("compressing: %w", err)
+	}
+	return zw.Close()
+}
+
+// decompressValue decompresses a value read through an IO without decompression, e.g. one
+// describing a previous encryption.
+func decompressValue(data []byte) ([]byte, error) {
+	return decryptWith(&CompressingIO{Inner: &CleartextIO{}}, data)
+}
+
+// baseIO returns the encryption of wrap, without compression and key refreshing.
+func baseIO(wrap IO) IO {
+	if c, ok := wrap.(*CompressingIO); ok {
+		wrap = c.Inner
+	}
+	if r, ok := wrap.(*refreshingIO); ok {
+		wrap = r.get()
+	}
+	return wrap
+}
+
+// isCleartext reports whether wrap writes values unencrypted.
+func isCleartext(wrap IO) bool {
+	_, cleartext := baseIO(wrap).(*CleartextIO)
+	return cleartext
+}
+
+// compressionNames lists the supported algorithms, for error messages.
+func compressionNames() []string {
+	names := make([]string, 0, len(compressionAlgorithms))
+	for name := range compressionAlgorithms {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,86 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCompressingIO(t *testing.T) {
+	secret, err := NewIO("12345678123456781234567812345678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := []byte(strings.Repeat("-----BEGIN CERTIFICATE-----\nMIIB...\n-----END CERTIFICATE-----\n", 20))
+	random := make([]byte, 1024)
+	rand.Read(random)
+
+	for _, inner := range []IO{&CleartextIO{}, secret} {
+		for _, algorithm := range []string{compressionGzip, compressionZstd} {
+			c, err := newCompressingIO(inner, algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range map[string][]byte{"chain": chain, "small": []byte("{}"), "random": random, "empty": {}} {
+				reader, length, err := c.ByteReader(value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				stored, _ := io.ReadAll(reader)
+				if int64(len(stored)) != length {
+					t.Errorf("%s/%s: length %d, got %d bytes", algorithm, name, length, len(stored))
+				}
+				if name == "chain" && len(stored) >= len(value)/2 {
+					t.Errorf("%s: chain of %d bytes stored in %d bytes", algorithm, len(value), len(stored))
+				}
+				if name != "chain" && len(stored) > len(value)+secretboxOverhead(inner) {
+					t.Errorf("%s/%s: value not worth compressing grew to %d bytes", algorithm, name, len(stored))
+				}
+				got, err := decryptWith(c, stored)
+				if err != nil || !bytes.Equal(got, value) {
+					t.Errorf("%s/%s: round trip failed: %v", algorithm, name, err)
+				}
+			}
+
+			// Streams of unknown size
+			stream, _, err := c.StreamReader(bytes.NewReader(chain), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored, err := io.ReadAll(stream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := decryptWith(c, stored); err != nil || !bytes.Equal(got, chain) {
+				t.Errorf("%s: stream round trip failed: %v", algorithm, err)
+			}
+		}
+
+		// Values written without compression stay readable, and compressed ones after turning it off.
+		plain, _ := newCompressingIO(inner, "")
+		zstd, _ := newCompressingIO(inner, compressionZstd)
+		reader, _, _ := plain.ByteReader(chain)
+		old, _ := io.ReadAll(reader)
+		reader, _, _ = zstd.ByteReader(chain)
+		compressed, _ := io.ReadAll(reader)
+		if got, err := decryptWith(zstd, old); err != nil || !bytes.Equal(got, chain) {
+			t.Errorf("uncompressed value not readable with compression: %v", err)
+		}
+		if got, err := decryptWith(plain, compressed); err != nil || !bytes.Equal(got, chain) {
+			t.Errorf("compressed value not readable without compression: %v", err)
+		}
+	}
+
+	if _, err := newCompressingIO(&CleartextIO{}, "brotli"); err == nil {
+		t.Error("expected unknown algorithm to be rejected")
+	}
+}
+
+func secretboxOverhead(inner IO) int {
+	if isCleartext(inner) {
+		return 0
+	}
+	return len(secretBoxMagic) + 24 + 16 // Header, nonce and tag
+}
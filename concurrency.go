@@ -0,0 +1,105 @@
+package s3
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/smithy-go/middleware"
+)
+
+// With max_concurrent_requests, at most that many S3 requests of a storage are in flight at a
+// time, across all of its clients (fallback regions and replica included). Further requests queue
+// until a slot frees up or their context ends, so that mass renewals don't trip the rate limits of
+// providers like Backblaze B2. A request holds its slot until the response headers arrive; every
+// retry attempt queues again.
+
+// ConcurrencyStats reports the request limiter of a storage.
+type ConcurrencyStats struct {
+	Limit    int    `json:"limit"`
+	InFlight int64  `json:"in_flight"`
+	Waiting  int64  `json:"waiting"`
+	Queued   uint64 `json:"queued"` // Requests which had to wait for a slot
+
+	TotalWaitSeconds float64 `json:"total_wait_seconds"`
+	MaxWaitSeconds   float64 `json:"max_wait_seconds"`
+}
+
+// requestLimiter is a semaphore bounding the in-flight requests of a storage.
+type requestLimiter struct {
+	slots chan struct{}
+
+	inFlight  atomic.Int64
+	waiting   atomic.Int64
+	queued    atomic.Uint64
+	totalWait atomic.Int64 // Nanoseconds
+	maxWait   atomic.Int64 // Nanoseconds
+}
+
+func newRequestLimiter(limit int) *requestLimiter {
+	return &requestLimiter{slots: make(chan struct{}, limit)}
+}
+
+// register adds the limiting middleware to a client's stack, after the retry middleware so that
+// every attempt takes a slot.
+func (l *requestLimiter) register(stack *middleware.Stack) error {
+	return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("LimitConcurrentRequests",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			if err := l.acquire(ctx); err != nil {
+				return middleware.FinalizeOutput{}, middleware.Metadata{}, err
+			}
+			defer l.release()
+			return next.HandleFinalize(ctx, in)
+		}), middleware.After)
+}
+
+// acquire takes a slot, waiting until one is free or ctx is done.
+func (l *requestLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		l.inFlight.Add(1)
+		return nil
+	default:
+	}
+
+	l.queued.Add(1)
+	l.waiting.Add(1)
+	defer l.waiting.Add(-1)
+	start := time.Now()
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	wait := int64(time.Since(start))
+	l.totalWait.Add(wait)
+	for {
+		prev := l.maxWait.Load()
+		if wait <= prev || l.maxWait.CompareAndSwap(prev, wait) {
+			break
+		}
+	}
+	l.inFlight.Add(1)
+	return nil
+}
+
+func (l *requestLimiter) release() {
+	l.inFlight.Add(-1)
+	<-l.slots
+}
+
+// ConcurrencyStats returns the statistics of the request limiter, if max_concurrent_requests is set.
+func (s *S3Storage) ConcurrencyStats() (ConcurrencyStats, bool) {
+	l := s.limiter
+	if l == nil {
+		return ConcurrencyStats{}, false
+	}
+	return ConcurrencyStats{
+		Limit:            cap(l.slots),
+		InFlight:         l.inFlight.Load(),
+		Waiting:          l.waiting.Load(),
+		Queued:           l.queued.Load(),
+		TotalWaitSeconds: time.Duration(l.totalWait.Load()).Seconds(),
+		MaxWaitSeconds:   time.Duration(l.maxWait.Load()).Seconds(),
+	}, true
+}
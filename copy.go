@@ -0,0 +1,169 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.uber.org/zap"
+)
+
+// Copy copies the value at srcKey, and everything stored below it, to dstKey. S3 copies the
+// objects (CopyObject) as they are, so nothing is downloaded or encrypted again; only with a
+// replica are the values loaded, to write them to the replica as well.
+func (s *S3Storage) Copy(ctx context.Context, srcKey, dstKey string) error {
+	err := s.copyTree(ctx, srcKey, dstKey)
+	s.auditOp(ctx, "copy", dstKey, 0, err)
+	return err
+}
+
+// Move moves the value at oldKey, and everything stored below it, to newKey: a Copy followed by
+// a Delete of oldKey.
+func (s *S3Storage) Move(ctx context.Context, oldKey, newKey string) error {
+	if err := s.Copy(ctx, oldKey, newKey); err != nil {
+		return err
+	}
+	return s.Delete(ctx, oldKey)
+}
+
+// copyTree copies srcKey, its companion objects and the keys below it.
+func (s *S3Storage) copyTree(ctx context.Context, srcKey, dstKey string) error {
+	srcKey, dstKey = strings.Trim(srcKey, "/"), strings.Trim(dstKey, "/")
+	switch {
+	case srcKey == "" || dstKey == "":
+		return errors.New("copy: keys must not be empty")
+	case srcKey == dstKey || keyBelow(srcKey, dstKey) || keyBelow(dstKey, srcKey):
+		return fmt.Errorf("copy: %s and %s overlap", srcKey, dstKey)
+	}
+	if err := s.checkWritable("copy", dstKey); err != nil {
+		return err
+	}
+
+	found := false
+	companions := []string{"", rolloverSuffix} // Written along with the value by Store
+	trees := []string{""}
+	if isOCSPStapleKey(srcKey) {
+		companions = append(companions, ocspBaseSuffix) // Legacy base version
+		trees = append(trees, ocspBaseSuffix)
+	}
+	for _, suffix := range companions {
+		size, err := s.objectSize(ctx, s.s3ObjectKey(srcKey+suffix))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.copyValue(ctx, srcKey+suffix, dstKey+suffix, size); err != nil {
+			return err
+		}
+		found = true
+	}
+
+	for _, suffix := range trees {
+		err := s.walkPrefix(ctx, srcKey+suffix, func(obj types.Object) error {
+			key := s.certMagicKey(aws.ToString(obj.Key))
+			found = true
+			return s.copyValue(ctx, key, path.Join(dstKey+suffix, strings.TrimPrefix(key, srcKey+suffix+"/")), aws.ToInt64(obj.Size))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("copying %s: %w", srcKey, fs.ErrNotExist)
+	}
+	return nil
+}
+
+// copyValue copies the object of one CertMagic key to another and updates what depends on the
+// destination.
+func (s *S3Storage) copyValue(ctx context.Context, srcKey, dstKey string, size int64) error {
+	srcS3Key, dstS3Key := s.s3ObjectKey(srcKey), s.s3ObjectKey(dstKey)
+	s.opLogger(ctx, dstKey).Debug("copying", zap.String("key", srcKey), zap.String("to", dstKey))
+	out, err := s.copyObject(ctx, srcS3Key, dstS3Key)
+	if err != nil {
+		s.recordError("copy", dstKey, err)
+		return fmt.Errorf("copying %s to %s: %w", srcKey, dstKey, s3Error(err))
+	}
+
+	s.forgetFlights(dstS3Key)
+	if s.cache != nil {
+		s.cache.remove(dstKey)
+	}
+	if s.existsCache != nil {
+		s.existsCache.stored(dstKey)
+	}
+	s.mirrorDelete(dstKey) // Refilled by the next Store; a stale fallback would be worse than none
+	var etag *string
+	if out.CopyObjectResult != nil {
+		etag = out.CopyObjectResult.ETag
+	}
+	s.manifestStore(ctx, dstKey, size, etag)
+	if s.replicaClient != nil {
+		if value, err := s.load(ctx, dstKey); err == nil {
+			s.replicateStore(ctx, dstKey, dstS3Key, value)
+		} else {
+			s.recordError("replica_store", dstKey, err)
+		}
+	}
+	if data, ok := certEventData(dstKey, false); ok {
+		data["copied_from"] = srcKey
+		s.emitEvent(eventCertStored, dstKey, data)
+	}
+	return nil
+}
+
+// copyObject copies an object within the bucket (or between the buckets of routes), keeping its
+// metadata and tags.
+func (s *S3Storage) copyObject(ctx context.Context, srcS3Key, dstS3Key string) (*awss3.CopyObjectOutput, error) {
+	ctx, cancel := s.opContext(ctx)
+	defer cancel()
+	return s.Client.CopyObject(ctx, &awss3.CopyObjectInput{
+		Bucket:            aws.String(s.Bucket),
+		Key:               aws.String(dstS3Key),
+		CopySource:        aws.String(s.copySource(srcS3Key)),
+		StorageClass:      types.StorageClass(s.StorageClass),
+		ChecksumAlgorithm: s.checksumAlgorithm(),
+	})
+}
+
+// copySource returns the URL-encoded source of a CopyObject request.
+func (s *S3Storage) copySource(s3Key string) string {
+	escaped := (&url.URL{Path: s3Key}).EscapedPath()
+	if isMultiRegionAccessPoint(s.Bucket) {
+		return s.Bucket + "/object/" + escaped
+	}
+	bucket := s.Bucket
+	if s.router != nil {
+		bucket = s.router.bucketFor(s3Key)
+	}
+	if s.sharder != nil {
+		bucket = s.sharder.bucketFor(s3Key)
+	}
+	return bucket + "/" + escaped
+}
+
+// objectSize returns the size of an object, or fs.ErrNotExist.
+func (s *S3Storage) objectSize(ctx context.Context, s3Key string) (int64, error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+	out, err := s.Client.HeadObject(ctx, &awss3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s3Key),
+	})
+	if err != nil {
+		if s.isNotFound(err) {
+			return 0, fs.ErrNotExist
+		}
+		return 0, fmt.Errorf("reading s3://%s/%s: %w", s.Bucket, s3Key, s3Error(err))
+	}
+	return aws.ToInt64(out.ContentLength), nil
+}
@@ -0,0 +1,233 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+)
+
+// pricingProfile holds list prices in USD. They are approximations of the public prices of the
+// providers' standard tiers and only meant for comparisons.
+type pricingProfile struct {
+	Name          string
+	StorageGBMo   float64 // Per GB and month
+	WritePer1000  float64 // PUT, COPY, POST and multipart requests
+	ListPer1000   float64
+	ReadPer1000   float64 // GET and HEAD
+	MinObjectSize int64   // Billed minimum per object, e.g. for infrequent access tiers
+}
+
+var pricingProfiles = []pricingProfile{
+	{Name: "aws-standard", StorageGBMo: 0.023, WritePer1000: 0.005, ListPer1000: 0.005, ReadPer1000: 0.0004},
+	{Name: "aws-standard-ia", StorageGBMo: 0.0125, WritePer1000: 0.01, ListPer1000: 0.01, ReadPer1000: 0.001, MinObjectSize: 128 << 10},
+	{Name: "gcs-standard", StorageGBMo: 0.020, WritePer1000: 0.005, ListPer1000: 0.005, ReadPer1000: 0.0004},
+	{Name: "r2", StorageGBMo: 0.015, WritePer1000: 0.0045, ListPer1000: 0.0045, ReadPer1000: 0.00036},
+	{Name: "b2", StorageGBMo: 0.006, ListPer1000: 0.004, ReadPer1000: 0.0004},
+	{Name: "wasabi", StorageGBMo: 0.0069},
+}
+
+// Request classes by the S3 operations this module uses. Deletes are free everywhere.
+var (
+	writeOperations = map[string]bool{"PutObject": true, "CopyObject": true, "CreateMultipartUpload": true,
+		"UploadPart": true, "CompleteMultipartUpload": true}
+	listOperations = map[string]bool{"ListObjectsV2": true, "ListObjects": true}
+	readOperations = map[string]bool{"GetObject": true, "HeadObject": true, "HeadBucket": true, "GetBucketVersioning": true}
+)
+
+const hoursPerMonth = 730
+
+// usage is the projected monthly usage of a storage.
+type usage struct {
+	Objects                  int64
+	Bytes                    int64
+	BilledBytes              map[string]int64 // By profile with a minimum object size
+	Writes, Lists, Reads     float64          // Requests per month
+	RequestsKnown            bool
+	ReadCache, ManifestInUse bool
+}
+
+// addRequests projects the request counts of one instance to a month of all instances.
+func (u *usage) addRequests(stats RequestStats, instances int) {
+	elapsed := time.Since(stats.Since).Hours()
+	if elapsed <= 0 {
+		return
+	}
+	scale := hoursPerMonth / elapsed * float64(instances)
+	for op, n := range stats.Requests {
+		switch {
+		case writeOperations[op]:
+			u.Writes += float64(n) * scale
+		case listOperations[op]:
+			u.Lists += float64(n) * scale
+		case readOperations[op]:
+			u.Reads += float64(n) * scale
+		}
+	}
+	u.RequestsKnown = true
+}
+
+// costEstimate is the projected monthly cost under one pricing profile.
+type costEstimate struct {
+	Profile                       string
+	Storage, Writes, Lists, Reads float64
+}
+
+func (c costEstimate) total() float64 {
+	return c.Storage + c.Writes + c.Lists + c.Reads
+}
+
+func estimateCost(u usage, p pricingProfile) costEstimate {
+	bytes := u.Bytes
+	if p.MinObjectSize > 0 {
+		bytes = u.BilledBytes[p.Name]
+	}
+	return costEstimate{
+		Profile: p.Name,
+		Storage: float64(bytes) / 1e9 * p.StorageGBMo,
+		Writes:  u.Writes / 1000 * p.WritePer1000,
+		Lists:   u.Lists / 1000 * p.ListPer1000,
+		Reads:   u.Reads / 1000 * p.ReadPer1000,
+	}
+}
+
+// hints suggests settings of this module that lower the dominant cost.
+func hints(u usage, c costEstimate) []string {
+	var h []string
+	if c.Reads > c.Storage && !u.ReadCache {
+		h = append(h, "reads dominate: read_cache_size keeps hot values in memory")
+	}
+	if c.Lists > c.Storage && !u.ManifestInUse {
+		h = append(h, "listing dominates: manifest true answers List from a single object")
+	}
+	return h
+}
+
+func costEstimateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cost-estimate --config <path> [--admin <addr>] [--instances <n>] [--profile <name>]",
+		Short: "Projects monthly S3 storage and request costs",
+		Long: `
+Counts the objects and bytes below the configured prefix and projects the
+monthly storage cost under several pricing profiles (approximate list prices in
+USD; check your provider's current pricing).
+
+Request costs are projected from the request counts of a running instance,
+read from its admin endpoint (GET /storage/s3/requests), and multiplied by the
+number of instances sharing the bucket. The longer the instance has been
+running, the more representative the projection.
+`,
+		RunE: caddycmd.WrapCommandFuncForCobra(cmdCostEstimate),
+	}
+	addConfigFlags(cmd)
+	cmd.Flags().String("admin", "", "Admin endpoint of a running instance, e.g. localhost:2019")
+	cmd.Flags().Int("instances", 1, "Number of instances sharing the storage")
+	cmd.Flags().String("profile", "", "Only show this pricing profile")
+	return cmd
+}
+
+func cmdCostEstimate(fl caddycmd.Flags) (int, error) {
+	s, cancel, err := loadStorageFromConfig(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	defer cancel()
+
+	u := usage{BilledBytes: make(map[string]int64), ReadCache: s.cache != nil, ManifestInUse: s.manifest != nil}
+	err = s.walkObjects(context.Background(), func(obj types.Object) error {
+		size := aws.ToInt64(obj.Size)
+		u.Objects++
+		u.Bytes += size
+		for _, p := range pricingProfiles {
+			if p.MinObjectSize > 0 {
+				u.BilledBytes[p.Name] += max(size, p.MinObjectSize)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	if addr := fl.String("admin"); addr != "" {
+		stats, err := fetchRequestStats(addr, s.instanceID())
+		if err != nil {
+			return caddy.ExitCodeFailedQuit, err
+		}
+		u.addRequests(stats, max(fl.Int("instances"), 1))
+	}
+
+	if err := printCostEstimate(os.Stdout, u, fl.String("profile")); err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+// fetchRequestStats reads the request counts of the given storage from an admin endpoint.
+func fetchRequestStats(addr, storage string) (RequestStats, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	resp, err := http.Get(strings.TrimSuffix(addr, "/") + "/storage/s3/requests")
+	if err != nil {
+		return RequestStats{}, fmt.Errorf("fetching request counts: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return RequestStats{}, fmt.Errorf("fetching request counts: %s", resp.Status)
+	}
+	var all []adminRequests
+	if err := json.NewDecoder(resp.Body).Decode(&all); err != nil {
+		return RequestStats{}, fmt.Errorf("decoding request counts: %w", err)
+	}
+	for _, r := range all {
+		if r.Storage == storage {
+			return r.Requests, nil
+		}
+	}
+	return RequestStats{}, fmt.Errorf("the instance at %s does not use storage %s", addr, storage)
+}
+
+func printCostEstimate(w io.Writer, u usage, profile string) error {
+	fmt.Fprintf(w, "objects: %d, bytes: %d\n", u.Objects, u.Bytes)
+	if u.RequestsKnown {
+		fmt.Fprintf(w, "requests per month: %.0f writes, %.0f lists, %.0f reads\n\n", u.Writes, u.Lists, u.Reads)
+	} else {
+		fmt.Fprint(w, "request costs omitted; pass --admin to project them from a running instance\n\n")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "profile\tstorage\twrites\tlists\treads\ttotal/month\t")
+	var shown []costEstimate
+	for _, p := range pricingProfiles {
+		if profile != "" && p.Name != profile {
+			continue
+		}
+		c := estimateCost(u, p)
+		shown = append(shown, c)
+		fmt.Fprintf(tw, "%s\t$%.2f\t$%.2f\t$%.2f\t$%.2f\t$%.2f\t\n", c.Profile, c.Storage, c.Writes, c.Lists, c.Reads, c.total())
+	}
+	if len(shown) == 0 {
+		return fmt.Errorf("unknown pricing profile '%s'", profile)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if h := hints(u, shown[0]); len(h) > 0 {
+		fmt.Fprintf(w, "\nhints (%s):\n", shown[0].Profile)
+		for _, line := range h {
+			fmt.Fprintf(w, "  - %s\n", line)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package s3
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimateCost(t *testing.T) {
+	u := usage{Objects: 2, Bytes: 2e9, BilledBytes: map[string]int64{"aws-standard-ia": 3e9}}
+	u.addRequests(RequestStats{
+		Since:    time.Now().Add(-hoursPerMonth * time.Hour / 2), // Half a month
+		Requests: map[string]uint64{"PutObject": 1000, "ListObjectsV2": 500, "GetObject": 4000, "HeadObject": 1000, "DeleteObject": 99},
+	}, 2)
+	if !u.RequestsKnown || !near(u.Writes, 4000) || !near(u.Lists, 2000) || !near(u.Reads, 20000) {
+		t.Fatalf("unexpected monthly requests %+v", u)
+	}
+
+	profiles := make(map[string]pricingProfile)
+	for _, p := range pricingProfiles {
+		profiles[p.Name] = p
+	}
+	c := estimateCost(u, profiles["aws-standard"])
+	if !near(c.Storage, 0.046) || !near(c.Writes, 0.02) || !near(c.Lists, 0.01) || !near(c.Reads, 0.008) {
+		t.Errorf("unexpected estimate %+v", c)
+	}
+	if ia := estimateCost(u, profiles["aws-standard-ia"]); !near(ia.Storage, 0.0375) {
+		t.Errorf("expected billed minimum object size to apply, got %+v", ia)
+	}
+
+	var out bytes.Buffer
+	if err := printCostEstimate(&out, u, "r2"); err != nil || !strings.Contains(out.String(), "r2") || strings.Contains(out.String(), "wasabi") {
+		t.Errorf("unexpected output %q, %v", out.String(), err)
+	}
+	if err := printCostEstimate(&out, u, "unknown"); err == nil {
+		t.Error("expected unknown profile to be rejected")
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6*math.Max(1, math.Abs(b))
+}
@@ -0,0 +1,115 @@
+package s3
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/processcreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+)
+
+// Credential sources of credential_source. Without one, static keys are used if configured and
+// the default credential chain otherwise.
+const (
+	credentialSourceDefault = "default" // The SDK's chain: environment, shared config, container and instance roles
+	credentialSourceStatic  = "static"  // access_key_id and secret_access_key
+	credentialSourceSSO     = "sso"     // IAM Identity Center (SSO) profile of the shared config
+	credentialSourceProcess = "process" // credential_process, configured here or in the profile
+)
+
+// validateCredentialSource checks that the options of the credential source fit together and
+// that the profile of the shared config has what sso and process need.
+func (s *S3Storage) validateCredentialSource() error {
+	static := s.AccessKeyID != "" || s.SecretAccessKey != ""
+	if s.CredentialProcess != "" {
+		if s.CredentialSource != "" && s.CredentialSource != credentialSourceProcess {
+			return fmt.Errorf("credential_process requires credential_source %s", credentialSourceProcess)
+		}
+		s.CredentialSource = credentialSourceProcess
+	}
+	if s.SSOCacheDir != "" && s.CredentialSource != credentialSourceSSO {
+		return fmt.Errorf("sso_cache_dir requires credential_source %s", credentialSourceSSO)
+	}
+
+	switch s.CredentialSource {
+	case "":
+		return nil
+	case credentialSourceStatic:
+		if s.AccessKeyID == "" || s.SecretAccessKey == "" {
+			return errors.New("credential_source static requires access_key_id and secret_access_key")
+		}
+		return nil
+	case credentialSourceDefault, credentialSourceSSO, credentialSourceProcess:
+		if static {
+			return fmt.Errorf("credential_source %s and access_key_id or secret_access_key are mutually exclusive", s.CredentialSource)
+		}
+	default:
+		return fmt.Errorf("unsupported credential_source '%s' (use %s, %s, %s or %s)", s.CredentialSource,
+			credentialSourceDefault, credentialSourceStatic, credentialSourceSSO, credentialSourceProcess)
+	}
+	if s.CredentialSource == credentialSourceDefault || s.CredentialProcess != "" {
+		return nil
+	}
+
+	profile := s.sharedProfile()
+	shared, err := awsconfig.LoadSharedConfigProfile(context.TODO(), profile, func(o *awsconfig.LoadSharedConfigOptions) {
+		if len(s.SharedConfigFiles) > 0 {
+			o.ConfigFiles = s.SharedConfigFiles
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("credential_source %s: loading profile %s: %w", s.CredentialSource, profile, err)
+	}
+	switch {
+	case s.CredentialSource == credentialSourceSSO && shared.SSOSession == nil && shared.SSOStartURL == "":
+		return fmt.Errorf("credential_source sso: profile %s has no sso_session or sso_start_url", profile)
+	case s.CredentialSource == credentialSourceProcess && shared.CredentialProcess == "":
+		return fmt.Errorf("credential_source process: profile %s has no credential_process "+
+			"(or set credential_process)", profile)
+	}
+	return nil
+}
+
+// sharedProfile returns the name of the shared config profile the SDK reads.
+func (s *S3Storage) sharedProfile() string {
+	return cmp.Or(s.Profile, os.Getenv("AWS_PROFILE"), "default")
+}
+
+// credentialLoadOptions returns the options of the SDK's config loading for the credential source.
+func (s *S3Storage) credentialLoadOptions() []func(*awsconfig.LoadOptions) error {
+	if s.SSOCacheDir == "" {
+		return nil
+	}
+	return []func(*awsconfig.LoadOptions) error{
+		// sso_session profiles: the token file named by the SDK, moved to the cache directory
+		awsconfig.WithSSOTokenProviderOptions(func(o *ssocreds.SSOTokenProviderOptions) {
+			o.CachedTokenFilepath = filepath.Join(s.SSOCacheDir, filepath.Base(o.CachedTokenFilepath))
+		}),
+		// Legacy profiles with sso_start_url: the token file is named after the start URL
+		awsconfig.WithSSOProviderOptions(func(o *ssocreds.Options) {
+			o.CachedTokenFilepath = filepath.Join(s.SSOCacheDir, ssoCacheFileName(o.StartURL))
+		}),
+	}
+}
+
+// ssoCacheFileName returns the name the AWS CLI gives the cached token of a start URL.
+func ssoCacheFileName(startURL string) string {
+	sum := sha1.Sum([]byte(startURL))
+	return hex.EncodeToString(sum[:]) + ".json"
+}
+
+// credentialProcessProvider returns the provider running credential_process, if configured.
+func (s *S3Storage) credentialProcessProvider() aws.CredentialsProvider {
+	if s.CredentialProcess == "" {
+		return nil
+	}
+	return aws.NewCredentialsCache(processcreds.NewProvider(s.CredentialProcess))
+}
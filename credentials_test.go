@@ -0,0 +1,19 @@
+package s3
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+)
+
+func TestSSOCacheFileName(t *testing.T) {
+	const startURL = "https://example.awsapps.com/start"
+	standard, err := ssocreds.StandardCachedTokenFilepath(startURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ssoCacheFileName(startURL); got != filepath.Base(standard) {
+		t.Errorf("ssoCacheFileName = %s, want %s as the SDK", got, filepath.Base(standard))
+	}
+}
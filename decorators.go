@@ -0,0 +1,322 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+// S3Storage has its features built in and configured through its fields. Go users composing
+// their own storage can add some of them to any certmagic.Storage, including an S3Storage,
+// with decorators:
+//
+//	storage := s3.Wrap(inner,
+//		s3.WithMetrics(metrics),
+//		s3.WithCircuitBreaker(5, 30*time.Second),
+//		s3.WithReadCache(64<<20, time.Minute),
+//	)
+//
+// The first decorator is the outermost, so above, metrics also count the calls failed fast by
+// the circuit breaker, and the circuit breaker doesn't see cache hits.
+//
+// The decorators are for storages other than S3Storage, or for composing on top of one. S3Storage
+// doesn't use them for read_only and read_cache_size, as its read cache is also filled by
+// warm_start and invalidated across instances by cache_invalidation, which the decorators know
+// nothing about.
+
+// Decorator adds a feature to a certmagic.Storage.
+type Decorator func(inner certmagic.Storage) certmagic.Storage
+
+// Wrap returns inner with the decorators applied, the first one outermost.
+func Wrap(inner certmagic.Storage, decorators ...Decorator) certmagic.Storage {
+	for i := len(decorators) - 1; i >= 0; i-- {
+		inner = decorators[i](inner)
+	}
+	return inner
+}
+
+// WithReadOnly refuses Store, Delete and Lock with ErrReadOnly; Unlock succeeds, as nothing
+// was locked.
+func WithReadOnly() Decorator {
+	return func(inner certmagic.Storage) certmagic.Storage {
+		return &readOnlyStorage{Storage: inner}
+	}
+}
+
+type readOnlyStorage struct {
+	certmagic.Storage
+}
+
+func (s *readOnlyStorage) Store(_ context.Context, key string, _ []byte) error {
+	return fmt.Errorf("store %s: %w", key, ErrReadOnly)
+}
+
+func (s *readOnlyStorage) Delete(_ context.Context, key string) error {
+	return fmt.Errorf("delete %s: %w", key, ErrReadOnly)
+}
+
+func (s *readOnlyStorage) Lock(_ context.Context, key string) error {
+	return fmt.Errorf("lock %s: %w", key, ErrReadOnly)
+}
+
+func (s *readOnlyStorage) Unlock(context.Context, string) error {
+	return nil
+}
+
+// WithReadCache caches loaded values in memory like read_cache_size and read_cache_ttl, bounded
+// by their total size in bytes. Store and Delete through the decorator drop the cached values.
+func WithReadCache(budget int64, ttl time.Duration) Decorator {
+	return func(inner certmagic.Storage) certmagic.Storage {
+		return &cachingStorage{Storage: inner, cache: newReadCache(budget, ttl, zap.NewNop())}
+	}
+}
+
+type cachingStorage struct {
+	certmagic.Storage
+	cache *readCache
+}
+
+func (s *cachingStorage) Load(ctx context.Context, key string) ([]byte, error) {
+	if value, ok := s.cache.get(key); ok {
+		return append([]byte(nil), value...), nil
+	}
+	gen := s.cache.generation()
+	value, err := s.Storage.Load(ctx, key)
+	if err == nil {
+		s.cache.put(key, append([]byte(nil), value...), gen)
+	}
+	return value, err
+}
+
+// Store drops the cached value before and after the write, so that a Load racing with it doesn't
+// cache the old value.
+func (s *cachingStorage) Store(ctx context.Context, key string, value []byte) error {
+	s.cache.remove(key)
+	defer s.cache.remove(key)
+	return s.Storage.Store(ctx, key, value)
+}
+
+func (s *cachingStorage) Delete(ctx context.Context, key string) error {
+	s.cache.remove(key)
+	defer s.cache.remove(key)
+	return s.Storage.Delete(ctx, key)
+}
+
+// CacheStats returns the statistics of the cache.
+func (s *cachingStorage) CacheStats() CacheStats {
+	return s.cache.snapshot()
+}
+
+// OperationStats describes the calls of one storage method.
+type OperationStats struct {
+	Calls        uint64  `json:"calls"`
+	Errors       uint64  `json:"errors"` // Not counting fs.ErrNotExist
+	TotalSeconds float64 `json:"total_seconds"`
+	MaxSeconds   float64 `json:"max_seconds"`
+}
+
+// StorageMetrics collects the calls passing WithMetrics decorators, by method.
+type StorageMetrics struct {
+	mu  sync.Mutex
+	ops map[string]*OperationStats
+}
+
+// Snapshot returns the statistics collected so far, by method name, e.g. "Load".
+func (m *StorageMetrics) Snapshot() map[string]OperationStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := make(map[string]OperationStats, len(m.ops))
+	for op, stats := range m.ops {
+		snapshot[op] = *stats
+	}
+	return snapshot
+}
+
+// observe records one call.
+func (m *StorageMetrics) observe(op string, start time.Time, err error) {
+	elapsed := time.Since(start).Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ops == nil {
+		m.ops = make(map[string]*OperationStats)
+	}
+	stats, ok := m.ops[op]
+	if !ok {
+		stats = new(OperationStats)
+		m.ops[op] = stats
+	}
+	stats.Calls++
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		stats.Errors++
+	}
+	stats.TotalSeconds += elapsed
+	stats.MaxSeconds = max(stats.MaxSeconds, elapsed)
+}
+
+// WithMetrics counts the calls, errors and latency of every storage method in m, which may be
+// shared by several decorated storages.
+func WithMetrics(m *StorageMetrics) Decorator {
+	return func(inner certmagic.Storage) certmagic.Storage {
+		return &instrumentedStorage{inner: inner, metrics: m}
+	}
+}
+
+type instrumentedStorage struct {
+	inner   certmagic.Storage
+	metrics *StorageMetrics
+}
+
+func (s *instrumentedStorage) Lock(ctx context.Context, key string) error {
+	return s.observed("Lock", func() error { return s.inner.Lock(ctx, key) })
+}
+
+func (s *instrumentedStorage) Unlock(ctx context.Context, key string) error {
+	return s.observed("Unlock", func() error { return s.inner.Unlock(ctx, key) })
+}
+
+func (s *instrumentedStorage) Store(ctx context.Context, key string, value []byte) error {
+	return s.observed("Store", func() error { return s.inner.Store(ctx, key, value) })
+}
+
+func (s *instrumentedStorage) Load(ctx context.Context, key string) (value []byte, err error) {
+	err = s.observed("Load", func() error { value, err = s.inner.Load(ctx, key); return err })
+	return value, err
+}
+
+func (s *instrumentedStorage) Delete(ctx context.Context, key string) error {
+	return s.observed("Delete", func() error { return s.inner.Delete(ctx, key) })
+}
+
+func (s *instrumentedStorage) Exists(ctx context.Context, key string) bool {
+	var exists bool
+	_ = s.observed("Exists", func() error { exists = s.inner.Exists(ctx, key); return nil })
+	return exists
+}
+
+func (s *instrumentedStorage) List(ctx context.Context, prefix string, recursive bool) (keys []string, err error) {
+	err = s.observed("List", func() error { keys, err = s.inner.List(ctx, prefix, recursive); return err })
+	return keys, err
+}
+
+func (s *instrumentedStorage) Stat(ctx context.Context, key string) (info certmagic.KeyInfo, err error) {
+	err = s.observed("Stat", func() error { info, err = s.inner.Stat(ctx, key); return err })
+	return info, err
+}
+
+func (s *instrumentedStorage) observed(op string, call func() error) error {
+	start := time.Now()
+	err := call()
+	s.metrics.observe(op, start, err)
+	return err
+}
+
+// ErrCircuitOpen is returned by a storage decorated WithCircuitBreaker while the circuit is open.
+var ErrCircuitOpen = errors.New("storage circuit breaker is open")
+
+// WithCircuitBreaker fails calls fast with ErrCircuitOpen for cooldown after threshold
+// consecutive failures, instead of letting every caller wait for an unavailable backend. Once
+// the cooldown has passed, one call is let through; its success closes the circuit again.
+// fs.ErrNotExist, ErrReadOnly and cancelled contexts are not failures.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Decorator {
+	return func(inner certmagic.Storage) certmagic.Storage {
+		return &circuitBreakerStorage{inner: inner, threshold: max(threshold, 1), cooldown: cooldown}
+	}
+}
+
+type circuitBreakerStorage struct {
+	inner     certmagic.Storage
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int       // Consecutive failures
+	openUntil time.Time // Zero while closed
+	probing   bool      // A call is let through after the cooldown
+}
+
+// allow reports whether a call may go to the inner storage.
+func (s *circuitBreakerStorage) allow() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.openUntil.IsZero():
+		return nil
+	case time.Now().Before(s.openUntil) || s.probing:
+		return ErrCircuitOpen
+	}
+	s.probing = true
+	return nil
+}
+
+// record updates the circuit with the outcome of a call.
+func (s *circuitBreakerStorage) record(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.probing = false
+	if err == nil || errors.Is(err, fs.ErrNotExist) || errors.Is(err, ErrReadOnly) || errors.Is(err, context.Canceled) {
+		s.failures, s.openUntil = 0, time.Time{}
+		return
+	}
+	s.failures++
+	if s.failures >= s.threshold {
+		s.openUntil = time.Now().Add(s.cooldown)
+	}
+}
+
+func (s *circuitBreakerStorage) call(fn func() error) error {
+	if err := s.allow(); err != nil {
+		return err
+	}
+	err := fn()
+	s.record(err)
+	return err
+}
+
+func (s *circuitBreakerStorage) Lock(ctx context.Context, key string) error {
+	return s.call(func() error { return s.inner.Lock(ctx, key) })
+}
+
+// Unlock always goes through, so a lock is not left behind while the circuit is open.
+func (s *circuitBreakerStorage) Unlock(ctx context.Context, key string) error {
+	return s.inner.Unlock(ctx, key)
+}
+
+func (s *circuitBreakerStorage) Store(ctx context.Context, key string, value []byte) error {
+	return s.call(func() error { return s.inner.Store(ctx, key, value) })
+}
+
+func (s *circuitBreakerStorage) Load(ctx context.Context, key string) (value []byte, err error) {
+	err = s.call(func() error { value, err = s.inner.Load(ctx, key); return err })
+	return value, err
+}
+
+func (s *circuitBreakerStorage) Delete(ctx context.Context, key string) error {
+	return s.call(func() error { return s.inner.Delete(ctx, key) })
+}
+
+// Exists reports true while the circuit is open, so that CertMagic doesn't take the key for
+// missing and, e.g., obtain a certificate it may already have, like exists_on_error.
+func (s *circuitBreakerStorage) Exists(ctx context.Context, key string) bool {
+	if s.allow() != nil {
+		return true
+	}
+	exists := s.inner.Exists(ctx, key)
+	s.record(nil) // Exists cannot tell failures from missing keys
+	return exists
+}
+
+func (s *circuitBreakerStorage) List(ctx context.Context, prefix string, recursive bool) (keys []string, err error) {
+	err = s.call(func() error { keys, err = s.inner.List(ctx, prefix, recursive); return err })
+	return keys, err
+}
+
+func (s *circuitBreakerStorage) Stat(ctx context.Context, key string) (info certmagic.KeyInfo, err error) {
+	err = s.call(func() error { info, err = s.inner.Stat(ctx, key); return err })
+	return info, err
+}
@@ -0,0 +1,91 @@
+package s3_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	s3 "github.com/cvhome-saas/certmagic-s3"
+)
+
+// flakyStorage fails every call while down is set, and counts the loads reaching it.
+type flakyStorage struct {
+	certmagic.Storage
+	down  bool
+	loads int
+}
+
+var errDown = errors.New("backend down")
+
+func (s *flakyStorage) Load(ctx context.Context, key string) ([]byte, error) {
+	s.loads++
+	if s.down {
+		return nil, errDown
+	}
+	return s.Storage.Load(ctx, key)
+}
+
+func TestWrap(t *testing.T) {
+	ctx := context.Background()
+	inner := &flakyStorage{Storage: &certmagic.FileStorage{Path: t.TempDir()}}
+	if err := inner.Store(ctx, "a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := new(s3.StorageMetrics)
+	storage := s3.Wrap(inner,
+		s3.WithMetrics(metrics),
+		s3.WithCircuitBreaker(2, 50*time.Millisecond),
+		s3.WithReadCache(1<<20, time.Minute),
+		s3.WithReadOnly(),
+	)
+
+	if err := storage.Store(ctx, "b", []byte("2")); !errors.Is(err, s3.ErrReadOnly) {
+		t.Fatalf("Store: got %v, want ErrReadOnly", err)
+	}
+	if _, err := storage.Load(ctx, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Load missing: got %v, want fs.ErrNotExist", err)
+	}
+	for range 2 {
+		if value, err := storage.Load(ctx, "a"); err != nil || string(value) != "1" {
+			t.Fatalf("Load: got %q, %v", value, err)
+		}
+	}
+	if inner.loads != 2 {
+		t.Errorf("loads reaching the backend = %d, want 2 (second load cached)", inner.loads)
+	}
+
+	// Two failures open the circuit; further calls fail fast until the cooldown has passed.
+	inner.down = true
+	for range 2 {
+		if _, err := storage.Load(ctx, "c"); !errors.Is(err, errDown) {
+			t.Fatalf("Load while down: got %v", err)
+		}
+	}
+	loads := inner.loads
+	if _, err := storage.Load(ctx, "c"); !errors.Is(err, s3.ErrCircuitOpen) {
+		t.Fatalf("Load with open circuit: got %v, want ErrCircuitOpen", err)
+	}
+	if inner.loads != loads {
+		t.Error("open circuit let a call through")
+	}
+	if !storage.Exists(ctx, "c") {
+		t.Error("Exists with open circuit = false, want true")
+	}
+	inner.down = false
+	time.Sleep(60 * time.Millisecond)
+	if _, err := storage.Load(ctx, "c"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Load after cooldown: got %v, want fs.ErrNotExist", err)
+	}
+
+	stats := metrics.Snapshot()
+	if got := stats["Load"]; got.Calls != 7 || got.Errors != 3 {
+		t.Errorf("Load stats = %+v, want 7 calls and 3 errors", got)
+	}
+	if got := stats["Store"]; got.Calls != 1 || got.Errors != 1 {
+		t.Errorf("Store stats = %+v, want 1 call and 1 error", got)
+	}
+}
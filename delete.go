@@ -0,0 +1,162 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.uber.org/zap"
+)
+
+// deleteBatchSize is the maximum number of keys accepted by a single DeleteObjects call.
+const deleteBatchSize = 1000
+
+// DeleteAll removes every object below the given CertMagic key prefix (treated as a directory)
+// using batched DeleteObjects calls. Lock objects are left alone so that locks held by other
+// instances are not released behind their back. It returns the number of deleted objects.
+func (s *S3Storage) DeleteAll(ctx context.Context, prefix string) (int, error) {
+	if err := s.checkWritable("delete", prefix); err != nil {
+		return 0, err
+	}
+	deleted, err := s.deletePrefix(ctx, prefix)
+	if deleted > 0 {
+		s.logger.Info("deleted objects under prefix", zap.String("prefix", prefix), zap.Int("count", deleted))
+	}
+	return deleted, err
+}
+
+// deletePrefix deletes the objects below a CertMagic key prefix for DeleteAll, descending into routes
+// and shards.
+func (s *S3Storage) deletePrefix(ctx context.Context, prefix string) (int, error) {
+	if s.shardedListing(ctx, prefix) {
+		deleted := 0
+		err := s.fanOut(ctx, func(ctx context.Context) error {
+			n, err := s.deletePrefix(ctx, prefix)
+			deleted += n
+			return err
+		})
+		return deleted, err
+	}
+	routes := s.routesBelow(prefix)
+	s3Prefix := s.s3ObjectKey(prefix)
+	if s3Prefix != "" && !strings.HasSuffix(s3Prefix, "/") {
+		s3Prefix += "/"
+	}
+	s.logger.Debug("deleting prefix", zap.String("prefix", prefix), zap.String("s3_prefix", s3Prefix))
+
+	paginator := awss3.NewListObjectsV2Paginator(s.Client, &awss3.ListObjectsV2Input{
+		Bucket:  aws.String(s.Bucket),
+		Prefix:  aws.String(s3Prefix),
+		MaxKeys: aws.Int32(deleteBatchSize), // One page fills at most one batch
+	})
+
+	deleted := 0
+	for paginator.HasMorePages() {
+		pageCtx, cancel := s.listContext(ctx)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			return deleted, fmt.Errorf("listing s3://%s/%s for deletion: %w", s.Bucket, s3Prefix, s3Error(err))
+		}
+
+		var objects []types.ObjectIdentifier
+		for _, obj := range page.Contents {
+			if obj.Key == nil {
+				continue
+			}
+			key := s.certMagicKey(*obj.Key)
+			if s.isLockKey(key) || routedAway(key, routes) || (isTrashKey(key) && !isTrashKey(prefix)) {
+				continue
+			}
+			if err := s.moveToTrash(ctx, key); err != nil {
+				return deleted, err
+			}
+			objects = append(objects, types.ObjectIdentifier{Key: obj.Key})
+		}
+		n, err := s.deleteBatch(ctx, objects)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	for _, route := range routes {
+		n, err := s.deletePrefix(ctx, route)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// deleteBatch removes up to deleteBatchSize objects with a single DeleteObjects request.
+func (s *S3Storage) deleteBatch(ctx context.Context, objects []types.ObjectIdentifier) (int, error) {
+	if len(objects) == 0 {
+		return 0, nil
+	}
+
+	if s.isGCS() {
+		return s.deleteEach(ctx, objects)
+	}
+
+	delCtx, cancel := s.opContext(ctx)
+	out, err := s.Client.DeleteObjects(delCtx, &awss3.DeleteObjectsInput{
+		Bucket: aws.String(s.Bucket),
+		Delete: &types.Delete{
+			Objects: objects,
+			Quiet:   aws.Bool(true), // Only report failures
+		},
+	})
+	cancel()
+	if err != nil {
+		return 0, fmt.Errorf("deleting %d objects from s3://%s: %w", len(objects), s.Bucket, s3Error(err))
+	}
+	var failed []types.Error
+	for _, e := range out.Errors {
+		if aws.ToString(e.Code) == "AccessDenied" && s.retainedDeleteHandled(ctx, e.Key, e.VersionId, fmt.Errorf("%s: %s", aws.ToString(e.Code), aws.ToString(e.Message))) {
+			continue
+		}
+		failed = append(failed, e)
+	}
+	if len(failed) > 0 {
+		first := failed[0]
+		return len(objects) - len(failed), fmt.Errorf("deleting %d of %d objects from s3://%s failed, first: %s: %s",
+			len(failed), len(objects), s.Bucket, aws.ToString(first.Key), aws.ToString(first.Message))
+	}
+	return len(objects), nil
+}
+
+// retainedDeleteHandled passes an object the bucket refused to delete in a batch to deleteDenied,
+// if object_lock is set, and reports whether that handled it, e.g. by writing a tombstone.
+// Refused deletes of specific versions are left alone.
+func (s *S3Storage) retainedDeleteHandled(ctx context.Context, s3Key, versionID *string, deleteErr error) bool {
+	if s.objectLock == nil || s3Key == nil || versionID != nil {
+		return false
+	}
+	key := s.certMagicKey(*s3Key)
+	if err := s.deleteDenied(ctx, key, *s3Key, deleteErr); err != nil {
+		s.recordError("delete", key, err)
+		return false
+	}
+	return true
+}
+
+// deleteEach removes objects one request at a time, for providers without DeleteObjects.
+func (s *S3Storage) deleteEach(ctx context.Context, objects []types.ObjectIdentifier) (int, error) {
+	for i, obj := range objects {
+		delCtx, cancel := s.opContext(ctx)
+		_, err := s.Client.DeleteObject(delCtx, &awss3.DeleteObjectInput{Bucket: aws.String(s.Bucket), Key: obj.Key, VersionId: obj.VersionId})
+		cancel()
+		if err != nil && classifyError(err) == errorClassAccessDenied && s.retainedDeleteHandled(ctx, obj.Key, obj.VersionId, err) {
+			continue
+		}
+		if err != nil && !s.isNotFound(err) {
+			return i, fmt.Errorf("deleting s3://%s/%s: %w", s.Bucket, aws.ToString(obj.Key), s3Error(err))
+		}
+	}
+	return len(objects), nil
+}
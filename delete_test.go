@@ -0,0 +1,81 @@
+package s3_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3 "github.com/cvhome-saas/certmagic-s3"
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+// listCountingClient counts the listings of the storage.
+type listCountingClient struct {
+	s3.S3API
+	lists atomic.Int32
+}
+
+func (c *listCountingClient) ListObjectsV2(ctx context.Context, params *awss3.ListObjectsV2Input, optFns ...func(*awss3.Options)) (*awss3.ListObjectsV2Output, error) {
+	c.lists.Add(1)
+	return c.S3API.ListObjectsV2(ctx, params, optFns...)
+}
+
+func TestDelete(t *testing.T) {
+	storage, fake := s3test.NewFakeStorage(t)
+	client := &listCountingClient{S3API: storage.Client}
+	storage.Client = client
+	ctx := context.Background()
+	for _, key := range []string{"certificates/a.crt", "certificates/dir/x", "certificates/dir/sub/y", "other"} {
+		if err := storage.Store(ctx, key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := storage.Delete(ctx, "certificates/a.crt"); err != nil {
+		t.Fatal(err)
+	}
+	if n := client.lists.Load(); n != 0 {
+		t.Errorf("deleting a single key listed %d times", n)
+	}
+	if err := storage.Delete(ctx, "certificates/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Delete(ctx, "certificates/missing"); err != nil {
+		t.Errorf("Delete of a missing key = %v", err)
+	}
+	for _, key := range []string{"", "/", "//"} {
+		if err := storage.Delete(ctx, key); err == nil {
+			t.Errorf("Delete(%q) succeeded", key)
+		}
+	}
+	if keys := fake.Keys(s3test.DefaultBucket); !slices.Equal(keys, []string{"certmagic/other"}) {
+		t.Errorf("remaining objects = %v, want only certmagic/other", keys)
+	}
+}
+
+func TestStrictDelete(t *testing.T) {
+	storage, _ := s3test.NewFakeStorage(t)
+	ctx := context.Background()
+	protected := "certificates/acme/a.com/a.com.crt"
+	storage.Client = &retainingClient{S3API: storage.Client, retained: func(s3Key string) bool { return s3Key == "certmagic/"+protected }}
+	if err := storage.Store(ctx, protected, []byte("cert")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Delete(ctx, protected); err != nil {
+		t.Errorf("Delete without strict_delete = %v, want nil", err)
+	}
+	storage.StrictDelete = true
+	if err := storage.Delete(ctx, protected); !errors.Is(err, s3.ErrAccessDenied) {
+		t.Errorf("strict Delete of a protected key = %v, want ErrAccessDenied", err)
+	}
+	if !storage.Exists(ctx, protected) {
+		t.Error("value gone after failed Delete")
+	}
+	if err := storage.Delete(ctx, "certificates/acme/missing.com/missing.com.crt"); err != nil {
+		t.Errorf("strict Delete of a missing key = %v, want nil", err)
+	}
+}
@@ -0,0 +1,101 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+)
+
+// AccessDeniedError is returned when S3 refuses an operation. It carries the AWS error code,
+// the request ID, and a remediation hint derived from the error document, which usually
+// names the kind of policy (SCP, bucket policy, ...) that caused the denial.
+type AccessDeniedError struct {
+	Code      string
+	Message   string
+	RequestID string
+	Hint      string
+	Err       error
+}
+
+func (e *AccessDeniedError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s", e.Code, e.Message)
+	if e.RequestID != "" {
+		fmt.Fprintf(&b, " (request id %s)", e.RequestID)
+	}
+	if e.Hint != "" {
+		fmt.Fprintf(&b, "; hint: %s", e.Hint)
+	}
+	return b.String()
+}
+
+func (e *AccessDeniedError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrAccessDenied) hold.
+func (e *AccessDeniedError) Is(target error) bool {
+	return target == ErrAccessDenied
+}
+
+// accessDeniedHints maps fragments of AWS denial messages to remediation hints; first match wins.
+var accessDeniedHints = []struct {
+	fragment string
+	hint     string
+}{
+	{"service control policy", "an AWS Organizations SCP denies this action; SCPs commonly require server-side encryption " +
+		"headers (s3:x-amz-server-side-encryption), TLS (aws:SecureTransport, s3:TlsVersion) or specific regions (aws:RequestedRegion)"},
+	{"resource control policy", "an AWS Organizations resource control policy denies this action; check its conditions on the bucket"},
+	{"resource-based policy", "the bucket policy explicitly denies this action; check its conditions, e.g. required " +
+		"encryption headers (s3:x-amz-server-side-encryption) or aws:SecureTransport"},
+	{"permissions boundary", "a permissions boundary attached to the caller does not allow this action"},
+	{"session policy", "the session policy of the assumed role does not allow this action"},
+	{"vpc endpoint policy", "the VPC endpoint policy does not allow this action on the bucket"},
+	{"identity-based policy", "the IAM policy of the caller does not allow this action"},
+	{"kms", "access to the KMS key used for bucket encryption is denied; grant kms:GenerateDataKey and kms:Decrypt"},
+	{"object ownership", "the bucket enforces object ownership; ACL headers are not allowed"},
+}
+
+// explainAccessDenied turns S3 AccessDenied errors into an *AccessDeniedError with a remediation
+// hint. Any other error is returned unchanged.
+func explainAccessDenied(err error) error {
+	var ade *AccessDeniedError
+	if errors.As(err, &ade) {
+		return err // Already explained
+	}
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch apiErr.ErrorCode() {
+	case "AccessDenied", "AllAccessDisabled", "Forbidden":
+	default:
+		return err
+	}
+
+	ade = &AccessDeniedError{
+		Code:    apiErr.ErrorCode(),
+		Message: apiErr.ErrorMessage(),
+		Err:     err,
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		ade.RequestID = respErr.ServiceRequestID()
+	}
+
+	msg := strings.ToLower(ade.Message)
+	for _, h := range accessDeniedHints {
+		if strings.Contains(msg, h.fragment) {
+			ade.Hint = h.hint
+			break
+		}
+	}
+	if ade.Hint == "" && ade.Message == "" {
+		// HEAD requests have no error document, only the status code.
+		ade.Hint = "no error document was returned (HEAD request); retry the equivalent GET to see the policy that denies access"
+	}
+	return ade
+}
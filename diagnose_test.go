@@ -0,0 +1,58 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestExplainAccessDenied(t *testing.T) {
+	denied := &smithy.GenericAPIError{
+		Code: "AccessDenied",
+		Message: "User: arn:aws:sts::123456789012:assumed-role/caddy/i-1 is not authorized to perform: s3:PutObject " +
+			"on resource: \"arn:aws:s3:::bucket/certmagic/x\" with an explicit deny in a service control policy",
+	}
+
+	err := explainAccessDenied(denied)
+	var ade *AccessDeniedError
+	if !errors.As(err, &ade) {
+		t.Fatalf("expected *AccessDeniedError, got %T", err)
+	}
+	if ade.Code != "AccessDenied" {
+		t.Errorf("unexpected code %q", ade.Code)
+	}
+	if !strings.Contains(ade.Hint, "SCP") {
+		t.Errorf("expected SCP hint, got %q", ade.Hint)
+	}
+	if !errors.Is(err, denied) {
+		t.Errorf("expected the original error to stay reachable")
+	}
+
+	other := &smithy.GenericAPIError{Code: "SlowDown", Message: "Please reduce your request rate."}
+	if explainAccessDenied(other) != other {
+		t.Errorf("expected non-denial errors to be returned unchanged")
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{explainAccessDenied(&smithy.GenericAPIError{Code: "AccessDenied"}), errorClassAccessDenied},
+		{fmt.Errorf("wrapped: %w", &smithy.GenericAPIError{Code: "ExpiredToken"}), errorClassCredentials},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, errorClassNetwork},
+		{context.DeadlineExceeded, errorClassNetwork},
+		{&smithy.GenericAPIError{Code: "InternalError"}, errorClassOther},
+	}
+	for _, c := range cases {
+		if got := classifyError(c.err); got != c.want {
+			t.Errorf("classifyError(%v) = %s, want %s", c.err, got, c.want)
+		}
+	}
+}
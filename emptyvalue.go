@@ -0,0 +1,67 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.uber.org/zap"
+)
+
+// CertMagic occasionally stores empty values as markers. Some S3-compatible providers reject
+// 0-byte PUTs, e.g. with certain checksum settings. Empty values are then written as a 1-byte
+// sentinel object flagged in its metadata, which Load and Stat map back to an empty value.
+
+// emptySentinelMeta is the user metadata key flagging a sentinel object.
+const emptySentinelMeta = "certmagic-empty"
+
+// emptySentinelBody is the body of a sentinel object.
+var emptySentinelBody = []byte{0}
+
+// useEmptySentinel reports whether empty values are written as sentinel objects, because it is
+// configured or because a 0-byte PUT was rejected before.
+func (s *S3Storage) useEmptySentinel() bool {
+	return s.EmptyValueSentinel || s.emptySentinel.Load()
+}
+
+// putEmptySentinel writes the sentinel object standing for an empty value at s3Key.
+func (s *S3Storage) putEmptySentinel(ctx context.Context, s3Key string) (*awss3.PutObjectOutput, error) {
+	ctx, cancel := s.opContext(ctx)
+	defer cancel()
+	input := &awss3.PutObjectInput{
+		Bucket:        aws.String(s.Bucket),
+		Key:           aws.String(s3Key),
+		Body:          bytes.NewReader(emptySentinelBody),
+		ContentLength: aws.Int64(int64(len(emptySentinelBody))),
+		StorageClass:  types.StorageClass(s.StorageClass),
+		ContentType:   s.contentType(),
+		Tagging:       s.objectTagging(),
+		Metadata:      s.objectMetadata(map[string]string{emptySentinelMeta: "1"}),
+	}
+	applyPutCondition(ctx, input)
+	return s.Client.PutObject(ctx, input)
+}
+
+// storeEmptyFallback retries a rejected 0-byte PUT as a sentinel object. Once that succeeds, all
+// later empty values are written as sentinels right away.
+func (s *S3Storage) storeEmptyFallback(ctx context.Context, key, s3Key string, putErr error) (*awss3.PutObjectOutput, error) {
+	if classifyError(putErr) != errorClassOther {
+		return nil, putErr // Not caused by the empty body
+	}
+	out, err := s.putEmptySentinel(ctx, s3Key)
+	if err != nil {
+		return nil, putErr
+	}
+	if !s.emptySentinel.Swap(true) {
+		s.logger.Info("provider rejected an empty object; writing empty values as 1-byte sentinel objects",
+			zap.String("key", key), zap.Error(putErr))
+	}
+	return out, nil
+}
+
+// isEmptySentinel reports whether an object's user metadata flags it as an empty value.
+func isEmptySentinel(metadata map[string]string) bool {
+	return metadata[emptySentinelMeta] == "1"
+}
@@ -0,0 +1,88 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// A wrong encryption key is otherwise only noticed when a value is read back, possibly long
+// after provisioning. VerifyEncryption reads a sentinel object of known content with the
+// configured encryption, writing it on first use, so a mismatch between the configured key and
+// the existing data is reported right away; with verify_on_start, Provision fails on it.
+
+// encryptionCheckKey is the CertMagic key of the sentinel object.
+const encryptionCheckKey = ".encryption-check"
+
+// encryptionCheckValue is the plaintext of the sentinel object.
+var encryptionCheckValue = []byte("certmagic-s3 encryption check")
+
+// ErrEncryptionMismatch is returned by VerifyEncryption when the configured encryption does not
+// decrypt the existing data.
+var ErrEncryptionMismatch = errors.New("encryption key does not match the existing data")
+
+// VerifyEncryption checks that the configured encryption decrypts the sentinel object below the
+// prefix, and writes the sentinel if there is none yet (unless read_only). It returns
+// ErrEncryptionMismatch if the sentinel was written with another key or encryption setting.
+// Re-encrypting the storage with Reencrypt rewrites the sentinel along with the other objects.
+func (s *S3Storage) VerifyEncryption(ctx context.Context) error {
+	value, err := s.Load(ctx, encryptionCheckKey)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if s.ReadOnly {
+			s.logger.Info("no encryption sentinel to verify the encryption key with")
+			return nil
+		}
+		if err := s.Store(ctx, encryptionCheckKey, encryptionCheckValue); err != nil {
+			return fmt.Errorf("writing encryption sentinel: %w", err)
+		}
+		s.logger.Info("wrote encryption sentinel", zap.String("key", encryptionCheckKey))
+		return nil
+	case errors.As(err, new(*Error)):
+		return fmt.Errorf("reading encryption sentinel: %w", err)
+	case err != nil:
+		return fmt.Errorf("%w (s3://%s/%s): %v", ErrEncryptionMismatch, s.Bucket, s.s3ObjectKey(encryptionCheckKey), err)
+	case !bytes.Equal(value, encryptionCheckValue):
+		return fmt.Errorf("%w (s3://%s/%s): unexpected content", ErrEncryptionMismatch, s.Bucket, s.s3ObjectKey(encryptionCheckKey))
+	}
+	s.logger.Info("verified encryption key against existing data")
+	return nil
+}
+
+func verifyEncryptionCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify-encryption --config <path>",
+		Short: "Checks that the configured encryption key matches the stored data",
+		Long: `
+Reads the encryption sentinel object below the configured prefix and checks
+that the encryption of the config decrypts it, writing it first if there is
+none yet. Exits with an error if the key (or its absence) does not match the
+data already in the bucket. The verify_on_start option runs the same check
+when Caddy provisions the storage.
+`,
+		RunE: caddycmd.WrapCommandFuncForCobra(cmdVerifyEncryption),
+	}
+	addConfigFlags(cmd)
+	return cmd
+}
+
+func cmdVerifyEncryption(fl caddycmd.Flags) (int, error) {
+	s, cancel, err := loadStorageFromConfig(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	defer cancel()
+
+	if err := s.VerifyEncryption(context.Background()); err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	fmt.Println("encryption key matches the stored data")
+	return caddy.ExitCodeSuccess, nil
+}
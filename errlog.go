@@ -0,0 +1,90 @@
+package s3
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultErrorSummaryInterval is how often repeated S3 errors are summarized when not configured.
+const defaultErrorSummaryInterval = time.Minute
+
+// errorAggregator collects S3 errors and logs a single summarized warning per interval
+// instead of one log entry per failed operation. Every error is still logged at debug level.
+type errorAggregator struct {
+	logger   *zap.Logger
+	interval time.Duration
+
+	mu          sync.Mutex
+	timer       *time.Timer
+	windowStart time.Time
+	count       int
+	first, last error
+	ops         map[string]int
+}
+
+func newErrorAggregator(logger *zap.Logger, interval time.Duration) *errorAggregator {
+	return &errorAggregator{
+		logger:   logger,
+		interval: interval,
+		ops:      make(map[string]int),
+	}
+}
+
+// record registers a failed operation. The first error of a window schedules the summary.
+func (a *errorAggregator) record(op, key string, err error) {
+	a.logger.Debug("s3 operation failed", zap.String("op", op), zap.String("key", key), zap.Error(err))
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.count == 0 {
+		a.windowStart = time.Now()
+		a.first = err
+		a.timer = time.AfterFunc(a.interval, a.flush)
+	}
+	a.count++
+	a.last = err
+	a.ops[op]++
+}
+
+// flush logs the summary of the current window, if any errors were recorded, and starts a new one.
+func (a *errorAggregator) flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.count == 0 {
+		return
+	}
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+
+	ops := make([]string, 0, len(a.ops))
+	for op, n := range a.ops {
+		ops = append(ops, fmt.Sprintf("%s=%d", op, n))
+	}
+	sort.Strings(ops)
+
+	a.logger.Warn("s3 operations failing",
+		zap.Int("count", a.count),
+		zap.Duration("window", time.Since(a.windowStart)),
+		zap.NamedError("first_error", a.first),
+		zap.NamedError("last_error", a.last),
+		zap.Strings("ops", ops),
+	)
+
+	a.count = 0
+	a.first, a.last = nil, nil
+	a.ops = make(map[string]int)
+}
+
+// recordError reports a failed S3 operation to the error aggregator.
+func (s *S3Storage) recordError(op, key string, err error) {
+	if s.errAgg == nil { // Not provisioned, e.g. in tests
+		return
+	}
+	s.errAgg.record(op, key, err)
+}
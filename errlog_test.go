@@ -0,0 +1,40 @@
+package s3
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestErrorAggregatorSummarizes(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	agg := newErrorAggregator(zap.New(core), time.Hour)
+
+	agg.record("load", "a", errors.New("first"))
+	agg.record("load", "b", errors.New("second"))
+	agg.record("store", "c", errors.New("third"))
+	if logs.Len() != 0 {
+		t.Fatalf("expected no warnings before the interval elapsed, got %d", logs.Len())
+	}
+
+	agg.flush()
+	if logs.Len() != 1 {
+		t.Fatalf("expected exactly one summary, got %d", logs.Len())
+	}
+	fields := logs.All()[0].ContextMap()
+	if fields["count"] != int64(3) {
+		t.Errorf("expected count 3, got %v", fields["count"])
+	}
+	if fields["first_error"] != "first" || fields["last_error"] != "third" {
+		t.Errorf("unexpected first/last errors: %v / %v", fields["first_error"], fields["last_error"])
+	}
+
+	agg.flush()
+	if logs.Len() != 1 {
+		t.Errorf("expected no summary for an empty window")
+	}
+}
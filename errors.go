@@ -0,0 +1,125 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+)
+
+// Kinds of storage errors, for use with errors.Is. Errors returned by the storage operations
+// match at most one of them; errors.As additionally yields *Error or *AccessDeniedError.
+var (
+	// ErrTransient marks failures likely to go away when retried later, e.g. network
+	// errors, timeouts and 5xx responses.
+	ErrTransient = errors.New("transient S3 failure")
+	// ErrThrottled marks requests S3 rejected because of the request rate.
+	ErrThrottled = errors.New("throttled by S3")
+	// ErrAccessDenied marks permission and credential problems, which need a configuration change.
+	ErrAccessDenied = errors.New("access to S3 denied")
+	// ErrNotFound is returned for missing keys. It is fs.ErrNotExist, as CertMagic expects.
+	ErrNotFound = fs.ErrNotExist
+)
+
+// Error is a failed S3 request, classified by Kind.
+type Error struct {
+	Kind error // ErrTransient, ErrThrottled or ErrAccessDenied
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Err, e.Kind}
+}
+
+// Error classes reported by classifyError.
+const (
+	errorClassAccessDenied = "access_denied"
+	errorClassCredentials  = "credentials"
+	errorClassThrottled    = "throttled"
+	errorClassNetwork      = "network"
+	errorClassServer       = "server"
+	errorClassOther        = "other"
+)
+
+// credentialErrorCodes are the S3 error codes caused by invalid or expired credentials.
+var credentialErrorCodes = map[string]bool{
+	"ExpiredToken":          true,
+	"InvalidAccessKeyId":    true,
+	"InvalidToken":          true,
+	"SignatureDoesNotMatch": true,
+	"TokenRefreshRequired":  true,
+}
+
+// throttlingErrorCodes are the error codes S3 and S3-compatibles use to reject requests by rate.
+var throttlingErrorCodes = map[string]bool{
+	"SlowDown":                               true,
+	"Throttling":                             true,
+	"ThrottlingException":                    true,
+	"RequestLimitExceeded":                   true,
+	"RequestThrottled":                       true,
+	"TooManyRequestsException":               true,
+	"ProvisionedThroughputExceededException": true,
+}
+
+// classifyError tells apart errors caused by permissions, credentials, throttling, the network
+// or the server, from errors concerning a single request.
+func classifyError(err error) string {
+	var ade *AccessDeniedError
+	if errors.As(err, &ade) {
+		return errorClassAccessDenied
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case credentialErrorCodes[apiErr.ErrorCode()]:
+			return errorClassCredentials
+		case throttlingErrorCodes[apiErr.ErrorCode()]:
+			return errorClassThrottled
+		}
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		switch status := respErr.HTTPStatusCode(); {
+		case status == http.StatusForbidden:
+			return errorClassAccessDenied // HEAD responses carry no error code
+		case status == http.StatusTooManyRequests:
+			return errorClassThrottled
+		case status >= 500:
+			return errorClassServer
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return errorClassNetwork
+	}
+	return errorClassOther
+}
+
+// s3Error prepares an error of an S3 request for returning it from a storage operation: AccessDenied
+// errors are explained (see explainAccessDenied) and other errors are marked with their kind.
+func s3Error(err error) error {
+	err = explainAccessDenied(err)
+	var kind error
+	switch classifyError(err) {
+	case errorClassAccessDenied, errorClassCredentials:
+		kind = ErrAccessDenied
+	case errorClassThrottled:
+		kind = ErrThrottled
+	case errorClassNetwork, errorClassServer:
+		kind = ErrTransient
+	default:
+		return err
+	}
+	if errors.Is(err, kind) {
+		return err
+	}
+	return &Error{Kind: kind, Err: err}
+}
@@ -0,0 +1,42 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"testing"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestS3ErrorKinds(t *testing.T) {
+	cases := []struct {
+		err  error
+		kind error
+	}{
+		{&smithy.GenericAPIError{Code: "AccessDenied"}, ErrAccessDenied},
+		{&smithy.GenericAPIError{Code: "InvalidAccessKeyId"}, ErrAccessDenied},
+		{&smithy.GenericAPIError{Code: "SlowDown"}, ErrThrottled},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, ErrTransient},
+		{&smithy.GenericAPIError{Code: "InvalidArgument"}, nil},
+	}
+	for _, c := range cases {
+		err := fmt.Errorf("storing x: %w", s3Error(c.err))
+		for _, kind := range []error{ErrAccessDenied, ErrThrottled, ErrTransient} {
+			if got := errors.Is(err, kind); got != (kind == c.kind) {
+				t.Errorf("errors.Is(%v, %v) = %v", c.err, kind, got)
+			}
+		}
+		if !errors.Is(err, c.err) {
+			t.Errorf("original error %v not reachable", c.err)
+		}
+		var apiErr smithy.APIError
+		if _, isAPI := c.err.(smithy.APIError); isAPI && !errors.As(err, &apiErr) {
+			t.Errorf("API error of %v not reachable with errors.As", c.err)
+		}
+	}
+	if !errors.Is(ErrNotFound, fs.ErrNotExist) {
+		t.Error("ErrNotFound must match fs.ErrNotExist, as CertMagic expects")
+	}
+}
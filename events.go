@@ -0,0 +1,72 @@
+package s3
+
+import (
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyevents"
+)
+
+// The storage emits Caddy events, so that the events app can e.g. run a webhook when a new
+// certificate lands in the bucket. Every event carries the key, bucket and prefix:
+//
+//	s3_storage.cert_stored      a site certificate (certificates/<issuer>/<name>/<name>.crt) was
+//	                            stored; with issuer_key, name and size
+//	s3_storage.cert_deleted     a site certificate or its directory was deleted; with issuer_key
+//	                            and name
+//	s3_storage.cert_updated     another instance reported storing a site certificate, see
+//	                            cert_notifications; with issuer_key, name and sender
+//	s3_storage.lock_contention  Lock found the lock held by another process and has to wait
+//	s3_storage.lock_takeover    Lock or TryLock overwrote an expired lock of another process
+//	s3_storage.lock_timeout     Lock gave up after the lock timeout; with waited
+//
+// Events are emitted synchronously and only if the events app is loaded, which the tls app
+// always does. Handlers cannot abort storage operations.
+
+// Names of the emitted events.
+const (
+	eventCertStored     = "s3_storage.cert_stored"
+	eventCertDeleted    = "s3_storage.cert_deleted"
+	eventCertUpdated    = "s3_storage.cert_updated"
+	eventLockContention = "s3_storage.lock_contention"
+	eventLockTakeover   = "s3_storage.lock_takeover"
+	eventLockTimeout    = "s3_storage.lock_timeout"
+)
+
+// emitCaddyEvent emits an event through the events app of the Caddy context the storage was
+// provisioned with, if it is loaded.
+func (s *S3Storage) emitCaddyEvent(name string, data map[string]any) {
+	ctx := s.caddyCtx
+	if ctx.Module() == nil { // Not provisioned as a Caddy module
+		return
+	}
+	app, ok := ctx.AppIfConfigured("events").(*caddyevents.App)
+	if !ok {
+		return
+	}
+	app.Emit(ctx, name, data)
+}
+
+// emitEvent adds the storage location to data and emits the event.
+func (s *S3Storage) emitEvent(name, key string, data map[string]any) {
+	if s.emit == nil {
+		return
+	}
+	if data == nil {
+		data = make(map[string]any)
+	}
+	data["key"], data["bucket"], data["prefix"] = key, s.Bucket, s.Prefix
+	s.emit(name, data)
+}
+
+// certEventData returns the event data of a key in CertMagic's certificate layout: a certificate
+// file or, with dirs, a site directory (certificates/<issuer>/<name>).
+func certEventData(key string, dirs bool) (map[string]any, bool) {
+	parts := strings.Split(strings.Trim(key, "/"), "/")
+	switch {
+	case isCertificateKey(key) && len(parts) == 4:
+	case dirs && len(parts) == 3 && parts[0] == "certificates":
+	default:
+		return nil, false
+	}
+	return map[string]any{"issuer_key": parts[1], "name": parts[2]}, true
+}
@@ -0,0 +1,6 @@
+package s3
+
+// OnEvent replaces the emission of Caddy events with fn, for the tests of package s3_test.
+func (s *S3Storage) OnEvent(fn func(name string, data map[string]any)) {
+	s.emit = fn
+}
@@ -0,0 +1,64 @@
+package s3_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+func TestStorageEvents(t *testing.T) {
+	storage, _ := s3test.NewFakeStorage(t)
+	var (
+		mu     sync.Mutex
+		events []string
+		data   []map[string]any
+	)
+	storage.OnEvent(func(name string, d map[string]any) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, name)
+		data = append(data, d)
+	})
+	ctx := context.Background()
+
+	cert := "certificates/acme-v02.api.letsencrypt.org-directory/example.com/example.com.crt"
+	for _, key := range []string{cert, "certificates/acme-v02.api.letsencrypt.org-directory/example.com/example.com.key"} {
+		if err := storage.Store(ctx, key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.Delete(ctx, "certificates/acme-v02.api.letsencrypt.org-directory/example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Lock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	if err := storage.Lock(waitCtx, "issue_cert_example.com"); err == nil {
+		t.Fatal("expected second lock to time out")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"s3_storage.cert_stored", "s3_storage.cert_deleted", "s3_storage.lock_contention"}
+	if len(events) != len(want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+	for i, name := range want {
+		if events[i] != name {
+			t.Errorf("event %d = %s, want %s", i, events[i], name)
+		}
+	}
+	if d := data[0]; d["key"] != cert || d["issuer_key"] != "acme-v02.api.letsencrypt.org-directory" ||
+		d["name"] != "example.com" || d["size"] != 5 || d["bucket"] != s3test.DefaultBucket || d["prefix"] != "certmagic" {
+		t.Errorf("cert_stored data = %v", d)
+	}
+	if d := data[2]; d["key"] != "issue_cert_example.com" || d["correlation_id"] == "" {
+		t.Errorf("lock_contention data = %v", d)
+	}
+}
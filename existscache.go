@@ -0,0 +1,95 @@
+package s3
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// With exists_cache_ttl, the results of Exists are cached for that long, both whether a key exists
+// and whether it doesn't. CertMagic calls Exists over and over for on-demand TLS hostnames which
+// have no certificate yet; each call would otherwise be a HeadObject request. Writes and deletes
+// of this instance update the cache right away; those of other instances become visible once the
+// entry expires (or through cache_invalidation).
+
+// maxExistsCacheEntries bounds the cache; when it is full, expired entries are dropped and, if
+// none are, the cache starts over.
+const maxExistsCacheEntries = 10000
+
+// existsCache caches the results of Exists.
+type existsCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]existsEntry
+	gen     uint64 // Incremented by every change, so results of checks racing with it are dropped
+}
+
+type existsEntry struct {
+	exists  bool
+	expires time.Time
+}
+
+func newExistsCache(ttl time.Duration) *existsCache {
+	return &existsCache{ttl: ttl, entries: make(map[string]existsEntry)}
+}
+
+// get returns the cached result for key and the generation to pass to put after checking S3.
+func (c *existsCache) get(key string) (exists, ok bool, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if ok && time.Now().After(e.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	return e.exists, ok, c.gen
+}
+
+// put caches the result of a check started at generation gen, unless the storage changed since.
+func (c *existsCache) put(key string, exists bool, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	c.set(key, exists)
+}
+
+// stored records that key was just written.
+func (c *existsCache) stored(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.set(key, true)
+}
+
+// remove forgets key and everything below it.
+func (c *existsCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	delete(c.entries, key)
+	prefix := key + "/"
+	for k := range c.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// set adds an entry, making room if the cache is full. The caller must hold c.mu.
+func (c *existsCache) set(key string, exists bool) {
+	now := time.Now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxExistsCacheEntries {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxExistsCacheEntries {
+			clear(c.entries)
+		}
+	}
+	c.entries[key] = existsEntry{exists: exists, expires: now.Add(c.ttl)}
+}
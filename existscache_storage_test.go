@@ -0,0 +1,44 @@
+package s3_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	s3 "github.com/cvhome-saas/certmagic-s3"
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+func TestStorageExistsCache(t *testing.T) {
+	storage := s3test.NewStorage(t, func(s *s3.S3Storage) { s.ExistsCacheTTL = caddy.Duration(time.Minute) })
+	ctx := context.Background()
+	heads := func() uint64 { return storage.RequestStats().Requests["HeadObject"] }
+
+	for range 3 {
+		if storage.Exists(ctx, "certificates/ca/example.com/example.com.crt") {
+			t.Fatal("expected key not to exist")
+		}
+	}
+	if n := heads(); n != 1 {
+		t.Errorf("HeadObject sent %d times for cached miss, want 1", n)
+	}
+
+	if err := storage.Store(ctx, "certificates/ca/example.com/example.com.crt", []byte("cert")); err != nil {
+		t.Fatal(err)
+	}
+	before := heads()
+	if !storage.Exists(ctx, "certificates/ca/example.com/example.com.crt") {
+		t.Fatal("expected stored key to exist")
+	}
+	if n := heads(); n != before {
+		t.Errorf("HeadObject sent %d times after Store, want 0", n-before)
+	}
+
+	if err := storage.Delete(ctx, "certificates/ca/example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if storage.Exists(ctx, "certificates/ca/example.com/example.com.crt") {
+		t.Fatal("expected key below deleted directory not to exist")
+	}
+}
@@ -0,0 +1,21 @@
+package s3
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExistsCache(t *testing.T) {
+	expiring := newExistsCache(time.Millisecond)
+	_, _, gen := expiring.get("a")
+	expiring.put("a", true, gen)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, _ := expiring.get("a"); ok {
+		t.Error("expected entry to expire")
+	}
+	_, _, gen = expiring.get("a")
+	expiring.remove("a")
+	if expiring.put("a", true, gen); len(expiring.entries) != 0 {
+		t.Error("expected result of a check racing with a change to be dropped")
+	}
+}
@@ -0,0 +1,105 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.uber.org/zap"
+)
+
+// The expiration index of renewal_prefetch is persisted below the prefix, with the ETag of each
+// certificate object it was built from. At startup, certificates/ is listed and only certificates
+// whose ETag changed since, or which are new, are loaded; the others are taken from the index.
+
+const expirationIndexKey = ".expirations"
+
+// expirationIndexEntry is the persisted index entry of one certificate.
+type expirationIndexEntry struct {
+	ETag         string    `json:"etag"`
+	RenewalStart time.Time `json:"renewal_start"`
+}
+
+// buildExpirationIndex fills the prefetcher's index and returns how many certificates it loaded.
+func (s *S3Storage) buildExpirationIndex(ctx context.Context) (int, error) {
+	persisted, err := s.loadExpirationIndex(ctx)
+	if err != nil {
+		s.logger.Warn("reading persisted expiration index failed, rebuilding it", zap.Error(err))
+	}
+	index := make(map[string]expirationIndexEntry, len(persisted))
+	var changed []string
+	err = s.walkPrefix(ctx, "certificates", func(obj types.Object) error {
+		key := s.certMagicKey(aws.ToString(obj.Key))
+		if !isCertificateKey(key) {
+			return nil
+		}
+		etag := strings.Trim(aws.ToString(obj.ETag), `"`)
+		if e, ok := persisted[key]; ok && e.ETag == etag {
+			index[key] = e
+		} else {
+			index[key] = expirationIndexEntry{ETag: etag}
+			changed = append(changed, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range changed {
+		value, err := s.Load(ctx, key)
+		if err == nil {
+			var e expirationIndexEntry
+			if e.RenewalStart, err = renewalStart(value); err == nil {
+				e.ETag = index[key].ETag
+				index[key] = e
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		s.logger.Debug("skipping certificate in expiration index", zap.String("key", key), zap.Error(err))
+		delete(index, key)
+	}
+	for key, e := range index {
+		s.prefetcher.set(key, e.RenewalStart)
+	}
+	if !maps.EqualFunc(index, persisted, func(a, b expirationIndexEntry) bool { return a.ETag == b.ETag && a.RenewalStart.Equal(b.RenewalStart) }) {
+		s.storeExpirationIndex(ctx, index)
+	}
+	s.logger.Info("certificate expiration index built", zap.Int("certificates", len(index)), zap.Int("loaded", len(changed)))
+	return len(changed), nil
+}
+
+// loadExpirationIndex returns the persisted index, or nil if there is none.
+func (s *S3Storage) loadExpirationIndex(ctx context.Context) (map[string]expirationIndexEntry, error) {
+	data, err := s.Load(ctx, expirationIndexKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var index map[string]expirationIndexEntry
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// storeExpirationIndex persists the index. Failures are logged only, as the index is rebuilt.
+func (s *S3Storage) storeExpirationIndex(ctx context.Context, index map[string]expirationIndexEntry) {
+	data, err := json.Marshal(index)
+	if err == nil {
+		err = s.Store(ctx, expirationIndexKey, data)
+	}
+	if err != nil {
+		s.logger.Warn("storing expiration index failed", zap.Error(err))
+	}
+}
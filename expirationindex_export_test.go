@@ -0,0 +1,21 @@
+package s3
+
+import (
+	"context"
+	"time"
+)
+
+// BuildExpirationIndex exposes the startup of renewal_prefetch to the tests of package s3_test.
+func (s *S3Storage) BuildExpirationIndex(ctx context.Context) (int, error) {
+	s.prefetcher = &renewalPrefetcher{certs: make(map[string]*certExpiry)}
+	return s.buildExpirationIndex(ctx)
+}
+
+// StoreSelfSigned stores a self-signed certificate for domain the way CertMagic stores one.
+func (s *S3Storage) StoreSelfSigned(ctx context.Context, domain string, notBefore, notAfter time.Time) error {
+	res, err := selfSignedResource(domain, "seed", notBefore, notAfter)
+	if err != nil {
+		return err
+	}
+	return s.storeCertificateResource(ctx, "acme", domain, res)
+}
@@ -0,0 +1,46 @@
+package s3_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	s3 "github.com/cvhome-saas/certmagic-s3"
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+func TestStorageExpirationIndex(t *testing.T) {
+	storage, fake := s3test.NewFakeStorage(t)
+	ctx := context.Background()
+	notBefore := time.Now().Add(-time.Hour)
+	for _, domain := range []string{"a.com", "b.com"} {
+		if err := storage.StoreSelfSigned(ctx, domain, notBefore, notBefore.Add(90*24*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if loaded, err := storage.BuildExpirationIndex(ctx); err != nil || loaded != 2 {
+		t.Errorf("first build loaded %d certificates, %v; want 2", loaded, err)
+	}
+
+	// A restarted instance takes unchanged certificates from the persisted index.
+	restarted, _ := s3test.NewFakeStorage(t, func(s *s3.S3Storage) { s.Client = fake })
+	if loaded, err := restarted.BuildExpirationIndex(ctx); err != nil || loaded != 0 {
+		t.Errorf("build after restart loaded %d certificates, %v; want 0", loaded, err)
+	}
+	if err := restarted.StoreSelfSigned(ctx, "c.com", notBefore, notBefore.Add(90*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := restarted.BuildExpirationIndex(ctx); err != nil || loaded != 1 {
+		t.Errorf("build after a new certificate loaded %d certificates, %v; want 1", loaded, err)
+	}
+
+	keys, err := restarted.List(ctx, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(keys, ".expirations") {
+		t.Errorf("the expiration index must be hidden from List, got %v", keys)
+	}
+}
@@ -0,0 +1,180 @@
+package s3
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+)
+
+// Export archives are gzipped tarballs with one regular file per value, named by its CertMagic key
+// and holding the decrypted value, i.e. the layout of a file_system storage. Locks and objects
+// internal to this module are not exported.
+
+func exportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export --config <path> --output <file>",
+		Short: "Writes every value of the storage to a .tar.gz archive",
+		Long: `
+Reads every value below the configured prefix, decrypting it if encryption is
+configured, and writes it to a gzipped tar archive under its CertMagic key,
+e.g. for scheduled offline backups of ACME accounts and keys. The archive is
+restored with "import --archive"; being unencrypted, it must be kept safe.
+
+The archive is written to a temporary file next to --output first and only
+renamed once complete. "-" writes to standard output.
+`,
+		RunE: caddycmd.WrapCommandFuncForCobra(cmdExport),
+	}
+	addConfigFlags(cmd)
+	cmd.Flags().StringP("output", "o", "", "Archive file to write (required)")
+	return cmd
+}
+
+func cmdExport(fl caddycmd.Flags) (int, error) {
+	output := fl.String("output")
+	if output == "" {
+		return caddy.ExitCodeFailedStartup, errors.New("--output is required")
+	}
+
+	s, cancel, err := loadStorageFromConfig(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	defer cancel()
+
+	var count int
+	if output == "-" {
+		count, err = s.Export(context.Background(), os.Stdout)
+	} else {
+		count, err = s.exportFile(context.Background(), output)
+	}
+	if err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d values\n", count)
+	return caddy.ExitCodeSuccess, nil
+}
+
+// exportFile writes the archive to a temporary file and renames it to name when complete.
+func (s *S3Storage) exportFile(ctx context.Context, name string) (int, error) {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name()) // No-op after the rename
+	count, err := s.Export(ctx, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return count, err
+	}
+	return count, os.Rename(f.Name(), name)
+}
+
+// Export writes every value below the prefix, decrypted, to w as a gzipped tar archive and returns
+// the number of values written. Values are read one at a time.
+func (s *S3Storage) Export(ctx context.Context, w io.Writer) (int, error) {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	count := 0
+	err := s.walkObjects(ctx, func(obj types.Object) error {
+		key := s.certMagicKey(aws.ToString(obj.Key))
+		if s.isHiddenKey(key) {
+			return nil
+		}
+		value, err := s.Load(ctx, key)
+		if errors.Is(err, fs.ErrNotExist) { // Deleted meanwhile, or a tombstone
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     key,
+			Size:     int64(len(value)),
+			Mode:     0o600,
+			ModTime:  aws.ToTime(obj.LastModified),
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tw.Write(value); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return count, fmt.Errorf("exporting: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return count, err
+	}
+	return count, zw.Close()
+}
+
+// importArchiveFile imports the archive in the named file, or standard input for "-".
+func (s *S3Storage) importArchiveFile(ctx context.Context, name string, overwrite, dryRun bool) (importStats, error) {
+	if name == "-" {
+		return s.importArchive(ctx, os.Stdin, overwrite, dryRun)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return importStats{}, err
+	}
+	defer f.Close()
+	return s.importArchive(ctx, f, overwrite, dryRun)
+}
+
+// importArchive stores every regular file of a gzipped tar archive under its name as CertMagic key.
+func (s *S3Storage) importArchive(ctx context.Context, r io.Reader, overwrite, dryRun bool) (importStats, error) {
+	var stats importStats
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return stats, fmt.Errorf("reading archive: %w", err)
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, fmt.Errorf("reading archive: %w", err)
+		}
+		key, ok := archiveKey(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || !ok || s.isHiddenKey(key) {
+			stats.ignored++
+			continue
+		}
+		read := func() ([]byte, error) { return io.ReadAll(tr) }
+		if err := s.importValue(ctx, key, read, overwrite, dryRun, &stats); err != nil {
+			return stats, fmt.Errorf("importing %s: %w", key, err)
+		}
+	}
+}
+
+// archiveKey returns the CertMagic key of an archive entry, rejecting names escaping the root.
+func archiveKey(name string) (string, bool) {
+	key := path.Clean(strings.TrimPrefix(name, "./"))
+	if key == "." || path.IsAbs(key) || key == ".." || strings.HasPrefix(key, "../") {
+		return "", false
+	}
+	return key, true
+}
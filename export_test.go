@@ -0,0 +1,136 @@
+package s3
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// newExportTestStorage provisions a storage on a fresh in-memory S3 server. The s3test package
+// cannot be used from inside this package.
+func newExportTestStorage(t *testing.T, encryptionKey string) *S3Storage {
+	t.Helper()
+	backend := s3mem.New()
+	if err := backend.CreateBucket("export"); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(gofakes3.New(backend).Server())
+	t.Cleanup(ts.Close)
+	s := &S3Storage{
+		Bucket:          "export",
+		Region:          "us-east-1",
+		Prefix:          "certmagic",
+		Endpoint:        ts.URL,
+		AccessKeyID:     "test",
+		SecretAccessKey: "test",
+		EncryptionKey:   encryptionKey,
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	t.Cleanup(cancel)
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Cleanup() })
+	return s
+}
+
+func TestExportImportArchive(t *testing.T) {
+	ctx := context.Background()
+	src := newExportTestStorage(t, "12345678901234567890123456789012")
+	values := map[string]string{
+		"acme/acme-v02.api.letsencrypt.org-directory/users/me/me.key": "account key",
+		"certificates/acme/example.com/example.com.crt":               "certificate",
+		"certificates/acme/example.com/example.com.key":               "private key",
+	}
+	for key, value := range values {
+		if err := src.Store(ctx, key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := src.Lock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatal(err)
+	}
+	defer src.Unlock(ctx, "issue_cert_example.com")
+
+	var archive bytes.Buffer
+	count, err := src.Export(ctx, &archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(values) {
+		t.Errorf("exported %d values, want %d", count, len(values))
+	}
+
+	// The archive holds the decrypted values and nothing else
+	zr, err := gzip.NewReader(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	entries := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(tr)
+		if values[hdr.Name] != string(content) {
+			t.Errorf("entry %s = %q", hdr.Name, content)
+		}
+		entries++
+	}
+	if entries != len(values) {
+		t.Errorf("archive has %d entries, want %d", entries, len(values))
+	}
+
+	dst := newExportTestStorage(t, "")
+	if err := dst.Store(ctx, "certificates/acme/example.com/example.com.crt", []byte("newer")); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := dst.importArchive(ctx, bytes.NewReader(archive.Bytes()), false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.uploaded != 2 || stats.skipped != 1 {
+		t.Errorf("stats = %+v, want 2 uploaded and 1 skipped", stats)
+	}
+	for key, want := range values {
+		if key == "certificates/acme/example.com/example.com.crt" {
+			want = "newer" // Kept without overwrite
+		}
+		got, err := dst.Load(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestArchiveKey(t *testing.T) {
+	for name, want := range map[string]string{
+		"certificates/a/a.crt":   "certificates/a/a.crt",
+		"./acme/users/x.json":    "acme/users/x.json",
+		"certificates//b/../b.k": "certificates/b.k",
+		"../etc/passwd":          "",
+		"/etc/passwd":            "",
+		".":                      "",
+	} {
+		got, ok := archiveKey(name)
+		if got != want || ok != (want != "") {
+			t.Errorf("archiveKey(%q) = %q, %v; want %q", name, got, ok, want)
+		}
+	}
+}
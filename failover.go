@@ -0,0 +1,70 @@
+package s3
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// With fallback_regions, reads survive regional S3 incidents: once the primary region fails
+// fallback_threshold reads in a row with transient errors, failed reads are retried against the
+// same bucket name (e.g. a replicated bucket or a multi-region setup) in each fallback region in
+// order. The first successful primary read ends the impairment.
+
+const defaultFallbackThreshold = 3
+
+// regionFailover tracks the health of the primary region.
+type regionFailover struct {
+	threshold int32
+	failures  atomic.Int32 // Consecutive transient read failures of the primary region
+	regions   []readSource // Read by loadFromFallbacks and existsInFallbacks
+}
+
+// record notes the outcome of a primary read.
+func (f *regionFailover) record(err error) {
+	if err == nil {
+		f.failures.Store(0)
+		return
+	}
+	switch classifyError(err) {
+	case errorClassNetwork, errorClassServer, errorClassThrottled:
+		f.failures.Add(1)
+	}
+}
+
+// impaired reports whether the primary region failed often enough to fail over.
+func (f *regionFailover) impaired() bool {
+	return f.failures.Load() >= f.threshold
+}
+
+// provisionFallbackRegions creates a client for each fallback region.
+func (s *S3Storage) provisionFallbackRegions() error {
+	threshold := s.FallbackThreshold
+	if threshold <= 0 {
+		threshold = defaultFallbackThreshold
+	}
+	f := &regionFailover{threshold: int32(threshold)}
+	for _, region := range s.FallbackRegions {
+		client, err := s.newClient(region, s.Endpoint, s.AccessKeyID, s.SecretAccessKey, s.SessionToken)
+		if err != nil {
+			return fmt.Errorf("fallback region %s: %w", region, err)
+		}
+		f.regions = append(f.regions, readSource{name: region, client: client, bucket: s.Bucket})
+	}
+	s.failover = f
+	s.logger.Info("reads fail over to fallback regions", zap.Strings("regions", s.FallbackRegions), zap.Int("threshold", threshold))
+	return nil
+}
+
+// recordPrimaryRead notes the outcome of a read from the primary region.
+func (s *S3Storage) recordPrimaryRead(err error) {
+	if s.failover != nil {
+		s.failover.record(err)
+	}
+}
+
+// failoverActive reports whether failed reads are retried in the fallback regions.
+func (s *S3Storage) failoverActive() bool {
+	return s.failover != nil && s.failover.impaired()
+}
@@ -0,0 +1,124 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// ForceUnlock deletes the lock of key, or every lock if key is empty, that is at least olderThan
+// old, regardless of the instance holding it, and returns the deleted locks. It is an escape
+// hatch for locks left behind by crashed instances; deleting a lock that is still in use lets
+// two instances work on the same name. As with ReapExpiredLocks, the age is checked again right
+// before each deletion.
+func (s *S3Storage) ForceUnlock(ctx context.Context, key string, olderThan time.Duration) ([]LockInfo, error) {
+	if s.httpLock != nil {
+		return nil, errors.New("lock objects are not used with lock_backend")
+	}
+	if err := s.checkWritable("unlock", key); err != nil {
+		return nil, err
+	}
+	var locks []LockInfo
+	if key != "" {
+		li, err := s.LockInfo(ctx, key)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		locks = []LockInfo{li}
+	} else {
+		var err error
+		if locks, err = s.ListLocks(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var deleted []LockInfo
+	var failed int
+	for _, li := range locks {
+		if li.Age < olderThan {
+			continue
+		}
+		candidates := []string{li.S3Key}
+		if legacy := s.s3LegacyLockKey(li.Key); s.legacyLocks() && legacy != li.S3Key {
+			candidates = append(candidates, legacy) // Written along with the current lock
+		}
+		var released bool
+		for _, lockS3Key := range candidates {
+			ok, err := s.reapLock(ctx, lockS3Key, olderThan)
+			if err != nil {
+				s.recordError("force_unlock", li.Key, err)
+				failed++
+				continue
+			}
+			released = released || ok
+		}
+		if released {
+			s.logger.Warn("lock force-released", zap.String("key", li.Key), zap.Duration("age", li.Age))
+			deleted = append(deleted, li)
+		}
+	}
+	if failed > 0 {
+		return deleted, fmt.Errorf("deleting %d locks failed", failed)
+	}
+	return deleted, nil
+}
+
+func unlockCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "unlock --config <path> [--all | --key <name>] [--older-than <duration>]",
+		Short: "Deletes stale lock objects",
+		Long: `
+Deletes lock objects left behind by crashed instances, which block renewals of
+their names until they expire. By default, every lock older than the lock
+expiration of the config is deleted. --key deletes the lock of one name,
+whatever its age, and --all deletes every lock; --older-than limits both to
+locks of at least that age.
+
+Deleting a lock that is still in use lets two instances work on the same name,
+so make sure its holder is gone; GET /storage/s3/locks on the admin API shows
+the owner of each lock.
+`,
+		RunE: caddycmd.WrapCommandFuncForCobra(cmdUnlock),
+	}
+	addConfigFlags(cmd)
+	cmd.Flags().Bool("all", false, "Delete every lock, not only expired ones")
+	cmd.Flags().String("key", "", "Delete the lock of this CertMagic key only")
+	cmd.Flags().Duration("older-than", 0, "Only delete locks at least this old")
+	return cmd
+}
+
+func cmdUnlock(fl caddycmd.Flags) (int, error) {
+	key := fl.String("key")
+	if key != "" && fl.Bool("all") {
+		return caddy.ExitCodeFailedStartup, errors.New("--all and --key are mutually exclusive")
+	}
+	s, cancel, err := loadStorageFromConfig(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	defer cancel()
+
+	olderThan := fl.Duration("older-than")
+	if !fl.Changed("older-than") && key == "" && !fl.Bool("all") {
+		olderThan = s.lockExpiration
+	}
+	deleted, err := s.ForceUnlock(context.Background(), key, olderThan)
+	for _, li := range deleted {
+		fmt.Printf("unlocked %s (age %s)\n", li.Key, li.Age.Round(time.Second))
+	}
+	fmt.Printf("unlocked %d\n", len(deleted))
+	if err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	return caddy.ExitCodeSuccess, nil
+}
@@ -0,0 +1,36 @@
+package s3_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+func TestForceUnlock(t *testing.T) {
+	s, _ := s3test.NewFakeStorage(t)
+	ctx := context.Background()
+	for _, key := range []string{"issue_cert_a.com", "issue_cert_b.com", "issue_cert_c.com"} {
+		if err := s.Lock(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if deleted, err := s.ForceUnlock(ctx, "", time.Hour); err != nil || len(deleted) != 0 {
+		t.Fatalf("ForceUnlock of old locks = %v, %v; want none", deleted, err)
+	}
+	deleted, err := s.ForceUnlock(ctx, "issue_cert_a.com", 0)
+	if err != nil || len(deleted) != 1 || deleted[0].Key != "issue_cert_a.com" {
+		t.Fatalf("ForceUnlock of one key = %v, %v", deleted, err)
+	}
+	if deleted, err := s.ForceUnlock(ctx, "issue_cert_a.com", 0); err != nil || len(deleted) != 0 {
+		t.Fatalf("ForceUnlock of an unlocked key = %v, %v", deleted, err)
+	}
+	if deleted, err := s.ForceUnlock(ctx, "", 0); err != nil || len(deleted) != 2 {
+		t.Fatalf("ForceUnlock of all locks = %v, %v", deleted, err)
+	}
+	if locks, err := s.ListLocks(ctx); err != nil || len(locks) != 0 {
+		t.Errorf("locks left = %v, %v", locks, err)
+	}
+}
@@ -0,0 +1,71 @@
+package s3
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// releaseLocksTimeout bounds how long Cleanup spends releasing the locks still held.
+const releaseLocksTimeout = 10 * time.Second
+
+// heldLocks is the set of keys this instance holds a lock for. Locks still held on config reload
+// or shutdown are released by Cleanup, so that other instances don't have to wait for them to
+// expire.
+type heldLocks struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+func newHeldLocks() *heldLocks {
+	return &heldLocks{keys: make(map[string]struct{})}
+}
+
+func (h *heldLocks) add(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.keys[key] = struct{}{}
+}
+
+func (h *heldLocks) remove(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.keys, key)
+}
+
+// list returns the held keys, sorted.
+func (h *heldLocks) list() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.keys))
+	for key := range h.keys {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// releaseHeldLocks unlocks every key still locked by this instance.
+func (s *S3Storage) releaseHeldLocks() {
+	if s.held == nil {
+		return
+	}
+	keys := s.held.list()
+	if len(keys) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), releaseLocksTimeout)
+	defer cancel()
+	released := 0
+	for _, key := range keys {
+		if err := s.Unlock(ctx, key); err != nil {
+			s.logger.Warn("releasing lock on cleanup failed", zap.String("key", key), zap.Error(err))
+			continue
+		}
+		released++
+	}
+	s.logger.Info("released held locks on cleanup", zap.Int("released", released), zap.Int("held", len(keys)))
+}
@@ -0,0 +1,132 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+)
+
+func importCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import --config <path> [--source <dir> | --archive <file>] [--overwrite] [--dry-run]",
+		Short: "Uploads an existing file_system storage directory or an export archive to S3",
+		Long: `
+Walks a CertMagic file_system storage directory (by default Caddy's data
+directory, e.g. $XDG_DATA_HOME/caddy) and uploads every file to the configured
+S3 storage, applying its prefix and encryption. Lock files are skipped.
+
+With --archive, the values of a .tar.gz written by the export command are
+uploaded instead, to restore a backup ("-" reads from standard input).
+
+Keys that already exist in S3 are left alone unless --overwrite is given, so
+the import can run while instances already use the S3 storage.
+`,
+		RunE: caddycmd.WrapCommandFuncForCobra(cmdImport),
+	}
+	addConfigFlags(cmd)
+	cmd.Flags().String("source", caddy.AppDataDir(), "Directory of the file_system storage")
+	cmd.Flags().String("archive", "", "Archive written by the export command to restore instead")
+	cmd.Flags().Bool("overwrite", false, "Replace keys that already exist in S3")
+	cmd.Flags().Bool("dry-run", false, "Only print what would be uploaded")
+	return cmd
+}
+
+func cmdImport(fl caddycmd.Flags) (int, error) {
+	source, archive := fl.String("source"), fl.String("archive")
+	if archive == "" {
+		if fi, err := os.Stat(source); err != nil || !fi.IsDir() {
+			return caddy.ExitCodeFailedStartup, fmt.Errorf("source %s is not a directory", source)
+		}
+	}
+
+	s, cancel, err := loadStorageFromConfig(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	defer cancel()
+
+	var stats importStats
+	if archive != "" {
+		stats, err = s.importArchiveFile(context.Background(), archive, fl.Bool("overwrite"), fl.Bool("dry-run"))
+	} else {
+		stats, err = s.importDirectory(context.Background(), source, fl.Bool("overwrite"), fl.Bool("dry-run"))
+	}
+	fmt.Printf("uploaded %d, skipped %d existing, ignored %d\n", stats.uploaded, stats.skipped, stats.ignored)
+	if err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+type importStats struct {
+	uploaded, skipped, ignored int
+}
+
+// importDirectory stores every file below dir under its relative path as CertMagic key.
+func (s *S3Storage) importDirectory(ctx context.Context, dir string, overwrite, dryRun bool) (importStats, error) {
+	var stats importStats
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if d.IsDir() {
+			if key == "locks" { // file_system storage keeps its locks here
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(key, ".lock") {
+			stats.ignored++
+			return nil
+		}
+
+		return s.importValue(ctx, key, func() ([]byte, error) { return os.ReadFile(path) }, overwrite, dryRun, &stats)
+	})
+	if err != nil && !errors.Is(err, fs.SkipAll) {
+		return stats, fmt.Errorf("importing %s: %w", dir, err)
+	}
+	return stats, nil
+}
+
+// importValue stores the value returned by read at key, unless the key exists and overwrite is not set.
+func (s *S3Storage) importValue(ctx context.Context, key string, read func() ([]byte, error), overwrite, dryRun bool, stats *importStats) error {
+	if !overwrite {
+		exists, err := s.ExistsErr(ctx, key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			stats.skipped++
+			return nil
+		}
+	}
+	if dryRun {
+		fmt.Printf("would upload %s\n", key)
+		stats.uploaded++
+		return nil
+	}
+
+	value, err := read()
+	if err != nil {
+		return err
+	}
+	if err := s.Store(ctx, key, value); err != nil {
+		return err
+	}
+	fmt.Printf("uploaded %s\n", key)
+	stats.uploaded++
+	return nil
+}
@@ -0,0 +1,10 @@
+package s3
+
+import "context"
+
+// ImportDirectory exposes the import command's upload of a file_system storage directory to the
+// tests of package s3_test.
+func (s *S3Storage) ImportDirectory(ctx context.Context, dir string, overwrite, dryRun bool) (uploaded, skipped, ignored int, err error) {
+	stats, err := s.importDirectory(ctx, dir, overwrite, dryRun)
+	return stats.uploaded, stats.skipped, stats.ignored, err
+}
@@ -0,0 +1,94 @@
+package s3_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3 "github.com/cvhome-saas/certmagic-s3"
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+// throttledHeadClient fails every HeadObject, like a throttled or unavailable bucket.
+type throttledHeadClient struct {
+	s3.S3API
+}
+
+func (c throttledHeadClient) HeadObject(context.Context, *awss3.HeadObjectInput, ...func(*awss3.Options)) (*awss3.HeadObjectOutput, error) {
+	return nil, errors.New("SlowDown: please reduce your request rate")
+}
+
+func TestStorageImportDirectory(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"certificates/acme/example.com/example.com.crt": "certificate",
+		"certificates/acme/example.com/example.com.key": "private key",
+		"acme/ca/users/me/me.json":                      "account",
+		"locks/issue_cert_example.com.lock":             "lock of the file_system storage",
+		"issue_cert_example.org.lock":                   "legacy lock",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storage, _ := s3test.NewFakeStorage(t)
+	ctx := context.Background()
+	if err := storage.Store(ctx, "acme/ca/users/me/me.json", []byte("existing account")); err != nil {
+		t.Fatal(err)
+	}
+
+	if uploaded, skipped, ignored, err := storage.ImportDirectory(ctx, dir, false, true); err != nil || uploaded != 2 || skipped != 1 || ignored != 1 {
+		t.Errorf("dry run = %d uploaded, %d skipped, %d ignored, %v", uploaded, skipped, ignored, err)
+	}
+	if storage.Exists(ctx, "certificates/acme/example.com/example.com.crt") {
+		t.Error("dry run uploaded values")
+	}
+
+	if uploaded, skipped, ignored, err := storage.ImportDirectory(ctx, dir, false, false); err != nil || uploaded != 2 || skipped != 1 || ignored != 1 {
+		t.Errorf("import = %d uploaded, %d skipped, %d ignored, %v", uploaded, skipped, ignored, err)
+	}
+	keys, err := storage.List(ctx, "", true)
+	want := []string{"acme/ca/users/me/me.json", "certificates/acme/example.com/example.com.crt", "certificates/acme/example.com/example.com.key"}
+	if slices.Sort(keys); err != nil || !slices.Equal(keys, want) {
+		t.Errorf("keys after import = %v, %v; want %v", keys, err, want)
+	}
+	if value, err := storage.Load(ctx, "acme/ca/users/me/me.json"); err != nil || string(value) != "existing account" {
+		t.Errorf("existing key = %q, %v; must be left alone", value, err)
+	}
+
+	if _, _, _, err := storage.ImportDirectory(ctx, dir, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := storage.Load(ctx, "acme/ca/users/me/me.json"); err != nil || string(value) != "account" {
+		t.Errorf("existing key after import with overwrite = %q, %v", value, err)
+	}
+}
+
+func TestStorageImportDirectoryExistsError(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "account.json"), []byte("older account"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	storage, _ := s3test.NewFakeStorage(t)
+	ctx := context.Background()
+	if err := storage.Store(ctx, "account.json", []byte("account")); err != nil {
+		t.Fatal(err)
+	}
+	storage.Client = throttledHeadClient{S3API: storage.Client}
+
+	if uploaded, _, _, err := storage.ImportDirectory(ctx, dir, false, false); err == nil || uploaded != 0 {
+		t.Errorf("import = %d uploaded, %v; want an error when existence can't be checked", uploaded, err)
+	}
+	if value, err := storage.Load(ctx, "account.json"); err != nil || string(value) != "account" {
+		t.Errorf("existing key = %q, %v; must be left alone", value, err)
+	}
+}
@@ -16,10 +16,29 @@ type IO interface {
 	// ByteReader takes plaintext, potentially encrypts it, and returns an io.Reader for the (cipher)text
 	// along with its length and any error encountered during preparation (e.g., nonce generation).
 	ByteReader(plaintext []byte) (reader io.Reader, length int64, err error)
+	// StreamReader is like ByteReader for a plaintext stream of the given size (-1 if unknown),
+	// without reading the whole plaintext into memory. The returned length is -1 if size is unknown.
+	StreamReader(plaintext io.Reader, size int64) (reader io.Reader, length int64, err error)
 	// WrapReader takes a reader of (cipher)text and returns an io.Reader that yields plaintext.
+	// It accepts the output of both ByteReader and StreamReader.
 	WrapReader(ciphertextReader io.Reader) io.Reader
 }
 
+// NewIO returns the IO for the given encryption key: CleartextIO if the key is empty,
+// SecretBoxIO otherwise.
+func NewIO(encryptionKey string) (IO, error) {
+	if len(encryptionKey) == 0 {
+		return &CleartextIO{}, nil
+	}
+	if len(encryptionKey) != 32 { // NaCl secretbox key size
+		return nil, fmt.Errorf("encryption key must have exactly 32 bytes for NaCl secretbox, got %d; "+
+			"use encryption_passphrase for a passphrase of any length", len(encryptionKey))
+	}
+	sb := &SecretBoxIO{}
+	copy(sb.SecretKey[:], []byte(encryptionKey))
+	return sb, nil
+}
+
 // CleartextIO provides IO operations without encryption.
 type CleartextIO struct{}
 
@@ -28,6 +47,11 @@ func (c *CleartextIO) ByteReader(plaintext []byte) (io.Reader, int64, error) {
 	return bytes.NewReader(plaintext), int64(len(plaintext)), nil
 }
 
+// StreamReader returns the plaintext stream as is.
+func (c *CleartextIO) StreamReader(plaintext io.Reader, size int64) (io.Reader, int64, error) {
+	return plaintext, size, nil
+}
+
 // WrapReader returns the original reader as no decryption is needed.
 func (c *CleartextIO) WrapReader(ciphertextReader io.Reader) io.Reader {
 	return ciphertextReader
@@ -38,18 +62,19 @@ type SecretBoxIO struct {
 	SecretKey [32]byte
 }
 
-// ByteReader encrypts plaintext using SecretKey and returns a reader to the ciphertext (nonce + encrypted_data)
-// and its total length.
+// ByteReader encrypts plaintext using SecretKey and returns a reader to the ciphertext
+// (header + nonce + encrypted_data) and its total length.
 func (sb *SecretBoxIO) ByteReader(plaintext []byte) (io.Reader, int64, error) {
 	var nonce [24]byte
 	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
 		return nil, 0, fmt.Errorf("failed to generate nonce: %w", err)
 	}
 
-	// Ciphertext will be nonce + sealed_data.
-	// secretbox.Seal prepends to the first argument if it has capacity,
-	// otherwise allocates. We provide a slice starting with the nonce.
-	sealed := secretbox.Seal(nonce[:], plaintext, &nonce, &sb.SecretKey)
+	// Ciphertext will be header + nonce + sealed_data.
+	// secretbox.Seal appends to the first argument, which we provide with room for the rest.
+	out := make([]byte, 0, len(secretBoxMagic)+len(nonce)+len(plaintext)+secretbox.Overhead)
+	out = append(append(out, secretBoxMagic...), nonce[:]...)
+	sealed := secretbox.Seal(out, plaintext, &nonce, &sb.SecretKey)
 	return bytes.NewReader(sealed), int64(len(sealed)), nil
 }
 
@@ -66,11 +91,15 @@ func (er *errorReader) Read(p []byte) (n int, err error) {
 // WrapReader takes a reader of ciphertext (nonce + encrypted_data) and returns a reader that decrypts on-the-fly.
 func (sb *SecretBoxIO) WrapReader(ciphertextReader io.Reader) io.Reader {
 	var nonce [24]byte
-	// Read exactly 24 bytes for the nonce.
+	// Read exactly 24 bytes for the nonce (or the start of a chunked stream header).
 	n, err := io.ReadFull(ciphertextReader, nonce[:])
 	if err != nil {
+		// An empty stream has nothing to decrypt.
+		if err == io.EOF {
+			return bytes.NewReader(nil)
+		}
 		// Handle cases where stream is too short for a nonce or other read errors.
-		if err == io.EOF || err == io.ErrUnexpectedEOF {
+		if err == io.ErrUnexpectedEOF {
 			return &errorReader{err: fmt.Errorf("failed to read full nonce (short stream): %w", err)}
 		}
 		return &errorReader{err: fmt.Errorf("failed to read nonce: %w", err)}
@@ -78,6 +107,23 @@ func (sb *SecretBoxIO) WrapReader(ciphertextReader io.Reader) io.Reader {
 	if n != 24 { // Should be caught by ReadFull's ErrUnexpectedEOF, but double check.
 		return &errorReader{err: fmt.Errorf("read %d bytes for nonce, expected 24", n)}
 	}
+	if cipherID, version, ok := parseObjectHeader(nonce[:]); ok {
+		switch string(nonce[:objectHeaderSize]) {
+		case secretBoxMagic:
+			// The nonce follows the header; read its remaining bytes.
+			copy(nonce[:], nonce[objectHeaderSize:])
+			if _, err := io.ReadFull(ciphertextReader, nonce[len(nonce)-objectHeaderSize:]); err != nil {
+				return &errorReader{err: fmt.Errorf("failed to read full nonce (short stream): %w", err)}
+			}
+		case chunkedMagic:
+			return sb.chunkedReader(ciphertextReader, nonce[len(chunkedMagic):])
+		case aesGCMMagic, aesGCMChunkedMagic: // Written with encryption_cipher aes-gcm and the same key
+			aes := &AESGCMIO{Key: sb.SecretKey}
+			return aes.WrapReader(io.MultiReader(bytes.NewReader(nonce[:]), ciphertextReader))
+		default:
+			return &errorReader{err: unsupportedFormatError(cipherID, version)}
+		}
+	}
 
 	ciphertext, err := io.ReadAll(ciphertextReader)
 	if err != nil {
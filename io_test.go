@@ -2,8 +2,12 @@ package s3
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"strings"
 	"testing"
+
+	"golang.org/x/crypto/nacl/secretbox"
 )
 
 func TestEncryptDecrypt(t *testing.T) {
@@ -17,7 +21,10 @@ func TestEncryptDecrypt(t *testing.T) {
 	}
 
 	msg := []byte("This is a very important message that shall be encrypted...")
-	r := sb.ByteReader(msg)
+	r, _, err := sb.ByteReader(msg)
+	if err != nil {
+		t.Fatalf("preparing reader failed: %v", err)
+	}
 
 	buf, err := io.ReadAll(r)
 	if err != nil {
@@ -51,3 +58,103 @@ func TestIOWrap(t *testing.T) {
 		t.Errorf("Buffer should be empty, got: %v", buf)
 	}
 }
+
+func TestChunkedStream(t *testing.T) {
+	sb, _ := NewIO("12345678123456781234567812345678")
+	gcm, _ := NewCipherIO("12345678123456781234567812345678", cipherAESGCM)
+	for _, wrap := range []IO{sb, gcm} {
+		for _, size := range []int{0, 1, chunkedSize, chunkedSize + 1, 3*chunkedSize + 17} {
+			plaintext := bytes.Repeat([]byte{'x'}, size)
+			r, length, err := wrap.StreamReader(bytes.NewReader(plaintext), int64(size))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ciphertext, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(ciphertext)) != length {
+				t.Errorf("%T size %d: announced length %d, got %d bytes", wrap, size, length, len(ciphertext))
+			}
+
+			decrypted, err := io.ReadAll(wrap.WrapReader(bytes.NewReader(ciphertext)))
+			if err != nil || !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("%T size %d: round trip failed: %v", wrap, size, err)
+			}
+
+			// Dropping the final chunk must be detected.
+			if size > chunkedSize {
+				truncated := ciphertext[:len(ciphertext)-(size%chunkedSize)-16]
+				if _, err := io.ReadAll(wrap.WrapReader(bytes.NewReader(truncated))); err == nil {
+					t.Errorf("%T size %d: truncated stream decrypted without error", wrap, size)
+				}
+			}
+		}
+	}
+}
+
+func TestAESGCMCoexistence(t *testing.T) {
+	key := "12345678123456781234567812345678"
+	sb, _ := NewCipherIO(key, "")
+	gcm, err := NewCipherIO(key, cipherAESGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("account key")
+	for _, writer := range []IO{sb, gcm} {
+		r, _, err := writer.ByteReader(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ciphertext, _ := io.ReadAll(r)
+		if _, isGCM := writer.(*AESGCMIO); isGCM != isAESGCM(ciphertext) {
+			t.Errorf("%T: unexpected format marker", writer)
+		}
+		for _, reader := range []IO{sb, gcm} {
+			if got, err := decryptWith(reader, ciphertext); err != nil || !bytes.Equal(got, msg) {
+				t.Errorf("%T cannot read objects of %T: %v", reader, writer, err)
+			}
+			if inCurrentFormat(reader, ciphertext) != (fmt.Sprintf("%T", reader) == fmt.Sprintf("%T", writer)) {
+				t.Errorf("%T: wrong current format for objects of %T", reader, writer)
+			}
+		}
+		other, _ := NewCipherIO("87654321876543218765432187654321", cipherAESGCM)
+		if _, err := decryptWith(other, ciphertext); err == nil {
+			t.Errorf("%T: decrypted with the wrong key", writer)
+		}
+	}
+
+	if _, err := NewCipherIO(key, "chacha"); err == nil {
+		t.Error("expected unknown cipher to be rejected")
+	}
+	if _, err := NewCipherIO("", cipherAESGCM); err == nil {
+		t.Error("expected aes-gcm without key to be rejected")
+	}
+}
+
+func TestSecretBoxHeader(t *testing.T) {
+	sb, _ := NewIO("12345678123456781234567812345678")
+	key := sb.(*SecretBoxIO).SecretKey
+
+	r, _, err := sb.ByteReader([]byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, _ := io.ReadAll(r)
+	if id, version, ok := parseObjectHeader(ciphertext); !ok || id != "SB" || version != '1' {
+		t.Fatalf("header = %q, %c, %v; want SB version 1", id, version, ok)
+	}
+
+	// Objects written before headers were introduced: nonce | sealed data
+	var nonce [24]byte
+	legacy := secretbox.Seal(nonce[:], []byte("legacy value"), &nonce, &key)
+	if got, err := io.ReadAll(sb.WrapReader(bytes.NewReader(legacy))); err != nil || string(got) != "legacy value" {
+		t.Errorf("legacy object = %q, %v", got, err)
+	}
+
+	future := append([]byte("\x00CMS3XC1"), ciphertext[objectHeaderSize:]...)
+	_, err = io.ReadAll(sb.WrapReader(bytes.NewReader(future)))
+	if err == nil || !strings.Contains(err.Error(), "unsupported object format (cipher XC, version 1)") {
+		t.Errorf("object of unknown format: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// AES-256-GCM objects start with a magic naming the format and its version, so that both ciphers
+// can coexist in a bucket: either IO reads the objects of the other one (given the same key), and
+// switching encryption_cipher only changes how new objects are written.
+//
+//	single:  aesGCMMagic (8 bytes) | nonce (12 bytes) | sealed data
+//	chunked: aesGCMChunkedMagic (8 bytes) | base nonce (12 bytes) | chunks, see iochunked.go
+const (
+	aesGCMMagic        = "\x00CMS3AG1"
+	aesGCMChunkedMagic = "\x00CMS3AG2"
+
+	cipherSecretBox = "secretbox"
+	cipherAESGCM    = "aes-gcm"
+)
+
+// NewCipherIO returns the IO for the given encryption key and cipher: "secretbox" (NaCl
+// XSalsa20-Poly1305, the default if empty) or "aes-gcm" (AES-256-GCM).
+func NewCipherIO(encryptionKey, cipherName string) (IO, error) {
+	switch cipherName {
+	case "", cipherSecretBox:
+		return NewIO(encryptionKey)
+	case cipherAESGCM:
+		if len(encryptionKey) == 0 {
+			return nil, errors.New("encryption_cipher aes-gcm requires an encryption key")
+		}
+		if len(encryptionKey) != 32 {
+			return nil, fmt.Errorf("encryption key must have exactly 32 bytes for AES-256-GCM, got %d; "+
+				"use encryption_passphrase for a passphrase of any length", len(encryptionKey))
+		}
+		a := &AESGCMIO{}
+		copy(a.Key[:], encryptionKey)
+		return a, nil
+	default:
+		return nil, fmt.Errorf("unsupported encryption_cipher '%s' (expected %s or %s)", cipherName, cipherSecretBox, cipherAESGCM)
+	}
+}
+
+// AESGCMIO provides IO operations with AES-256-GCM encryption.
+type AESGCMIO struct {
+	Key [32]byte
+}
+
+func (a *AESGCMIO) aead() cipher.AEAD {
+	block, err := aes.NewCipher(a.Key[:])
+	if err != nil {
+		panic(err) // Only fails for invalid key sizes
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err) // Only fails for non-128-bit block ciphers
+	}
+	return aead
+}
+
+// ByteReader encrypts plaintext and returns a reader to the ciphertext (magic + nonce + sealed data)
+// and its total length.
+func (a *AESGCMIO) ByteReader(plaintext []byte) (io.Reader, int64, error) {
+	aead := a.aead()
+	out := make([]byte, len(aesGCMMagic)+aead.NonceSize(), len(aesGCMMagic)+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	copy(out, aesGCMMagic)
+	nonce := out[len(aesGCMMagic):]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, 0, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(out, nonce, plaintext, nil)
+	return bytes.NewReader(sealed), int64(len(sealed)), nil
+}
+
+// StreamReader encrypts the plaintext stream chunk by chunk in the chunked format.
+func (a *AESGCMIO) StreamReader(plaintext io.Reader, size int64) (io.Reader, int64, error) {
+	return chunkedStream(a.aead(), aesGCMChunkedMagic, plaintext, size)
+}
+
+// WrapReader decrypts AES-GCM objects in either format, and secretbox objects with the same key.
+func (a *AESGCMIO) WrapReader(ciphertextReader io.Reader) io.Reader {
+	var magic [len(aesGCMMagic)]byte
+	n, err := io.ReadFull(ciphertextReader, magic[:])
+	if err == io.EOF {
+		return bytes.NewReader(nil) // An empty stream has nothing to decrypt
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return &errorReader{err: fmt.Errorf("failed to read header: %w", err)}
+	}
+	switch string(magic[:n]) {
+	case aesGCMMagic:
+		return a.openSingle(ciphertextReader)
+	case aesGCMChunkedMagic:
+		return newChunkedDecrypter(a.aead(), ciphertextReader, nil)
+	default:
+		sb := &SecretBoxIO{SecretKey: a.Key}
+		return sb.WrapReader(io.MultiReader(bytes.NewReader(magic[:n]), ciphertextReader))
+	}
+}
+
+// openSingle decrypts the single format after its magic.
+func (a *AESGCMIO) openSingle(src io.Reader) io.Reader {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return &errorReader{err: fmt.Errorf("failed to read ciphertext body: %w", err)}
+	}
+	aead := a.aead()
+	if len(data) < aead.NonceSize() {
+		return &errorReader{err: errors.New("failed to read full nonce (short stream)")}
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return &errorReader{err: errors.New("failed to decrypt data (AES-GCM open failed)")}
+	}
+	return bytes.NewReader(plaintext)
+}
+
+// isAESGCM reports whether data is an AES-GCM object.
+func isAESGCM(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(aesGCMMagic)) || bytes.HasPrefix(data, []byte(aesGCMChunkedMagic))
+}
+
+// inCurrentFormat reports whether an object readable by wrap was also written with its cipher,
+// so that reencrypt migrates objects between ciphers sharing a key.
+func inCurrentFormat(wrap IO, data []byte) bool {
+	switch baseIO(wrap).(type) {
+	case *AESGCMIO:
+		return isAESGCM(data)
+	case *SecretBoxIO: // Headerless objects are rewritten with a header
+		return bytes.HasPrefix(data, []byte(secretBoxMagic)) || bytes.HasPrefix(data, []byte(chunkedMagic))
+	default:
+		return true
+	}
+}
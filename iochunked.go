@@ -0,0 +1,188 @@
+package s3
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Chunked format, written by the StreamReader of SecretBoxIO and AESGCMIO:
+//
+//	magic (8 bytes) | base nonce (24 or 12 bytes) | chunk | chunk | ... | final chunk
+//
+// Every chunk seals chunkedSize bytes of plaintext, except the final one which seals less (possibly
+// nothing). The nonce of a chunk is the base nonce with its index XORed into the last 8 bytes and,
+// for the final chunk, a flag set in the byte before them, so chunks cannot be reordered, dropped
+// or truncated unnoticed. ByteReader keeps writing the single-box format, which older versions can read.
+const (
+	chunkedMagic = "\x00CMS3SB2" // secretbox
+	chunkedSize  = 64 << 10
+)
+
+// chunkedLength returns the ciphertext length for a plaintext of the given size.
+func chunkedLength(aead cipher.AEAD, size int64) int64 {
+	chunks := size/chunkedSize + 1
+	return int64(len(chunkedMagic)+aead.NonceSize()) + chunks*int64(aead.Overhead()) + size
+}
+
+// chunkNonce derives the nonce of chunk i from the base nonce.
+func chunkNonce(base []byte, i uint64, final bool) []byte {
+	nonce := append([]byte(nil), base...)
+	tail := nonce[len(nonce)-8:]
+	binary.BigEndian.PutUint64(tail, binary.BigEndian.Uint64(tail)^i)
+	if final {
+		nonce[len(nonce)-9] ^= 0x01
+	}
+	return nonce
+}
+
+// secretboxAEAD adapts NaCl secretbox to cipher.AEAD, without additional data.
+type secretboxAEAD struct {
+	key *[32]byte
+}
+
+func (secretboxAEAD) NonceSize() int { return 24 }
+func (secretboxAEAD) Overhead() int  { return secretbox.Overhead }
+
+func (a secretboxAEAD) Seal(dst, nonce, plaintext, _ []byte) []byte {
+	return secretbox.Seal(dst, plaintext, (*[24]byte)(nonce), a.key)
+}
+
+func (a secretboxAEAD) Open(dst, nonce, ciphertext, _ []byte) ([]byte, error) {
+	plaintext, ok := secretbox.Open(dst, ciphertext, (*[24]byte)(nonce), a.key)
+	if !ok {
+		return nil, errors.New("secretbox.Open failed")
+	}
+	return plaintext, nil
+}
+
+// StreamReader encrypts the plaintext stream chunk by chunk in the chunked format.
+func (sb *SecretBoxIO) StreamReader(plaintext io.Reader, size int64) (io.Reader, int64, error) {
+	return chunkedStream(secretboxAEAD{key: &sb.SecretKey}, chunkedMagic, plaintext, size)
+}
+
+// chunkedReader decrypts a chunked stream whose magic and first nonce bytes were already read.
+func (sb *SecretBoxIO) chunkedReader(src io.Reader, nonceStart []byte) io.Reader {
+	return newChunkedDecrypter(secretboxAEAD{key: &sb.SecretKey}, src, nonceStart)
+}
+
+// chunkedStream returns a reader encrypting the plaintext stream in the chunked format.
+func chunkedStream(aead cipher.AEAD, magic string, plaintext io.Reader, size int64) (io.Reader, int64, error) {
+	r := &chunkedEncrypter{aead: aead, src: plaintext, base: make([]byte, aead.NonceSize())}
+	if _, err := io.ReadFull(rand.Reader, r.base); err != nil {
+		return nil, 0, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	r.out = append([]byte(magic), r.base...)
+	length := int64(-1)
+	if size >= 0 {
+		length = chunkedLength(aead, size)
+	}
+	return r, length, nil
+}
+
+type chunkedEncrypter struct {
+	aead  cipher.AEAD
+	src   io.Reader
+	base  []byte
+	index uint64
+	buf   []byte
+	out   []byte // Pending ciphertext
+	done  bool
+}
+
+func (r *chunkedEncrypter) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if r.buf == nil {
+			r.buf = make([]byte, chunkedSize)
+		}
+		n, err := io.ReadFull(r.src, r.buf)
+		switch {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			r.done = true
+		case err != nil:
+			return 0, err
+		}
+		r.out = r.aead.Seal(r.out[:0], chunkNonce(r.base, r.index, r.done), r.buf[:n], nil)
+		r.index++
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// newChunkedDecrypter decrypts a chunked stream whose magic and first nonce bytes were already read.
+func newChunkedDecrypter(aead cipher.AEAD, src io.Reader, nonceStart []byte) io.Reader {
+	r := &chunkedDecrypter{aead: aead, src: src, base: make([]byte, aead.NonceSize())}
+	n := copy(r.base, nonceStart)
+	if _, err := io.ReadFull(src, r.base[n:]); err != nil {
+		return &errorReader{err: fmt.Errorf("failed to read chunked stream header: %w", err)}
+	}
+	return r
+}
+
+type chunkedDecrypter struct {
+	aead  cipher.AEAD
+	src   io.Reader
+	base  []byte
+	index uint64
+	buf   []byte
+	out   []byte // Pending plaintext
+	done  bool
+	err   error
+}
+
+func (r *chunkedDecrypter) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			r.err = r.checkTrailer()
+			continue
+		}
+		r.err = r.nextChunk()
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// nextChunk reads and opens the next chunk; a chunk shorter than a full one is the final chunk.
+func (r *chunkedDecrypter) nextChunk() error {
+	if r.buf == nil {
+		r.buf = make([]byte, chunkedSize+r.aead.Overhead())
+	}
+	n, err := io.ReadFull(r.src, r.buf)
+	switch {
+	case err == io.EOF:
+		return errors.New("failed to decrypt data: chunked stream is truncated")
+	case err == io.ErrUnexpectedEOF:
+		r.done = true
+	case err != nil:
+		return fmt.Errorf("failed to read ciphertext body: %w", err)
+	}
+	plaintext, err := r.aead.Open(r.out[:0], chunkNonce(r.base, r.index, r.done), r.buf[:n], nil)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt data (chunk %d)", r.index)
+	}
+	r.out = plaintext
+	r.index++
+	return nil
+}
+
+// checkTrailer makes sure nothing follows the final chunk.
+func (r *chunkedDecrypter) checkTrailer() error {
+	var b [1]byte
+	if n, _ := io.ReadFull(r.src, b[:]); n > 0 {
+		return errors.New("failed to decrypt data: unexpected data after final chunk")
+	}
+	return io.EOF
+}
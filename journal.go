@@ -0,0 +1,389 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// If S3 is unreachable when an ACME issuance completes, the new certificate would be lost and
+// Caddy would have to issue it again. With journal_dir, Store first writes the value to a
+// journal on local disk and then to S3. If the upload fails, Store succeeds anyway: a background
+// flusher retries the upload every journal_flush_interval, and Load and Exists answer from the
+// journal until then. Only transient failures are journaled; others, such as AccessDenied, fail
+// Store right away. A late flush doesn't overwrite a value another instance stored after the
+// journaled write: it is dropped if the object is newer, and otherwise written conditionally on
+// the ETag of the object it replaces. Journal files hold the same (possibly encrypted) bytes as
+// the S3 objects, prefixed with the key, and are synced to disk before Store returns.
+
+// defaultJournalFlushInterval is how often pending writes are retried by default.
+const defaultJournalFlushInterval = 5 * time.Second
+
+// journalFileSuffix marks the files of pending writes in the journal directory.
+const journalFileSuffix = ".pending"
+
+// writeJournal holds the writes which have not reached S3 yet.
+type writeJournal struct {
+	dir string
+
+	mu      sync.Mutex
+	pending map[string]uint64 // Key to the generation of its latest write
+	gen     uint64
+
+	uploads sync.Map // Key to a *sync.Mutex serializing its uploads, so an older value never wins
+}
+
+// openJournal opens the journal directory and picks up the writes pending from earlier runs.
+func openJournal(dir string) (*writeJournal, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	j := &writeJournal{dir: dir, pending: make(map[string]uint64)}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), journalFileSuffix) {
+			continue
+		}
+		key, _, err := j.readFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", e.Name(), err)
+		}
+		j.gen++
+		j.pending[key] = j.gen
+	}
+	return j, nil
+}
+
+// path returns the file of a key's pending write.
+func (j *writeJournal) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(j.dir, hex.EncodeToString(sum[:])+journalFileSuffix)
+}
+
+// readFile returns the key and data of a journal file.
+func (j *writeJournal) readFile(p string) (string, []byte, error) {
+	content, err := os.ReadFile(p)
+	if err != nil {
+		return "", nil, err
+	}
+	key, data, ok := bytes.Cut(content, []byte{'\n'})
+	if !ok || len(key) == 0 {
+		return "", nil, errors.New("malformed journal file")
+	}
+	return string(key), data, nil
+}
+
+// write durably records a pending write and returns its generation.
+func (j *writeJournal) write(key string, data []byte) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	tmp, err := os.CreateTemp(j.dir, ".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+	_, err = tmp.Write(append([]byte(key+"\n"), data...))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), j.path(key))
+	}
+	if err != nil {
+		return 0, err
+	}
+	j.gen++
+	j.pending[key] = j.gen
+	return j.gen, nil
+}
+
+// read returns the data of a key's pending write, or fs.ErrNotExist.
+func (j *writeJournal) read(key string) ([]byte, uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	gen, ok := j.pending[key]
+	if !ok {
+		return nil, 0, fs.ErrNotExist
+	}
+	_, data, err := j.readFile(j.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		delete(j.pending, key) // Flushed by another instance using the same directory
+	}
+	return data, gen, err
+}
+
+// writtenAt returns when the pending write of a key was journaled.
+func (j *writeJournal) writtenAt(key string) (time.Time, error) {
+	info, err := os.Stat(j.path(key))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// has reports whether a write of the key is pending.
+func (j *writeJournal) has(key string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, ok := j.pending[key]
+	return ok
+}
+
+// done removes the pending write of a key once it reached S3, unless it was written again since.
+func (j *writeJournal) done(key string, gen uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.pending[key] != gen {
+		return nil
+	}
+	delete(j.pending, key)
+	if err := os.Remove(j.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// remove drops the pending writes of a key and of all keys below it, for Delete.
+func (j *writeJournal) remove(key string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var errs []error
+	for k := range j.pending {
+		if k == key || keyBelow(k, key) {
+			delete(j.pending, k)
+			if err := os.Remove(j.path(k)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// lockUpload serializes the uploads of a key by Store and the flusher, and returns the unlock.
+func (j *writeJournal) lockUpload(key string) func() {
+	mu, _ := j.uploads.LoadOrStore(key, new(sync.Mutex))
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// keys returns the keys with pending writes, sorted.
+func (j *writeJournal) keys() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	keys := make([]string, 0, len(j.pending))
+	for k := range j.pending {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// provisionJournal opens journal_dir and starts the flusher.
+func (s *S3Storage) provisionJournal(ctx context.Context) error {
+	dir, err := filepath.Abs(s.JournalDir)
+	if err != nil {
+		return fmt.Errorf("journal_dir: %w", err)
+	}
+	journal, err := openJournal(dir)
+	if err != nil {
+		return fmt.Errorf("opening journal_dir: %w", err)
+	}
+	s.journal = journal
+	interval := time.Duration(s.JournalFlushInterval)
+	if interval <= 0 {
+		interval = defaultJournalFlushInterval
+	}
+	s.logger.Info("write journal active", zap.String("dir", dir), zap.Int("pending", len(journal.keys())),
+		zap.Duration("flush_interval", interval))
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.FlushJournal(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// journalStore writes a value to the journal and then to S3. An upload failing transiently is
+// left to the flusher, so the value is not lost.
+func (s *S3Storage) journalStore(ctx context.Context, key string, value []byte) error {
+	if err := s.checkWritable("store", key); err != nil {
+		return err
+	}
+	unlock := s.journal.lockUpload(key)
+	defer unlock()
+	gen, err := s.journalWrite(key, value)
+	if err != nil {
+		return fmt.Errorf("writing %s to the journal: %w", key, err)
+	}
+	if err := s.store(ctx, key, value); err != nil {
+		if !isTransient(err) {
+			if doneErr := s.journal.done(key, gen); doneErr != nil {
+				s.logger.Warn("removing failed write from the journal failed", zap.String("key", key), zap.Error(doneErr))
+			}
+			return err
+		}
+		s.opLogger(ctx, key).Warn("storing in S3 failed, keeping the value in the write journal",
+			zap.String("key", key), zap.Error(err))
+		return nil
+	}
+	if err := s.journal.done(key, gen); err != nil {
+		s.logger.Warn("removing flushed write from the journal failed", zap.String("key", key), zap.Error(err))
+	}
+	return nil
+}
+
+// journalWrite records a value in the journal, encrypted like the S3 object.
+func (s *S3Storage) journalWrite(key string, value []byte) (uint64, error) {
+	reader, _, err := s.iowrap.ByteReader(value)
+	if err != nil {
+		return 0, err
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, err
+	}
+	return s.journal.write(key, data)
+}
+
+// journalLoad returns the value of a pending write, or fs.ErrNotExist.
+func (s *S3Storage) journalLoad(key string) ([]byte, uint64, error) {
+	data, gen, err := s.journal.read(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	value, err := io.ReadAll(s.iowrap.WrapReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading/decrypting journaled %s: %w", key, err)
+	}
+	return value, gen, nil
+}
+
+// FlushJournal uploads the pending writes of the journal to S3 and returns how many are still
+// pending. It runs in the background every journal_flush_interval.
+func (s *S3Storage) FlushJournal(ctx context.Context) int {
+	if s.journal == nil {
+		return 0
+	}
+	pending := 0
+	for _, key := range s.journal.keys() {
+		if ctx.Err() != nil {
+			return len(s.journal.keys())
+		}
+		if s.flushJournaled(ctx, key) != nil {
+			pending++
+		}
+	}
+	return pending
+}
+
+// flushJournaled uploads the pending write of one key.
+func (s *S3Storage) flushJournaled(ctx context.Context, key string) error {
+	unlock := s.journal.lockUpload(key)
+	defer unlock()
+	value, gen, err := s.journalLoad(key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil // Uploaded by Store or deleted meanwhile
+	}
+	var cond putCondition
+	if err == nil {
+		cond, err = s.flushCondition(ctx, key)
+	}
+	if err == nil && cond.superseded {
+		s.logger.Warn("dropping journaled write, S3 holds a newer value", zap.String("key", key))
+		return s.journal.done(key, gen)
+	}
+	if err == nil {
+		err = s.store(withPutCondition(ctx, cond), key, value)
+	}
+	if isPreconditionFailed(err) {
+		s.logger.Warn("dropping journaled write, the value was changed in S3 meanwhile", zap.String("key", key))
+		return s.journal.done(key, gen)
+	}
+	if err != nil {
+		s.logger.Warn("flushing write journal failed", zap.String("key", key), zap.Error(err))
+		return err
+	}
+	if err := s.journal.done(key, gen); err != nil {
+		s.logger.Warn("removing flushed write from the journal failed", zap.String("key", key), zap.Error(err))
+	}
+	s.logger.Info("flushed journaled write to S3", zap.String("key", key))
+	return nil
+}
+
+// isTransient reports whether a failed S3 request may succeed when retried later.
+func isTransient(err error) bool {
+	return errors.Is(err, ErrTransient) || errors.Is(err, ErrThrottled)
+}
+
+// putCondition makes the PutObject of store conditional, for late journal flushes.
+type putCondition struct {
+	ifMatch     *string // ETag of the object replaced
+	ifNoneMatch *string // "*" if there is no object yet
+	superseded  bool    // The object is newer than the journaled write
+}
+
+type putConditionKey struct{}
+
+// withPutCondition makes the writes of store with ctx conditional.
+func withPutCondition(ctx context.Context, cond putCondition) context.Context {
+	return context.WithValue(ctx, putConditionKey{}, cond)
+}
+
+// applyPutCondition sets the condition of ctx, if any, on a PutObject.
+func applyPutCondition(ctx context.Context, in *awss3.PutObjectInput) {
+	if cond, ok := ctx.Value(putConditionKey{}).(putCondition); ok {
+		in.IfMatch, in.IfNoneMatch = cond.ifMatch, cond.ifNoneMatch
+	}
+}
+
+// flushCondition compares the object of a key with its journaled write.
+func (s *S3Storage) flushCondition(ctx context.Context, key string) (putCondition, error) {
+	written, err := s.journal.writtenAt(key)
+	if err != nil {
+		return putCondition{}, err
+	}
+	headCtx, cancel := s.readContext(ctx)
+	defer cancel()
+	head, err := s.Client.HeadObject(headCtx, &awss3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.s3ObjectKey(key)),
+	})
+	if err != nil {
+		if s.isNotFound(err) {
+			return putCondition{ifNoneMatch: aws.String("*")}, nil
+		}
+		return putCondition{}, s3Error(err)
+	}
+	if head.LastModified != nil && !head.LastModified.Before(written.Truncate(time.Second)) { // S3 times are in seconds
+		return putCondition{superseded: true}, nil
+	}
+	return putCondition{ifMatch: head.ETag}, nil
+}
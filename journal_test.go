@@ -0,0 +1,163 @@
+package s3_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/caddyserver/caddy/v2"
+	s3 "github.com/cvhome-saas/certmagic-s3"
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+var errConnRefused = &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+// unavailableClient fails writes and reads while down is set, and denies writes while denied is set.
+type unavailableClient struct {
+	*s3test.FakeClient
+	down   atomic.Bool
+	denied atomic.Bool
+}
+
+func (c *unavailableClient) PutObject(ctx context.Context, params *awss3.PutObjectInput, optFns ...func(*awss3.Options)) (*awss3.PutObjectOutput, error) {
+	if c.denied.Load() {
+		return nil, &smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied"}
+	}
+	if c.down.Load() {
+		return nil, errConnRefused
+	}
+	return c.FakeClient.PutObject(ctx, params, optFns...)
+}
+
+func (c *unavailableClient) GetObject(ctx context.Context, params *awss3.GetObjectInput, optFns ...func(*awss3.Options)) (*awss3.GetObjectOutput, error) {
+	if c.down.Load() {
+		return nil, errConnRefused
+	}
+	return c.FakeClient.GetObject(ctx, params, optFns...)
+}
+
+func TestStorageWriteJournal(t *testing.T) {
+	client := &unavailableClient{FakeClient: s3test.NewFakeClient(s3test.DefaultBucket)}
+	dir := t.TempDir()
+	configure := func(s *s3.S3Storage) {
+		s.Client = client
+		s.EncryptionKey = "12345678123456781234567812345678"
+		s.JournalDir = dir
+		s.JournalFlushInterval = caddy.Duration(time.Hour) // Flushed by the test
+	}
+	storage, _ := s3test.NewFakeStorage(t, configure)
+	ctx := context.Background()
+
+	client.down.Store(true)
+	const key = "certificates/example.com/example.com.crt"
+	if err := storage.Store(ctx, key, []byte("certificate")); err != nil {
+		t.Fatalf("Store during an outage failed: %v", err)
+	}
+	if err := storage.Store(ctx, "certificates/example.com/example.com.key", []byte("key")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := storage.Load(ctx, key); err != nil || string(value) != "certificate" {
+		t.Errorf("Load of a journaled value = %q, %v", value, err)
+	}
+	if !storage.Exists(ctx, key) {
+		t.Errorf("journaled value does not exist")
+	}
+	if err := storage.Delete(ctx, "certificates/example.com/example.com.key"); err != nil {
+		t.Fatal(err)
+	}
+	if n := storage.FlushJournal(ctx); n != 1 {
+		t.Errorf("FlushJournal during the outage = %d pending, want 1", n)
+	}
+
+	restarted, _ := s3test.NewFakeStorage(t, configure) // Picks up the pending write
+	client.down.Store(false)
+	if n := restarted.FlushJournal(ctx); n != 0 {
+		t.Errorf("FlushJournal after the outage = %d pending", n)
+	}
+	if keys := client.Keys(s3test.DefaultBucket); !slices.Equal(keys, []string{"certmagic/" + key}) {
+		t.Errorf("bucket after flushing = %v", keys)
+	}
+	if value, err := restarted.Load(ctx, key); err != nil || string(value) != "certificate" {
+		t.Errorf("Load after flushing = %q, %v", value, err)
+	}
+}
+
+func TestStorageWriteJournalPermanentError(t *testing.T) {
+	client := &unavailableClient{FakeClient: s3test.NewFakeClient(s3test.DefaultBucket)}
+	storage, _ := s3test.NewFakeStorage(t, func(s *s3.S3Storage) {
+		s.Client = client
+		s.JournalDir = t.TempDir()
+		s.JournalFlushInterval = caddy.Duration(time.Hour)
+	})
+	ctx := context.Background()
+
+	client.denied.Store(true)
+	const key = "certificates/example.com/example.com.crt"
+	if err := storage.Store(ctx, key, []byte("certificate")); !errors.Is(err, s3.ErrAccessDenied) {
+		t.Fatalf("Store when access is denied = %v, want ErrAccessDenied", err)
+	}
+	if storage.Exists(ctx, key) {
+		t.Errorf("denied write was journaled")
+	}
+	if n := storage.FlushJournal(ctx); n != 0 {
+		t.Errorf("FlushJournal = %d pending, want 0", n)
+	}
+}
+
+func TestStorageWriteJournalLateFlush(t *testing.T) {
+	client := &unavailableClient{FakeClient: s3test.NewFakeClient(s3test.DefaultBucket)}
+	storage, _ := s3test.NewFakeStorage(t, func(s *s3.S3Storage) {
+		s.Client = client
+		s.JournalDir = t.TempDir()
+		s.JournalFlushInterval = caddy.Duration(time.Hour)
+	})
+	other, _ := s3test.NewFakeStorage(t, func(s *s3.S3Storage) { s.Client = client.FakeClient })
+	ctx := context.Background()
+
+	client.down.Store(true)
+	const key = "certificates/example.com/example.com.crt"
+	if err := storage.Store(ctx, key, []byte("stale")); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Store(ctx, key, []byte("renewed")); err != nil { // Another instance, after the outage
+		t.Fatal(err)
+	}
+	client.down.Store(false)
+	if n := storage.FlushJournal(ctx); n != 0 {
+		t.Errorf("FlushJournal = %d pending, want 0", n)
+	}
+	if value, err := other.Load(ctx, key); err != nil || string(value) != "renewed" {
+		t.Errorf("value after the late flush = %q, %v, want the newer value", value, err)
+	}
+}
+
+func TestStorageWithPrefixJournalDir(t *testing.T) {
+	dir := t.TempDir()
+	storage, _ := s3test.NewFakeStorage(t, func(s *s3.S3Storage) { s.JournalDir = dir })
+	child, err := storage.WithPrefix("tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "tenant"); child.JournalDir != want {
+		t.Errorf("JournalDir of the child = %q, want %q", child.JournalDir, want)
+	}
+}
+
+func TestStorageWriteJournalStoreStream(t *testing.T) {
+	storage, fake := s3test.NewFakeStorage(t, func(s *s3.S3Storage) { s.JournalDir = t.TempDir() })
+	if err := storage.StoreStream(context.Background(), "big", strings.NewReader("value"), 5); err == nil {
+		t.Error("StoreStream with journal_dir succeeded")
+	}
+	if keys := fake.Keys(s3test.DefaultBucket); len(keys) != 0 {
+		t.Errorf("rejected stream was uploaded: %v", keys)
+	}
+}
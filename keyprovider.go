@@ -0,0 +1,211 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// The encryption key comes from a KeyProvider: encryption_key is a StaticKeyProvider,
+// encryption_key_source selects a built-in one (env, file, secretsmanager, ssm), and library users
+// may set S3Storage.KeyProvider to their own, e.g. for an HSM or KMS. The key is fetched during
+// Provision and, with encryption_key_refresh, again periodically.
+
+// KeyProvider supplies the 32-byte encryption key.
+type KeyProvider interface {
+	GetKey(ctx context.Context) ([32]byte, error)
+}
+
+// KeyIDProvider is optionally implemented by KeyProviders whose keys have an ID, e.g. a key
+// version. The ID of the current key is logged when it is fetched or rotated.
+type KeyIDProvider interface {
+	KeyID() string
+}
+
+// StaticKeyProvider provides a fixed key.
+type StaticKeyProvider struct {
+	Key [32]byte
+}
+
+// NewStaticKeyProvider returns a provider for a key of exactly 32 bytes.
+func NewStaticKeyProvider(key string) (*StaticKeyProvider, error) {
+	k, err := keyFromString(key)
+	if err != nil {
+		return nil, err
+	}
+	return &StaticKeyProvider{Key: k}, nil
+}
+
+// GetKey returns the key.
+func (p *StaticKeyProvider) GetKey(context.Context) ([32]byte, error) {
+	return p.Key, nil
+}
+
+// EnvKeyProvider reads the key from an environment variable, each time it is asked for it.
+type EnvKeyProvider struct {
+	Name string
+}
+
+// GetKey returns the value of the environment variable.
+func (p *EnvKeyProvider) GetKey(context.Context) ([32]byte, error) {
+	value, ok := os.LookupEnv(p.Name)
+	if !ok {
+		return [32]byte{}, fmt.Errorf("environment variable %s is not set", p.Name)
+	}
+	return keyFromString(value)
+}
+
+// FileKeyProvider reads the key from a file, e.g. a secret mount, each time it is asked for it.
+// A trailing newline is ignored.
+type FileKeyProvider struct {
+	Path string
+}
+
+// GetKey returns the content of the file.
+func (p *FileKeyProvider) GetKey(context.Context) ([32]byte, error) {
+	content, err := os.ReadFile(p.Path)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("reading encryption key: %w", err)
+	}
+	return keyFromString(strings.TrimRight(string(content), "\r\n"))
+}
+
+// keyFromString checks that key has exactly 32 bytes.
+func keyFromString(key string) ([32]byte, error) {
+	var k [32]byte
+	switch len(key) {
+	case 0:
+		return k, errors.New("encryption key is empty")
+	case len(k):
+	default:
+		return k, fmt.Errorf("encryption key must have exactly 32 bytes, got %d; "+
+			"use encryption_passphrase for a passphrase of any length", len(key))
+	}
+	copy(k[:], key)
+	return k, nil
+}
+
+// keyProviderName describes the provider in logs.
+func (s *S3Storage) keyProviderName() string {
+	if s.EncryptionKeySource != "" {
+		return s.EncryptionKeySource
+	}
+	return fmt.Sprintf("%T", s.KeyProvider)
+}
+
+// provisionKeyProvider sets up the key provider of the configuration, fetches the key into
+// EncryptionKey and, with encryption_key_refresh, keeps the provider for refreshing it.
+func (s *S3Storage) provisionKeyProvider(ctx context.Context) error {
+	switch {
+	case s.KeyProvider != nil:
+		if s.EncryptionKey != "" || s.EncryptionKeySource != "" {
+			return errors.New("KeyProvider and encryption_key or encryption_key_source are mutually exclusive")
+		}
+	case s.EncryptionKeySource != "":
+		provider, err := s.newKeySource()
+		if err != nil {
+			return err
+		}
+		s.KeyProvider = provider
+	case s.EncryptionKey != "":
+		provider, err := NewStaticKeyProvider(s.EncryptionKey)
+		if err != nil {
+			return err
+		}
+		s.KeyProvider = provider
+		return nil // Nothing to fetch or refresh
+	default:
+		return nil // Clear text, or encrypted by vault_transit or encryption_passphrase
+	}
+
+	key, err := s.KeyProvider.GetKey(ctx)
+	if err != nil {
+		return fmt.Errorf("fetching encryption key from %s: %w", s.keyProviderName(), err)
+	}
+	s.EncryptionKey = string(key[:])
+	s.logger.Info("fetched encryption key", zap.String("source", s.keyProviderName()), zap.String("key_id", keyID(s.KeyProvider)))
+	if s.EncryptionKeyRefresh > 0 {
+		s.keyRefresh = s.KeyProvider
+	}
+	return nil
+}
+
+// keyID returns the ID of the provider's current key, if it has one.
+func keyID(p KeyProvider) string {
+	if ider, ok := p.(KeyIDProvider); ok {
+		return ider.KeyID()
+	}
+	return ""
+}
+
+// refreshingIO is the IO of a storage whose key is refreshed, swapped when the key rotates.
+type refreshingIO struct {
+	mu      sync.RWMutex
+	current IO
+}
+
+func (r *refreshingIO) get() IO {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+func (r *refreshingIO) ByteReader(plaintext []byte) (io.Reader, int64, error) {
+	return r.get().ByteReader(plaintext)
+}
+
+func (r *refreshingIO) StreamReader(plaintext io.Reader, size int64) (io.Reader, int64, error) {
+	return r.get().StreamReader(plaintext, size)
+}
+
+func (r *refreshingIO) WrapReader(ciphertextReader io.Reader) io.Reader {
+	return r.get().WrapReader(ciphertextReader)
+}
+
+// startKeyRefresh fetches the key every interval until ctx is done and rotates the IO when it
+// changed. The IO must be a refreshingIO.
+func (s *S3Storage) startKeyRefresh(ctx context.Context, wrap *refreshingIO) {
+	interval := time.Duration(s.EncryptionKeyRefresh)
+	go func() {
+		key := []byte(s.EncryptionKey)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			fetched, err := s.keyRefresh.GetKey(ctx)
+			if err != nil {
+				s.logger.Warn("refreshing encryption key failed, keeping the current key", zap.Error(err))
+				continue
+			}
+			if bytes.Equal(fetched[:], key) {
+				continue
+			}
+			current, err := NewCipherIO(string(fetched[:]), s.EncryptionCipher)
+			if err != nil {
+				s.logger.Error("refreshed encryption key is invalid, keeping the current key", zap.Error(err))
+				continue
+			}
+			previous := wrap.get()
+			if r, ok := previous.(*RolloverIO); ok {
+				previous = r.Current // Only the last key before the rotation stays readable
+			}
+			wrap.mu.Lock()
+			wrap.current = &RolloverIO{Current: current, Previous: previous}
+			wrap.mu.Unlock()
+			key = fetched[:]
+			s.logger.Info("encryption key rotated", zap.String("source", s.keyProviderName()), zap.String("key_id", keyID(s.keyRefresh)))
+		}
+	}()
+}
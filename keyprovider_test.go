@@ -0,0 +1,58 @@
+package s3
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// hsmKeyProvider stands in for a provider plugged in by a library user.
+type hsmKeyProvider struct{ key [32]byte }
+
+func (p *hsmKeyProvider) GetKey(context.Context) ([32]byte, error) { return p.key, nil }
+func (p *hsmKeyProvider) KeyID() string                            { return "hsm-key-1" }
+
+func TestKeyProviders(t *testing.T) {
+	ctx := context.Background()
+	key := strings.Repeat("k", 32)
+	t.Setenv("S3TEST_ENCRYPTION_KEY", key)
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte(key+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	custom := &hsmKeyProvider{key: [32]byte([]byte(key))}
+
+	for name, s := range map[string]*S3Storage{
+		"static": {EncryptionKey: key},
+		"env":    {EncryptionKeySource: "env:S3TEST_ENCRYPTION_KEY"},
+		"file":   {EncryptionKeySource: "file:" + keyFile},
+		"custom": {KeyProvider: custom},
+	} {
+		s.logger = zap.NewNop()
+		if err := s.provisionKeyProvider(ctx); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if s.EncryptionKey != key || s.KeyProvider == nil {
+			t.Errorf("%s: key = %q, provider %T", name, s.EncryptionKey, s.KeyProvider)
+		}
+	}
+	if id := keyID(custom); id != "hsm-key-1" {
+		t.Errorf("key ID = %q", id)
+	}
+
+	for name, s := range map[string]*S3Storage{
+		"short static key": {EncryptionKey: "short"},
+		"unset variable":   {EncryptionKeySource: "env:S3TEST_UNSET_KEY"},
+		"missing file":     {EncryptionKeySource: "file:" + keyFile + ".missing"},
+		"provider and key": {KeyProvider: custom, EncryptionKey: key},
+	} {
+		s.logger = zap.NewNop()
+		if err := s.provisionKeyProvider(ctx); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
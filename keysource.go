@@ -0,0 +1,93 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// With encryption_key_source, the encryption key is fetched from AWS Secrets Manager
+// ("secretsmanager:<secret ARN or name>"), SSM Parameter Store ("ssm:<parameter name or ARN>"),
+// an environment variable ("env:<name>") or a file ("file:<path>") during Provision, so it never
+// appears in the Caddy config. With encryption_key_refresh, it is fetched again periodically;
+// after a rotation, new objects are written with the new key while objects written with the
+// previous key stay readable.
+
+const (
+	keySourceSecretsManager = "secretsmanager"
+	keySourceSSM            = "ssm"
+	keySourceEnv            = "env"
+	keySourceFile           = "file"
+)
+
+// keySource fetches the encryption key from AWS.
+type keySource struct {
+	kind string // keySourceSecretsManager or keySourceSSM
+	id   string // Secret or parameter name or ARN
+	cfg  aws.Config
+}
+
+// newKeySource parses encryption_key_source. The region of an ARN takes precedence over the
+// storage region, so that keys can be kept in another region.
+func (s *S3Storage) newKeySource() (KeyProvider, error) {
+	kind, id, ok := strings.Cut(s.EncryptionKeySource, ":")
+	if !ok || id == "" || !slices.Contains([]string{keySourceSecretsManager, keySourceSSM, keySourceEnv, keySourceFile}, kind) {
+		return nil, fmt.Errorf("invalid encryption_key_source '%s' (expected secretsmanager:<secret>, ssm:<parameter>, "+
+			"env:<variable> or file:<path>)", s.EncryptionKeySource)
+	}
+	if s.EncryptionKey != "" {
+		return nil, errors.New("encryption_key_source and encryption_key are mutually exclusive")
+	}
+	switch kind {
+	case keySourceEnv:
+		return &EnvKeyProvider{Name: id}, nil
+	case keySourceFile:
+		return &FileKeyProvider{Path: id}, nil
+	}
+	region := s.Region
+	if parsed, err := arn.Parse(id); err == nil && parsed.Region != "" {
+		region = parsed.Region
+	}
+	cfg, err := s.loadAWSConfig(region, s.AccessKeyID, s.SecretAccessKey, s.SessionToken)
+	if err != nil {
+		return nil, fmt.Errorf("encryption_key_source: %w", err)
+	}
+	return &keySource{kind: kind, id: id, cfg: cfg}, nil
+}
+
+// GetKey fetches the current key.
+func (ks *keySource) GetKey(ctx context.Context) ([32]byte, error) {
+	var key string
+	switch ks.kind {
+	case keySourceSecretsManager:
+		out, err := secretsmanager.NewFromConfig(ks.cfg).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(ks.id),
+		})
+		if err != nil {
+			return [32]byte{}, fmt.Errorf("fetching encryption key from secret %s: %w", ks.id, err)
+		}
+		key = aws.ToString(out.SecretString)
+		if out.SecretString == nil {
+			key = string(out.SecretBinary)
+		}
+	case keySourceSSM:
+		out, err := ssm.NewFromConfig(ks.cfg).GetParameter(ctx, &ssm.GetParameterInput{
+			Name:           aws.String(ks.id),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return [32]byte{}, fmt.Errorf("fetching encryption key from parameter %s: %w", ks.id, err)
+		}
+		if out.Parameter != nil {
+			key = aws.ToString(out.Parameter.Value)
+		}
+	}
+	return keyFromString(strings.TrimRight(key, "\r\n"))
+}
@@ -0,0 +1,141 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// fakeKeyService answers GetSecretValue and GetParameter with the current key.
+type fakeKeyService struct {
+	mu  sync.Mutex
+	key string
+}
+
+func (f *fakeKeyService) setKey(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.key = key
+}
+
+func (f *fakeKeyService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	key := f.key
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	switch target := r.Header.Get("X-Amz-Target"); {
+	case strings.HasSuffix(target, ".GetSecretValue"):
+		json.NewEncoder(w).Encode(map[string]any{"SecretString": key + "\n"})
+	case strings.HasSuffix(target, ".GetParameter"):
+		json.NewEncoder(w).Encode(map[string]any{"Parameter": map[string]any{"Value": key}})
+	default:
+		http.Error(w, "unexpected target "+target, http.StatusBadRequest)
+	}
+}
+
+func newKeySourceTestStorage(t *testing.T, source string) (*S3Storage, *fakeKeyService) {
+	t.Helper()
+	svc := &fakeKeyService{key: strings.Repeat("a", 32)}
+	srv := httptest.NewServer(svc)
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL_SECRETS_MANAGER", srv.URL)
+	t.Setenv("AWS_ENDPOINT_URL_SSM", srv.URL)
+	s := &S3Storage{
+		Region:              "us-east-1",
+		AccessKeyID:         "id",
+		SecretAccessKey:     "secret",
+		EncryptionKeySource: source,
+		logger:              zap.NewNop(),
+	}
+	return s, svc
+}
+
+func TestKeySourceFetch(t *testing.T) {
+	for _, source := range []string{
+		"secretsmanager:arn:aws:secretsmanager:eu-west-1:123456789012:secret:caddy-key",
+		"ssm:/caddy/encryption-key",
+	} {
+		s, _ := newKeySourceTestStorage(t, source)
+		if err := s.provisionKeyProvider(context.Background()); err != nil {
+			t.Fatalf("%s: %v", source, err)
+		}
+		if s.EncryptionKey != strings.Repeat("a", 32) {
+			t.Errorf("%s: key = %q", source, s.EncryptionKey)
+		}
+		if s.keyRefresh != nil {
+			t.Errorf("%s: refreshing without encryption_key_refresh", source)
+		}
+	}
+
+	for _, s := range []*S3Storage{
+		{EncryptionKeySource: "vault:secret", logger: zap.NewNop()},
+		{EncryptionKeySource: "ssm:", logger: zap.NewNop()},
+		{EncryptionKeySource: "ssm:/key", EncryptionKey: "literal", logger: zap.NewNop()},
+	} {
+		if _, err := s.newKeySource(); err == nil {
+			t.Errorf("%q: expected an error", s.EncryptionKeySource)
+		}
+	}
+}
+
+func TestKeySourceRefresh(t *testing.T) {
+	s, svc := newKeySourceTestStorage(t, "ssm:/caddy/encryption-key")
+	s.EncryptionKeyRefresh = caddy.Duration(10 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.provisionKeyProvider(ctx); err != nil {
+		t.Fatal(err)
+	}
+	initial, err := NewCipherIO(s.EncryptionKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrap := &refreshingIO{current: initial}
+	s.startKeyRefresh(ctx, wrap)
+
+	oldValue := encryptTestValue(t, wrap, "written with the first key")
+	svc.setKey(strings.Repeat("b", 32))
+	deadline := time.Now().Add(5 * time.Second)
+	for wrap.get() == initial {
+		if time.Now().After(deadline) {
+			t.Fatal("key was not rotated")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	newValue := encryptTestValue(t, wrap, "written with the second key")
+	if _, err := decryptWith(initial, newValue); err == nil {
+		t.Error("new value is still written with the first key")
+	}
+	for value, want := range map[string]string{string(oldValue): "written with the first key", string(newValue): "written with the second key"} {
+		got, err := decryptWith(wrap, []byte(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func encryptTestValue(t *testing.T, wrap IO, value string) []byte {
+	t.Helper()
+	r, _, err := wrap.ByteReader([]byte(value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,202 @@
+package s3
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// With a lifecycle block, Provision installs lifecycle rules scoped to the prefix in the bucket's
+// lifecycle configuration. The rules are identified by their IDs, which name the prefix, so rules
+// of other prefixes and of other tools are kept; a rule whose option is removed is removed too.
+// The configuration is only written when the rules changed.
+
+// defaultTransitionStorageClass is the storage class objects transition to by default.
+const defaultTransitionStorageClass = types.TransitionStorageClassStandardIa
+
+// LifecycleConfig configures the lifecycle rules for the prefix; a zero number of days leaves the
+// rule out.
+type LifecycleConfig struct {
+	AbortIncompleteUploadsDays int32  `json:"abort_incomplete_uploads_days,omitempty"` // Abort multipart uploads left by StoreStream
+	ExpireOCSPDays             int32  `json:"expire_ocsp_days,omitempty"`              // Delete OCSP staples, which CertMagic fetches again
+	TransitionDays             int32  `json:"transition_days,omitempty"`               // Move all objects to TransitionStorageClass
+	TransitionStorageClass     string `json:"transition_storage_class,omitempty"`      // Default STANDARD_IA
+}
+
+// lifecycleRuleID returns the ID of the rule for a purpose of this storage's prefix.
+func (s *S3Storage) lifecycleRuleID(purpose string) string {
+	return fmt.Sprintf("certmagic-s3:%s:%s", s.Prefix, purpose)
+}
+
+// lifecycleRules returns the rules the configuration asks for, and the IDs of all rules this
+// storage manages.
+func (s *S3Storage) lifecycleRules() (rules []types.LifecycleRule, managed []string) {
+	cfg := s.Lifecycle
+	prefix := s.s3ObjectKey("")
+	if prefix != "" {
+		prefix += "/"
+	}
+	rule := func(purpose, prefix string, days int32, fn func(*types.LifecycleRule)) {
+		id := s.lifecycleRuleID(purpose)
+		managed = append(managed, id)
+		if days <= 0 {
+			return
+		}
+		r := types.LifecycleRule{
+			ID:     aws.String(id),
+			Status: types.ExpirationStatusEnabled,
+			Filter: &types.LifecycleRuleFilter{Prefix: aws.String(prefix)},
+		}
+		fn(&r)
+		rules = append(rules, r)
+	}
+	rule("abort-uploads", prefix, cfg.AbortIncompleteUploadsDays, func(r *types.LifecycleRule) {
+		r.AbortIncompleteMultipartUpload = &types.AbortIncompleteMultipartUpload{DaysAfterInitiation: aws.Int32(cfg.AbortIncompleteUploadsDays)}
+	})
+	rule("expire-ocsp", s.s3ObjectKey(ocspKeyPrefix)+"/", cfg.ExpireOCSPDays, func(r *types.LifecycleRule) {
+		r.Expiration = &types.LifecycleExpiration{Days: aws.Int32(cfg.ExpireOCSPDays)}
+	})
+	rule("transition", prefix, cfg.TransitionDays, func(r *types.LifecycleRule) {
+		r.Transitions = []types.Transition{{
+			Days:         aws.Int32(cfg.TransitionDays),
+			StorageClass: types.TransitionStorageClass(cmp.Or(cfg.TransitionStorageClass, string(defaultTransitionStorageClass))),
+		}}
+	})
+	return rules, managed
+}
+
+// validateLifecycle checks the lifecycle block.
+func (s *S3Storage) validateLifecycle() error {
+	cfg := s.Lifecycle
+	switch {
+	case s.ReadOnly:
+		return errors.New("lifecycle cannot be used with read_only")
+	case isMultiRegionAccessPoint(s.Bucket):
+		return errors.New("lifecycle is not supported with multi-region access points; configure it on the buckets")
+	case s.isGCS():
+		return errors.New("lifecycle is not supported by provider gcs")
+	case cfg.AbortIncompleteUploadsDays < 0 || cfg.ExpireOCSPDays < 0 || cfg.TransitionDays < 0:
+		return errors.New("lifecycle: days must not be negative")
+	}
+	if cfg.TransitionStorageClass != "" {
+		cfg.TransitionStorageClass = strings.ToUpper(cfg.TransitionStorageClass)
+		if !slices.Contains(defaultTransitionStorageClass.Values(), types.TransitionStorageClass(cfg.TransitionStorageClass)) {
+			return fmt.Errorf("lifecycle: unsupported transition storage class '%s'", cfg.TransitionStorageClass)
+		}
+	}
+	return nil
+}
+
+// applyLifecycle installs or updates the lifecycle rules of the prefix.
+func (s *S3Storage) applyLifecycle(ctx context.Context) error {
+	rules, managed := s.lifecycleRules()
+
+	getCtx, cancel := s.opContext(ctx)
+	out, err := s.Client.GetBucketLifecycleConfiguration(getCtx, &awss3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(s.Bucket)})
+	cancel()
+	var existing []types.LifecycleRule
+	var apiErr smithy.APIError
+	switch {
+	case err == nil:
+		existing = out.Rules
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchLifecycleConfiguration":
+	default:
+		return fmt.Errorf("reading lifecycle configuration of bucket %s: %w", s.Bucket, s3Error(err))
+	}
+
+	var current []types.LifecycleRule // Rules of this prefix as they are
+	kept := slices.DeleteFunc(slices.Clone(existing), func(r types.LifecycleRule) bool {
+		if slices.Contains(managed, aws.ToString(r.ID)) {
+			current = append(current, r)
+			return true
+		}
+		return false
+	})
+	if slices.EqualFunc(current, rules, func(a, b types.LifecycleRule) bool { return lifecycleRuleKey(a) == lifecycleRuleKey(b) }) {
+		s.logger.Debug("lifecycle rules are up to date", zap.Int("rules", len(rules)))
+		return nil
+	}
+
+	putCtx, cancel := s.opContext(ctx)
+	defer cancel()
+	all := append(kept, rules...)
+	if len(all) == 0 {
+		_, err = s.Client.DeleteBucketLifecycle(putCtx, &awss3.DeleteBucketLifecycleInput{Bucket: aws.String(s.Bucket)})
+	} else {
+		_, err = s.Client.PutBucketLifecycleConfiguration(putCtx, &awss3.PutBucketLifecycleConfigurationInput{
+			Bucket:                 aws.String(s.Bucket),
+			LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: all},
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("updating lifecycle configuration of bucket %s: %w", s.Bucket, s3Error(err))
+	}
+	s.logger.Info("updated lifecycle rules", zap.Int("rules", len(rules)), zap.Int("other_rules", len(kept)))
+	return nil
+}
+
+// lifecycleRuleKey describes the parts of a rule this storage sets, for comparison.
+func lifecycleRuleKey(r types.LifecycleRule) string {
+	key := []string{aws.ToString(r.ID), string(r.Status)}
+	if r.Filter != nil {
+		key = append(key, "prefix="+aws.ToString(r.Filter.Prefix))
+	}
+	if r.AbortIncompleteMultipartUpload != nil {
+		key = append(key, "abort="+strconv.Itoa(int(aws.ToInt32(r.AbortIncompleteMultipartUpload.DaysAfterInitiation))))
+	}
+	if r.Expiration != nil {
+		key = append(key, "expire="+strconv.Itoa(int(aws.ToInt32(r.Expiration.Days))))
+	}
+	for _, t := range r.Transitions {
+		key = append(key, fmt.Sprintf("transition=%d:%s", aws.ToInt32(t.Days), t.StorageClass))
+	}
+	return strings.Join(key, " ")
+}
+
+// unmarshalLifecycle parses the lifecycle block:
+//
+//	lifecycle {
+//		abort_incomplete_uploads <days>
+//		expire_ocsp <days>
+//		transition <days> [<storage class>]
+//	}
+func (s *S3Storage) unmarshalLifecycle(d *caddyfile.Dispenser) error {
+	cfg := new(LifecycleConfig)
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		key := d.Val()
+		args := d.RemainingArgs()
+		if len(args) == 0 || len(args) > 2 || (len(args) == 2 && key != "transition") {
+			return d.ArgErr()
+		}
+		days, err := strconv.ParseInt(args[0], 10, 32)
+		if err != nil || days <= 0 {
+			return d.Errf("invalid lifecycle %s days '%s'", key, args[0])
+		}
+		switch key {
+		case "abort_incomplete_uploads":
+			cfg.AbortIncompleteUploadsDays = int32(days)
+		case "expire_ocsp":
+			cfg.ExpireOCSPDays = int32(days)
+		case "transition":
+			cfg.TransitionDays = int32(days)
+			if len(args) == 2 {
+				cfg.TransitionStorageClass = args[1]
+			}
+		default:
+			return d.Errf("unrecognized lifecycle subdirective '%s'", key)
+		}
+	}
+	s.Lifecycle = cfg
+	return nil
+}
@@ -0,0 +1,55 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"iter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// maxListPageSize is the most keys S3 returns per ListObjectsV2 page.
+const maxListPageSize = 1000
+
+// errStopList stops listPages when the consumer of ListIter is done.
+var errStopList = errors.New("listing stopped")
+
+// ListIter lists like List, but yields the keys page by page as they are listed, so memory stays
+// flat for storages with very many keys. Listing stops when the loop is left early; an error is
+// yielded once, as the last element.
+func (s *S3Storage) ListIter(ctx context.Context, prefix string, recursive bool) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		if s.manifest != nil { // The manifest is held in memory anyway
+			keys, err := s.listFromManifest(ctx, prefix, recursive)
+			if err != nil {
+				yield("", err)
+				return
+			}
+			for _, key := range keys {
+				if !yield(key, nil) {
+					return
+				}
+			}
+			return
+		}
+		err := s.listPages(ctx, prefix, recursive, func(keys []string) error {
+			for _, key := range keys {
+				if !yield(key, nil) {
+					return errStopList
+				}
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errStopList) {
+			yield("", err)
+		}
+	}
+}
+
+// listPageSize returns MaxKeys for list requests, or nil for the S3 default of 1000.
+func (s *S3Storage) listPageSize() *int32 {
+	if s.ListPageSize <= 0 {
+		return nil
+	}
+	return aws.Int32(int32(min(s.ListPageSize, maxListPageSize)))
+}
@@ -0,0 +1,89 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// With list_concurrency, a recursive listing first lists one level below the prefix, and then
+// each directory found there recursively, list_concurrency at a time. Prefixes with hundreds of
+// thousands of keys, such as certificates/ during CertMagic's cleanup scans, are listed in a
+// fraction of the time a single sequence of pages takes.
+
+// listParallel lists recursively for listBucketPages. fn is called for one page at a time, with
+// the pages of different directories in no particular order.
+func (s *S3Storage) listParallel(ctx context.Context, listPrefix string, routes []string, fn func(keys []string) error) error {
+	s3ListPrefix := s.s3ObjectKey(listPrefix)
+	if s3ListPrefix != "" && !strings.HasSuffix(s3ListPrefix, "/") {
+		s3ListPrefix += "/"
+	}
+	stripPrefixFromS3Key := ""
+	if s.Prefix != "" {
+		stripPrefixFromS3Key = s.Prefix + "/"
+	}
+
+	var dirs []string
+	seen := make(map[string]bool) // Some S3-compatible backends repeat common prefixes across pages
+	client, bucket := s.reader()
+	paginator := awss3.NewListObjectsV2Paginator(client, &awss3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(s3ListPrefix),
+		Delimiter: aws.String("/"),
+		MaxKeys:   s.listPageSize(),
+	})
+	for paginator.HasMorePages() {
+		pageCtx, cancel := s.listContext(ctx)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			s.recordError("list", listPrefix, err)
+			return fmt.Errorf("listing s3://%s/%s: %w", bucket, s3ListPrefix, s3Error(err))
+		}
+		for _, cp := range page.CommonPrefixes {
+			dir := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(cp.Prefix), stripPrefixFromS3Key), "/")
+			if dir != "" && !seen[dir] && !routedAway(dir, routes) {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+		var keys []string
+		for _, obj := range page.Contents {
+			if aws.ToString(obj.Key) == s3ListPrefix {
+				continue // Directory marker
+			}
+			key := strings.TrimPrefix(aws.ToString(obj.Key), stripPrefixFromS3Key)
+			if key != "" && !s.isHiddenKey(key) && !routedAway(key, routes) {
+				keys = append(keys, key)
+			}
+		}
+		if err := fn(keys); err != nil {
+			return err
+		}
+	}
+	s.logger.Debug("listing directories in parallel", zap.String("certmagic_prefix_arg", listPrefix),
+		zap.Int("directories", len(dirs)), zap.Int("concurrency", s.ListConcurrency))
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(s.ListConcurrency)
+	var mu sync.Mutex
+	for _, dir := range dirs {
+		g.Go(func() error {
+			return s.listBucketSequential(gctx, dir, true, routes, func(keys []string) error {
+				mu.Lock()
+				defer mu.Unlock()
+				if err := gctx.Err(); err != nil {
+					return err // fn failed for another directory
+				}
+				return fn(keys)
+			})
+		})
+	}
+	return g.Wait()
+}
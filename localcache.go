@@ -0,0 +1,148 @@
+package s3
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+// localMirror is a write-through copy of the storage on local disk. It is used as read fallback
+// while S3 is unreachable, so existing certificates keep working during outages.
+// Files hold the same (possibly encrypted) bytes as the S3 objects.
+type localMirror struct {
+	dir string
+}
+
+// path maps a CertMagic key to a file below the mirror directory.
+func (m *localMirror) path(key string) string {
+	// Cleaning an absolute path removes any ".." elements, keeping the result below dir.
+	clean := filepath.Clean(string(filepath.Separator) + filepath.FromSlash(strings.TrimPrefix(key, "/")))
+	return filepath.Join(m.dir, clean)
+}
+
+// write atomically replaces the mirrored file for key.
+func (m *localMirror) write(key string, data []byte) error {
+	p := m.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (m *localMirror) read(key string) ([]byte, error) {
+	p := m.path(key)
+	return os.ReadFile(p)
+}
+
+// remove deletes the mirrored file or directory for key.
+func (m *localMirror) remove(key string) error {
+	p := m.path(key)
+	if p == m.dir {
+		return errors.New("refusing to remove the mirror root")
+	}
+	return os.RemoveAll(p)
+}
+
+func (m *localMirror) stat(key string) (certmagic.KeyInfo, error) {
+	p := m.path(key)
+	fi, err := os.Stat(p)
+	if err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+	return certmagic.KeyInfo{
+		Key:        key,
+		Modified:   fi.ModTime(),
+		Size:       fi.Size(),
+		IsTerminal: !fi.IsDir(),
+	}, nil
+}
+
+// provisionLocalMirror prepares the mirror directory.
+func (s *S3Storage) provisionLocalMirror() error {
+	dir, err := filepath.Abs(s.LocalCacheDir)
+	if err != nil {
+		return fmt.Errorf("local_cache_dir: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("creating local_cache_dir: %w", err)
+	}
+	s.mirror = &localMirror{dir: dir}
+	s.logger.Info("local fallback cache active", zap.String("dir", dir))
+	return nil
+}
+
+// mirrorStore writes the value to the local mirror; failures are logged, never returned.
+func (s *S3Storage) mirrorStore(key string, value []byte) {
+	if s.mirror == nil {
+		return
+	}
+	reader, _, err := s.iowrap.ByteReader(value)
+	if err == nil {
+		var data []byte
+		if data, err = io.ReadAll(reader); err == nil {
+			err = s.mirror.write(key, data)
+		}
+	}
+	if err != nil {
+		s.logger.Warn("updating local fallback cache failed", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// mirrorLoaded mirrors a value loaded from S3 unless the mirror is already up to date, i.e. its
+// file is at least as recent as the object. The file then gets the object's modification time.
+func (s *S3Storage) mirrorLoaded(key string, value []byte, modified time.Time) {
+	if s.mirror == nil {
+		return
+	}
+	p := s.mirror.path(key)
+	if fi, err := os.Stat(p); err == nil && !fi.ModTime().Before(modified) {
+		return
+	}
+	s.mirrorStore(key, value)
+	if !modified.IsZero() {
+		_ = os.Chtimes(p, modified, modified) // Best effort; at worst the file is rewritten on the next load
+	}
+}
+
+// mirrorDelete removes the key from the local mirror.
+func (s *S3Storage) mirrorDelete(key string) {
+	if s.mirror == nil {
+		return
+	}
+	if err := s.mirror.remove(key); err != nil {
+		s.logger.Warn("removing from local fallback cache failed", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// mirrorLoad reads and decrypts the value for key from the local mirror.
+func (s *S3Storage) mirrorLoad(key string) ([]byte, error) {
+	data, err := s.mirror.read(key)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fs.ErrNotExist
+		}
+		return nil, err
+	}
+	return io.ReadAll(s.iowrap.WrapReader(bytes.NewReader(data)))
+}
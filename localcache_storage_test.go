@@ -0,0 +1,60 @@
+package s3_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	s3 "github.com/cvhome-saas/certmagic-s3"
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+func TestStorageLocalMirrorLoad(t *testing.T) {
+	srv := s3test.NewServer(t)
+	dir := t.TempDir()
+	writer := srv.Storage(t)
+	storage := srv.Storage(t, func(s *s3.S3Storage) { s.LocalCacheDir = dir })
+	ctx := context.Background()
+	key := "certificates/acme/example.com/example.com.crt"
+	file := filepath.Join(dir, filepath.FromSlash(key))
+
+	// Objects written by other instances are mirrored on load.
+	if err := writer.Store(ctx, key, []byte("certificate")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Load(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatalf("value not mirrored: %v", err)
+	}
+
+	// An up-to-date mirror file is not rewritten.
+	if err := os.WriteFile(file, []byte("untouched"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Load(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(file); string(data) != "untouched" {
+		t.Errorf("up-to-date mirror file rewritten with %q", data)
+	}
+
+	// One older than the object is.
+	old := fi.ModTime().Add(-time.Hour)
+	if err := os.Chtimes(file, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Load(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(file); string(data) != "certificate" {
+		t.Errorf("outdated mirror file not rewritten, has %q", data)
+	}
+}
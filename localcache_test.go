@@ -0,0 +1,35 @@
+package s3
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalMirror(t *testing.T) {
+	dir := t.TempDir()
+	m := &localMirror{dir: dir}
+
+	if p := m.path("../../etc/passwd"); !strings.HasPrefix(p, dir+string(filepath.Separator)) {
+		t.Errorf("path escaped the mirror directory: %s", p)
+	}
+
+	key := "certificates/example.com/example.com.crt"
+	if err := m.write(key, []byte("cert")); err != nil {
+		t.Fatalf("writing failed: %v", err)
+	}
+	data, err := m.read(key)
+	if err != nil || string(data) != "cert" {
+		t.Fatalf("expected to read back the value, got %q (%v)", data, err)
+	}
+
+	if err := m.remove("certificates/example.com"); err != nil {
+		t.Fatalf("removing failed: %v", err)
+	}
+	if _, err := m.read(key); err == nil {
+		t.Errorf("expected the value to be gone after removing its directory")
+	}
+	if err := m.remove(""); err == nil {
+		t.Errorf("expected removing the root to be refused")
+	}
+}
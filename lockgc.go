@@ -0,0 +1,143 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// Instances crashing while holding a lock leave the lock object behind. Lock takes expired locks
+// over, but one nobody asks for again stays forever. With lock_gc_interval, a janitor deletes
+// lock objects older than the lock expiration every interval.
+
+// LockGCStats reports the work of the lock janitor.
+type LockGCStats struct {
+	Runs    uint64    `json:"runs"`
+	Reaped  uint64    `json:"reaped"` // Lock objects deleted
+	Errors  uint64    `json:"errors"` // Failed runs and deletions
+	LastRun time.Time `json:"last_run,omitzero"`
+}
+
+// lockJanitor counts the work of the lock janitor.
+type lockJanitor struct {
+	runs, reaped, errors atomic.Uint64
+	lastRun              atomic.Int64 // Unix nanoseconds
+}
+
+// startLockGC deletes stale lock objects every interval until ctx is done.
+func (s *S3Storage) startLockGC(ctx context.Context, interval time.Duration) {
+	s.lockGC = new(lockJanitor)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if _, err := s.ReapExpiredLocks(ctx); err != nil && ctx.Err() == nil {
+				s.logger.Warn("lock garbage collection failed", zap.Error(err))
+			}
+		}
+	}()
+}
+
+// ReapExpiredLocks deletes all lock objects older than the lock expiration and returns how many
+// were deleted. Each lock is checked again right before its deletion, so a lock taken over in the
+// meantime survives.
+func (s *S3Storage) ReapExpiredLocks(ctx context.Context) (int, error) {
+	if err := s.checkWritable("reap", "locks"); err != nil {
+		return 0, err
+	}
+	if s.lockGC != nil {
+		s.lockGC.runs.Add(1)
+		s.lockGC.lastRun.Store(time.Now().UnixNano())
+	}
+	locks, err := s.ListLocks(ctx)
+	if err != nil {
+		s.countLockGC(0, 1)
+		return 0, err
+	}
+	var reaped, failed int
+	for _, li := range locks {
+		if !li.Expired {
+			continue
+		}
+		candidates := []string{li.S3Key}
+		if legacy := s.s3LegacyLockKey(li.Key); s.legacyLocks() && legacy != li.S3Key {
+			candidates = append(candidates, legacy) // Written along with the current lock
+		}
+		for _, lockS3Key := range candidates {
+			deleted, err := s.reapLock(ctx, lockS3Key, s.lockExpiration)
+			if err != nil {
+				s.recordError("lock_gc", li.Key, err)
+				failed++
+				continue
+			}
+			if deleted {
+				reaped++
+				s.logger.Debug("reaped stale lock", zap.String("key", li.Key), zap.String("s3_lock_key", lockS3Key))
+			}
+		}
+	}
+	s.countLockGC(reaped, failed)
+	if reaped > 0 {
+		s.logger.Info("reaped stale locks", zap.Int("reaped", reaped))
+	}
+	if failed > 0 {
+		return reaped, fmt.Errorf("deleting %d stale locks failed", failed)
+	}
+	return reaped, nil
+}
+
+// reapLock deletes a lock object if it is still at least minAge old.
+func (s *S3Storage) reapLock(ctx context.Context, lockS3Key string, minAge time.Duration) (bool, error) {
+	headCtx, cancel := s.readContext(ctx)
+	head, err := s.Client.HeadObject(headCtx, &awss3.HeadObjectInput{
+		Bucket: aws.String(s.lockBucket()),
+		Key:    aws.String(lockS3Key),
+	})
+	cancel()
+	if err != nil {
+		if s.isNotFound(err) {
+			return false, nil // Released in the meantime
+		}
+		return false, s3Error(err)
+	}
+	if head.LastModified == nil || time.Since(*head.LastModified) < minAge {
+		return false, nil
+	}
+	if err := s.deleteLock(ctx, lockS3Key); err != nil {
+		return false, s3Error(err)
+	}
+	return true, nil
+}
+
+func (s *S3Storage) countLockGC(reaped, failed int) {
+	if s.lockGC != nil {
+		s.lockGC.reaped.Add(uint64(reaped))
+		s.lockGC.errors.Add(uint64(failed))
+	}
+}
+
+// LockGCStats returns the statistics of the lock janitor, and false if lock_gc_interval is not set.
+func (s *S3Storage) LockGCStats() (LockGCStats, bool) {
+	if s.lockGC == nil {
+		return LockGCStats{}, false
+	}
+	stats := LockGCStats{
+		Runs:   s.lockGC.runs.Load(),
+		Reaped: s.lockGC.reaped.Load(),
+		Errors: s.lockGC.errors.Load(),
+	}
+	if last := s.lockGC.lastRun.Load(); last != 0 {
+		stats.LastRun = time.Unix(0, last)
+	}
+	return stats, true
+}
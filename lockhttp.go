@@ -0,0 +1,253 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// Supported lock backend types.
+const (
+	lockBackendS3   = "s3"
+	lockBackendHTTP = "http"
+)
+
+// LockBackendConfig selects where locks are kept. With type "http", Lock and Unlock are delegated
+// to an external, strongly consistent lock service (e.g. fronting etcd or Consul), while the data
+// stays in S3. The service implements this contract, with JSON request bodies:
+//
+//	POST <url>/acquire  {"key", "owner", "ttl_seconds"}  200 acquired, 409 held by another owner
+//	POST <url>/renew    {"key", "owner", "ttl_seconds"}  200 renewed, 409 no longer held
+//	POST <url>/release  {"key", "owner"}                 200 released, 404 or 409 not held
+//
+// Held locks are renewed every third of the TTL until they are released.
+type LockBackendConfig struct {
+	Type  string         `json:"type,omitempty"`  // "s3" (default) or "http"
+	URL   string         `json:"url,omitempty"`   // Base URL of the lock service
+	Token string         `json:"token,omitempty"` // Sent as bearer token, supports {env.*}
+	TTL   caddy.Duration `json:"ttl,omitempty"`   // Lease duration; defaults to the lock expiration
+}
+
+// lockRequest is the body of lock service requests.
+type lockRequest struct {
+	Key        string `json:"key"`
+	Owner      string `json:"owner"`
+	TTLSeconds int64  `json:"ttl_seconds,omitempty"`
+}
+
+// Errors returned by httpLocker.call for the 409 Conflict and 404 Not Found answers.
+var (
+	errLockHeld     = errors.New("lock held by another owner")
+	errLockNotFound = errors.New("lock not found")
+)
+
+// httpLocker is the client of an external lock service.
+type httpLocker struct {
+	url    string
+	token  string
+	owner  string
+	ttl    time.Duration
+	client *http.Client
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	renewals map[string]context.CancelFunc
+}
+
+// provisionLockBackend validates the lock backend configuration and creates the HTTP locker.
+func (s *S3Storage) provisionLockBackend() error {
+	switch s.LockBackend.Type {
+	case "", lockBackendS3:
+		return nil
+	case lockBackendHTTP:
+	default:
+		return fmt.Errorf("unsupported lock_backend '%s' (expected '%s' or '%s')", s.LockBackend.Type, lockBackendS3, lockBackendHTTP)
+	}
+	if s.LockBackend.URL == "" {
+		return errors.New("lock_backend http: url must be specified")
+	}
+	ttl := time.Duration(s.LockBackend.TTL)
+	if ttl <= 0 {
+		ttl = s.lockExpiration
+	}
+	if ttl < time.Second {
+		return fmt.Errorf("lock_backend http: ttl %s is too short, the lock service gets it in whole seconds", ttl)
+	}
+	hostname, _ := os.Hostname()
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	s.httpLock = &httpLocker{
+		url:      strings.TrimSuffix(s.LockBackend.URL, "/"),
+		token:    s.LockBackend.Token,
+		owner:    hostname + "-" + hex.EncodeToString(b[:]),
+		ttl:      ttl,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		logger:   s.logger,
+		renewals: make(map[string]context.CancelFunc),
+	}
+	s.logger.Info("delegating locks to lock service", zap.String("url", s.httpLock.url), zap.String("owner", s.httpLock.owner))
+	return nil
+}
+
+// call performs one lock service request.
+func (l *httpLocker) call(ctx context.Context, op, key string) error {
+	body, _ := json.Marshal(lockRequest{Key: key, Owner: l.owner, TTLSeconds: int64(l.ttl / time.Second)})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.url+"/"+op, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.token != "" {
+		req.Header.Set("Authorization", "Bearer "+l.token)
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("lock service %s: %w", op, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusConflict:
+		return errLockHeld
+	case http.StatusNotFound:
+		return errLockNotFound
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("lock service %s: %s: %s", op, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// lock acquires the lock, polling while another owner holds it, and starts renewing it.
+func (l *httpLocker) lock(ctx context.Context, key string, backoff lockBackoff, timeout time.Duration) error {
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		err := l.call(ctx, "acquire", key)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errLockHeld) {
+			return fmt.Errorf("acquiring lock for %s: %w", key, err)
+		}
+		if time.Since(start) > timeout {
+			return fmt.Errorf("timeout acquiring lock for %s (lock held by another process)", key)
+		}
+		if err := backoff.wait(ctx, attempt); err != nil {
+			return err
+		}
+	}
+	l.startRenewal(key)
+	return nil
+}
+
+// tryLock acquires the lock with a single request, and reports false if another owner holds it.
+func (l *httpLocker) tryLock(ctx context.Context, key string) (bool, error) {
+	err := l.call(ctx, "acquire", key)
+	if errors.Is(err, errLockHeld) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("acquiring lock for %s: %w", key, err)
+	}
+	l.startRenewal(key)
+	return true, nil
+}
+
+// startRenewal renews an acquired lock in the background until it is unlocked.
+func (l *httpLocker) startRenewal(key string) {
+	renewCtx, cancel := context.WithCancel(context.Background())
+	l.mu.Lock()
+	if previous, ok := l.renewals[key]; ok {
+		previous()
+	}
+	l.renewals[key] = cancel
+	l.mu.Unlock()
+	go l.renew(renewCtx, key)
+}
+
+// renew extends the lease of a held lock until ctx is cancelled or the lock is lost.
+func (l *httpLocker) renew(ctx context.Context, key string) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := l.call(ctx, "renew", key)
+		switch {
+		case err == nil || ctx.Err() != nil:
+		case errors.Is(err, errLockHeld):
+			l.logger.Error("lock lost, lock service refused renewal", zap.String("key", key))
+			return
+		default:
+			l.logger.Warn("renewing lock failed", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
+
+// unlock stops renewing the lock and releases it.
+func (l *httpLocker) unlock(ctx context.Context, key string) error {
+	l.mu.Lock()
+	if cancel, ok := l.renewals[key]; ok {
+		cancel()
+		delete(l.renewals, key)
+	}
+	l.mu.Unlock()
+	err := l.call(ctx, "release", key)
+	if err != nil && !errors.Is(err, errLockHeld) && !errors.Is(err, errLockNotFound) {
+		return fmt.Errorf("unlocking %s: %w", key, err)
+	}
+	return nil // Not held (anymore) is fine, e.g. after the lease expired
+}
+
+// unmarshalLockBackend parses the lock_backend directive:
+//
+//	lock_backend http {
+//		url <base-url>
+//		token <token>
+//		ttl <duration>
+//	}
+func (s *S3Storage) unmarshalLockBackend(d *caddyfile.Dispenser) error {
+	cfg := new(LockBackendConfig)
+	if !d.Args(&cfg.Type) {
+		return d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		key := d.Val()
+		var value string
+		if !d.AllArgs(&value) {
+			return d.ArgErr()
+		}
+		switch key {
+		case "url":
+			cfg.URL = value
+		case "token":
+			cfg.Token = value
+		case "ttl":
+			dur, err := caddy.ParseDuration(value)
+			if err != nil {
+				return d.Errf("invalid lock_backend ttl '%s': %v", value, err)
+			}
+			cfg.TTL = caddy.Duration(dur)
+		default:
+			return d.Errf("unrecognized lock_backend subdirective '%s'", key)
+		}
+	}
+	s.LockBackend = cfg
+	return nil
+}
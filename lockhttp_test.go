@@ -0,0 +1,114 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// fakeLockService implements the lock service contract in memory.
+type fakeLockService struct {
+	mu     sync.Mutex
+	owners map[string]string
+	renews int
+}
+
+func (f *fakeLockService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var req lockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	owner, held := f.owners[req.Key]
+	switch r.URL.Path {
+	case "/acquire":
+		if held && owner != req.Owner {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.owners[req.Key] = req.Owner
+	case "/renew":
+		if owner != req.Owner {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.renews++
+	case "/release":
+		if !held {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.owners, req.Key)
+	}
+}
+
+func TestHTTPLocker(t *testing.T) {
+	svc := &fakeLockService{owners: make(map[string]string)}
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+
+	newLocker := func(owner string) *httpLocker {
+		return &httpLocker{url: srv.URL, token: "secret", owner: owner, ttl: 30 * time.Millisecond,
+			client: srv.Client(), logger: zap.NewNop(), renewals: make(map[string]context.CancelFunc)}
+	}
+	a, b := newLocker("a"), newLocker("b")
+	poll := lockBackoff{base: time.Millisecond, max: time.Millisecond}
+	ctx := context.Background()
+
+	if err := a.lock(ctx, "issue_cert_example.com", poll, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.lock(ctx, "issue_cert_example.com", poll, 20*time.Millisecond); err == nil {
+		t.Fatal("second owner acquired a held lock")
+	}
+
+	time.Sleep(50 * time.Millisecond) // Let the lease be renewed at least once
+	if err := a.unlock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatal(err)
+	}
+	svc.mu.Lock()
+	renews := svc.renews
+	svc.mu.Unlock()
+	if renews == 0 {
+		t.Error("held lock was not renewed")
+	}
+
+	if err := b.lock(ctx, "issue_cert_example.com", poll, time.Second); err != nil {
+		t.Fatalf("lock not acquirable after release: %v", err)
+	}
+	if err := b.unlock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.unlock(ctx, "issue_cert_example.com"); err != nil {
+		t.Errorf("releasing an unheld lock failed: %v", err)
+	}
+}
+
+func TestProvisionLockBackendTTL(t *testing.T) {
+	for _, ttl := range []time.Duration{time.Nanosecond, 999 * time.Millisecond} {
+		s := &S3Storage{
+			LockBackend: &LockBackendConfig{Type: lockBackendHTTP, URL: "http://locks.internal", TTL: caddy.Duration(ttl)},
+			logger:      zap.NewNop(),
+		}
+		if err := s.provisionLockBackend(); err == nil {
+			t.Errorf("lock_backend ttl %s accepted", ttl)
+		}
+	}
+	s := &S3Storage{LockBackend: &LockBackendConfig{Type: lockBackendHTTP, URL: "http://locks.internal"}, logger: zap.NewNop(), lockExpiration: 2 * time.Minute}
+	if err := s.provisionLockBackend(); err != nil || s.httpLock.ttl != 2*time.Minute {
+		t.Errorf("default ttl = %v, %v; want the lock expiration", s.httpLock, err)
+	}
+}
@@ -0,0 +1,233 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// LockInfo describes a lock object found in the bucket.
+type LockInfo struct {
+	Key      string        `json:"key"`      // CertMagic key the lock belongs to
+	S3Key    string        `json:"s3_key"`   // Full key of the lock object
+	Modified time.Time     `json:"modified"` // Time the lock was (re)written
+	Age      time.Duration `json:"age"`
+	Expired  bool          `json:"expired"` // Expired locks are taken over by the next Lock call
+	Content  string        `json:"content"` // Raw lock file content
+	Owner    *LockOwner    `json:"owner,omitempty"`
+}
+
+// LockOwner is the content of the lock objects written by Lock, identifying the process holding
+// the lock. Locks of earlier versions only contain the acquisition time and have no owner.
+type LockOwner struct {
+	Hostname      string    `json:"hostname"`
+	PID           int       `json:"pid"`
+	InstanceID    string    `json:"instance_id,omitempty"` // Caddy instance ID
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	Acquired      time.Time `json:"acquired"`
+	Expires       time.Time `json:"expires"` // When other processes may take the lock over
+}
+
+// processIdentity is the LockOwner of this process, without the fields of a particular lock.
+var processIdentity = sync.OnceValue(func() LockOwner {
+	hostname, _ := os.Hostname()
+	owner := LockOwner{Hostname: hostname, PID: os.Getpid()}
+	if id, err := caddy.InstanceID(); err == nil {
+		owner.InstanceID = id.String()
+	}
+	return owner
+})
+
+// lockContent returns the content of a lock object acquired at the given time.
+func (s *S3Storage) lockContent(acquired time.Time, correlationID string) []byte {
+	owner := processIdentity()
+	owner.CorrelationID = correlationID
+	owner.Acquired = acquired.UTC()
+	owner.Expires = owner.Acquired.Add(s.lockExpiration)
+	content, _ := json.Marshal(owner) // Cannot fail
+	return content
+}
+
+// parseLockOwner returns the owner recorded in a lock object, or nil for locks of earlier versions.
+func parseLockOwner(content string) *LockOwner {
+	var owner LockOwner
+	if json.Unmarshal([]byte(content), &owner) != nil {
+		return nil
+	}
+	return &owner
+}
+
+// LockInfo returns the lock of a CertMagic key, or fs.ErrNotExist if it is not locked, e.g. to
+// find out which instance holds it.
+func (s *S3Storage) LockInfo(ctx context.Context, key string) (LockInfo, error) {
+	if s.httpLock != nil {
+		return LockInfo{}, errors.New("lock objects are not used with lock_backend")
+	}
+	s3Keys := []string{s.s3LockKey(key)}
+	if s.legacyLocks() {
+		s3Keys = append(s3Keys, s.s3LegacyLockKey(key))
+	}
+	for _, s3Key := range s3Keys {
+		li, err := s.readLock(ctx, key, s3Key)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		return li, err
+	}
+	return LockInfo{}, fs.ErrNotExist
+}
+
+// readLock reads the lock object at lockS3Key.
+func (s *S3Storage) readLock(ctx context.Context, key, lockS3Key string) (LockInfo, error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+	out, err := s.Client.GetObject(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(s.lockBucket()),
+		Key:    aws.String(lockS3Key),
+	})
+	if err != nil {
+		if s.isNotFound(err) {
+			return LockInfo{}, fs.ErrNotExist
+		}
+		return LockInfo{}, fmt.Errorf("reading lock of %s (s3://%s/%s): %w", key, s.lockBucket(), lockS3Key, s3Error(err))
+	}
+	defer out.Body.Close()
+	content, err := io.ReadAll(io.LimitReader(out.Body, 4096))
+	if err != nil {
+		return LockInfo{}, fmt.Errorf("reading lock of %s (s3://%s/%s): %w", key, s.lockBucket(), lockS3Key, err)
+	}
+	li := LockInfo{Key: key, S3Key: lockS3Key, Content: string(content), Owner: parseLockOwner(string(content))}
+	if out.LastModified != nil {
+		li.Modified = *out.LastModified
+		li.Age = time.Since(li.Modified)
+		li.Expired = li.Age >= s.lockExpiration
+	}
+	return li, nil
+}
+
+// ListLocks returns all lock objects below the storage prefix. Legacy locks are written at the
+// current location as well, so only the lock directory is listed.
+func (s *S3Storage) ListLocks(ctx context.Context) ([]LockInfo, error) {
+	s3Prefix := s.s3ObjectKey(s.lockNamespace()) + "/"
+
+	paginator := awss3.NewListObjectsV2Paginator(s.Client, &awss3.ListObjectsV2Input{
+		Bucket: aws.String(s.lockBucket()),
+		Prefix: aws.String(s3Prefix),
+	})
+
+	var locks []LockInfo
+	for paginator.HasMorePages() {
+		pageCtx, cancel := s.listContext(ctx)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("listing locks in s3://%s/%s: %w", s.lockBucket(), s3Prefix, s3Error(err))
+		}
+		for _, obj := range page.Contents {
+			if obj.Key == nil || !s.isLockKey(s.certMagicKey(*obj.Key)) {
+				continue
+			}
+			li := LockInfo{Key: s.lockedKey(s.certMagicKey(*obj.Key)), S3Key: *obj.Key}
+			if obj.LastModified != nil {
+				li.Modified = *obj.LastModified
+				li.Age = time.Since(li.Modified)
+				li.Expired = li.Age >= s.lockExpiration
+			}
+			locks = append(locks, li)
+		}
+	}
+
+	for i := range locks {
+		locks[i].Content, _ = s.readLockContent(ctx, locks[i].S3Key) // Best effort; the lock may be gone already
+		locks[i].Owner = parseLockOwner(locks[i].Content)
+	}
+	return locks, nil
+}
+
+// validateLockBucket checks that lock_bucket names a bucket the locks can be moved to.
+func (s *S3Storage) validateLockBucket() error {
+	switch {
+	case s.LockBucket == "":
+		return nil
+	case s.LockBucket == s.Bucket:
+		return errors.New("lock_bucket must differ from bucket")
+	case s.LockBackend != nil:
+		return errors.New("lock_bucket cannot be combined with lock_backend")
+	case isMultiRegionAccessPoint(s.Bucket) || isMultiRegionAccessPoint(s.LockBucket):
+		return errors.New("lock_bucket is not supported with multi-region access points")
+	}
+	s.logger.Info("keeping locks in separate bucket", zap.String("lock_bucket", s.LockBucket))
+	return nil
+}
+
+// lockedKey returns the CertMagic key a lock object (given by its CertMagic-relative key) belongs to.
+func (s *S3Storage) lockedKey(lockKey string) string {
+	return strings.TrimPrefix(lockKey, s.lockNamespace()+"/")
+}
+
+// readLockContent returns the content of a lock object.
+func (s *S3Storage) readLockContent(ctx context.Context, lockS3Key string) (string, error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+	out, err := s.Client.GetObject(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(s.lockBucket()),
+		Key:    aws.String(lockS3Key),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer out.Body.Close()
+	content, err := io.ReadAll(io.LimitReader(out.Body, 4096))
+	return string(content), err
+}
+
+// instances tracks the provisioned storages, so that admin endpoints can reach them.
+var instances = struct {
+	sync.Mutex
+	m map[*S3Storage]struct{}
+}{m: make(map[*S3Storage]struct{})}
+
+func registerInstance(s *S3Storage) {
+	instances.Lock()
+	defer instances.Unlock()
+	instances.m[s] = struct{}{}
+}
+
+func unregisterInstance(s *S3Storage) {
+	instances.Lock()
+	defer instances.Unlock()
+	delete(instances.m, s)
+}
+
+// activeInstances returns the provisioned storages, de-duplicated by bucket and prefix
+// (Caddy may provision the same storage config several times).
+func activeInstances() []*S3Storage {
+	instances.Lock()
+	defer instances.Unlock()
+	seen := make(map[string]bool)
+	var list []*S3Storage
+	for s := range instances.m {
+		if id := s.instanceID(); !seen[id] {
+			seen[id] = true
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// instanceID identifies the storage location of an instance as bucket/prefix.
+func (s *S3Storage) instanceID() string {
+	return s.Bucket + "/" + s.Prefix
+}
@@ -0,0 +1,116 @@
+package s3
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultLockContentionWarnAfter is how long Lock waits for a held lock before warning by default.
+const defaultLockContentionWarnAfter = 10 * time.Second
+
+// lockWaitBuckets are the upper bounds of the lock wait histogram; the last bucket is +Inf.
+var lockWaitBuckets = []time.Duration{100 * time.Millisecond, time.Second, 5 * time.Second, 10 * time.Second,
+	30 * time.Second, time.Minute}
+
+// LockStats reports how the Lock calls of a storage competed with other processes for locks.
+type LockStats struct {
+	Acquired  uint64 `json:"acquired"`
+	Contended uint64 `json:"contended"` // Lock calls which found the lock held and had to wait
+	Takeovers uint64 `json:"takeovers"` // Expired locks of other processes overwritten
+	Timeouts  uint64 `json:"timeouts"`  // Lock calls giving up after the lock timeout
+
+	// Wait is the cumulative histogram of how long acquired locks were waited for
+	Wait             []LockWaitBucket `json:"wait"`
+	TotalWaitSeconds float64          `json:"total_wait_seconds"`
+	MaxWaitSeconds   float64          `json:"max_wait_seconds"`
+}
+
+// LockWaitBucket counts the acquired locks waited for at most LE seconds ("+Inf" for all).
+type LockWaitBucket struct {
+	LE    string `json:"le"`
+	Count uint64 `json:"count"`
+}
+
+// lockContention counts the outcomes of Lock calls.
+type lockContention struct {
+	acquired, contended, takeovers, timeouts atomic.Uint64
+	buckets                                  []atomic.Uint64 // Per bucket, not cumulative
+	totalWait                                atomic.Int64    // Nanoseconds
+	maxWait                                  atomic.Int64    // Nanoseconds
+
+	mu     sync.Mutex
+	warned map[string]bool // Keys warned about since this instance last acquired them
+}
+
+func newLockContention() *lockContention {
+	return &lockContention{
+		buckets: make([]atomic.Uint64, len(lockWaitBuckets)+1),
+		warned:  make(map[string]bool),
+	}
+}
+
+// acquire records an acquired lock and how long it was waited for, and re-arms the warning of
+// the key.
+func (c *lockContention) acquire(key string, wait time.Duration) {
+	c.acquired.Add(1)
+	i := 0
+	for i < len(lockWaitBuckets) && wait > lockWaitBuckets[i] {
+		i++
+	}
+	c.buckets[i].Add(1)
+	c.totalWait.Add(int64(wait))
+	for {
+		cur := c.maxWait.Load()
+		if int64(wait) <= cur || c.maxWait.CompareAndSwap(cur, int64(wait)) {
+			break
+		}
+	}
+	c.mu.Lock()
+	delete(c.warned, key)
+	c.mu.Unlock()
+}
+
+// warnOnce reports whether a contention warning for the key is due, which is once until this
+// instance acquires the lock.
+func (c *lockContention) warnOnce(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.warned[key] {
+		return false
+	}
+	c.warned[key] = true
+	return true
+}
+
+// snapshot returns the current statistics.
+func (c *lockContention) snapshot() LockStats {
+	stats := LockStats{
+		Acquired:         c.acquired.Load(),
+		Contended:        c.contended.Load(),
+		Takeovers:        c.takeovers.Load(),
+		Timeouts:         c.timeouts.Load(),
+		Wait:             make([]LockWaitBucket, len(c.buckets)),
+		TotalWaitSeconds: time.Duration(c.totalWait.Load()).Seconds(),
+		MaxWaitSeconds:   time.Duration(c.maxWait.Load()).Seconds(),
+	}
+	var cumulative uint64
+	for i := range c.buckets {
+		cumulative += c.buckets[i].Load()
+		le := "+Inf"
+		if i < len(lockWaitBuckets) {
+			le = strconv.FormatFloat(lockWaitBuckets[i].Seconds(), 'f', -1, 64)
+		}
+		stats.Wait[i] = LockWaitBucket{LE: le, Count: cumulative}
+	}
+	return stats
+}
+
+// LockStats returns the lock contention statistics since the storage was provisioned.
+func (s *S3Storage) LockStats() LockStats {
+	if s.lockStats == nil {
+		return newLockContention().snapshot()
+	}
+	return s.lockStats.snapshot()
+}
@@ -0,0 +1,15 @@
+package s3
+
+import "time"
+
+// SetLockTimings replaces how long locks are held at most and waited for, for the tests of
+// package s3_test.
+func (s *S3Storage) SetLockTimings(expiration, timeout time.Duration) {
+	s.lockExpiration, s.lockTimeout = expiration, timeout
+}
+
+// ContentionWarningDue reports whether a contention warning for the key is due, and marks it as
+// given.
+func (s *S3Storage) ContentionWarningDue(key string) bool {
+	return s.lockStats.warnOnce(key)
+}
@@ -0,0 +1,75 @@
+package s3_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	s3 "github.com/cvhome-saas/certmagic-s3"
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+func TestLockStats(t *testing.T) {
+	s, _ := s3test.NewFakeStorage(t, func(s *s3.S3Storage) { s.LockContentionWarnAfter = caddy.Duration(time.Nanosecond) })
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	s.OnEvent(func(name string, _ map[string]any) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, name)
+	})
+	ctx := context.Background()
+	key := "issue_cert_example.com"
+
+	if err := s.Lock(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	if err := s.Lock(waitCtx, key); err == nil {
+		t.Fatal("expected second lock to wait until the context is done")
+	}
+	if s.ContentionWarningDue(key) {
+		t.Error("contended key was not warned about")
+	}
+
+	s.SetLockTimings(2*time.Minute, 0)
+	if err := s.Lock(ctx, key); err == nil {
+		t.Fatal("expected lock to time out")
+	}
+
+	s.SetLockTimings(0, 30*time.Second) // The lock held above has expired
+	if err := s.Lock(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if !s.ContentionWarningDue(key) {
+		t.Error("acquiring the lock did not re-arm the warning")
+	}
+
+	stats := s.LockStats()
+	if stats.Acquired != 2 || stats.Contended != 2 || stats.Takeovers != 1 || stats.Timeouts != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if last := stats.Wait[len(stats.Wait)-1]; last.LE != "+Inf" || last.Count != 2 {
+		t.Errorf("+Inf bucket = %+v, want 2 acquired locks", last)
+	}
+	if first := stats.Wait[0]; first.LE != "0.1" || first.Count != 2 {
+		t.Errorf("first bucket = %+v, want both locks acquired without waiting", first)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"s3_storage.lock_contention", "s3_storage.lock_contention", "s3_storage.lock_timeout", "s3_storage.lock_takeover"}
+	if len(events) != len(want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+	for i, name := range want {
+		if events[i] != name {
+			t.Errorf("event %d = %s, want %s", i, events[i], name)
+		}
+	}
+}
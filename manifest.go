@@ -0,0 +1,389 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.uber.org/zap"
+)
+
+// With the manifest option, a compact index of all keys is kept in one object below the prefix,
+// so List needs a single (usually conditional) GET instead of paging through the whole bucket.
+// Writers update it with If-Match on its ETag and retry on conflicts. If the manifest is missing,
+// or could not be updated after a write, it is rebuilt from a full listing.
+//
+// Updates are applied in the background, so Store and Delete don't wait for them: changes made
+// while an update is in flight are batched into the next one, a single GET and PUT however many
+// writes happened meanwhile. List applies the changes still pending on top of the manifest.
+
+const (
+	manifestKey           = ".manifest"
+	manifestMagic         = "CMS3MAN1"
+	manifestUpdateRetries = 5
+)
+
+// manifestEntry describes one stored object.
+type manifestEntry struct {
+	Size     int64
+	Modified time.Time
+	ETag     string
+}
+
+// manifestCache holds the last manifest read and its ETag, and the changes not written yet.
+type manifestCache struct {
+	mu      sync.Mutex // Held while reading or writing the manifest
+	etag    string
+	entries map[string]manifestEntry
+
+	pendingMu sync.Mutex
+	pending   []manifestChange
+	flushing  bool           // A goroutine is writing the pending changes
+	flushes   sync.WaitGroup // Done when the flushing goroutine returns
+}
+
+// manifestChange is a change to apply to the manifest for a written key.
+type manifestChange struct {
+	key   string
+	apply func(entries map[string]manifestEntry)
+}
+
+// takePending returns the pending changes and clears them, or ends the flush if there are none.
+func (m *manifestCache) takePending() []manifestChange {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	changes := m.pending
+	m.pending = nil
+	if len(changes) == 0 {
+		m.flushing = false
+	}
+	return changes
+}
+
+// pendingChanges returns the changes not taken by a flush yet.
+func (m *manifestCache) pendingChanges() []manifestChange {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	return slices.Clone(m.pending)
+}
+
+// encodeManifest serializes the entries sorted by key, with each key sharing its common
+// prefix with the previous one (front coding) and all numbers as varints.
+func encodeManifest(entries map[string]manifestEntry) []byte {
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := bytes.NewBufferString(manifestMagic)
+	var tmp [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) { buf.Write(tmp[:binary.PutUvarint(tmp[:], v)]) }
+	putString := func(s string) { putUvarint(uint64(len(s))); buf.WriteString(s) }
+
+	putUvarint(uint64(len(keys)))
+	prev := ""
+	for _, k := range keys {
+		shared := 0
+		for shared < len(prev) && shared < len(k) && prev[shared] == k[shared] {
+			shared++
+		}
+		e := entries[k]
+		putUvarint(uint64(shared))
+		putString(k[shared:])
+		putUvarint(uint64(e.Size))
+		buf.Write(tmp[:binary.PutVarint(tmp[:], e.Modified.Unix())])
+		putString(e.ETag)
+		prev = k
+	}
+	return buf.Bytes()
+}
+
+// decodeManifest parses the output of encodeManifest.
+func decodeManifest(data []byte) (map[string]manifestEntry, error) {
+	if !bytes.HasPrefix(data, []byte(manifestMagic)) {
+		return nil, errors.New("not a manifest")
+	}
+	r := bytes.NewReader(data[len(manifestMagic):])
+	readString := func() (string, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return "", err
+		}
+		if n > uint64(r.Len()) {
+			return "", io.ErrUnexpectedEOF
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		return string(b), err
+	}
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt manifest: %w", err)
+	}
+	entries := make(map[string]manifestEntry, min(count, uint64(r.Len())))
+	prev := ""
+	for i := uint64(0); i < count; i++ {
+		shared, err := binary.ReadUvarint(r)
+		if err != nil || shared > uint64(len(prev)) {
+			return nil, fmt.Errorf("corrupt manifest entry %d", i)
+		}
+		suffix, err := readString()
+		if err != nil {
+			return nil, fmt.Errorf("corrupt manifest entry %d: %w", i, err)
+		}
+		var e manifestEntry
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt manifest entry %d: %w", i, err)
+		}
+		modified, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt manifest entry %d: %w", i, err)
+		}
+		if e.ETag, err = readString(); err != nil {
+			return nil, fmt.Errorf("corrupt manifest entry %d: %w", i, err)
+		}
+		e.Size, e.Modified = int64(size), time.Unix(modified, 0).UTC()
+		key := prev[:shared] + suffix
+		entries[key] = e
+		prev = key
+	}
+	return entries, nil
+}
+
+// manifestEntries returns the current manifest, re-downloading it only if it changed since the
+// last read. The caller must hold s.manifest.mu.
+func (s *S3Storage) manifestEntries(ctx context.Context) (map[string]manifestEntry, error) {
+	s3Key := s.s3ObjectKey(manifestKey)
+	getCtx, cancel := s.readContext(ctx)
+	defer cancel()
+	input := &awss3.GetObjectInput{Bucket: aws.String(s.Bucket), Key: aws.String(s3Key)}
+	if s.manifest.etag != "" {
+		input.IfNoneMatch = aws.String(s.manifest.etag)
+	}
+	out, err := s.Client.GetObject(getCtx, input)
+	if err != nil {
+		var respErr *awshttp.ResponseError
+		if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotModified {
+			return s.manifest.entries, nil
+		}
+		if s.isNotFound(err) {
+			return s.rebuildManifest(ctx)
+		}
+		return nil, fmt.Errorf("reading manifest: %w", s3Error(err))
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	entries, err := decodeManifest(data)
+	if err != nil {
+		s.logger.Warn("rebuilding unreadable manifest", zap.Error(err))
+		return s.rebuildManifest(ctx)
+	}
+	s.manifest.etag, s.manifest.entries = aws.ToString(out.ETag), entries
+	return entries, nil
+}
+
+// rebuildManifest lists all objects and writes a new manifest. The caller must hold s.manifest.mu.
+func (s *S3Storage) rebuildManifest(ctx context.Context) (map[string]manifestEntry, error) {
+	entries := make(map[string]manifestEntry)
+	err := s.walkObjects(ctx, func(obj types.Object) error {
+		entries[s.certMagicKey(aws.ToString(obj.Key))] = manifestEntry{
+			Size:     aws.ToInt64(obj.Size),
+			Modified: aws.ToTime(obj.LastModified),
+			ETag:     strings.Trim(aws.ToString(obj.ETag), `"`),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.manifest.etag = ""
+	if err := s.putManifest(ctx, entries); err != nil {
+		s.logger.Warn("storing rebuilt manifest failed", zap.Error(err))
+	}
+	s.logger.Info("manifest rebuilt", zap.Int("keys", len(entries)))
+	return entries, nil
+}
+
+// putManifest writes the entries, conditional on the manifest not having changed since it was
+// last read, and caches them on success. The caller must hold s.manifest.mu.
+func (s *S3Storage) putManifest(ctx context.Context, entries map[string]manifestEntry) error {
+	body := encodeManifest(entries)
+	input := &awss3.PutObjectInput{
+		Bucket:        aws.String(s.Bucket),
+		Key:           aws.String(s.s3ObjectKey(manifestKey)),
+		Body:          bytes.NewReader(body),
+		ContentLength: aws.Int64(int64(len(body))),
+		ContentType:   s.contentType(),
+		Tagging:       s.objectTagging(),
+		Metadata:      s.objectMetadata(nil),
+	}
+	if s.manifest.etag != "" {
+		input.IfMatch = aws.String(s.manifest.etag)
+	} else {
+		input.IfNoneMatch = aws.String("*")
+	}
+	putCtx, cancel := s.opContext(ctx)
+	defer cancel()
+	out, err := s.Client.PutObject(putCtx, input)
+	if err != nil {
+		s.manifest.etag, s.manifest.entries = "", nil // Re-read on next use
+		return err
+	}
+	s.manifest.etag, s.manifest.entries = aws.ToString(out.ETag), entries
+	return nil
+}
+
+// updateManifest queues a change to the manifest and starts writing the queue in the background
+// unless that is already happening.
+func (s *S3Storage) updateManifest(ctx context.Context, key string, change func(entries map[string]manifestEntry)) {
+	if s.manifest == nil {
+		return
+	}
+	m := s.manifest
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	m.pending = append(m.pending, manifestChange{key: key, apply: change})
+	if m.flushing {
+		return
+	}
+	m.flushing = true
+	m.flushes.Add(1)
+	go func() {
+		defer m.flushes.Done()
+		s.flushManifest(context.WithoutCancel(ctx))
+	}()
+}
+
+// FlushManifest waits until the manifest includes every Store and Delete made so far.
+func (s *S3Storage) FlushManifest() {
+	if s.manifest != nil {
+		s.manifest.flushes.Wait()
+	}
+}
+
+// flushManifest writes the pending changes, batch by batch, until there are none left.
+func (s *S3Storage) flushManifest(ctx context.Context) {
+	for {
+		s.manifest.mu.Lock() // Before taking the changes, so List doesn't miss them in between
+		changes := s.manifest.takePending()
+		if len(changes) == 0 {
+			s.manifest.mu.Unlock()
+			return
+		}
+		s.applyManifestChanges(ctx, changes)
+		s.manifest.mu.Unlock()
+	}
+}
+
+// applyManifestChanges applies changes to the manifest, retrying when another writer updated it
+// concurrently. If that keeps failing, the manifest is deleted so it gets rebuilt on next use
+// rather than serving a stale listing. The caller must hold s.manifest.mu.
+func (s *S3Storage) applyManifestChanges(ctx context.Context, changes []manifestChange) {
+	var err error
+	for attempt := 0; attempt < manifestUpdateRetries; attempt++ {
+		var entries map[string]manifestEntry
+		if entries, err = s.manifestEntries(ctx); err != nil {
+			break
+		}
+		for _, c := range changes {
+			c.apply(entries)
+		}
+		if err = s.putManifest(ctx, entries); err == nil || !isPreconditionFailed(err) {
+			break
+		}
+	}
+	if err == nil {
+		return
+	}
+	key := changes[0].key
+	s.recordError("manifest_update", key, err)
+	delCtx, cancel := s.opContext(ctx)
+	defer cancel()
+	if _, delErr := s.Client.DeleteObject(delCtx, &awss3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.s3ObjectKey(manifestKey)),
+	}); delErr != nil {
+		s.recordError("manifest_update", key, delErr)
+	}
+}
+
+// manifestStore records a written object in the manifest.
+func (s *S3Storage) manifestStore(ctx context.Context, key string, size int64, etag *string) {
+	s.updateManifest(ctx, key, func(entries map[string]manifestEntry) {
+		entries[key] = manifestEntry{Size: size, Modified: time.Now().UTC(), ETag: strings.Trim(aws.ToString(etag), `"`)}
+	})
+}
+
+// manifestDelete removes a key and everything below it from the manifest.
+func (s *S3Storage) manifestDelete(ctx context.Context, key string) {
+	s.updateManifest(ctx, key, func(entries map[string]manifestEntry) {
+		for k := range entries {
+			if k == key || strings.HasPrefix(k, key+"/") {
+				delete(entries, k)
+			}
+		}
+	})
+}
+
+// listFromManifest implements List using the manifest.
+func (s *S3Storage) listFromManifest(ctx context.Context, listPrefix string, recursive bool) ([]string, error) {
+	s.manifest.mu.Lock()
+	entries, err := s.manifestEntries(ctx)
+	if pending := s.manifest.pendingChanges(); err == nil && len(pending) > 0 {
+		entries = maps.Clone(entries)
+		if entries == nil {
+			entries = make(map[string]manifestEntry)
+		}
+		for _, c := range pending {
+			c.apply(entries)
+		}
+	}
+	var keys []string
+	seen := make(map[string]bool)
+	dirPrefix := strings.TrimSuffix(listPrefix, "/") + "/"
+	if listPrefix == "" {
+		dirPrefix = ""
+	}
+	for k := range entries {
+		rest, ok := strings.CutPrefix(k, dirPrefix)
+		if !ok || rest == "" || s.isHiddenKey(k) {
+			continue
+		}
+		if !recursive {
+			if i := strings.Index(rest, "/"); i >= 0 {
+				k = dirPrefix + rest[:i] // Only the immediate child "directory"
+			}
+		}
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	s.manifest.mu.Unlock()
+	if err != nil {
+		s.recordError("list", listPrefix, err)
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
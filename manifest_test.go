@@ -0,0 +1,28 @@
+package s3
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestManifestEncoding(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	entries := map[string]manifestEntry{
+		"certificates/acme/example.com/example.com.crt":  {Size: 1234, Modified: modified, ETag: "abc"},
+		"certificates/acme/example.com/example.com.key":  {Size: 227, Modified: modified, ETag: "def"},
+		"certificates/acme/example.com/example.com.json": {Size: 0, Modified: modified},
+		"ocsp/example.com-1234":                          {Size: 99, Modified: modified.Add(time.Hour), ETag: "0123"},
+	}
+	data := encodeManifest(entries)
+	decoded, err := decodeManifest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, entries) {
+		t.Errorf("round trip changed entries: %+v", decoded)
+	}
+	if _, err := decodeManifest(data[:len(data)-2]); err == nil {
+		t.Error("expected truncated manifest to be rejected")
+	}
+}
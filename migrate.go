@@ -0,0 +1,142 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// MigrateStats summarizes a MigratePrefix run.
+type MigrateStats struct {
+	Copied  int // Objects copied to the new prefix
+	Deleted int // Copied objects removed from the old prefix
+	Skipped int // Lock objects and the manifest, which are not carried over
+}
+
+// MigratePrefix moves every object below the S3 key prefix from to the same relative key below
+// to, with server-side copies (CopyObject), so that the storage can be re-prefixed without
+// downloading and uploading it. Either prefix may be empty for the bucket root. The old objects
+// are deleted after they were copied, unless keepSource is set; an interrupted run can simply be
+// repeated. Locks and the manifest are left behind, as they only describe the old prefix.
+func (s *S3Storage) MigratePrefix(ctx context.Context, from, to string, keepSource, dryRun bool) (MigrateStats, error) {
+	var stats MigrateStats
+	from, to = strings.Trim(from, "/"), strings.Trim(to, "/")
+	if from == to {
+		return stats, errors.New("migrate prefix: source and destination are the same")
+	}
+	if !dryRun {
+		if err := s.checkWritable("migrate", to); err != nil {
+			return stats, err
+		}
+	}
+	listPrefix := from
+	if listPrefix != "" {
+		listPrefix += "/"
+	}
+
+	paginator := awss3.NewListObjectsV2Paginator(s.Client, &awss3.ListObjectsV2Input{
+		Bucket:  aws.String(s.Bucket),
+		Prefix:  aws.String(listPrefix),
+		MaxKeys: aws.Int32(deleteBatchSize), // One page fills at most one batch
+	})
+	for paginator.HasMorePages() {
+		pageCtx, cancel := s.listContext(ctx)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			return stats, fmt.Errorf("listing s3://%s/%s: %w", s.Bucket, listPrefix, s3Error(err))
+		}
+
+		var copied []types.ObjectIdentifier
+		for _, obj := range page.Contents {
+			srcKey := aws.ToString(obj.Key)
+			if to != "" && (srcKey == to || keyBelow(srcKey, to)) {
+				continue // Already migrated, when the destination is below the source
+			}
+			rel := strings.TrimPrefix(srcKey, listPrefix)
+			if s.isLockKey(rel) || rel == manifestKey {
+				stats.Skipped++
+				continue
+			}
+			dstKey := path.Join(to, rel)
+			if dryRun {
+				s.logger.Info("would copy object", zap.String("s3_key", srcKey), zap.String("to", dstKey))
+				stats.Copied++
+				continue
+			}
+			if _, err := s.copyObject(ctx, srcKey, dstKey); err != nil {
+				return stats, fmt.Errorf("copying s3://%s/%s to %s: %w", s.Bucket, srcKey, dstKey, s3Error(err))
+			}
+			stats.Copied++
+			copied = append(copied, types.ObjectIdentifier{Key: obj.Key})
+		}
+		if keepSource || len(copied) == 0 {
+			continue
+		}
+		n, err := s.deleteBatch(ctx, copied)
+		stats.Deleted += n
+		if err != nil {
+			return stats, err
+		}
+	}
+	s.logger.Info("migrated prefix", zap.String("from", from), zap.String("to", to),
+		zap.Int("copied", stats.Copied), zap.Int("deleted", stats.Deleted), zap.Bool("dry_run", dryRun))
+	return stats, nil
+}
+
+func migratePrefixCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate-prefix --config <path> --from <prefix> [--to <prefix>] [--keep-source] [--dry-run]",
+		Short: "Moves all objects from one key prefix of the bucket to another",
+		Long: `
+Moves every object below the --from prefix of the configured bucket to the
+same relative key below --to, which defaults to the prefix of the config.
+S3 copies the objects server-side, so nothing is downloaded or re-encrypted;
+the encryption of the config has to match that of the objects to read them
+afterwards. The old objects are deleted once copied, unless --keep-source is
+given. Lock objects and the manifest are left behind.
+
+Use an empty --from ("") to move objects from the bucket root. An interrupted
+run can simply be repeated.
+`,
+		RunE: caddycmd.WrapCommandFuncForCobra(cmdMigratePrefix),
+	}
+	addConfigFlags(cmd)
+	cmd.Flags().String("from", "", "S3 key prefix to move the objects from (required, may be empty)")
+	cmd.Flags().String("to", "", "S3 key prefix to move the objects to (default: the configured prefix)")
+	cmd.Flags().Bool("keep-source", false, "Copy only, leaving the old objects in place")
+	cmd.Flags().Bool("dry-run", false, "Only report what would be moved")
+	return cmd
+}
+
+func cmdMigratePrefix(fl caddycmd.Flags) (int, error) {
+	if !fl.Changed("from") {
+		return caddy.ExitCodeFailedStartup, errors.New("--from is required")
+	}
+	s, cancel, err := loadStorageFromConfig(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	defer cancel()
+
+	to := s.Prefix
+	if fl.Changed("to") {
+		to = fl.String("to")
+	}
+	stats, err := s.MigratePrefix(context.Background(), fl.String("from"), to, fl.Bool("keep-source"), fl.Bool("dry-run"))
+	fmt.Printf("copied %d, deleted %d, skipped %d\n", stats.Copied, stats.Deleted, stats.Skipped)
+	if err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	return caddy.ExitCodeSuccess, nil
+}
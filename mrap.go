@@ -0,0 +1,69 @@
+package s3
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// The bucket may be the ARN of an S3 Multi-Region Access Point
+// (arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap), which routes every request to the
+// closest of its buckets. The SDK then sends requests to
+// <alias>.mrap.accesspoint.s3-global.amazonaws.com, signed with SigV4A for all regions ("*").
+// Access points only exist on AWS and are addressed by host name, so neither a custom endpoint nor
+// path-style addressing can be used; region only picks the partition and the STS endpoint.
+
+// mrapDefaultRegion is used for the client config if no region is set.
+const mrapDefaultRegion = "us-east-1"
+
+// isMultiRegionAccessPoint reports whether bucket is the ARN of a Multi-Region Access Point.
+func isMultiRegionAccessPoint(bucket string) bool {
+	if !arn.IsARN(bucket) {
+		return false
+	}
+	a, err := arn.Parse(bucket)
+	return err == nil && a.Service == "s3" && a.Region == "" && strings.HasPrefix(a.Resource, "accesspoint/")
+}
+
+// provisionMultiRegionAccessPoint validates the options for a Multi-Region Access Point bucket.
+func (s *S3Storage) provisionMultiRegionAccessPoint() error {
+	if s.Endpoint != "" || s.isGCS() {
+		return errors.New("a multi-region access point can only be used with AWS S3, not with a custom endpoint")
+	}
+	if len(s.FallbackRegions) > 0 {
+		return errors.New("fallback_regions cannot be used with a multi-region access point, which fails over by itself")
+	}
+	if s.Region == "" {
+		s.Region = mrapDefaultRegion
+	}
+	s.logger.Info("using multi-region access point with SigV4A signing", zap.String("arn", s.Bucket))
+	return nil
+}
+
+// mrapClientOptions makes sure the SDK resolves Multi-Region Access Point ARNs, which it signs
+// with SigV4A.
+func (s *S3Storage) mrapClientOptions(o *awss3.Options) {
+	if isMultiRegionAccessPoint(s.Bucket) {
+		o.DisableMultiRegionAccessPoints = false
+		o.UsePathStyle = false
+	}
+}
+
+// bucketARN returns the ARN of the bucket for IAM policies; a Multi-Region Access Point is its own.
+func (s *S3Storage) bucketARN() string {
+	if isMultiRegionAccessPoint(s.Bucket) {
+		return s.Bucket
+	}
+	return "arn:aws:s3:::" + s.Bucket
+}
+
+// objectARN returns the ARN of the object s3Key for IAM policies.
+func (s *S3Storage) objectARN(s3Key string) string {
+	if isMultiRegionAccessPoint(s.Bucket) {
+		return s.Bucket + "/object/" + s3Key
+	}
+	return s.bucketARN() + "/" + s3Key
+}
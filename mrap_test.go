@@ -0,0 +1,73 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+type recordingTransport struct{ req *http.Request }
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.req = req
+	if req.Body != nil {
+		_, _ = io.Copy(io.Discard, req.Body)
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+func TestMultiRegionAccessPoint(t *testing.T) {
+	const mrap = "arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap"
+	for bucket, want := range map[string]bool{
+		mrap:        true,
+		"my-bucket": false,
+		"arn:aws:s3:eu-central-1:123456789012:accesspoint/regional": false,
+	} {
+		if got := isMultiRegionAccessPoint(bucket); got != want {
+			t.Errorf("isMultiRegionAccessPoint(%s) = %v, want %v", bucket, got, want)
+		}
+	}
+
+	s := &S3Storage{Bucket: mrap, logger: zap.NewNop()}
+	if err := s.provisionMultiRegionAccessPoint(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Region != mrapDefaultRegion {
+		t.Errorf("region = %q, want %q", s.Region, mrapDefaultRegion)
+	}
+	if got := s.objectARN("certmagic/key"); got != mrap+"/object/certmagic/key" {
+		t.Errorf("object ARN = %s", got)
+	}
+	if err := (&S3Storage{Bucket: mrap, Endpoint: "https://minio.example.com", logger: zap.NewNop()}).provisionMultiRegionAccessPoint(); err == nil {
+		t.Error("expected a custom endpoint to be rejected")
+	}
+
+	client, err := s.newClient(s.Region, "", "key", "secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := &recordingTransport{}
+	_, err = client.PutObject(context.Background(), &awss3.PutObjectInput{
+		Bucket: aws.String(mrap),
+		Key:    aws.String("certmagic/key"),
+		Body:   strings.NewReader("value"),
+	}, func(o *awss3.Options) { o.HTTPClient = &http.Client{Transport: rt} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host := rt.req.URL.Host; host != "mfzwi23gnjvgw.mrap.accesspoint.s3-global.amazonaws.com" {
+		t.Errorf("host = %s", host)
+	}
+	if auth := rt.req.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-ECDSA-P256-SHA256 ") {
+		t.Errorf("request not signed with SigV4A: %s", auth)
+	}
+	if set := rt.req.Header.Get("X-Amz-Region-Set"); set != "*" {
+		t.Errorf("region set = %q, want *", set)
+	}
+}
@@ -0,0 +1,54 @@
+package s3
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Encrypted objects start with a header naming their format, so that readers can tell a format
+// they don't know (written by a newer version or another encryption configuration) from
+// corruption, and future ciphers can be introduced next to the current ones:
+//
+//	objectHeaderMagic (5 bytes) | cipher ID (2 ASCII letters) | format version (1 ASCII digit)
+//
+// Cipher IDs are SB (NaCl secretbox), AG (AES-256-GCM), VT (vault_transit envelope) and PP
+// (encryption_passphrase envelope). For SB and AG, version 1 is the single-box format and version
+// 2 the chunked format of iochunked.go. Objects written by SecretBoxIO before headers were
+// introduced start directly with their nonce; they are still read, as a random nonce starts with a
+// valid header only with negligible probability (below 2^-50).
+const (
+	objectHeaderMagic = "\x00CMS3"
+	objectHeaderSize  = len(objectHeaderMagic) + 3
+
+	secretBoxMagic = "\x00CMS3SB1" // secretbox, single box: magic | nonce (24 bytes) | sealed data
+)
+
+// parseObjectHeader returns the cipher ID and format version of data, if it starts with a header.
+func parseObjectHeader(data []byte) (cipherID string, version byte, ok bool) {
+	if len(data) < objectHeaderSize || !strings.HasPrefix(string(data), objectHeaderMagic) {
+		return "", 0, false
+	}
+	id := data[len(objectHeaderMagic) : len(objectHeaderMagic)+2]
+	version = data[objectHeaderSize-1]
+	for _, c := range id {
+		if c < 'A' || c > 'Z' {
+			return "", 0, false
+		}
+	}
+	if version < '0' || version > '9' {
+		return "", 0, false
+	}
+	return string(id), version, true
+}
+
+// hasObjectHeader reports whether data starts with a format header.
+func hasObjectHeader(data []byte) bool {
+	_, _, ok := parseObjectHeader(data)
+	return ok
+}
+
+// unsupportedFormatError describes an object whose header names a format the IO can't read.
+func unsupportedFormatError(cipherID string, version byte) error {
+	return fmt.Errorf("unsupported object format (cipher %s, version %c): written by a newer version "+
+		"of this module or with another encryption configuration", cipherID, version)
+}
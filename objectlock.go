@@ -0,0 +1,211 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// Buckets with S3 Object Lock keep object versions for a retention period or while a legal hold
+// is set. With object_lock, every value written gets that retention and legal hold, set by a
+// client middleware on the writing requests like SSE-C. Lock objects, probes and other internal
+// objects never get them, as they must stay deletable; if the bucket itself applies a default
+// retention, keep the locks in a lock_bucket without Object Lock.
+//
+// Deleting a value the bucket refuses to delete (AccessDenied, e.g. a bucket policy protecting
+// retained objects) fails the Delete by default. With on_delete_denied tombstone, the value is
+// instead overwritten by a tombstone object, which Load, Stat and Exists report as missing; it
+// is still listed, though, until the retained object can be deleted.
+
+// Modes of on_delete_denied.
+const (
+	deleteDeniedError     = "error"
+	deleteDeniedTombstone = "tombstone"
+)
+
+// tombstoneMeta is the user metadata key flagging a tombstone object.
+const tombstoneMeta = "certmagic-tombstone"
+
+// ObjectLockConfig configures the Object Lock parameters of written values.
+type ObjectLockConfig struct {
+	Mode           string         `json:"mode,omitempty"`             // "GOVERNANCE" or "COMPLIANCE"; requires retain
+	Retain         caddy.Duration `json:"retain,omitempty"`           // Retention period of written values
+	LegalHold      bool           `json:"legal_hold,omitempty"`       // Set a legal hold on written values
+	OnDeleteDenied string         `json:"on_delete_denied,omitempty"` // "error" (default) or "tombstone"
+}
+
+// objectLock sets the Object Lock parameters of the requests writing values.
+type objectLock struct {
+	cfg      ObjectLockConfig
+	retained func(s3Key string) bool // Whether an object gets the parameters
+}
+
+// provisionObjectLock validates object_lock and sets up the middleware.
+func (s *S3Storage) provisionObjectLock() error {
+	cfg := *s.ObjectLock
+	cfg.Mode = strings.ToUpper(cfg.Mode)
+	switch {
+	case cfg.Mode != "" && cfg.Mode != string(types.ObjectLockModeGovernance) && cfg.Mode != string(types.ObjectLockModeCompliance):
+		return fmt.Errorf("object_lock: unsupported mode '%s' (expected GOVERNANCE or COMPLIANCE)", cfg.Mode)
+	case (cfg.Mode == "") != (cfg.Retain <= 0):
+		return errors.New("object_lock: mode and retain must be specified together")
+	case cfg.Mode == "" && !cfg.LegalHold && cfg.OnDeleteDenied == "":
+		return errors.New("object_lock: specify mode and retain, legal_hold or on_delete_denied")
+	}
+	switch cfg.OnDeleteDenied {
+	case "":
+		cfg.OnDeleteDenied = deleteDeniedError
+	case deleteDeniedError, deleteDeniedTombstone:
+	default:
+		return fmt.Errorf("object_lock: unsupported on_delete_denied '%s' (expected %s or %s)",
+			cfg.OnDeleteDenied, deleteDeniedError, deleteDeniedTombstone)
+	}
+	s.objectLock = &objectLock{cfg: cfg, retained: s.retainedObject}
+	if s.LockBucket == "" && s.LockBackend == nil {
+		s.logger.Warn("object_lock without lock_bucket: lock objects can't be deleted if the bucket applies a default retention")
+	}
+	s.logger.Info("object lock retention active",
+		zap.String("mode", cfg.Mode),
+		zap.Duration("retain", time.Duration(cfg.Retain)),
+		zap.Bool("legal_hold", cfg.LegalHold),
+		zap.String("on_delete_denied", cfg.OnDeleteDenied))
+	return nil
+}
+
+// retainedObject reports whether an object of the storage's bucket holds a value, as opposed to a
+// lock object, probe or other internal object.
+func (s *S3Storage) retainedObject(s3Key string) bool {
+	if s.Prefix != "" && !strings.HasPrefix(s3Key, s.Prefix+"/") {
+		return false
+	}
+	key := s.certMagicKey(s3Key)
+	return !strings.HasPrefix(key, ".") && !s.isHiddenKey(key)
+}
+
+// objectLockClientOptions adds the Object Lock middleware to a client, if object_lock is set.
+func (s *S3Storage) objectLockClientOptions(o *awss3.Options) {
+	if s.objectLock != nil {
+		o.APIOptions = append(o.APIOptions, s.objectLock.register)
+	}
+}
+
+// register adds the middleware setting the Object Lock parameters to a client's stack.
+func (l *objectLock) register(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("ObjectLockRetention",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			l.apply(in.Parameters)
+			return next.HandleInitialize(ctx, in)
+		}), middleware.Before)
+}
+
+// apply sets the Object Lock parameters of the operations writing values.
+func (l *objectLock) apply(params any) {
+	var mode types.ObjectLockMode
+	var until *time.Time
+	if l.cfg.Mode != "" {
+		mode, until = types.ObjectLockMode(l.cfg.Mode), aws.Time(time.Now().Add(time.Duration(l.cfg.Retain)))
+	}
+	var hold types.ObjectLockLegalHoldStatus
+	if l.cfg.LegalHold {
+		hold = types.ObjectLockLegalHoldStatusOn
+	}
+	switch in := params.(type) {
+	case *awss3.PutObjectInput:
+		if l.retained(aws.ToString(in.Key)) && in.Metadata[tombstoneMeta] == "" {
+			in.ObjectLockMode, in.ObjectLockRetainUntilDate, in.ObjectLockLegalHoldStatus = mode, until, hold
+		}
+	case *awss3.CreateMultipartUploadInput:
+		if l.retained(aws.ToString(in.Key)) {
+			in.ObjectLockMode, in.ObjectLockRetainUntilDate, in.ObjectLockLegalHoldStatus = mode, until, hold
+		}
+	case *awss3.CopyObjectInput:
+		if l.retained(aws.ToString(in.Key)) {
+			in.ObjectLockMode, in.ObjectLockRetainUntilDate, in.ObjectLockLegalHoldStatus = mode, until, hold
+		}
+	}
+}
+
+// deleteDenied handles a value whose deletion the bucket refused, according to on_delete_denied.
+func (s *S3Storage) deleteDenied(ctx context.Context, key, s3Key string, deleteErr error) error {
+	if s.objectLock.cfg.OnDeleteDenied != deleteDeniedTombstone {
+		return fmt.Errorf("deleting %s (s3://%s/%s), which may be retained by Object Lock: %w", key, s.Bucket, s3Key, s3Error(deleteErr))
+	}
+	ctx, cancel := s.opContext(ctx)
+	defer cancel()
+	_, err := s.Client.PutObject(ctx, &awss3.PutObjectInput{
+		Bucket:        aws.String(s.Bucket),
+		Key:           aws.String(s3Key),
+		Body:          bytes.NewReader(emptySentinelBody),
+		ContentLength: aws.Int64(int64(len(emptySentinelBody))),
+		StorageClass:  types.StorageClass(s.StorageClass),
+		ContentType:   s.contentType(),
+		Tagging:       s.objectTagging(),
+		Metadata:      s.objectMetadata(map[string]string{tombstoneMeta: "1"}),
+	})
+	if err != nil {
+		return fmt.Errorf("writing tombstone for retained %s (s3://%s/%s): %w", key, s.Bucket, s3Key, s3Error(err))
+	}
+	s.opLogger(ctx, key).Info("deletion denied, wrote tombstone", zap.String("key", key), zap.Error(deleteErr))
+	return nil
+}
+
+// isTombstone reports whether an object's user metadata flags it as a deleted value.
+func isTombstone(metadata map[string]string) bool {
+	return metadata[tombstoneMeta] == "1"
+}
+
+// unmarshalObjectLock parses the object_lock block:
+//
+//	object_lock {
+//		mode governance
+//		retain 2160h
+//		legal_hold true
+//		on_delete_denied tombstone
+//	}
+func (s *S3Storage) unmarshalObjectLock(d *caddyfile.Dispenser) error {
+	cfg := new(ObjectLockConfig)
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		key := d.Val()
+		var value string
+		if !d.AllArgs(&value) {
+			return d.ArgErr()
+		}
+		switch key {
+		case "mode":
+			cfg.Mode = value
+		case "retain":
+			dur, err := caddy.ParseDuration(value)
+			if err != nil {
+				return d.Errf("invalid object_lock retain '%s': %v", value, err)
+			}
+			cfg.Retain = caddy.Duration(dur)
+		case "legal_hold":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return d.Errf("invalid object_lock legal_hold '%s': %v", value, err)
+			}
+			cfg.LegalHold = b
+		case "on_delete_denied":
+			cfg.OnDeleteDenied = value
+		default:
+			return d.Errf("unrecognized object_lock subdirective '%s'", key)
+		}
+	}
+	s.ObjectLock = cfg
+	return nil
+}
@@ -0,0 +1,146 @@
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/caddyserver/caddy/v2"
+	s3 "github.com/cvhome-saas/certmagic-s3"
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+// retainingClient refuses to delete value objects, like a bucket protecting retained objects.
+type retainingClient struct {
+	s3.S3API
+	retained func(s3Key string) bool
+}
+
+func (c *retainingClient) DeleteObject(ctx context.Context, params *awss3.DeleteObjectInput, optFns ...func(*awss3.Options)) (*awss3.DeleteObjectOutput, error) {
+	if c.retained(aws.ToString(params.Key)) {
+		return nil, &smithy.OperationError{ServiceID: "S3", OperationName: "DeleteObject", Err: &awshttp.ResponseError{
+			ResponseError: &smithyhttp.ResponseError{
+				Response: &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusForbidden}},
+				Err:      errors.New("AccessDenied: object is WORM protected"),
+			},
+		}}
+	}
+	return c.S3API.DeleteObject(ctx, params, optFns...)
+}
+
+func (c *retainingClient) DeleteObjects(ctx context.Context, params *awss3.DeleteObjectsInput, optFns ...func(*awss3.Options)) (*awss3.DeleteObjectsOutput, error) {
+	var (
+		deletable []types.ObjectIdentifier
+		refused   []types.Error
+	)
+	for _, obj := range params.Delete.Objects {
+		if c.retained(aws.ToString(obj.Key)) {
+			refused = append(refused, types.Error{Key: obj.Key, Code: aws.String("AccessDenied"), Message: aws.String("object is WORM protected")})
+			continue
+		}
+		deletable = append(deletable, obj)
+	}
+	out := &awss3.DeleteObjectsOutput{}
+	if len(deletable) > 0 {
+		in := *params
+		in.Delete = &types.Delete{Objects: deletable, Quiet: params.Delete.Quiet}
+		var err error
+		if out, err = c.S3API.DeleteObjects(ctx, &in, optFns...); err != nil {
+			return nil, err
+		}
+	}
+	out.Errors = append(out.Errors, refused...)
+	return out, nil
+}
+
+// retainValues makes the storage's client refuse to delete the values below certificates.
+func retainValues(storage *s3.S3Storage) {
+	storage.Client = &retainingClient{S3API: storage.Client, retained: func(s3Key string) bool {
+		return strings.HasPrefix(s3Key, "certmagic/certificates/")
+	}}
+}
+
+func TestStorageObjectLock(t *testing.T) {
+	ctx := context.Background()
+	key := "certificates/acme/a.com/a.com.crt"
+
+	storage, _ := s3test.NewFakeStorage(t, func(s *s3.S3Storage) {
+		s.ObjectLock = &s3.ObjectLockConfig{Mode: "governance", Retain: caddy.Duration(24 * time.Hour)}
+	})
+	retainValues(storage)
+	if err := storage.Store(ctx, key, []byte("cert")); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Delete(ctx, key); err == nil || !strings.Contains(err.Error(), "Object Lock") {
+		t.Errorf("Delete of a retained value = %v, want an error", err)
+	}
+	if !storage.Exists(ctx, key) {
+		t.Fatal("value gone after failed Delete")
+	}
+
+	storage, _ = s3test.NewFakeStorage(t, func(s *s3.S3Storage) {
+		s.ObjectLock = &s3.ObjectLockConfig{LegalHold: true, OnDeleteDenied: "tombstone"}
+	})
+	retainValues(storage)
+	if err := storage.Store(ctx, key, []byte("cert")); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Load(ctx, key); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Load of tombstone = %v, want fs.ErrNotExist", err)
+	}
+	if _, err := storage.Stat(ctx, key); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat of tombstone = %v, want fs.ErrNotExist", err)
+	}
+	if storage.Exists(ctx, key) {
+		t.Error("tombstone reported as existing")
+	}
+	if _, err := storage.LoadStream(ctx, key); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadStream of tombstone = %v, want fs.ErrNotExist", err)
+	}
+
+	// CertMagic's cleanups delete whole directories
+	if err := storage.Store(ctx, key, []byte("cert")); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Delete(ctx, "certificates/acme/a.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Load(ctx, key); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Load below a deleted directory = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestStorageExportTombstone(t *testing.T) {
+	storage, _ := s3test.NewFakeStorage(t, func(s *s3.S3Storage) {
+		s.ObjectLock = &s3.ObjectLockConfig{OnDeleteDenied: "tombstone"}
+	})
+	retainValues(storage)
+	ctx := context.Background()
+	for _, key := range []string{"certificates/acme/a.com/a.com.crt", "certificates/acme/b.com/b.com.crt"} {
+		if err := storage.Store(ctx, key, []byte("cert")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.Delete(ctx, "certificates/acme/a.com/a.com.crt"); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	if count, err := storage.Export(ctx, &archive); err != nil || count != 1 {
+		t.Errorf("Export = %d, %v; want only the live value", count, err)
+	}
+}
@@ -0,0 +1,50 @@
+package s3
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+func TestObjectLock(t *testing.T) {
+	s := &S3Storage{
+		Prefix:     "certmagic",
+		ObjectLock: &ObjectLockConfig{Mode: "governance", Retain: caddy.Duration(24 * time.Hour), LegalHold: true},
+		logger:     zap.NewNop(),
+	}
+	if err := s.provisionObjectLock(); err != nil {
+		t.Fatal(err)
+	}
+
+	value := &awss3.PutObjectInput{Key: aws.String(s.s3ObjectKey("certificates/acme/a.com/a.com.crt"))}
+	lock := &awss3.PutObjectInput{Key: aws.String(s.s3LockKey("issue_cert_a.com"))}
+	probe := &awss3.PutObjectInput{Key: aws.String(s.s3ObjectKey(validationProbeKey))}
+	for _, in := range []*awss3.PutObjectInput{value, lock, probe} {
+		s.objectLock.apply(in)
+	}
+	if value.ObjectLockMode != types.ObjectLockModeGovernance || value.ObjectLockLegalHoldStatus != types.ObjectLockLegalHoldStatusOn ||
+		value.ObjectLockRetainUntilDate == nil || time.Until(*value.ObjectLockRetainUntilDate) < 23*time.Hour {
+		t.Errorf("value not retained: %+v", value)
+	}
+	if lock.ObjectLockMode != "" || probe.ObjectLockMode != "" || lock.ObjectLockLegalHoldStatus != "" {
+		t.Error("internal objects must stay deletable")
+	}
+
+	for _, cfg := range []ObjectLockConfig{
+		{Mode: "governance"},
+		{Retain: caddy.Duration(time.Hour)},
+		{Mode: "forever", Retain: caddy.Duration(time.Hour)},
+		{LegalHold: true, OnDeleteDenied: "ignore"},
+		{},
+	} {
+		s.ObjectLock = &cfg
+		if err := s.provisionObjectLock(); err == nil {
+			t.Errorf("object_lock %+v accepted", cfg)
+		}
+	}
+}
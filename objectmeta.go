@@ -0,0 +1,94 @@
+package s3
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// object_tags and object_metadata are attached to every object written, e.g. to drive
+// cost-allocation or lifecycle rules. Values support global placeholders such as {env.TENANT}.
+
+const (
+	maxObjectTags          = 10 // S3's limit per object
+	reservedMetadataPrefix = "certmagic-"
+)
+
+// provisionObjectMeta resolves the placeholders of the tags and metadata and validates them.
+func (s *S3Storage) provisionObjectMeta() error {
+	if len(s.ObjectTags) > maxObjectTags {
+		return fmt.Errorf("object_tags: at most %d tags are allowed, got %d", maxObjectTags, len(s.ObjectTags))
+	}
+	repl := caddy.NewReplacer()
+	s.ObjectTags = resolveMap(repl, s.ObjectTags)
+	s.ObjectMetadata = resolveMap(repl, s.ObjectMetadata)
+	if len(s.ObjectTags) > 0 {
+		tags := make(url.Values, len(s.ObjectTags))
+		for k, v := range s.ObjectTags {
+			if k == "" {
+				return fmt.Errorf("object_tags: empty tag key")
+			}
+			tags.Set(k, v)
+		}
+		s.tagging = tags.Encode()
+	}
+	for k := range s.ObjectMetadata {
+		if k == "" || strings.HasPrefix(strings.ToLower(k), reservedMetadataPrefix) {
+			return fmt.Errorf("object_metadata: invalid key '%s' (keys starting with %s are reserved)", k, reservedMetadataPrefix)
+		}
+	}
+	return nil
+}
+
+// resolveMap returns m with placeholders replaced in keys and values.
+func resolveMap(repl *caddy.Replacer, m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	resolved := make(map[string]string, len(m))
+	for k, v := range m {
+		resolved[repl.ReplaceKnown(k, "")] = repl.ReplaceKnown(v, "")
+	}
+	return resolved
+}
+
+// objectTagging returns the tag set of written objects in the form of the x-amz-tagging header.
+func (s *S3Storage) objectTagging() *string {
+	if s.tagging == "" {
+		return nil
+	}
+	return &s.tagging
+}
+
+// objectMetadata returns the metadata of written objects, plus the given internal metadata.
+func (s *S3Storage) objectMetadata(internal map[string]string) map[string]string {
+	if len(s.ObjectMetadata) == 0 {
+		return internal
+	}
+	meta := maps.Clone(s.ObjectMetadata)
+	maps.Copy(meta, internal)
+	return meta
+}
+
+// unmarshalStringMap parses a block of key value pairs into m, e.g. object_tags.
+func unmarshalStringMap(d *caddyfile.Dispenser, m *map[string]string) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	if *m == nil {
+		*m = make(map[string]string)
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		key := d.Val()
+		var value string
+		if !d.AllArgs(&value) {
+			return d.ArgErr()
+		}
+		(*m)[key] = value
+	}
+	return nil
+}
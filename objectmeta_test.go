@@ -0,0 +1,36 @@
+package s3
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProvisionObjectMeta(t *testing.T) {
+	t.Setenv("S3TEST_ENVIRONMENT", "staging")
+	s := &S3Storage{
+		ObjectTags:     map[string]string{"environment": "{env.S3TEST_ENVIRONMENT}", "cost center": "a&b"},
+		ObjectMetadata: map[string]string{"owner": "platform"},
+	}
+	if err := s.provisionObjectMeta(); err != nil {
+		t.Fatal(err)
+	}
+	if got := *s.objectTagging(); got != "cost+center=a%26b&environment=staging" {
+		t.Errorf("tagging = %q", got)
+	}
+	meta := s.objectMetadata(map[string]string{emptySentinelMeta: "1"})
+	if meta["owner"] != "platform" || meta[emptySentinelMeta] != "1" || len(s.ObjectMetadata) != 1 {
+		t.Errorf("metadata = %v, configured %v", meta, s.ObjectMetadata)
+	}
+
+	s = &S3Storage{ObjectMetadata: map[string]string{"Certmagic-Empty": "1"}}
+	if err := s.provisionObjectMeta(); err == nil || !strings.Contains(err.Error(), "reserved") {
+		t.Errorf("expected reserved metadata key to be rejected, got %v", err)
+	}
+	s = &S3Storage{ObjectTags: make(map[string]string)}
+	for _, k := range strings.Split("a b c d e f g h i j k", " ") {
+		s.ObjectTags[k] = "v"
+	}
+	if err := s.provisionObjectMeta(); err == nil {
+		t.Error("expected more than 10 tags to be rejected")
+	}
+}
@@ -0,0 +1,120 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// walkObjects calls fn for every object below the storage prefix, except lock objects, the manifest
+// and the trash of soft_delete.
+// Maintenance tasks use it to visit the whole storage page by page.
+func (s *S3Storage) walkObjects(ctx context.Context, fn func(obj types.Object) error) error {
+	return s.walkPrefix(ctx, "", fn)
+}
+
+// walkPrefix walks the objects below a CertMagic key prefix for walkObjects, descending into routes
+// and shards.
+func (s *S3Storage) walkPrefix(ctx context.Context, prefix string, fn func(obj types.Object) error) error {
+	if s.shardedListing(ctx, prefix) {
+		return s.fanOut(ctx, func(ctx context.Context) error { return s.walkPrefix(ctx, prefix, fn) })
+	}
+	routes := s.routesBelow(prefix)
+	s3Prefix := s.s3ObjectKey(prefix)
+	if s3Prefix != "" && !strings.HasSuffix(s3Prefix, "/") {
+		s3Prefix += "/"
+	}
+
+	paginator := awss3.NewListObjectsV2Paginator(s.Client, &awss3.ListObjectsV2Input{
+		Bucket:  aws.String(s.Bucket),
+		Prefix:  aws.String(s3Prefix),
+		MaxKeys: s.listPageSize(),
+	})
+	for paginator.HasMorePages() {
+		pageCtx, cancel := s.listContext(ctx)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("listing s3://%s/%s: %w", s.Bucket, s3Prefix, s3Error(err))
+		}
+		for _, obj := range page.Contents {
+			if obj.Key == nil {
+				continue
+			}
+			key := s.certMagicKey(*obj.Key)
+			if s.isLockKey(key) || key == manifestKey || key == expirationIndexKey || key == passphraseSaltKey ||
+				routedAway(key, routes) || (isTrashKey(key) && !isTrashKey(prefix)) {
+				continue
+			}
+			if err := fn(obj); err != nil {
+				return err
+			}
+		}
+	}
+	for _, route := range routes {
+		if err := s.walkPrefix(ctx, route, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// certMagicKey converts a full S3 object key back to the CertMagic key.
+func (s *S3Storage) certMagicKey(s3Key string) string {
+	if s.Prefix == "" {
+		return s3Key
+	}
+	return strings.TrimPrefix(s3Key, s.Prefix+"/")
+}
+
+// getObjectBytes reads the raw, possibly encrypted, content of an object.
+func (s *S3Storage) getObjectBytes(ctx context.Context, s3Key string) ([]byte, error) {
+	data, _, err := s.getObjectWithMetadata(ctx, s3Key)
+	return data, err
+}
+
+// getObjectWithMetadata is getObjectBytes also returning the object's user metadata.
+func (s *S3Storage) getObjectWithMetadata(ctx context.Context, s3Key string) ([]byte, map[string]string, error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+	out, err := s.Client.GetObject(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s3Key),
+	})
+	if err != nil {
+		if s.isNotFound(err) {
+			return nil, nil, fs.ErrNotExist
+		}
+		return nil, nil, fmt.Errorf("reading s3://%s/%s: %w", s.Bucket, s3Key, s3Error(err))
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	return data, out.Metadata, err
+}
+
+// putObjectBytes writes raw content to an object as is, without going through the IO wrapper.
+func (s *S3Storage) putObjectBytes(ctx context.Context, s3Key string, body []byte) error {
+	ctx, cancel := s.opContext(ctx)
+	defer cancel()
+	_, err := s.Client.PutObject(ctx, &awss3.PutObjectInput{
+		Bucket:        aws.String(s.Bucket),
+		Key:           aws.String(s3Key),
+		Body:          bytes.NewReader(body),
+		ContentLength: aws.Int64(int64(len(body))),
+		StorageClass:  types.StorageClass(s.StorageClass),
+		ContentType:   s.contentType(),
+		Tagging:       s.objectTagging(),
+		Metadata:      s.objectMetadata(nil),
+	})
+	if err != nil {
+		return fmt.Errorf("writing s3://%s/%s: %w", s.Bucket, s3Key, s3Error(err))
+	}
+	return nil
+}
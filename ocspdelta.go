@@ -0,0 +1,221 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// OCSP staples of the same certificate differ in a few timestamps and the signature only. With
+// ocsp_delta enabled, a staple is stored as a small delta against a base version kept next to it,
+// which keeps writes small and avoids version bloat in versioned buckets.
+//
+// The stored envelope is: deltaMagic | sha256(base) | delta ops. Bases are content-addressed, at
+// <key>.ocspbase/<hex sha256>, so a new base never replaces the one an envelope refers to; the
+// previous base is deleted once the envelope referring to the new one is stored. Envelopes of
+// older versions refer to bases at <key>.ocspbase, which are still read. Loading an envelope
+// always works, regardless of the ocsp_delta setting, so the option can be turned off at any time.
+
+const (
+	ocspKeyPrefix  = "ocsp/"     // certmagic.StorageKeys.OCSPStaple places staples here
+	ocspBaseSuffix = ".ocspbase" // Base versions are hidden from List
+
+	deltaBlockSize = 8 // Minimum match length used when searching the base
+
+	deltaOpInsert byte = 0
+	deltaOpCopy   byte = 1
+)
+
+var deltaMagic = []byte("\x00CMS3DELTA1")
+
+// isOCSPStapleKey reports whether key refers to an OCSP staple (and not to a base version).
+func isOCSPStapleKey(key string) bool {
+	key = strings.TrimPrefix(key, "/")
+	return strings.HasPrefix(key, ocspKeyPrefix) && !isOCSPBaseKey(key)
+}
+
+// isOCSPBaseKey reports whether key refers to a base version or the directory of base versions.
+func isOCSPBaseKey(key string) bool {
+	return strings.HasSuffix(key, ocspBaseSuffix) || strings.Contains(key, ocspBaseSuffix+"/")
+}
+
+// ocspBaseKey returns the directory of the base versions for the given staple key, which is also
+// where the legacy base version was stored.
+func ocspBaseKey(key string) string {
+	return key + ocspBaseSuffix
+}
+
+// ocspBaseSumKey returns the key of the base version with the given hash.
+func ocspBaseSumKey(key string, sum []byte) string {
+	return ocspBaseKey(key) + "/" + hex.EncodeToString(sum)
+}
+
+// encodeOCSPDelta returns the envelope to store for the staple value. If there is no usable base,
+// or the value diverged too far from it, the value becomes the new base first, and the key of the
+// base to delete once the envelope is stored is returned as well.
+func (s *S3Storage) encodeOCSPDelta(ctx context.Context, key string, value []byte) (envelope []byte, staleBase string, err error) {
+	if current, err := s.load(ctx, key); err == nil && isDeltaEnvelope(current) {
+		baseKey, base, err := s.loadOCSPBase(ctx, key, current)
+		if err == nil {
+			delta := encodeDelta(base, value)
+			if len(delta) <= len(value)/2 {
+				return newDeltaEnvelope(base, delta), "", nil
+			}
+			s.logger.Debug("ocsp staple diverged from base, rebasing", zap.String("key", key))
+			staleBase = baseKey
+		}
+	}
+
+	sum := sha256.Sum256(value)
+	baseKey := ocspBaseSumKey(key, sum[:])
+	if err := s.Store(ctx, baseKey, value); err != nil {
+		return nil, "", fmt.Errorf("storing ocsp base for %s: %w", key, err)
+	}
+	if staleBase == baseKey {
+		staleBase = ""
+	}
+	return newDeltaEnvelope(value, encodeDelta(value, value)), staleBase, nil
+}
+
+// deleteStaleOCSPBase deletes the base an envelope referred to before it was rebased. Failures are
+// logged only, as the staple is stored.
+func (s *S3Storage) deleteStaleOCSPBase(ctx context.Context, key, baseKey string) {
+	if err := s.Delete(ctx, baseKey); err != nil {
+		s.logger.Warn("deleting previous ocsp base failed", zap.String("key", key), zap.String("base", baseKey), zap.Error(err))
+	}
+}
+
+// loadOCSPBase loads the base version an envelope refers to and returns its key.
+func (s *S3Storage) loadOCSPBase(ctx context.Context, key string, envelope []byte) (string, []byte, error) {
+	sum := envelope[len(deltaMagic) : len(deltaMagic)+sha256.Size]
+	baseKey := ocspBaseSumKey(key, sum)
+	base, err := s.Load(ctx, baseKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		baseKey = ocspBaseKey(key) // Written before bases were content-addressed
+		base, err = s.Load(ctx, baseKey)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("loading ocsp base for %s: %w", key, err)
+	}
+	if baseSum := sha256.Sum256(base); !bytes.Equal(baseSum[:], sum) {
+		return "", nil, fmt.Errorf("ocsp base for %s does not match the stored delta", key)
+	}
+	return baseKey, base, nil
+}
+
+// decodeOCSPDelta reconstructs a staple from its envelope and the stored base version.
+func (s *S3Storage) decodeOCSPDelta(ctx context.Context, key string, envelope []byte) ([]byte, error) {
+	_, base, err := s.loadOCSPBase(ctx, key, envelope)
+	if err != nil {
+		return nil, err
+	}
+	value, err := applyDelta(base, envelope[len(deltaMagic)+sha256.Size:])
+	if err != nil {
+		return nil, fmt.Errorf("applying ocsp delta for %s: %w", key, err)
+	}
+	return value, nil
+}
+
+// isDeltaEnvelope reports whether data was written by encodeOCSPDelta.
+func isDeltaEnvelope(data []byte) bool {
+	return len(data) >= len(deltaMagic)+sha256.Size && bytes.HasPrefix(data, deltaMagic)
+}
+
+func newDeltaEnvelope(base, delta []byte) []byte {
+	sum := sha256.Sum256(base)
+	envelope := make([]byte, 0, len(deltaMagic)+len(sum)+len(delta))
+	envelope = append(envelope, deltaMagic...)
+	envelope = append(envelope, sum[:]...)
+	return append(envelope, delta...)
+}
+
+// encodeDelta computes a sequence of copy (from base) and insert operations producing target.
+// Each operation is a type byte followed by uvarints: copy has offset and length, insert has
+// length and the literal bytes.
+func encodeDelta(base, target []byte) []byte {
+	index := make(map[string]int)
+	for i := 0; i+deltaBlockSize <= len(base); i++ {
+		block := string(base[i : i+deltaBlockSize])
+		if _, ok := index[block]; !ok {
+			index[block] = i
+		}
+	}
+
+	var out, pending []byte
+	flushInsert := func() {
+		if len(pending) == 0 {
+			return
+		}
+		out = append(out, deltaOpInsert)
+		out = binary.AppendUvarint(out, uint64(len(pending)))
+		out = append(out, pending...)
+		pending = pending[:0]
+	}
+
+	for i := 0; i < len(target); {
+		if i+deltaBlockSize <= len(target) {
+			if off, ok := index[string(target[i:i+deltaBlockSize])]; ok {
+				n := deltaBlockSize
+				for off+n < len(base) && i+n < len(target) && base[off+n] == target[i+n] {
+					n++
+				}
+				flushInsert()
+				out = append(out, deltaOpCopy)
+				out = binary.AppendUvarint(out, uint64(off))
+				out = binary.AppendUvarint(out, uint64(n))
+				i += n
+				continue
+			}
+		}
+		pending = append(pending, target[i])
+		i++
+	}
+	flushInsert()
+	return out
+}
+
+// applyDelta reconstructs the target from base and a delta produced by encodeDelta.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	var out []byte
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch op {
+		case deltaOpCopy:
+			off, n1 := binary.Uvarint(delta)
+			if n1 <= 0 {
+				return nil, errors.New("malformed copy offset")
+			}
+			length, n2 := binary.Uvarint(delta[n1:])
+			if n2 <= 0 {
+				return nil, errors.New("malformed copy length")
+			}
+			delta = delta[n1+n2:]
+			if off > uint64(len(base)) || length > uint64(len(base))-off {
+				return nil, errors.New("copy out of range")
+			}
+			out = append(out, base[off:off+length]...)
+		case deltaOpInsert:
+			length, n := binary.Uvarint(delta)
+			if n <= 0 || length > uint64(len(delta)-n) {
+				return nil, errors.New("malformed insert")
+			}
+			out = append(out, delta[n:n+int(length)]...)
+			delta = delta[n+int(length):]
+		default:
+			return nil, fmt.Errorf("unknown delta op %d", op)
+		}
+	}
+	if out == nil {
+		out = []byte{}
+	}
+	return out, nil
+}
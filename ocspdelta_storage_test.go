@@ -0,0 +1,69 @@
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	s3 "github.com/cvhome-saas/certmagic-s3"
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+func TestStorageOCSPDelta(t *testing.T) {
+	storage, fake := s3test.NewFakeStorage(t, func(s *s3.S3Storage) { s.OCSPDelta = true })
+	ctx := context.Background()
+	const key = "ocsp/example.com-0123456789abcdef"
+	bases := func() []string {
+		var bases []string
+		for _, k := range fake.Keys(s3test.DefaultBucket) {
+			if strings.HasPrefix(k, "certmagic/"+key+".ocspbase/") {
+				bases = append(bases, k)
+			}
+		}
+		return bases
+	}
+
+	v1 := bytes.Repeat([]byte("ocsp response "), 20)
+	v2 := slices.Clone(v1)
+	copy(v2[100:], "renewed")
+	v3 := bytes.Repeat([]byte("another responder "), 20)
+
+	if err := storage.Store(ctx, key, v1); err != nil {
+		t.Fatal(err)
+	}
+	first := bases()
+	if len(first) != 1 {
+		t.Fatalf("bases after the first store = %v, want one", first)
+	}
+	if err := storage.Store(ctx, key, v2); err != nil {
+		t.Fatal(err)
+	}
+	if got := bases(); !slices.Equal(got, first) {
+		t.Errorf("bases after storing a delta = %v, want %v", got, first)
+	}
+	if value, err := storage.Load(ctx, key); err != nil || !bytes.Equal(value, v2) {
+		t.Errorf("Load = %q, %v", value, err)
+	}
+
+	if err := storage.Store(ctx, key, v3); err != nil { // Rebases
+		t.Fatal(err)
+	}
+	if got := bases(); len(got) != 1 || got[0] == first[0] {
+		t.Errorf("bases after rebasing = %v, want one replacing %v", got, first)
+	}
+	if value, err := storage.Load(ctx, key); err != nil || !bytes.Equal(value, v3) {
+		t.Errorf("Load after rebasing = %q, %v", value, err)
+	}
+	if keys, err := storage.List(ctx, "ocsp", true); err != nil || !slices.Equal(keys, []string{key}) {
+		t.Errorf("List = %v, %v; want the staple only", keys, err)
+	}
+
+	if err := storage.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if keys := fake.Keys(s3test.DefaultBucket); len(keys) != 0 {
+		t.Errorf("objects after Delete = %v", keys)
+	}
+}
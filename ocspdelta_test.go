@@ -0,0 +1,53 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestDeltaRoundTrip(t *testing.T) {
+	base := make([]byte, 1500)
+	if _, err := rand.Read(base); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a new staple: changed timestamps in the middle and a new signature at the end.
+	target := bytes.Clone(base)
+	copy(target[400:], "20240102030405Z")
+	if _, err := rand.Read(target[len(target)-72:]); err != nil {
+		t.Fatal(err)
+	}
+
+	delta := encodeDelta(base, target)
+	if len(delta) >= len(target)/2 {
+		t.Errorf("expected a small delta, got %d bytes for %d bytes of input", len(delta), len(target))
+	}
+	got, err := applyDelta(base, delta)
+	if err != nil {
+		t.Fatalf("applying delta failed: %v", err)
+	}
+	if !bytes.Equal(got, target) {
+		t.Errorf("reconstructed value differs from target")
+	}
+
+	empty, err := applyDelta(base, encodeDelta(base, nil))
+	if err != nil || len(empty) != 0 {
+		t.Errorf("expected empty result for empty target, got %v (%v)", empty, err)
+	}
+}
+
+func TestIsOCSPStapleKey(t *testing.T) {
+	if !isOCSPStapleKey("ocsp/example.com-abcdef") {
+		t.Errorf("expected staple key to be recognized")
+	}
+	if isOCSPStapleKey(ocspBaseKey("ocsp/example.com-abcdef")) {
+		t.Errorf("base keys must not be treated as staples")
+	}
+	if isOCSPStapleKey(ocspBaseSumKey("ocsp/example.com-abcdef", []byte{1, 2})) {
+		t.Errorf("content-addressed base keys must not be treated as staples")
+	}
+	if isOCSPStapleKey("certificates/example.com/example.com.crt") {
+		t.Errorf("certificate keys must not be treated as staples")
+	}
+}
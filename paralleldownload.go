@@ -0,0 +1,122 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// Load reads an object with a single GetObject. With parallel_download, objects larger than the
+// threshold (e.g. large bundled chains or OCSP data) are instead fetched in ranged parts by the
+// S3 download manager, several at a time. The size is taken from the GetObject response, whose
+// body is then dropped; the parts are requested with If-Match on its ETag, so that an object
+// overwritten meanwhile fails the Load rather than being mixed from two versions.
+
+// defaultParallelDownloadThreshold is the object size above which parts are downloaded by default.
+const defaultParallelDownloadThreshold = 16 << 20
+
+// ParallelDownloadConfig configures downloading large objects in concurrent parts.
+type ParallelDownloadConfig struct {
+	Threshold   int64 `json:"threshold,omitempty"`   // Bytes; objects up to this size use a single request, default 16MiB
+	PartSize    int64 `json:"part_size,omitempty"`   // Bytes per ranged request; default 5MiB
+	Concurrency int   `json:"concurrency,omitempty"` // Parts downloaded at a time; default 5
+}
+
+// validateParallelDownload checks parallel_download and applies its defaults.
+func (s *S3Storage) validateParallelDownload() error {
+	cfg := s.ParallelDownload
+	switch {
+	case cfg.Threshold < 0:
+		return errors.New("parallel_download: threshold must not be negative")
+	case cfg.PartSize < 0:
+		return errors.New("parallel_download: part_size must not be negative")
+	case cfg.Concurrency < 0:
+		return errors.New("parallel_download: concurrency must not be negative")
+	}
+	if cfg.Threshold == 0 {
+		cfg.Threshold = defaultParallelDownloadThreshold
+	}
+	if cfg.PartSize == 0 {
+		cfg.PartSize = manager.DefaultDownloadPartSize
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = manager.DefaultDownloadConcurrency
+	}
+	return nil
+}
+
+// downloadInParts reports whether an object of the given size is downloaded in parts.
+func (s *S3Storage) downloadInParts(size *int64) bool {
+	cfg := s.ParallelDownload
+	return cfg != nil && size != nil && *size > cfg.Threshold && *size > cfg.PartSize
+}
+
+// downloadParts downloads the object described by a GetObject response in concurrent parts and
+// returns its raw (possibly encrypted) content.
+func (s *S3Storage) downloadParts(ctx context.Context, s3Key string, result *awss3.GetObjectOutput) ([]byte, error) {
+	result.Body.Close() // Replaced by the ranged requests
+	cfg := s.ParallelDownload
+	downloader := manager.NewDownloader(s.Client, func(d *manager.Downloader) {
+		d.PartSize = cfg.PartSize
+		d.Concurrency = cfg.Concurrency
+	})
+	buf := manager.NewWriteAtBuffer(make([]byte, 0, aws.ToInt64(result.ContentLength)))
+	n, err := downloader.Download(ctx, buf, &awss3.GetObjectInput{
+		Bucket:  aws.String(s.Bucket),
+		Key:     aws.String(s3Key),
+		IfMatch: result.ETag,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if n != aws.ToInt64(result.ContentLength) {
+		return nil, fmt.Errorf("downloaded %d of %d bytes", n, aws.ToInt64(result.ContentLength))
+	}
+	return buf.Bytes(), nil
+}
+
+// unmarshalParallelDownload parses "parallel_download [<threshold>] { part_size <size>; concurrency <n> }".
+func (s *S3Storage) unmarshalParallelDownload(d *caddyfile.Dispenser) error {
+	cfg := new(ParallelDownloadConfig)
+	if d.NextArg() {
+		size, err := parseByteSize(d.Val())
+		if err != nil {
+			return d.Errf("invalid parallel_download threshold: %v", err)
+		}
+		cfg.Threshold = size
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		key := d.Val()
+		var value string
+		if !d.AllArgs(&value) {
+			return d.ArgErr()
+		}
+		switch key {
+		case "part_size":
+			size, err := parseByteSize(value)
+			if err != nil {
+				return d.Errf("invalid parallel_download part_size: %v", err)
+			}
+			cfg.PartSize = size
+		case "concurrency":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return d.Errf("invalid parallel_download concurrency '%s'", value)
+			}
+			cfg.Concurrency = n
+		default:
+			return d.Errf("unrecognized parallel_download subdirective '%s'", key)
+		}
+	}
+	s.ParallelDownload = cfg
+	return nil
+}
@@ -0,0 +1,260 @@
+package s3
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/sync/singleflight"
+)
+
+// With encryption_passphrase, the 32-byte key is derived from a passphrase of any length with
+// Argon2id. By default, the key is derived from a random salt, which the first instance stores in
+// the hidden object .passphrase-salt for all instances and restarts to reuse, and the salt is
+// stored in front of each object written; reading an object derives the key for its salt, which is
+// cached. With encryption_salt, the key is derived once from that salt and objects have the plain
+// format of encryption_key, so the derived key can later be configured as encryption_key.
+//
+//	passphraseMagic (8 bytes) | salt (16 bytes) | object of encryption_cipher
+const (
+	passphraseMagic   = "\x00CMS3PP1"
+	passphraseSaltKey = ".passphrase-salt"
+
+	passphraseSaltSize     = 16
+	passphraseMinSaltSize  = 8
+	passphraseKeyCacheSize = 64 // Keys derived for the salts of other instances
+
+	// Argon2id parameters, as recommended by RFC 9106 for memory-constrained environments. They
+	// are part of the format; changing them makes existing objects unreadable.
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024 // KiB
+	argon2Threads = 4
+)
+
+// derivePassphraseKey derives a 32-byte encryption key from passphrase and salt.
+func derivePassphraseKey(passphrase string, salt []byte) string {
+	return string(argon2.IDKey([]byte(passphrase), salt, argon2Time, argon2Memory, argon2Threads, 32))
+}
+
+// PassphraseIO encrypts with a key derived from a passphrase and a salt stored in each object.
+type PassphraseIO struct {
+	passphrase string
+	cipher     string
+
+	salt   [passphraseSaltSize]byte // Salt of the objects written by this instance
+	writer IO
+
+	derivations singleflight.Group // Concurrent reads of objects with the same salt derive its key once
+
+	mu   sync.Mutex
+	lru  *list.List // Of *passphraseKey, front is most recently used
+	keys map[[passphraseSaltSize]byte]*list.Element
+}
+
+// passphraseKey is the IO for the key derived for the salt of read objects.
+type passphraseKey struct {
+	salt [passphraseSaltSize]byte
+	io   IO
+}
+
+// NewPassphraseIO derives the key for a new random salt, for encrypting with cipherName.
+func NewPassphraseIO(passphrase, cipherName string) (*PassphraseIO, error) {
+	var salt [passphraseSaltSize]byte
+	if _, err := io.ReadFull(rand.Reader, salt[:]); err != nil {
+		return nil, fmt.Errorf("generating salt: %w", err)
+	}
+	return newPassphraseIO(passphrase, cipherName, salt)
+}
+
+// newPassphraseIO derives the key for salt, for encrypting with cipherName.
+func newPassphraseIO(passphrase, cipherName string, salt [passphraseSaltSize]byte) (*PassphraseIO, error) {
+	writer, err := NewCipherIO(derivePassphraseKey(passphrase, salt[:]), cipherName)
+	if err != nil {
+		return nil, err
+	}
+	return &PassphraseIO{
+		passphrase: passphrase,
+		cipher:     cipherName,
+		salt:       salt,
+		writer:     writer,
+		lru:        list.New(),
+		keys:       make(map[[passphraseSaltSize]byte]*list.Element),
+	}, nil
+}
+
+// header returns the object header naming the salt of this instance.
+func (p *PassphraseIO) header() []byte {
+	return append([]byte(passphraseMagic), p.salt[:]...)
+}
+
+// ByteReader encrypts plaintext with the key of this instance's salt.
+func (p *PassphraseIO) ByteReader(plaintext []byte) (io.Reader, int64, error) {
+	r, n, err := p.writer.ByteReader(plaintext)
+	if err != nil {
+		return nil, 0, err
+	}
+	header := p.header()
+	return io.MultiReader(bytes.NewReader(header), r), int64(len(header)) + n, nil
+}
+
+// StreamReader encrypts the plaintext stream with the key of this instance's salt.
+func (p *PassphraseIO) StreamReader(plaintext io.Reader, size int64) (io.Reader, int64, error) {
+	r, n, err := p.writer.StreamReader(plaintext, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	header := p.header()
+	if n >= 0 {
+		n += int64(len(header))
+	}
+	return io.MultiReader(bytes.NewReader(header), r), n, nil
+}
+
+// WrapReader derives the key for the salt of the object and decrypts it.
+func (p *PassphraseIO) WrapReader(ciphertextReader io.Reader) io.Reader {
+	var header [len(passphraseMagic) + passphraseSaltSize]byte
+	n, err := io.ReadFull(ciphertextReader, header[:])
+	if err == io.EOF {
+		return bytes.NewReader(nil) // An empty stream has nothing to decrypt
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return &errorReader{err: fmt.Errorf("failed to read header: %w", err)}
+	}
+	if n < len(header) || string(header[:len(passphraseMagic)]) != passphraseMagic {
+		return &errorReader{err: errors.New("failed to decrypt data: not encrypted with encryption_passphrase")}
+	}
+	salt := [passphraseSaltSize]byte(header[len(passphraseMagic):])
+	wrap, err := p.ioForSalt(salt)
+	if err != nil {
+		return &errorReader{err: err}
+	}
+	return wrap.WrapReader(ciphertextReader)
+}
+
+// ioForSalt returns the IO for the key derived from salt, deriving it unless it is cached. The
+// derivation takes 64 MiB and tens of milliseconds, so it runs outside the lock, once per salt.
+func (p *PassphraseIO) ioForSalt(salt [passphraseSaltSize]byte) (IO, error) {
+	if salt == p.salt {
+		return p.writer, nil
+	}
+	p.mu.Lock()
+	if el, ok := p.keys[salt]; ok {
+		p.lru.MoveToFront(el)
+		p.mu.Unlock()
+		return el.Value.(*passphraseKey).io, nil
+	}
+	p.mu.Unlock()
+
+	wrap, err, _ := p.derivations.Do(string(salt[:]), func() (any, error) {
+		wrap, err := NewCipherIO(derivePassphraseKey(p.passphrase, salt[:]), p.cipher)
+		if err != nil {
+			return nil, err
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if _, ok := p.keys[salt]; !ok {
+			p.keys[salt] = p.lru.PushFront(&passphraseKey{salt: salt, io: wrap})
+		}
+		for p.lru.Len() > passphraseKeyCacheSize {
+			oldest := p.lru.Remove(p.lru.Back()).(*passphraseKey)
+			delete(p.keys, oldest.salt)
+		}
+		return wrap, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wrap.(IO), nil
+}
+
+// provisionPassphrase returns the IO for encryption_passphrase, which replaces encryption_key.
+// Commands don't create the shared salt, they use a random one.
+func (s *S3Storage) provisionPassphrase(ctx context.Context, cli bool) (IO, error) {
+	if s.EncryptionKey != "" || s.EncryptionKeySource != "" {
+		return nil, errors.New("encryption_passphrase and encryption_key or encryption_key_source are mutually exclusive")
+	}
+	if s.EncryptionSalt != "" {
+		if len(s.EncryptionSalt) < passphraseMinSaltSize {
+			return nil, fmt.Errorf("encryption_salt must have at least %d bytes", passphraseMinSaltSize)
+		}
+		s.logger.Info("encrypting with key derived from passphrase and configured salt")
+		return NewCipherIO(derivePassphraseKey(s.EncryptionPassphrase, []byte(s.EncryptionSalt)), s.EncryptionCipher)
+	}
+	var (
+		p   *PassphraseIO
+		err error
+	)
+	if cli {
+		p, err = NewPassphraseIO(s.EncryptionPassphrase, s.EncryptionCipher)
+	} else if salt, saltErr := s.passphraseSalt(ctx); saltErr == nil {
+		p, err = newPassphraseIO(s.EncryptionPassphrase, s.EncryptionCipher, salt)
+	} else {
+		s.logger.Warn("reading or creating the shared passphrase salt failed, using a random salt", zap.Error(saltErr))
+		p, err = NewPassphraseIO(s.EncryptionPassphrase, s.EncryptionCipher)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Info("encrypting with key derived from passphrase and salt", zap.String("salt", fmt.Sprintf("%x", p.salt)))
+	return p, nil
+}
+
+// passphraseSalt returns the salt stored for all instances, creating it if there is none yet.
+func (s *S3Storage) passphraseSalt(ctx context.Context) ([passphraseSaltSize]byte, error) {
+	salt, err := s.readPassphraseSalt(ctx)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return salt, err
+	}
+	if _, err := io.ReadFull(rand.Reader, salt[:]); err != nil {
+		return salt, fmt.Errorf("generating salt: %w", err)
+	}
+	s3Key := s.s3ObjectKey(passphraseSaltKey)
+	putCtx, cancel := s.opContext(ctx)
+	defer cancel()
+	_, err = s.Client.PutObject(putCtx, &awss3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(s3Key),
+		Body:        bytes.NewReader(salt[:]),
+		IfNoneMatch: aws.String("*"),
+	})
+	if isPreconditionFailed(err) {
+		return s.readPassphraseSalt(ctx) // Created by another instance meanwhile
+	}
+	if err != nil {
+		return salt, fmt.Errorf("storing passphrase salt (s3://%s/%s): %w", s.Bucket, s3Key, s3Error(err))
+	}
+	return salt, nil
+}
+
+// readPassphraseSalt reads the stored salt; fs.ErrNotExist if there is none.
+func (s *S3Storage) readPassphraseSalt(ctx context.Context) ([passphraseSaltSize]byte, error) {
+	var salt [passphraseSaltSize]byte
+	s3Key := s.s3ObjectKey(passphraseSaltKey)
+	getCtx, cancel := s.readContext(ctx)
+	defer cancel()
+	out, err := s.Client.GetObject(getCtx, &awss3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s3Key),
+	})
+	if s.isNotFound(err) {
+		return salt, fs.ErrNotExist
+	}
+	if err != nil {
+		return salt, fmt.Errorf("reading passphrase salt (s3://%s/%s): %w", s.Bucket, s3Key, s3Error(err))
+	}
+	defer out.Body.Close()
+	if _, err := io.ReadFull(out.Body, salt[:]); err != nil {
+		return salt, fmt.Errorf("reading passphrase salt (s3://%s/%s): %w", s.Bucket, s3Key, err)
+	}
+	return salt, nil
+}
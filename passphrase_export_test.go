@@ -0,0 +1,8 @@
+package s3
+
+// PassphraseSalt exposes the salt of the objects written with encryption_passphrase to the tests
+// of package s3_test.
+func (s *S3Storage) PassphraseSalt() []byte {
+	p := s.iowrap.(*CompressingIO).Inner.(*PassphraseIO)
+	return p.salt[:]
+}
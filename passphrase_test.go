@@ -0,0 +1,59 @@
+package s3
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestPassphraseIO(t *testing.T) {
+	writer, err := NewPassphraseIO("correct horse battery staple", cipherAESGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewPassphraseIO("correct horse battery staple", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writer.salt == reader.salt {
+		t.Fatal("instances share a salt")
+	}
+
+	r, n, err := writer.ByteReader([]byte("private key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, _ := io.ReadAll(r)
+	if int64(len(ciphertext)) != n || !bytes.HasPrefix(ciphertext, []byte(passphraseMagic)) {
+		t.Fatalf("ciphertext of %d bytes (length %d) without header", len(ciphertext), n)
+	}
+	plaintext, err := io.ReadAll(reader.WrapReader(bytes.NewReader(ciphertext)))
+	if err != nil || string(plaintext) != "private key" {
+		t.Errorf("decrypted with another instance's salt: %q, %v", plaintext, err)
+	}
+
+	wrong, err := NewPassphraseIO("wrong passphrase", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(wrong.WrapReader(bytes.NewReader(ciphertext))); err == nil {
+		t.Error("decrypted with a wrong passphrase")
+	}
+
+	// A configured salt yields a plain key, readable with encryption_key
+	key := derivePassphraseKey("correct horse battery staple", []byte("fixed salt"))
+	fixed, err := NewIO(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != derivePassphraseKey("correct horse battery staple", []byte("fixed salt")) {
+		t.Error("key derivation is not deterministic")
+	}
+	r, _, err = fixed.ByteReader([]byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, err := io.ReadAll(fixed.WrapReader(r)); err != nil || string(plaintext) != "value" {
+		t.Errorf("round trip with derived key = %q, %v", plaintext, err)
+	}
+}
@@ -0,0 +1,42 @@
+package s3
+
+import (
+	"reflect"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// resolvePlaceholders replaces the global placeholders, e.g. {env.S3_BUCKET}, in all string
+// fields of the configuration, including those of nested blocks, so that the configuration can be
+// environment-driven. Unknown placeholders are left as they are. The prefix has its own resolution
+// in resolvePrefix, and maps resolve their keys and values where they are used.
+func (s *S3Storage) resolvePlaceholders() {
+	prefix := s.Prefix
+	resolveStrings(caddy.NewReplacer(), reflect.ValueOf(s).Elem())
+	s.Prefix = prefix
+}
+
+// resolveStrings replaces placeholders in the exported, JSON-configurable strings of v.
+func resolveStrings(repl *caddy.Replacer, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(repl.ReplaceKnown(v.String(), ""))
+	case reflect.Pointer:
+		if !v.IsNil() {
+			resolveStrings(repl, v.Elem())
+		}
+	case reflect.Slice:
+		for i := range v.Len() {
+			resolveStrings(repl, v.Index(i))
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := range t.NumField() {
+			field := t.Field(i)
+			if !field.IsExported() || field.Tag.Get("json") == "-" {
+				continue
+			}
+			resolveStrings(repl, v.Field(i))
+		}
+	}
+}
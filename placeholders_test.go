@@ -0,0 +1,36 @@
+package s3
+
+import "testing"
+
+func TestResolvePlaceholders(t *testing.T) {
+	t.Setenv("S3TEST_BUCKET", "env-bucket")
+	t.Setenv("S3TEST_REGION", "eu-west-1")
+	t.Setenv("S3TEST_VAULT", "https://vault.example.com")
+
+	s := &S3Storage{
+		Bucket:            "{env.S3TEST_BUCKET}",
+		Region:            "{env.S3TEST_REGION}",
+		Endpoint:          "https://{env.S3TEST_REGION}.example.com",
+		Prefix:            "certs/{tenant}",
+		SharedConfigFiles: []string{"/etc/{env.S3TEST_REGION}/config"},
+		VaultTransit:      &VaultTransitConfig{Address: "{env.S3TEST_VAULT}"},
+		Routes:            []RouteConfig{{Prefix: "ocsp", Bucket: "{env.S3TEST_BUCKET}-ocsp"}},
+		ContentType:       "{unknown.placeholder}",
+	}
+	s.resolvePlaceholders()
+
+	for _, tc := range []struct{ name, got, want string }{
+		{"bucket", s.Bucket, "env-bucket"},
+		{"region", s.Region, "eu-west-1"},
+		{"endpoint", s.Endpoint, "https://eu-west-1.example.com"},
+		{"prefix", s.Prefix, "certs/{tenant}"}, // Resolved by resolvePrefix
+		{"shared config file", s.SharedConfigFiles[0], "/etc/eu-west-1/config"},
+		{"vault address", s.VaultTransit.Address, "https://vault.example.com"},
+		{"route bucket", s.Routes[0].Bucket, "env-bucket-ocsp"},
+		{"content type", s.ContentType, "{unknown.placeholder}"},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s = %q, want %q", tc.name, tc.got, tc.want)
+		}
+	}
+}
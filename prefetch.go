@@ -0,0 +1,143 @@
+package s3
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+// With renewal_prefetch, an index of certificate expirations is kept, and the certificate, key and
+// metadata of each certificate are loaded shortly before its renewal window opens. This warms the
+// caches ahead of the renewal, spread out over time, instead of CertMagic hitting S3 for many
+// certificates sharing an expiry date all at once.
+
+const (
+	prefetchCheckInterval = time.Minute
+	prefetchPause         = 100 * time.Millisecond // Between the prefetches of one check
+)
+
+// certExpiry is the index entry of one certificate.
+type certExpiry struct {
+	renewalStart time.Time
+	prefetched   bool
+}
+
+// renewalPrefetcher maintains the expiration index, keyed by certificate (.crt) key.
+type renewalPrefetcher struct {
+	ahead time.Duration // How long before the renewal window opens to prefetch
+
+	mu    sync.Mutex
+	certs map[string]*certExpiry
+}
+
+// isCertificateKey reports whether key holds a site certificate.
+func isCertificateKey(key string) bool {
+	return strings.HasPrefix(key, "certificates/") && strings.HasSuffix(key, ".crt")
+}
+
+// renewalStart parses the first certificate of a PEM bundle and returns when CertMagic's default
+// renewal window for it opens.
+func renewalStart(certPEM []byte) (time.Time, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return time.Time{}, errors.New("no PEM data")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotAfter.Add(-time.Duration(float64(lifetime) * certmagic.DefaultRenewalWindowRatio)), nil
+}
+
+// update records the certificate stored at key.
+func (p *renewalPrefetcher) update(key string, certPEM []byte) {
+	start, err := renewalStart(certPEM)
+	if err != nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.certs, key)
+		return
+	}
+	p.set(key, start)
+}
+
+// set records the renewal start of the certificate at key.
+func (p *renewalPrefetcher) set(key string, start time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.certs[key] = &certExpiry{renewalStart: start}
+}
+
+// remove drops key, and everything below it, from the index.
+func (p *renewalPrefetcher) remove(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for k := range p.certs {
+		if k == key || strings.HasPrefix(k, key+"/") {
+			delete(p.certs, k)
+		}
+	}
+}
+
+// due returns the certificates to prefetch now, earliest renewal first, and marks them prefetched.
+func (p *renewalPrefetcher) due(now time.Time) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var keys []string
+	for k, c := range p.certs {
+		if !c.prefetched && !now.Before(c.renewalStart.Add(-p.ahead)) {
+			c.prefetched = true
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return p.certs[keys[i]].renewalStart.Before(p.certs[keys[j]].renewalStart) })
+	return keys
+}
+
+// startRenewalPrefetch builds the index in the background and then prefetches due certificates
+// until ctx is done.
+func (s *S3Storage) startRenewalPrefetch(ctx context.Context) {
+	s.prefetcher = &renewalPrefetcher{ahead: time.Duration(s.RenewalPrefetch), certs: make(map[string]*certExpiry)}
+	go func() {
+		if _, err := s.buildExpirationIndex(ctx); err != nil {
+			s.logger.Warn("building certificate expiration index failed", zap.Error(err))
+		}
+		ticker := time.NewTicker(prefetchCheckInterval)
+		defer ticker.Stop()
+		for {
+			s.prefetchDue(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// prefetchDue loads the certificate, key and metadata of certificates whose renewal is near.
+func (s *S3Storage) prefetchDue(ctx context.Context) {
+	for _, certKey := range s.prefetcher.due(time.Now()) {
+		base := strings.TrimSuffix(certKey, ".crt")
+		for _, key := range []string{certKey, base + ".key", base + ".json"} {
+			if _, err := s.Load(ctx, key); err != nil {
+				s.logger.Debug("prefetch failed", zap.String("key", key), zap.Error(err))
+			}
+		}
+		s.logger.Debug("prefetched certificate ahead of renewal", zap.String("key", certKey))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(prefetchPause):
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package s3
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRenewalPrefetcherDue(t *testing.T) {
+	notBefore := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := &renewalPrefetcher{ahead: 24 * time.Hour, certs: make(map[string]*certExpiry)}
+	for domain, lifetime := range map[string]time.Duration{"a.com": 90 * 24 * time.Hour, "b.com": 30 * 24 * time.Hour} {
+		res, err := selfSignedResource(domain, "seed", notBefore, notBefore.Add(lifetime))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.update("certificates/acme/"+domain+"/"+domain+".crt", res.CertificatePEM)
+	}
+
+	// b.com's renewal window opens after 20 of its 30 days, a.com's after 60 of 90 days.
+	if due := p.due(notBefore.Add(18 * 24 * time.Hour)); len(due) != 0 {
+		t.Errorf("nothing should be due yet, got %v", due)
+	}
+	if due := p.due(notBefore.Add(19 * 24 * time.Hour)); !slices.Equal(due, []string{"certificates/acme/b.com/b.com.crt"}) {
+		t.Errorf("unexpected due certificates %v", due)
+	}
+	if due := p.due(notBefore.Add(80 * 24 * time.Hour)); !slices.Equal(due, []string{"certificates/acme/a.com/a.com.crt"}) {
+		t.Errorf("certificates must be prefetched once, got %v", due)
+	}
+
+	p.remove("certificates/acme")
+	if len(p.certs) != 0 {
+		t.Errorf("remove left %d entries", len(p.certs))
+	}
+}
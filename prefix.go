@@ -0,0 +1,148 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// The prefix may be a template, e.g. "certs/{tenant}" or "certs/{env.TENANT}", so that every tenant
+// of a multi-tenant deployment gets its own prefix for IAM scoping. Besides the global placeholders,
+// it may use the names defined by prefix_vars. Placeholders are resolved during Provision; an
+// unknown one is an error rather than silently becoming part of the key.
+//
+// Library users serving many tenants from one configuration call WithPrefix for each of them.
+
+// goConfig holds the options which can only be set from Go and are therefore missing from the
+// JSON configuration.
+type goConfig struct {
+	client              S3API
+	keyProvider         KeyProvider
+	apiOptions          []func(*middleware.Stack) error
+	clientOptions       []func(*awss3.Options)
+	onCertificateUpdate func(ctx context.Context, issuerKey, name string)
+}
+
+// saveConfig keeps the configuration as given, before Provision resolves it, for WithPrefix.
+func (s *S3Storage) saveConfig() error {
+	config, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("saving configuration: %w", err)
+	}
+	s.config = config
+	s.goConfig = goConfig{
+		client:              s.Client,
+		keyProvider:         s.KeyProvider,
+		apiOptions:          s.APIOptions,
+		clientOptions:       s.ClientOptions,
+		onCertificateUpdate: s.OnCertificateUpdate,
+	}
+	return nil
+}
+
+// defaultPrefix is the prefix of storages configured without prefix, unless no_prefix is set.
+// It applies to JSON and Caddyfile configurations alike.
+const defaultPrefix = "certmagic"
+
+// resolvePrefix applies the default prefix, resolves the placeholders of the prefix template
+// and normalizes it.
+func (s *S3Storage) resolvePrefix() error {
+	switch {
+	case s.NoPrefix && s.Prefix != "":
+		return fmt.Errorf("prefix '%s' and no_prefix are mutually exclusive", s.Prefix)
+	case s.NoPrefix:
+		return nil
+	case s.Prefix == "":
+		s.Prefix = defaultPrefix
+	}
+	repl := caddy.NewReplacer()
+	for name, value := range s.PrefixVars {
+		repl.Set(name, repl.ReplaceKnown(value, ""))
+	}
+	original := s.Prefix
+	prefix, err := repl.ReplaceOrErr(s.Prefix, false, true)
+	if err != nil {
+		return fmt.Errorf("prefix '%s': %w", s.Prefix, err)
+	}
+	s.Prefix = strings.Trim(prefix, "/")
+	if s.Prefix == "" { // Rather than writing to the bucket root by accident
+		return fmt.Errorf("prefix '%s' is empty once resolved; set no_prefix to use the bucket root", original)
+	}
+	return nil
+}
+
+// warnRootData warns if the default prefix applies while the bucket root holds CertMagic data,
+// e.g. written with no_prefix or by an earlier version, which would silently be ignored. It runs
+// in the background, as Provision doesn't otherwise require access to the bucket.
+func (s *S3Storage) warnRootData(ctx context.Context) {
+	for _, dir := range []string{"certificates/", "acme/"} {
+		listCtx, cancel := s.listContext(ctx)
+		out, err := s.Client.ListObjectsV2(listCtx, &awss3.ListObjectsV2Input{
+			Bucket:  aws.String(s.Bucket),
+			Prefix:  aws.String(dir),
+			MaxKeys: aws.Int32(1),
+		})
+		cancel()
+		if err != nil {
+			s.logger.Debug("checking the bucket root for data failed", zap.Error(err))
+			return
+		}
+		if len(out.Contents) > 0 {
+			s.logger.Warn("CertMagic data found at the bucket root, but the default prefix is used; "+
+				"set no_prefix to keep using it, or the prefix explicitly to ignore it",
+				zap.String("bucket", s.Bucket),
+				zap.String("found", aws.ToString(out.Contents[0].Key)),
+				zap.String("prefix", s.Prefix))
+			return
+		}
+	}
+}
+
+// WithPrefix returns a new storage for the directory sub below the prefix, e.g. for a tenant.
+// It has the configuration the storage was provisioned with, including the options set from Go
+// such as Client and KeyProvider, but its own caches and locks, and its own client unless Client
+// was given; the local cache and write journal, if any, move to a subdirectory, and the sidecar and cache
+// invalidation endpoints stay with the parent. The returned storage is provisioned and must be
+// cleaned up by the caller.
+func (s *S3Storage) WithPrefix(sub string) (*S3Storage, error) {
+	if s.config == nil {
+		return nil, fmt.Errorf("s3 storage: WithPrefix called before Provision")
+	}
+	sub = strings.Trim(sub, "/")
+	if sub == "" || slices.Contains(strings.Split(sub, "/"), "..") || path.Clean(sub) != sub {
+		return nil, fmt.Errorf("s3 storage: invalid sub-prefix '%s'", sub)
+	}
+
+	child := new(S3Storage)
+	if err := json.Unmarshal(s.config, child); err != nil {
+		return nil, fmt.Errorf("s3 storage: copying configuration: %w", err)
+	}
+	child.Client = s.goConfig.client // A client created by Provision is not shared
+	child.KeyProvider = s.goConfig.keyProvider
+	child.APIOptions = s.goConfig.apiOptions
+	child.ClientOptions = s.goConfig.clientOptions
+	child.OnCertificateUpdate = s.goConfig.onCertificateUpdate
+	child.Prefix = path.Join(s.Prefix, sub)
+	child.NoPrefix = false // The sub-prefix replaces the bucket root
+	child.Sidecar, child.CacheInvalidation = nil, nil
+	if child.LocalCacheDir != "" {
+		child.LocalCacheDir = filepath.Join(child.LocalCacheDir, filepath.FromSlash(sub))
+	}
+	if child.JournalDir != "" { // Otherwise the child would flush the parent's pending writes
+		child.JournalDir = filepath.Join(child.JournalDir, filepath.FromSlash(sub))
+	}
+	if err := child.Provision(s.caddyCtx); err != nil {
+		return nil, err
+	}
+	return child, nil
+}
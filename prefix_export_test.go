@@ -0,0 +1,13 @@
+package s3
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// WarnRootData runs the check for data at the bucket root with logger, for the tests of package s3_test.
+func (s *S3Storage) WarnRootData(ctx context.Context, logger *zap.Logger) {
+	s.logger = logger
+	s.warnRootData(ctx)
+}
@@ -0,0 +1,130 @@
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/caddyserver/caddy/v2"
+	s3 "github.com/cvhome-saas/certmagic-s3"
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestStorageWithPrefix(t *testing.T) {
+	srv := s3test.NewServer(t)
+	storage := srv.Storage(t, func(s *s3.S3Storage) {
+		s.Prefix = "/certs/{tenant}/"
+		s.PrefixVars = map[string]string{"tenant": "acme"}
+	})
+	if storage.Prefix != "certs/acme" {
+		t.Fatalf("prefix = %q, want certs/acme", storage.Prefix)
+	}
+	ctx := context.Background()
+	if err := storage.Store(ctx, "key", []byte("parent")); err != nil {
+		t.Fatal(err)
+	}
+
+	child, err := storage.WithPrefix("eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = child.Cleanup() })
+	if child.Exists(ctx, "key") {
+		t.Error("child sees the parent's key")
+	}
+	if err := child.Store(ctx, "key", []byte("child")); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"certs/acme/key", "certs/acme/eu/key"} {
+		if _, err := storage.Client.HeadObject(ctx, &awss3.HeadObjectInput{Bucket: aws.String(srv.Bucket), Key: aws.String(key)}); err != nil {
+			t.Errorf("object %s: %v", key, err)
+		}
+	}
+	if value, err := storage.Load(ctx, "key"); err != nil || string(value) != "parent" {
+		t.Errorf("parent Load = %q, %v", value, err)
+	}
+
+	for _, sub := range []string{"", "..", "eu/../us"} {
+		if _, err := storage.WithPrefix(sub); err == nil {
+			t.Errorf("WithPrefix(%q) succeeded", sub)
+		}
+	}
+	unknown := &s3.S3Storage{Bucket: srv.Bucket, Endpoint: srv.URL, Region: "us-east-1", Prefix: "certs/{tenant}"}
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	if err := unknown.Provision(caddyCtx); err == nil {
+		t.Error("expected unknown placeholder in prefix to be rejected")
+	}
+}
+
+func TestStorageWithPrefixGoConfig(t *testing.T) {
+	provider, err := s3.NewStaticKeyProvider("12345678123456781234567812345678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var updates []string
+	storage, client := s3test.NewFakeStorage(t, func(s *s3.S3Storage) {
+		s.KeyProvider = provider
+		s.OnCertificateUpdate = func(ctx context.Context, issuerKey, name string) { updates = append(updates, name) }
+	})
+	child, err := storage.WithPrefix("eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = child.Cleanup() })
+
+	if child.Client != storage.Client {
+		t.Errorf("child client = %T, want the parent's %T", child.Client, storage.Client)
+	}
+	if child.KeyProvider != provider || child.OnCertificateUpdate == nil {
+		t.Error("child lacks the parent's KeyProvider or OnCertificateUpdate")
+	}
+	ctx := context.Background()
+	if err := child.Store(ctx, "key", []byte("secret value")); err != nil {
+		t.Fatal(err)
+	}
+	out, err := client.GetObject(ctx, &awss3.GetObjectInput{Bucket: aws.String(s3test.DefaultBucket), Key: aws.String("certmagic/eu/key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(out.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("secret value")) {
+		t.Error("child stored the value in clear text")
+	}
+	if value, err := child.Load(ctx, "key"); err != nil || string(value) != "secret value" {
+		t.Errorf("child Load = %q, %v", value, err)
+	}
+}
+
+func TestStorageWarnRootData(t *testing.T) {
+	storage, fake := s3test.NewFakeStorage(t)
+	ctx := context.Background()
+	core, logs := observer.New(zapcore.WarnLevel)
+	storage.WarnRootData(ctx, zap.New(core))
+	if logs.Len() != 0 {
+		t.Errorf("warned without data at the bucket root: %v", logs.All())
+	}
+
+	root, _ := s3test.NewFakeStorage(t, func(s *s3.S3Storage) {
+		s.Client = fake
+		s.Prefix = ""
+		s.NoPrefix = true
+	})
+	if err := root.Store(ctx, "certificates/acme/example.com/example.com.crt", []byte("certificate")); err != nil {
+		t.Fatal(err)
+	}
+	storage.WarnRootData(ctx, zap.New(core))
+	if logs.Len() != 1 || !strings.Contains(logs.All()[0].Message, "no_prefix") {
+		t.Errorf("expected a warning pointing to no_prefix, got %v", logs.All())
+	}
+}
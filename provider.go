@@ -0,0 +1,181 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"go.uber.org/zap"
+)
+
+// Supported provider profiles.
+const (
+	providerAWS = "aws"
+	providerGCS = "gcs"
+	providerB2  = "b2"
+)
+
+// Google Cloud Storage's XML API accepts HMAC keys as S3 credentials, but differs from S3 in ways
+// the SDK doesn't expect: missing keys are plain 404 responses without an S3 error type, multi-object
+// delete is not implemented, conditional PUTs use x-goog-* headers, the SDK's default request
+// checksums are rejected, and storage classes have other names.
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// gcsStorageClasses are the storage classes of Google Cloud Storage.
+var gcsStorageClasses = []string{"STANDARD", "NEARLINE", "COLDLINE", "ARCHIVE"}
+
+// Backblaze B2's S3 API rejects the SDK's default request checksums, has neither storage classes
+// nor object tags, doesn't honor conditional PUTs, answers HEAD requests for missing keys with a
+// bare 404 and throttles with 429 responses well below S3's request rates. The b2 profile strips
+// the unsupported parameters, bounds the requests in flight and backs off adaptively by default.
+const (
+	b2MaxConcurrentRequests = 10 // Default of max_concurrent_requests
+	b2MaxRetries            = 5  // Default of max_retries
+)
+
+// applyProvider validates the provider profile and fills in its defaults.
+func (s *S3Storage) applyProvider() error {
+	switch s.Provider {
+	case "", providerAWS:
+		return nil
+	case providerGCS:
+	case providerB2:
+		return s.applyB2Provider()
+	default:
+		return fmt.Errorf("unsupported provider '%s' (expected '%s', '%s' or '%s')", s.Provider, providerAWS, providerGCS, providerB2)
+	}
+	if s.Endpoint == "" {
+		s.Endpoint = gcsEndpoint
+	}
+	if s.Region == "" {
+		s.Region = "auto"
+	}
+	if s.Manifest {
+		return errors.New("manifest requires conditional PUTs, which provider gcs does not support")
+	}
+	if s.SSECustomerKey != "" || s.SSECustomerKeyFile != "" {
+		return errors.New("sse_customer_key is not supported by provider gcs")
+	}
+	if len(s.ObjectTags) > 0 {
+		return errors.New("object_tags are not supported by provider gcs; use object_metadata")
+	}
+	if s.StorageClass != "" && !slices.Contains(gcsStorageClasses, s.StorageClass) {
+		return fmt.Errorf("unsupported storage_class '%s' for provider gcs (expected one of %v)", s.StorageClass, gcsStorageClasses)
+	}
+	s.logger.Info("using Google Cloud Storage interop profile", zap.String("endpoint", s.Endpoint))
+	return nil
+}
+
+// applyB2Provider validates the options for Backblaze B2 and fills in its defaults.
+func (s *S3Storage) applyB2Provider() error {
+	if s.Endpoint == "" {
+		if s.Region == "" {
+			return errors.New("provider b2 requires the region of the bucket (e.g. us-west-004) or an endpoint")
+		}
+		s.Endpoint = "https://s3." + s.Region + ".backblazeb2.com"
+	}
+	if s.Manifest {
+		return errors.New("manifest requires conditional PUTs, which provider b2 does not support")
+	}
+	if s.ChecksumAlgorithm != "" {
+		return errors.New("checksum_algorithm is not supported by provider b2")
+	}
+	if len(s.ObjectTags) > 0 {
+		s.logger.Warn("object_tags are not supported by provider b2 and are not sent; use object_metadata")
+	}
+	if s.StorageClass != "" && s.StorageClass != string(types.StorageClassStandard) {
+		s.logger.Warn("storage_class is not supported by provider b2 and is not sent", zap.String("storage_class", s.StorageClass))
+	}
+	if s.MaxConcurrentRequests == 0 {
+		s.MaxConcurrentRequests = b2MaxConcurrentRequests
+	}
+	if s.MaxRetries == 0 {
+		s.MaxRetries = b2MaxRetries
+	}
+	if s.RetryMode == "" {
+		s.RetryMode = retryModeAdaptive
+	}
+	s.logger.Info("using Backblaze B2 profile", zap.String("endpoint", s.Endpoint),
+		zap.Int("max_concurrent_requests", s.MaxConcurrentRequests), zap.String("retry_mode", s.RetryMode))
+	return nil
+}
+
+// isGCS reports whether the gcs provider profile is active.
+func (s *S3Storage) isGCS() bool {
+	return s.Provider == providerGCS
+}
+
+// isB2 reports whether the b2 provider profile is active.
+func (s *S3Storage) isB2() bool {
+	return s.Provider == providerB2
+}
+
+// providerClientOptions adapts S3 clients to the provider.
+func (s *S3Storage) providerClientOptions(o *awss3.Options) {
+	if s.isGCS() || s.isB2() {
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	}
+	if s.isB2() {
+		o.APIOptions = append(o.APIOptions, registerB2Strip)
+	}
+}
+
+// registerB2Strip adds the middleware removing the parameters B2 rejects to a client's stack.
+func registerB2Strip(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("B2StripUnsupported",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			stripB2Unsupported(in.Parameters)
+			return next.HandleInitialize(ctx, in)
+		}), middleware.After) // After the middlewares of other options have set them
+}
+
+// stripB2Unsupported clears the storage class and tags of writes.
+func stripB2Unsupported(params any) {
+	switch in := params.(type) {
+	case *awss3.PutObjectInput:
+		in.StorageClass, in.Tagging = "", nil
+	case *awss3.CopyObjectInput:
+		in.StorageClass, in.Tagging, in.TaggingDirective = "", nil, ""
+	case *awss3.CreateMultipartUploadInput:
+		in.StorageClass, in.Tagging = "", nil
+	}
+}
+
+// b2Throttled classifies B2's 429 Too Many Requests responses as retryable, which the SDK
+// doesn't by default.
+func b2Throttled(err error) aws.Ternary {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusTooManyRequests {
+		return aws.TrueTernary
+	}
+	return aws.UnknownTernary
+}
+
+// isNotFound reports whether err means that the requested object does not exist. With providers
+// gcs and b2, any 404 response other than for a missing bucket counts, as they don't send S3 error
+// codes for HEAD requests.
+func (s *S3Storage) isNotFound(err error) bool {
+	var nsk *types.NoSuchKey
+	var nf *types.NotFound // Some S3-compatibles (like MinIO) return NotFound for HeadObject
+	if errors.As(err, &nsk) || errors.As(err, &nf) {
+		return true
+	}
+	if !s.isGCS() && !s.isB2() {
+		return false
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchBucket" {
+		return false
+	}
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound
+}
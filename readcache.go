@@ -0,0 +1,209 @@
+package s3
+
+import (
+	"container/list"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultReadCacheTTL = time.Minute
+	// overBudgetLogInterval limits how often an undersized cache is reported.
+	overBudgetLogInterval = 10 * time.Minute
+)
+
+// CacheStats describes the in-memory read cache.
+type CacheStats struct {
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	Budget    int64  `json:"budget"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"` // Fresh entries dropped to stay within the budget
+
+	Invalidations uint64 `json:"invalidations,omitempty"` // Entries dropped by bucket notifications
+}
+
+// readCache is an LRU cache of loaded values, bounded by the total size of the values.
+// Entries expire after ttl, so writes of other instances become visible.
+type readCache struct {
+	budget int64
+	ttl    time.Duration
+	logger *zap.Logger
+
+	mu            sync.Mutex
+	lru           *list.List // Front is most recently used
+	items         map[string]*list.Element
+	stats         CacheStats
+	lastBudgetLog time.Time
+	gen           uint64 // Incremented by remove and invalidate
+}
+
+type readCacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func newReadCache(budget int64, ttl time.Duration, logger *zap.Logger) *readCache {
+	if ttl <= 0 {
+		ttl = defaultReadCacheTTL
+	}
+	return &readCache{
+		budget: budget,
+		ttl:    ttl,
+		logger: logger,
+		lru:    list.New(),
+		items:  make(map[string]*list.Element),
+		stats:  CacheStats{Budget: budget},
+	}
+}
+
+// get returns a fresh cached value.
+func (c *readCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if ok && time.Now().Before(el.Value.(*readCacheEntry).expires) {
+		c.lru.MoveToFront(el)
+		c.stats.Hits++
+		return el.Value.(*readCacheEntry).value, true
+	}
+	if ok {
+		c.removeElement(el)
+	}
+	c.stats.Misses++
+	return nil, false
+}
+
+// generation returns the generation to pass to put for a value about to be loaded.
+func (c *readCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// put caches a value loaded from generation gen on, evicting the least recently used entries to
+// stay within the budget. A value is not cached if a key was removed or invalidated since, as the
+// load may have returned what a concurrent write replaced.
+func (c *readCache) put(key string, value []byte, gen uint64) {
+	size := int64(len(value))
+	if size > c.budget {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	now := time.Now()
+	for c.stats.Bytes+size > c.budget {
+		oldest := c.lru.Back()
+		if now.Before(oldest.Value.(*readCacheEntry).expires) {
+			c.stats.Evictions++
+			c.reportOverBudget(now)
+		}
+		c.removeElement(oldest)
+	}
+	c.items[key] = c.lru.PushFront(&readCacheEntry{key: key, value: value, expires: now.Add(c.ttl)})
+	c.stats.Bytes += size
+	c.stats.Entries++
+}
+
+// remove drops key and everything below it.
+func (c *readCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	prefix := key + "/"
+	for k, el := range c.items {
+		if strings.HasPrefix(k, prefix) {
+			c.removeElement(el)
+		}
+	}
+}
+
+// invalidate drops a key changed by another instance, counting it if it was cached.
+func (c *readCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+		c.stats.Invalidations++
+	}
+}
+
+func (c *readCache) removeElement(el *list.Element) {
+	e := c.lru.Remove(el).(*readCacheEntry)
+	delete(c.items, e.key)
+	c.stats.Bytes -= int64(len(e.value))
+	c.stats.Entries--
+}
+
+// reportOverBudget logs, at most once per interval, that fresh entries had to be evicted, which
+// means the working set does not fit into the budget. The caller must hold c.mu.
+func (c *readCache) reportOverBudget(now time.Time) {
+	if now.Sub(c.lastBudgetLog) < overBudgetLogInterval {
+		return
+	}
+	c.lastBudgetLog = now
+	c.logger.Warn("read cache working set exceeds budget; consider raising read_cache_size",
+		zap.Int64("budget", c.budget),
+		zap.Int("entries", c.stats.Entries),
+		zap.Uint64("evictions", c.stats.Evictions),
+		zap.Uint64("hits", c.stats.Hits),
+		zap.Uint64("misses", c.stats.Misses))
+}
+
+// snapshot returns the current statistics.
+func (c *readCache) snapshot() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// CacheStats returns the statistics of the in-memory read cache; ok is false if it is disabled.
+func (s *S3Storage) CacheStats() (stats CacheStats, ok bool) {
+	if s.cache == nil {
+		return CacheStats{}, false
+	}
+	return s.cache.snapshot(), true
+}
+
+// byteUnits are the suffixes accepted by parseByteSize.
+var byteUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"B", 1},
+}
+
+// parseByteSize parses sizes like "512KiB", "64MB" or "1048576".
+func parseByteSize(value string) (int64, error) {
+	number, factor := value, int64(1)
+	for _, u := range byteUnits {
+		if n, ok := strings.CutSuffix(value, u.suffix); ok {
+			number, factor = strings.TrimSpace(n), u.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", value)
+	}
+	return n * factor, nil
+}
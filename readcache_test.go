@@ -0,0 +1,54 @@
+package s3
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestReadCacheBudget(t *testing.T) {
+	c := newReadCache(10, time.Minute, zap.NewNop())
+	c.put("a", []byte("aaaa"), 0)
+	c.put("b", []byte("bbbb"), 0)
+	if _, ok := c.get("a"); !ok { // a is now more recently used than b
+		t.Fatal("expected hit for a")
+	}
+	c.put("c", []byte("cccc"), 0) // Exceeds the budget, so b is evicted
+	if _, ok := c.get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	c.put("huge", make([]byte, 11), 0) // Larger than the budget, not cached
+	c.remove("a")
+
+	stats := c.snapshot()
+	want := CacheStats{Entries: 1, Bytes: 4, Budget: 10, Hits: 1, Misses: 1, Evictions: 1}
+	if stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestReadCacheGeneration(t *testing.T) {
+	c := newReadCache(10, time.Minute, zap.NewNop())
+	gen := c.generation() // A load starts
+	c.remove("a")         // A write of a completes meanwhile
+	c.put("a", []byte("old"), gen)
+	if _, ok := c.get("a"); ok {
+		t.Error("value loaded before a write was cached")
+	}
+	c.put("a", []byte("new"), c.generation())
+	if value, ok := c.get("a"); !ok || string(value) != "new" {
+		t.Errorf("get = %q, %v", value, ok)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for in, want := range map[string]int64{"1024": 1024, "64MiB": 64 << 20, "10 KB": 10e3, "1GiB": 1 << 30} {
+		if got, err := parseByteSize(in); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	if _, err := parseByteSize("lots"); err == nil {
+		t.Error("expected error for invalid size")
+	}
+}
@@ -0,0 +1,221 @@
+package s3
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// With read_endpoint, read_bucket and/or read_region, Load, Stat, Exists and List use a second
+// client, e.g. for the nearest replica of a cross-region replicated bucket or a CDN in front of
+// it, while Store, Delete and locks stay on the primary bucket. Keys the read endpoint doesn't
+// have, e.g. because replication lags behind, and failed reads are tried on the primary again.
+//
+// A lagging replica may also return an older value of a key, or one that has been deleted. Keys
+// this instance wrote within readEndpointLag are therefore read from the primary bucket; values
+// other instances wrote meanwhile may still be stale, as may listings, which are not retried.
+//
+// The read endpoint, fallback_regions and replica are all read sources. The read endpoint is
+// read first; fallback regions (once the primary region is impaired) and the replica are read
+// after the primary failed, in that order, by loadFromFallbacks and existsInFallbacks.
+
+// readEndpointLag is how long written keys are read from the primary bucket. S3 Replication Time
+// Control replicates 99.99% of objects within 15 minutes.
+const readEndpointLag = 15 * time.Minute
+
+// readSource is a bucket reads can go to besides the primary one.
+type readSource struct {
+	name   string // For logs and errors, e.g. the region
+	client S3API
+	bucket string
+}
+
+// readEndpointConfigured reports whether reads go to a separate endpoint or bucket.
+func (s *S3Storage) readEndpointConfigured() bool {
+	return s.ReadEndpoint != "" || s.ReadBucket != "" || s.ReadRegion != ""
+}
+
+// provisionReadEndpoint creates the client of the read endpoint.
+func (s *S3Storage) provisionReadEndpoint() error {
+	switch {
+	case len(s.Routes) > 0:
+		return errors.New("read_endpoint, read_bucket and read_region cannot be combined with routes")
+	case len(s.Shards) > 0:
+		return errors.New("read_endpoint, read_bucket and read_region cannot be combined with shards")
+	}
+	region := cmp.Or(s.ReadRegion, s.Region)
+	endpoint := s.ReadEndpoint
+	if endpoint == "" && s.ReadRegion == "" {
+		endpoint = s.Endpoint
+	}
+	client, err := s.newClient(region, endpoint, s.AccessKeyID, s.SecretAccessKey, s.SessionToken)
+	if err != nil {
+		return fmt.Errorf("read endpoint: %w", err)
+	}
+	s.readSrc = &readSource{name: "read_endpoint", client: client, bucket: cmp.Or(s.ReadBucket, s.Bucket)}
+	s.recentWrites = &recentWrites{at: make(map[string]time.Time)}
+	s.logger.Info("reading from separate endpoint",
+		zap.String("bucket", s.readSrc.bucket),
+		zap.String("region", region),
+		zap.String("endpoint", endpoint))
+	return nil
+}
+
+// reader returns the client and bucket of List.
+func (s *S3Storage) reader() (S3API, string) {
+	if s.readSrc != nil {
+		return s.readSrc.client, s.readSrc.bucket
+	}
+	return s.Client, s.Bucket
+}
+
+// readFrom runs a read against the read endpoint, if configured and the key wasn't written
+// recently, and against the primary bucket if the read endpoint doesn't have the key or fails.
+// Each attempt gets its own read timeout; the returned function cancels the one answering.
+func readFrom[T any](ctx context.Context, s *S3Storage, op, key string, read func(ctx context.Context, client S3API, bucket string) (T, error)) (T, context.CancelFunc, error) {
+	if s.readSrc != nil && !s.recentWrites.has(key) {
+		readCtx, cancel := s.readContext(ctx)
+		out, err := read(readCtx, s.readSrc.client, s.readSrc.bucket)
+		if err == nil {
+			return out, cancel, nil
+		}
+		cancel()
+		if !s.isNotFound(err) {
+			s.recordError("read_endpoint_"+op, key, err)
+		}
+	}
+	readCtx, cancel := s.readContext(ctx)
+	out, err := read(readCtx, s.Client, s.Bucket)
+	return out, cancel, err
+}
+
+// noteWrite makes reads of a key go to the primary bucket for readEndpointLag.
+func (s *S3Storage) noteWrite(key string) {
+	if s.recentWrites != nil {
+		s.recentWrites.add(key)
+	}
+}
+
+// recentWrites remembers when keys were last written or deleted.
+type recentWrites struct {
+	mu      sync.Mutex
+	at      map[string]time.Time
+	pruneAt int // Size of at from which expired keys are removed
+}
+
+func (r *recentWrites) add(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if len(r.at) >= r.pruneAt {
+		for k, at := range r.at {
+			if now.Sub(at) > readEndpointLag {
+				delete(r.at, k)
+			}
+		}
+		r.pruneAt = max(2*len(r.at), 1024)
+	}
+	r.at[key] = now
+}
+
+// has reports whether the key or a directory containing it was written within readEndpointLag.
+func (r *recentWrites) has(key string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k := key; ; k = path.Dir(k) {
+		if at, ok := r.at[k]; ok && time.Since(at) <= readEndpointLag {
+			return true
+		}
+		if k == "." || k == "/" || k == path.Dir(k) {
+			return false
+		}
+	}
+}
+
+// fallbackSources returns the sources read after the primary failed: the fallback regions while
+// the primary region is impaired, and the replica.
+func (s *S3Storage) fallbackSources() []readSource {
+	var sources []readSource
+	if s.failoverActive() {
+		sources = append(sources, s.failover.regions...)
+	}
+	if s.replicaClient != nil {
+		sources = append(sources, readSource{name: "replica", client: s.replicaClient, bucket: s.Replica.Bucket})
+	}
+	return sources
+}
+
+// loadFromFallbacks reads a value from the first fallback source able to answer.
+func (s *S3Storage) loadFromFallbacks(ctx context.Context, sources []readSource, key, s3Key string) ([]byte, error) {
+	var lastErr error
+	for _, src := range sources {
+		data, err := s.loadFromSource(ctx, src, key, s3Key)
+		if err == nil || errors.Is(err, fs.ErrNotExist) {
+			s.opLogger(ctx, key).Debug("primary read failed, read from fallback",
+				zap.String("key", key), zap.String("source", src.name))
+			return data, err
+		}
+		s.recordError("fallback_load", key, err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (s *S3Storage) loadFromSource(ctx context.Context, src readSource, key, s3Key string) ([]byte, error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+	result, err := src.client.GetObject(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(src.bucket),
+		Key:    aws.String(s3Key),
+	})
+	if err != nil {
+		if s.isNotFound(err) {
+			return nil, fs.ErrNotExist
+		}
+		return nil, fmt.Errorf("loading %s (s3://%s/%s from %s): %w", key, src.bucket, s3Key, src.name, s3Error(err))
+	}
+	defer result.Body.Close()
+	if isTombstone(result.Metadata) {
+		return nil, fs.ErrNotExist
+	}
+	if isEmptySentinel(result.Metadata) {
+		return []byte{}, nil
+	}
+	data, err := io.ReadAll(s.iowrap.WrapReader(result.Body))
+	if err != nil {
+		return nil, fmt.Errorf("reading/decrypting %s from %s: %w", key, src.name, err)
+	}
+	return data, nil
+}
+
+// existsInFallbacks checks for a key in the first fallback source able to answer.
+func (s *S3Storage) existsInFallbacks(ctx context.Context, sources []readSource, key, s3Key string) (bool, error) {
+	var lastErr error
+	for _, src := range sources {
+		headCtx, cancel := s.readContext(ctx)
+		head, err := src.client.HeadObject(headCtx, &awss3.HeadObjectInput{
+			Bucket: aws.String(src.bucket),
+			Key:    aws.String(s3Key),
+		})
+		cancel()
+		if err == nil || s.isNotFound(err) {
+			return err == nil && !isTombstone(head.Metadata), nil
+		}
+		s.recordError("fallback_exists", key, err)
+		lastErr = err
+	}
+	return false, lastErr
+}
@@ -0,0 +1,64 @@
+package s3_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+
+	s3 "github.com/cvhome-saas/certmagic-s3"
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+func TestStorageReadBucket(t *testing.T) {
+	srv := s3test.NewServer(t)
+	srv.CreateBucket(t, "nearest-replica")
+	replica := srv.Storage(t, func(s *s3.S3Storage) { s.Bucket = "nearest-replica" })
+	primary := srv.Storage(t) // Another instance writing
+	storage := srv.Storage(t, func(s *s3.S3Storage) { s.ReadBucket = "nearest-replica" })
+	ctx := context.Background()
+
+	if err := primary.Store(ctx, "certificates/replicated", []byte("primary")); err != nil {
+		t.Fatal(err)
+	}
+	if err := replica.Store(ctx, "certificates/replicated", []byte("replica")); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.Store(ctx, "certificates/lagging", []byte("primary")); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := storage.Load(ctx, "certificates/replicated"); err != nil || string(value) != "replica" {
+		t.Errorf("load of replicated key = %q, %v; want the replica's value", value, err)
+	}
+	if value, err := storage.Load(ctx, "certificates/lagging"); err != nil || string(value) != "primary" {
+		t.Errorf("load of key missing in the replica = %q, %v; want the primary's value", value, err)
+	}
+	if !storage.Exists(ctx, "certificates/lagging") {
+		t.Error("key missing in the replica should exist")
+	}
+	if ki, err := storage.Stat(ctx, "certificates/replicated"); err != nil || ki.Size != int64(len("replica")) {
+		t.Errorf("stat = %+v, %v", ki, err)
+	}
+	if keys, err := storage.List(ctx, "certificates", true); err != nil || !slices.Equal(keys, []string{"certificates/replicated"}) {
+		t.Errorf("list = %v, %v; want the replica's keys", keys, err)
+	}
+
+	// Keys this instance wrote are read from the primary, which the replica lags behind.
+	if err := storage.Store(ctx, "certificates/replicated", []byte("renewed")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := storage.Load(ctx, "certificates/replicated"); err != nil || string(value) != "renewed" {
+		t.Errorf("load after store = %q, %v; want the stored value", value, err)
+	}
+	if value, err := replica.Load(ctx, "certificates/replicated"); err != nil || string(value) != "replica" {
+		t.Errorf("writes must go to the primary only, replica has %q, %v", value, err)
+	}
+	if err := storage.Delete(ctx, "certificates"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Load(ctx, "certificates/replicated"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("load of a key deleted with its directory = %v; want fs.ErrNotExist", err)
+	}
+}
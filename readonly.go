@@ -0,0 +1,46 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// With read_only, the storage never writes, locks or deletes in the bucket, e.g. for canary or
+// disaster recovery instances serving the certificates of a shared bucket. Store, Delete and Lock
+// fail with ErrReadOnly, so CertMagic keeps serving what it has but doesn't issue or renew;
+// Unlock has nothing to release and succeeds. Loading, listing and stat'ing work as usual.
+
+// ErrReadOnly is returned by writing operations of a read-only storage.
+var ErrReadOnly = errors.New("s3 storage is read-only")
+
+// checkWritable returns ErrReadOnly for a read-only storage.
+func (s *S3Storage) checkWritable(op, key string) error {
+	if !s.ReadOnly {
+		return nil
+	}
+	s.logger.Debug("refusing write in read-only mode", zap.String("op", op), zap.String("key", key))
+	return fmt.Errorf("%s %s: %w", op, key, ErrReadOnly)
+}
+
+// validateReadOnly rejects options which only make sense when writing.
+func (s *S3Storage) validateReadOnly() error {
+	if !s.ReadOnly {
+		return nil
+	}
+	if s.LockGCInterval > 0 {
+		return errors.New("lock_gc_interval cannot be used with read_only")
+	}
+	if s.Replica != nil {
+		return errors.New("replica cannot be used with read_only")
+	}
+	if s.VerifyWrites {
+		return errors.New("verify_writes cannot be used with read_only")
+	}
+	if s.JournalDir != "" {
+		return errors.New("journal_dir cannot be used with read_only")
+	}
+	s.logger.Info("read-only mode: writes, locks and deletes are refused")
+	return nil
+}
@@ -0,0 +1,161 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Keys contain the hostnames of the certificates, which operators may not want to reveal to log
+// aggregators. With log_redaction, every match of the configured patterns in key fields, error
+// messages and log messages is replaced by a stable hash, so log lines of one name can still be
+// correlated.
+
+// defaultRedactionPattern matches hostnames, including CertMagic's "wildcard_" prefix.
+const defaultRedactionPattern = `(?i)(?:[a-z0-9_*](?:[a-z0-9_-]{0,61}[a-z0-9])?\.)+[a-z][a-z0-9-]{0,62}`
+
+// redactedFields are the names of the log fields holding keys or certificate names.
+var redactedFields = map[string]bool{
+	"key": true, "s3_key": true, "s3_lock_key": true, "prefix": true, "s3_prefix": true,
+	"from": true, "to": true, "found": true, "name": true,
+}
+
+// keyFileExtensions are kept in the clear, so the files of one name hash alike.
+var keyFileExtensions = []string{".crt", ".key", ".json", ".lock"}
+
+// LogRedactionConfig replaces sensitive parts of keys in logs by hashes.
+type LogRedactionConfig struct {
+	// Patterns are regular expressions of the parts to redact; defaults to hostnames
+	Patterns []string `json:"patterns,omitempty"`
+	// Salt keys the hashes, so hostnames can't be recovered by hashing guesses; supports {env.*}
+	Salt string `json:"salt,omitempty"`
+}
+
+// keyRedactor hashes the matches of its patterns.
+type keyRedactor struct {
+	patterns []*regexp.Regexp
+	salt     []byte
+}
+
+func newKeyRedactor(cfg *LogRedactionConfig) (*keyRedactor, error) {
+	patterns := cfg.Patterns
+	if len(patterns) == 0 {
+		patterns = []string{defaultRedactionPattern}
+	}
+	r := &keyRedactor{salt: []byte(cfg.Salt)}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log_redaction pattern '%s': %w", p, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// redact replaces every match in s by "h-" followed by 12 hex digits of its keyed hash.
+func (r *keyRedactor) redact(s string) string {
+	for _, re := range r.patterns {
+		s = re.ReplaceAllStringFunc(s, func(match string) string {
+			ext := ""
+			for _, e := range keyFileExtensions {
+				if strings.HasSuffix(match, e) && len(match) > len(e) {
+					match, ext = strings.TrimSuffix(match, e), e
+					break
+				}
+			}
+			mac := hmac.New(sha256.New, r.salt)
+			mac.Write([]byte(match))
+			return "h-" + hex.EncodeToString(mac.Sum(nil))[:12] + ext
+		})
+	}
+	return s
+}
+
+// fields returns fields with keys and errors redacted.
+func (r *keyRedactor) fields(fields []zapcore.Field) []zapcore.Field {
+	out := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		switch {
+		case f.Type == zapcore.StringType && redactedFields[f.Key]:
+			f.String = r.redact(f.String)
+		case f.Type == zapcore.ErrorType:
+			if err, ok := f.Interface.(error); ok {
+				f = zap.String(f.Key, r.redact(err.Error()))
+			}
+		}
+		out[i] = f
+	}
+	return out
+}
+
+// redactingCore redacts the entries of the wrapped core.
+type redactingCore struct {
+	zapcore.Core
+	r *keyRedactor
+}
+
+func (c redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactingCore{Core: c.Core.With(c.r.fields(fields)), r: c.r}
+}
+
+func (c redactingCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c redactingCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	ent.Message = c.r.redact(ent.Message)
+	return c.Core.Write(ent, c.r.fields(fields))
+}
+
+// provisionLogRedaction wraps the logger to redact keys.
+func (s *S3Storage) provisionLogRedaction() error {
+	r, err := newKeyRedactor(s.LogRedaction)
+	if err != nil {
+		return err
+	}
+	s.logger = s.logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return redactingCore{Core: core, r: r}
+	}))
+	return nil
+}
+
+// unmarshalLogRedaction parses the log_redaction directive:
+//
+//	log_redaction {
+//		pattern <regexp>
+//		salt <secret>
+//	}
+func (s *S3Storage) unmarshalLogRedaction(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	cfg := new(LogRedactionConfig)
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		key := d.Val()
+		var value string
+		if !d.AllArgs(&value) {
+			return d.ArgErr()
+		}
+		switch key {
+		case "pattern":
+			cfg.Patterns = append(cfg.Patterns, value)
+		case "salt":
+			cfg.Salt = value
+		default:
+			return d.Errf("unrecognized log_redaction subdirective '%s'", key)
+		}
+	}
+	s.LogRedaction = cfg
+	return nil
+}
@@ -0,0 +1,62 @@
+package s3
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestKeyRedactor(t *testing.T) {
+	r, err := newKeyRedactor(&LogRedactionConfig{Salt: "salt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := r.redact("certificates/acme/example.com/example.com.crt")
+	parts := strings.Split(got, "/")
+	if len(parts) != 4 || parts[0] != "certificates" || parts[1] != "acme" {
+		t.Fatalf("unexpected redaction %q", got)
+	}
+	if !strings.HasPrefix(parts[2], "h-") || parts[3] != parts[2]+".crt" {
+		t.Errorf("expected the same stable hash for directory and file, got %q", got)
+	}
+	if lock := r.redact("issue_cert_wildcard_.example.org.lock"); strings.Contains(lock, "example") || !strings.HasSuffix(lock, ".lock") {
+		t.Errorf("unexpected lock key redaction %q", lock)
+	}
+
+	other, _ := newKeyRedactor(&LogRedactionConfig{Salt: "other"})
+	if other.redact("example.com") == r.redact("example.com") {
+		t.Errorf("expected the salt to change the hash")
+	}
+	if _, err := newKeyRedactor(&LogRedactionConfig{Patterns: []string{"("}}); err == nil {
+		t.Errorf("expected invalid pattern to be rejected")
+	}
+}
+
+func TestLogRedaction(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	s := &S3Storage{logger: zap.New(core), LogRedaction: &LogRedactionConfig{Patterns: []string{`customer-\d+`}}}
+	if err := s.provisionLogRedaction(); err != nil {
+		t.Fatal(err)
+	}
+	s.logger.With(zap.String("prefix", "tenants/customer-42")).Debug("loading customer-42",
+		zap.String("key", "tenants/customer-42/x"), zap.String("name", "customer-42"), zap.String("bucket", "customer-42"),
+		zap.Error(errors.New("reading tenants/customer-42/x failed")))
+
+	entry := logs.All()[0]
+	if strings.Contains(entry.Message, "customer-42") {
+		t.Errorf("message not redacted: %q", entry.Message)
+	}
+	fields := entry.ContextMap()
+	for _, name := range []string{"prefix", "key", "name", "error"} {
+		if v := fields[name].(string); strings.Contains(v, "customer-42") || !strings.Contains(v, "h-") {
+			t.Errorf("field %s not redacted: %q", name, v)
+		}
+	}
+	if fields["bucket"] != "customer-42" {
+		t.Errorf("unrelated field redacted: %v", fields["bucket"])
+	}
+}
@@ -0,0 +1,161 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// ReencryptStats summarizes a Reencrypt run.
+type ReencryptStats struct {
+	Reencrypted int // Objects rewritten with the current encryption
+	Skipped     int // Objects already readable with the current encryption
+}
+
+// Reencrypt rewrites every object below the prefix with the storage's current encryption.
+// Objects are decrypted with from, which describes the previous encryption (CleartextIO when
+// enabling encryption), re-encrypted, written back and read again to verify the result.
+// Objects which already decrypt with the current encryption are skipped, so an interrupted
+// run can simply be repeated, as are empty value sentinels and tombstones.
+func (s *S3Storage) Reencrypt(ctx context.Context, from IO, dryRun bool) (ReencryptStats, error) {
+	var stats ReencryptStats
+	if !dryRun {
+		if err := s.checkWritable("reencrypt", s.Prefix); err != nil {
+			return stats, err
+		}
+	}
+	toCleartext := isCleartext(s.iowrap)
+	_, fromCleartext := from.(*CleartextIO)
+
+	err := s.walkObjects(ctx, func(obj types.Object) error {
+		s3Key := *obj.Key
+		raw, metadata, err := s.getObjectWithMetadata(ctx, s3Key)
+		if err != nil {
+			return err
+		}
+		if isEmptySentinel(metadata) || isTombstone(metadata) { // Not encrypted, and must keep their metadata
+			stats.Skipped++
+			return nil
+		}
+
+		// Cleartext never fails to "decrypt", so only trust a successful decryption with a real key.
+		if !toCleartext {
+			if _, err := decryptWith(s.iowrap, raw); err == nil && inCurrentFormat(s.iowrap, raw) {
+				stats.Skipped++
+				return nil
+			}
+		}
+		plaintext, err := decryptWith(from, raw)
+		if err != nil {
+			if toCleartext && !fromCleartext {
+				stats.Skipped++ // Not decryptable with the old key, so presumably already cleartext
+				return nil
+			}
+			return fmt.Errorf("decrypting %s with the old key: %w", s3Key, err)
+		}
+		if plaintext, err = decompressValue(plaintext); err != nil {
+			return fmt.Errorf("decompressing %s: %w", s3Key, err)
+		}
+
+		if dryRun {
+			s.logger.Info("would re-encrypt object", zap.String("s3_key", s3Key))
+			stats.Reencrypted++
+			return nil
+		}
+
+		reader, _, err := s.iowrap.ByteReader(plaintext)
+		if err != nil {
+			return fmt.Errorf("encrypting %s: %w", s3Key, err)
+		}
+		ciphertext, err := io.ReadAll(reader)
+		if err != nil {
+			return fmt.Errorf("encrypting %s: %w", s3Key, err)
+		}
+		if err := s.putObjectBytes(ctx, s3Key, ciphertext); err != nil {
+			return err
+		}
+
+		// Verify the written object decrypts to the original plaintext.
+		written, err := s.getObjectBytes(ctx, s3Key)
+		if err != nil {
+			return fmt.Errorf("verifying %s: %w", s3Key, err)
+		}
+		check, err := decryptWith(s.iowrap, written)
+		if err != nil || !bytes.Equal(check, plaintext) {
+			return fmt.Errorf("verifying %s: re-encrypted object does not decrypt to the original content", s3Key)
+		}
+		s.logger.Info("re-encrypted object", zap.String("s3_key", s3Key))
+		stats.Reencrypted++
+		return nil
+	})
+	return stats, err
+}
+
+// decryptWith reads data through the IO wrapper.
+func decryptWith(wrap IO, data []byte) ([]byte, error) {
+	return io.ReadAll(wrap.WrapReader(bytes.NewReader(data)))
+}
+
+func reencryptCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reencrypt --config <path> (--old-key-file <path> | --from-cleartext) [--dry-run]",
+		Short: "Re-encrypts all objects with the configured encryption key",
+		Long: `
+Reads every object below the configured prefix, decrypts it with the old key
+(or treats it as cleartext with --from-cleartext), encrypts it with the
+encryption_key from the config and writes it back, verifying each write.
+Without an encryption_key in the config, objects are written as cleartext.
+
+Objects already readable with the configured key are skipped, so the command
+can be repeated after an interruption.
+`,
+		RunE: caddycmd.WrapCommandFuncForCobra(cmdReencrypt),
+	}
+	addConfigFlags(cmd)
+	cmd.Flags().String("old-key-file", "", "File containing the previous 32-byte encryption key")
+	cmd.Flags().Bool("from-cleartext", false, "Objects are currently stored without encryption")
+	cmd.Flags().Bool("dry-run", false, "Only report what would be re-encrypted")
+	return cmd
+}
+
+func cmdReencrypt(fl caddycmd.Flags) (int, error) {
+	var from IO
+	switch oldKeyFile := fl.String("old-key-file"); {
+	case oldKeyFile != "" && fl.Bool("from-cleartext"):
+		return caddy.ExitCodeFailedStartup, errors.New("--old-key-file and --from-cleartext are mutually exclusive")
+	case oldKeyFile != "":
+		key, err := os.ReadFile(oldKeyFile)
+		if err != nil {
+			return caddy.ExitCodeFailedStartup, fmt.Errorf("reading old key: %v", err)
+		}
+		if from, err = NewIO(string(bytes.TrimRight(key, "\r\n"))); err != nil {
+			return caddy.ExitCodeFailedStartup, fmt.Errorf("old key: %v", err)
+		}
+	case fl.Bool("from-cleartext"):
+		from = &CleartextIO{}
+	default:
+		return caddy.ExitCodeFailedStartup, errors.New("either --old-key-file or --from-cleartext is required")
+	}
+
+	s, cancel, err := loadStorageFromConfig(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	defer cancel()
+
+	stats, err := s.Reencrypt(context.Background(), from, fl.Bool("dry-run"))
+	fmt.Printf("re-encrypted %d, skipped %d\n", stats.Reencrypted, stats.Skipped)
+	if err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	return caddy.ExitCodeSuccess, nil
+}
@@ -0,0 +1,61 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// Without region, requests to AWS S3 would go to the region of the SDK's environment (or none),
+// and fail with redirects if the bucket lives elsewhere. Provision then looks the region up with
+// an unsigned HeadBucket, which S3 answers with the x-amz-bucket-region header even when it
+// denies access, and creates the clients for that region.
+
+// detectRegionHint is the region asked for the bucket region if the environment sets none; any
+// region of the partition knows all of its buckets.
+const detectRegionHint = "us-east-1"
+
+// shouldDetectRegion reports whether the region of the bucket is looked up during Provision.
+func (s *S3Storage) shouldDetectRegion() bool {
+	return s.Region == "" && s.Endpoint == "" && s.Client == nil && !isMultiRegionAccessPoint(s.Bucket)
+}
+
+// detectRegion sets the region to that of the bucket. If the lookup fails, the region of the
+// SDK's environment is used if there is one.
+func (s *S3Storage) detectRegion(ctx context.Context, optFns ...func(*awss3.Options)) error {
+	var envRegion string
+	client, err := s.newClient("", "", s.AccessKeyID, s.SecretAccessKey, s.SessionToken, append(optFns, func(o *awss3.Options) {
+		envRegion = o.Region
+		if o.Region == "" {
+			o.Region = detectRegionHint
+		}
+	})...)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+	region, err := manager.GetBucketRegion(ctx, client, s.Bucket)
+	var notFound manager.BucketNotFound
+	switch {
+	case errors.As(err, &notFound):
+		err = fmt.Errorf("bucket %s does not exist", s.Bucket)
+	case err == nil && region == "":
+		err = errors.New("no region in the response")
+	}
+	if err != nil {
+		if envRegion == "" {
+			return fmt.Errorf("region not specified and detecting the region of bucket %s failed: %w", s.Bucket, s3Error(err))
+		}
+		s.logger.Warn("detecting the bucket region failed, using the region of the environment",
+			zap.String("region", envRegion), zap.Error(err))
+		return nil
+	}
+	s.Region = region
+	s.logger.Info("detected bucket region", zap.String("bucket", s.Bucket), zap.String("region", region))
+	return nil
+}
@@ -0,0 +1,129 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// ReplicaConfig describes a secondary bucket, possibly in another region or at another endpoint,
+// to which every Store and Delete is mirrored. Load and Exists fall back to it when the primary
+// fails (see fallbackSources).
+// Objects use the same prefix and encryption as the primary bucket.
+type ReplicaConfig struct {
+	Bucket          string `json:"bucket,omitempty"`
+	Region          string `json:"region,omitempty"`
+	Endpoint        string `json:"endpoint,omitempty"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	SessionToken    string `json:"session_token,omitempty"`
+}
+
+// provisionReplica creates the client for the replica bucket.
+func (s *S3Storage) provisionReplica() error {
+	if s.Replica.Bucket == "" {
+		return errors.New("replica bucket must be specified")
+	}
+	client, err := s.newClient(s.Replica.Region, s.Replica.Endpoint, s.Replica.AccessKeyID, s.Replica.SecretAccessKey, s.Replica.SessionToken)
+	if err != nil {
+		return fmt.Errorf("replica: %w", err)
+	}
+	s.replicaClient = client
+	s.logger.Info("replicating to secondary bucket",
+		zap.String("bucket", s.Replica.Bucket),
+		zap.String("region", s.Replica.Region))
+	return nil
+}
+
+// replicateStore mirrors a stored value to the replica bucket. Failures are recorded but do not
+// fail the primary write.
+func (s *S3Storage) replicateStore(ctx context.Context, key, s3Key string, value []byte) {
+	if s.replicaClient == nil {
+		return
+	}
+	reader, length, err := s.iowrap.ByteReader(value)
+	if err != nil {
+		s.recordError("replica_store", key, err)
+		return
+	}
+
+	ctx, cancel := s.opContext(ctx)
+	defer cancel()
+	_, err = s.replicaClient.PutObject(ctx, &awss3.PutObjectInput{
+		Bucket:        aws.String(s.Replica.Bucket),
+		Key:           aws.String(s3Key),
+		Body:          reader,
+		ContentLength: aws.Int64(length),
+		StorageClass:  types.StorageClass(s.StorageClass),
+		ContentType:   s.contentType(),
+		Tagging:       s.objectTagging(),
+		Metadata:      s.objectMetadata(nil),
+	})
+	if err != nil {
+		s.recordError("replica_store", key, fmt.Errorf("storing %s (s3://%s/%s): %w", key, s.Replica.Bucket, s3Key, s3Error(err)))
+	}
+}
+
+// replicateDelete mirrors a deletion to the replica bucket.
+func (s *S3Storage) replicateDelete(ctx context.Context, key, s3Key string) {
+	if s.replicaClient == nil {
+		return
+	}
+	ctx, cancel := s.opContext(ctx)
+	defer cancel()
+	_, err := s.replicaClient.DeleteObject(ctx, &awss3.DeleteObjectInput{
+		Bucket: aws.String(s.Replica.Bucket),
+		Key:    aws.String(s3Key),
+	})
+	if err != nil {
+		s.recordError("replica_delete", key, fmt.Errorf("deleting %s (s3://%s/%s): %w", key, s.Replica.Bucket, s3Key, s3Error(err)))
+	}
+}
+
+// unmarshalReplica parses the replica block:
+//
+//	replica {
+//		bucket <name>
+//		region <region>
+//		endpoint <url>
+//		access_key_id <id>
+//		secret_access_key <secret>
+//		session_token <token>
+//	}
+func (s *S3Storage) unmarshalReplica(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	cfg := new(ReplicaConfig)
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		key := d.Val()
+		var value string
+		if !d.AllArgs(&value) {
+			return d.ArgErr()
+		}
+		switch key {
+		case "bucket":
+			cfg.Bucket = value
+		case "region":
+			cfg.Region = value
+		case "endpoint":
+			cfg.Endpoint = value
+		case "access_key_id":
+			cfg.AccessKeyID = value
+		case "secret_access_key":
+			cfg.SecretAccessKey = value
+		case "session_token":
+			cfg.SessionToken = value
+		default:
+			return d.Errf("unrecognized replica subdirective '%s'", key)
+		}
+	}
+	s.Replica = cfg
+	return nil
+}
@@ -0,0 +1,64 @@
+package s3_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	s3 "github.com/cvhome-saas/certmagic-s3"
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+func TestStorageReplica(t *testing.T) {
+	srv := s3test.NewServer(t)
+	srv.CreateBucket(t, "replica")
+	target, _ := url.Parse(srv.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	var down atomic.Bool
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, `<Error><Code>InternalError</Code></Error>`)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer primary.Close()
+
+	storage := srv.Storage(t, func(s *s3.S3Storage) {
+		s.Endpoint = primary.URL
+		s.Replica = &s3.ReplicaConfig{Bucket: "replica", Region: "us-east-1", Endpoint: srv.URL, AccessKeyID: "s3test", SecretAccessKey: "s3test"}
+		s.RetryErrorCodes = map[string]string{"InternalError": "fatal", "InternalServerError": "fatal"} // HEAD responses have no body
+	})
+	replica := srv.Storage(t, func(s *s3.S3Storage) { s.Bucket = "replica" })
+	ctx := context.Background()
+	key := "certificates/acme/example.com/example.com.crt"
+
+	if err := storage.Store(ctx, key, []byte("certificate")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := replica.Load(ctx, key); err != nil || string(value) != "certificate" {
+		t.Errorf("replica has %q, %v; want the stored value", value, err)
+	}
+
+	down.Store(true)
+	if value, err := storage.Load(ctx, key); err != nil || string(value) != "certificate" {
+		t.Errorf("load while the primary fails = %q, %v; want the replica's value", value, err)
+	}
+	if exists, err := storage.ExistsErr(ctx, key); err != nil || !exists {
+		t.Errorf("exists while the primary fails = %v, %v", exists, err)
+	}
+	down.Store(false)
+
+	if err := storage.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if replica.Exists(ctx, key) {
+		t.Error("deletion not replicated")
+	}
+}
@@ -0,0 +1,62 @@
+package s3
+
+import (
+	"context"
+
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// With requester_pays, every request acknowledges that the requester is charged for it, which
+// requester-pays buckets require of accounts other than the bucket owner. Like the SSE-C
+// parameters, RequestPayer is set by a client middleware, so that no operation can miss it.
+
+// registerRequesterPays adds the middleware setting RequestPayer to a client's stack.
+func registerRequesterPays(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("RequesterPays",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			applyRequesterPays(in.Parameters)
+			return next.HandleInitialize(ctx, in)
+		}), middleware.Before)
+}
+
+// applyRequesterPays sets RequestPayer on the operations which accept it.
+func applyRequesterPays(params any) {
+	const payer = types.RequestPayerRequester
+	switch in := params.(type) {
+	case *awss3.PutObjectInput:
+		in.RequestPayer = payer
+	case *awss3.GetObjectInput:
+		in.RequestPayer = payer
+	case *awss3.HeadObjectInput:
+		in.RequestPayer = payer
+	case *awss3.CopyObjectInput:
+		in.RequestPayer = payer
+	case *awss3.DeleteObjectInput:
+		in.RequestPayer = payer
+	case *awss3.DeleteObjectsInput:
+		in.RequestPayer = payer
+	case *awss3.ListObjectsV2Input:
+		in.RequestPayer = payer
+	case *awss3.ListObjectVersionsInput:
+		in.RequestPayer = payer
+	case *awss3.CreateMultipartUploadInput:
+		in.RequestPayer = payer
+	case *awss3.UploadPartInput:
+		in.RequestPayer = payer
+	case *awss3.CompleteMultipartUploadInput:
+		in.RequestPayer = payer
+	case *awss3.AbortMultipartUploadInput:
+		in.RequestPayer = payer
+	case *awss3.GetBucketAccelerateConfigurationInput:
+		in.RequestPayer = payer
+	}
+}
+
+// requesterPaysClientOptions adds the RequestPayer middleware to a client, if requester_pays is set.
+func (s *S3Storage) requesterPaysClientOptions(o *awss3.Options) {
+	if s.RequesterPays {
+		o.APIOptions = append(o.APIOptions, registerRequesterPays)
+	}
+}
@@ -0,0 +1,64 @@
+package s3
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// RequestStats counts the S3 requests sent by a storage since it was provisioned, by operation.
+// Retried attempts count separately, as S3 bills them separately.
+type RequestStats struct {
+	Since    time.Time         `json:"since"`
+	Requests map[string]uint64 `json:"requests"` // e.g. "GetObject": 42
+}
+
+// requestCounter counts the requests of all S3 clients of a storage.
+type requestCounter struct {
+	since time.Time
+
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func newRequestCounter() *requestCounter {
+	return &requestCounter{since: time.Now(), counts: make(map[string]uint64)}
+}
+
+// register adds the counting middleware to a client's stack, after the retry middleware so that
+// every attempt is counted.
+func (c *requestCounter) register(stack *middleware.Stack) error {
+	return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("CountRequests",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			c.add(awsmiddleware.GetOperationName(ctx))
+			return next.HandleFinalize(ctx, in)
+		}), middleware.After)
+}
+
+func (c *requestCounter) add(op string) {
+	c.mu.Lock()
+	c.counts[op]++
+	c.mu.Unlock()
+}
+
+// snapshot returns the current counts.
+func (c *requestCounter) snapshot() RequestStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := RequestStats{Since: c.since, Requests: make(map[string]uint64, len(c.counts))}
+	for op, n := range c.counts {
+		stats.Requests[op] = n
+	}
+	return stats
+}
+
+// RequestStats returns the number of S3 requests sent since the storage was provisioned.
+func (s *S3Storage) RequestStats() RequestStats {
+	if s.requests == nil {
+		return RequestStats{Requests: map[string]uint64{}}
+	}
+	return s.requests.snapshot()
+}
@@ -0,0 +1,139 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// Supported values for the retry_mode option.
+const (
+	retryModeStandard = "standard"
+	retryModeAdaptive = "adaptive"
+)
+
+// Classifications for the retry_error_codes option.
+const (
+	retryClassRetryable = "retryable"
+	retryClassFatal     = "fatal"
+)
+
+// validateRetryConfig checks the retry related options before they are used to build the retryer.
+func (s *S3Storage) validateRetryConfig() error {
+	if s.MaxRetries < 0 {
+		return fmt.Errorf("max_retries must not be negative, got %d", s.MaxRetries)
+	}
+	switch strings.ToLower(s.RetryMode) {
+	case "", retryModeStandard, retryModeAdaptive:
+	default:
+		return fmt.Errorf("unsupported retry_mode '%s' (expected '%s' or '%s')", s.RetryMode, retryModeStandard, retryModeAdaptive)
+	}
+	if s.OperationTimeout < 0 || s.ReadTimeout < 0 || s.ListTimeout < 0 {
+		return fmt.Errorf("operation_timeout, read_timeout and list_timeout must not be negative")
+	}
+	for code, class := range s.RetryErrorCodes {
+		if class != retryClassRetryable && class != retryClassFatal {
+			return fmt.Errorf("retry_error_codes: unsupported classification '%s' for %s (expected '%s' or '%s')",
+				class, code, retryClassRetryable, retryClassFatal)
+		}
+	}
+	return nil
+}
+
+// newRetryer builds the SDK retryer according to the configured retry mode and max retries.
+// It is handed to the AWS config in Provision so every S3 call uses the same policy.
+func (s *S3Storage) newRetryer() aws.Retryer {
+	standardOpts := func(o *retry.StandardOptions) {
+		if s.MaxRetries > 0 {
+			o.MaxAttempts = s.MaxRetries + 1 // The SDK counts the first attempt as well
+		}
+		if s.isB2() {
+			o.Retryables = append([]retry.IsErrorRetryable{retry.IsErrorRetryableFunc(b2Throttled)}, o.Retryables...)
+		}
+		if len(s.RetryErrorCodes) > 0 {
+			// The first classifier with an opinion wins, so the configured codes take precedence.
+			o.Retryables = append([]retry.IsErrorRetryable{retryCodeTable(s.RetryErrorCodes)}, o.Retryables...)
+		}
+	}
+
+	if strings.ToLower(s.RetryMode) == retryModeAdaptive {
+		return retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+			o.StandardOptions = append(o.StandardOptions, standardOpts)
+		})
+	}
+	return retry.NewStandard(standardOpts)
+}
+
+// retryCodeTable classifies errors by their provider error code according to retry_error_codes.
+type retryCodeTable map[string]string
+
+// IsErrorRetryable implements retry.IsErrorRetryable.
+func (t retryCodeTable) IsErrorRetryable(err error) aws.Ternary {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return aws.UnknownTernary
+	}
+	switch t[apiErr.ErrorCode()] {
+	case retryClassRetryable:
+		return aws.TrueTernary
+	case retryClassFatal:
+		return aws.FalseTernary
+	}
+	return aws.UnknownTernary
+}
+
+// unmarshalRetryErrorCodes parses a retry_error_codes block of "<code> retryable|fatal" lines.
+func (s *S3Storage) unmarshalRetryErrorCodes(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	if s.RetryErrorCodes == nil {
+		s.RetryErrorCodes = make(map[string]string)
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		code := d.Val()
+		var class string
+		if !d.AllArgs(&class) {
+			return d.ArgErr()
+		}
+		s.RetryErrorCodes[code] = class
+	}
+	return nil
+}
+
+// opContext derives the context for a single S3 operation, applying operation_timeout if configured.
+// The returned cancel function must always be called once the operation (including body reads) is done.
+func (s *S3Storage) opContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, s.OperationTimeout)
+}
+
+// readContext is opContext for GET and HEAD requests, applying read_timeout if configured.
+func (s *S3Storage) readContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.ReadTimeout > 0 {
+		return withTimeout(ctx, s.ReadTimeout)
+	}
+	return s.opContext(ctx)
+}
+
+// listContext is opContext for fetching one page of a listing, applying list_timeout if configured.
+func (s *S3Storage) listContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.ListTimeout > 0 {
+		return withTimeout(ctx, s.ListTimeout)
+	}
+	return s.opContext(ctx)
+}
+
+func withTimeout(ctx context.Context, timeout caddy.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, time.Duration(timeout))
+}
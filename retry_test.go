@@ -0,0 +1,40 @@
+package s3
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go"
+)
+
+func TestRetryCodeTable(t *testing.T) {
+	s := &S3Storage{RetryErrorCodes: map[string]string{
+		"InternalError": retryClassRetryable,
+		"SlowDown":      retryClassFatal,
+	}}
+	if err := s.validateRetryConfig(); err != nil {
+		t.Fatal(err)
+	}
+	retryer := s.newRetryer()
+
+	cases := map[string]bool{
+		"InternalError": true,
+		"SlowDown":      false, // Retryable by default
+		"NoSuchKey":     false,
+	}
+	for code, want := range cases {
+		err := &smithy.GenericAPIError{Code: code}
+		if got := retryer.IsErrorRetryable(err); got != want {
+			t.Errorf("IsErrorRetryable(%s) = %v, want %v", code, got, want)
+		}
+	}
+	if got := retryCodeTable(s.RetryErrorCodes).IsErrorRetryable(errors.New("plain")); got != aws.UnknownTernary {
+		t.Errorf("plain error classified as %v", got)
+	}
+
+	s.RetryErrorCodes["Foo"] = "sometimes"
+	if err := s.validateRetryConfig(); err == nil {
+		t.Error("expected invalid classification to be rejected")
+	}
+}
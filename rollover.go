@@ -0,0 +1,142 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// Two-phase key rollover: while rollover_until lies in the future, objects are still written with
+// previous_encryption_key, so instances that only know the old key keep working during a rolling
+// upgrade. A sibling object (<key>.rollover) encrypted with the new key is written next to each one,
+// so that instances configured with the new key only can read everything written during the
+// window. Reads accept both keys at any time. After the window, writes use the new key only; the
+// reencrypt command migrates the remaining objects.
+
+const rolloverSuffix = ".rollover"
+
+// RolloverIO reads with either of two encryptions and writes with the previous one until the
+// rollover deadline and with the current one afterwards.
+type RolloverIO struct {
+	Current  IO
+	Previous IO
+	Until    time.Time
+
+	now func() time.Time // For tests
+}
+
+// InWindow reports whether the overlap window is still active.
+func (r *RolloverIO) InWindow() bool {
+	now := time.Now
+	if r.now != nil {
+		now = r.now
+	}
+	return now().Before(r.Until)
+}
+
+// ByteReader encrypts with the previous encryption during the window and the current one afterwards.
+func (r *RolloverIO) ByteReader(plaintext []byte) (io.Reader, int64, error) {
+	if r.InWindow() {
+		return r.Previous.ByteReader(plaintext)
+	}
+	return r.Current.ByteReader(plaintext)
+}
+
+// StreamReader encrypts like ByteReader, for a plaintext stream.
+func (r *RolloverIO) StreamReader(plaintext io.Reader, size int64) (io.Reader, int64, error) {
+	if r.InWindow() {
+		return r.Previous.StreamReader(plaintext, size)
+	}
+	return r.Current.StreamReader(plaintext, size)
+}
+
+// WrapReader decrypts with whichever encryption matches. Real encryptions are tried before
+// cleartext, since "decrypting" cleartext never fails.
+func (r *RolloverIO) WrapReader(ciphertextReader io.Reader) io.Reader {
+	data, err := io.ReadAll(ciphertextReader)
+	if err != nil {
+		return &errorReader{err: fmt.Errorf("failed to read ciphertext body: %w", err)}
+	}
+
+	candidates := []IO{r.Current, r.Previous}
+	if _, ok := r.Current.(*CleartextIO); ok {
+		candidates = []IO{r.Previous, r.Current}
+	}
+	var firstErr error
+	for _, c := range candidates {
+		plaintext, err := decryptWith(c, data)
+		if err == nil {
+			return bytes.NewReader(plaintext)
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return &errorReader{err: firstErr}
+}
+
+// provisionRollover wraps the current IO into a RolloverIO if a previous key is configured.
+func (s *S3Storage) provisionRollover() error {
+	previous, err := NewIO(s.PreviousEncryptionKey)
+	if err != nil {
+		return fmt.Errorf("previous_encryption_key: %w", err)
+	}
+	var until time.Time
+	if s.RolloverUntil != "" {
+		if until, err = time.Parse(time.RFC3339, s.RolloverUntil); err != nil {
+			return fmt.Errorf("invalid rollover_until: %w", err)
+		}
+	}
+	s.rollover = &RolloverIO{Current: s.iowrap, Previous: previous, Until: until}
+	s.iowrap = s.rollover
+	s.logger.Info("encryption key rollover active",
+		zap.Time("rollover_until", until),
+		zap.Bool("writing_previous_key", s.rollover.InWindow()))
+	return nil
+}
+
+// storeRolloverSibling writes the value encrypted with the new key next to the object while the
+// window is active.
+func (s *S3Storage) storeRolloverSibling(ctx context.Context, key, s3Key string, value []byte) {
+	if s.rollover == nil || !s.rollover.InWindow() {
+		return
+	}
+	reader, _, err := s.rollover.Current.ByteReader(value)
+	if err == nil {
+		var body []byte
+		if body, err = io.ReadAll(reader); err == nil {
+			err = s.putObjectBytes(ctx, s3Key+rolloverSuffix, body)
+		}
+	}
+	if err != nil {
+		s.recordError("rollover_store", key, err)
+	}
+}
+
+// loadRolloverSibling reads the new-key sibling of an object which could not be decrypted.
+func (s *S3Storage) loadRolloverSibling(ctx context.Context, s3Key string) ([]byte, error) {
+	body, err := s.getObjectBytes(ctx, s3Key+rolloverSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return decryptWith(s.iowrap, body)
+}
+
+// deleteRolloverSibling removes the new-key sibling along with the object.
+func (s *S3Storage) deleteRolloverSibling(ctx context.Context, key, s3Key string) {
+	ctx, cancel := s.opContext(ctx)
+	defer cancel()
+	_, err := s.Client.DeleteObject(ctx, &awss3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s3Key + rolloverSuffix),
+	})
+	if err != nil {
+		s.recordError("rollover_delete", key, err)
+	}
+}
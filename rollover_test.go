@@ -0,0 +1,42 @@
+package s3
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRolloverIO(t *testing.T) {
+	oldIO, _ := NewIO("00000000000000000000000000000000")
+	newIO, _ := NewIO("11111111111111111111111111111111")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := &RolloverIO{Current: newIO, Previous: oldIO, Until: now.Add(time.Hour), now: func() time.Time { return now }}
+
+	encrypt := func() []byte {
+		reader, _, err := r.ByteReader([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(reader)
+		return data
+	}
+
+	// During the window, old instances must be able to read what is written.
+	written := encrypt()
+	if got, err := decryptWith(oldIO, written); err != nil || string(got) != "secret" {
+		t.Fatalf("previous key cannot read value written during window: %q, %v", got, err)
+	}
+	if got, err := decryptWith(r, written); err != nil || string(got) != "secret" {
+		t.Fatalf("rollover IO cannot read value written during window: %q, %v", got, err)
+	}
+
+	// Afterwards, values are written with the new key only.
+	now = now.Add(2 * time.Hour)
+	written = encrypt()
+	if _, err := decryptWith(oldIO, written); err == nil {
+		t.Fatal("value written after window is readable with the previous key")
+	}
+	if got, err := decryptWith(r, written); err != nil || string(got) != "secret" {
+		t.Fatalf("rollover IO cannot read value written after window: %q, %v", got, err)
+	}
+}
@@ -0,0 +1,190 @@
+package s3
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// With routes, keys below a CertMagic key prefix are kept in another bucket, e.g. ACME account
+// keys in a locked-down bucket apart from the certificates. The bucket of every request is chosen
+// by a client middleware from the object key (or list prefix), so that no code path can write to
+// the wrong bucket; listings, maintenance tasks and prefix deletions descend into the routed
+// prefixes. Route buckets are reached with the client of the storage, i.e. in the same region and
+// with the same credentials. Objects already stored below a routed prefix must be copied to the
+// route's bucket before the route is enabled; copies left in the default bucket are ignored.
+
+// RouteConfig sends the keys below a CertMagic key prefix to another bucket.
+type RouteConfig struct {
+	Prefix string `json:"prefix"` // CertMagic key prefix, e.g. "acme"
+	Bucket string `json:"bucket"`
+}
+
+// bucketRouter chooses the bucket of a request.
+type bucketRouter struct {
+	bucket string        // Default bucket; requests for other buckets are left alone
+	routes []bucketRoute // Longest prefix first
+}
+
+type bucketRoute struct {
+	s3Prefix string // Full S3 key prefix, without trailing slash
+	bucket   string
+}
+
+// provisionRoutes validates the routes and sets up the router.
+func (s *S3Storage) provisionRoutes() error {
+	if s.Client != nil {
+		return errors.New("routes cannot be used with a client set before provisioning")
+	}
+	r := &bucketRouter{bucket: s.Bucket}
+	seen := make(map[string]bool)
+	for i, route := range s.Routes {
+		prefix := normalizeRoutePrefix(route.Prefix)
+		if prefix == "" || route.Bucket == "" {
+			return fmt.Errorf("route %d: prefix and bucket must be specified", i)
+		}
+		if seen[prefix] {
+			return fmt.Errorf("duplicate route for prefix '%s'", prefix)
+		}
+		seen[prefix] = true
+		s.Routes[i].Prefix = prefix
+		r.routes = append(r.routes, bucketRoute{s3Prefix: s.s3ObjectKey(prefix), bucket: route.Bucket})
+		s.logger.Info("routing keys to bucket", zap.String("prefix", prefix), zap.String("bucket", route.Bucket))
+	}
+	slices.SortFunc(r.routes, func(a, b bucketRoute) int {
+		return cmp.Compare(len(b.s3Prefix), len(a.s3Prefix))
+	})
+	s.router = r
+	return nil
+}
+
+// normalizeRoutePrefix turns "acme/*" or "/acme/" into "acme".
+func normalizeRoutePrefix(prefix string) string {
+	return strings.Trim(strings.TrimSuffix(prefix, "*"), "/")
+}
+
+// bucketFor returns the bucket of an S3 key or list prefix.
+func (r *bucketRouter) bucketFor(s3Key string) string {
+	for _, route := range r.routes {
+		if s3Key == route.s3Prefix || strings.HasPrefix(s3Key, route.s3Prefix+"/") {
+			return route.bucket
+		}
+	}
+	return r.bucket
+}
+
+// route returns the bucket for a request addressing key in bucket.
+func (r *bucketRouter) route(bucket, key *string) *string {
+	if aws.ToString(bucket) != r.bucket {
+		return bucket
+	}
+	return aws.String(r.bucketFor(aws.ToString(key)))
+}
+
+// register adds the routing middleware to a client's stack.
+func (r *bucketRouter) register(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("RouteBucket",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			r.apply(in.Parameters)
+			return next.HandleInitialize(ctx, in)
+		}), middleware.Before)
+}
+
+// apply sets the bucket of the operations addressing objects. A batch deletion is routed by its
+// first key, as its keys come from one listing.
+func (r *bucketRouter) apply(params any) {
+	switch in := params.(type) {
+	case *awss3.PutObjectInput:
+		in.Bucket = r.route(in.Bucket, in.Key)
+	case *awss3.GetObjectInput:
+		in.Bucket = r.route(in.Bucket, in.Key)
+	case *awss3.HeadObjectInput:
+		in.Bucket = r.route(in.Bucket, in.Key)
+	case *awss3.DeleteObjectInput:
+		in.Bucket = r.route(in.Bucket, in.Key)
+	case *awss3.CopyObjectInput: // The source bucket is part of CopySource, see copySource
+		in.Bucket = r.route(in.Bucket, in.Key)
+	case *awss3.CreateMultipartUploadInput:
+		in.Bucket = r.route(in.Bucket, in.Key)
+	case *awss3.UploadPartInput:
+		in.Bucket = r.route(in.Bucket, in.Key)
+	case *awss3.CompleteMultipartUploadInput:
+		in.Bucket = r.route(in.Bucket, in.Key)
+	case *awss3.AbortMultipartUploadInput:
+		in.Bucket = r.route(in.Bucket, in.Key)
+	case *awss3.ListObjectsV2Input:
+		in.Bucket = r.route(in.Bucket, in.Prefix)
+	case *awss3.ListObjectVersionsInput:
+		in.Bucket = r.route(in.Bucket, in.Prefix)
+	case *awss3.DeleteObjectsInput:
+		if in.Delete != nil && len(in.Delete.Objects) > 0 {
+			in.Bucket = r.route(in.Bucket, in.Delete.Objects[0].Key)
+		}
+	}
+}
+
+// routerClientOptions adds the routing middleware to a client, if routes are configured.
+func (s *S3Storage) routerClientOptions(o *awss3.Options) {
+	if s.router != nil {
+		o.APIOptions = append(o.APIOptions, s.router.register)
+	}
+}
+
+// routesBelow returns the route prefixes strictly below a CertMagic key prefix which are not
+// below another one of them. Listings of prefix skip the keys below them and descend into each.
+func (s *S3Storage) routesBelow(prefix string) []string {
+	prefix = strings.Trim(prefix, "/")
+	var below []string
+	for _, route := range s.Routes {
+		if prefix == "" || strings.HasPrefix(route.Prefix, prefix+"/") {
+			below = append(below, route.Prefix)
+		}
+	}
+	all := slices.Clone(below)
+	return slices.DeleteFunc(below, func(p string) bool {
+		return slices.ContainsFunc(all, func(other string) bool { return keyBelow(p, other) })
+	})
+}
+
+// keyBelow reports whether key is strictly below the directory prefix.
+func keyBelow(key, prefix string) bool {
+	return strings.HasPrefix(key, prefix+"/")
+}
+
+// routedAway reports whether a CertMagic key belongs to one of the routes.
+func routedAway(key string, routes []string) bool {
+	return slices.ContainsFunc(routes, func(p string) bool { return key == p || keyBelow(key, p) })
+}
+
+// unmarshalRoutes parses the routes block, one "<key prefix> <bucket>" per line:
+//
+//	routes {
+//		acme/*       caddy-acme-accounts
+//		certificates caddy-certificates
+//	}
+func (s *S3Storage) unmarshalRoutes(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		prefix := d.Val()
+		var bucket string
+		if !d.AllArgs(&bucket) {
+			return d.ArgErr()
+		}
+		s.Routes = append(s.Routes, RouteConfig{Prefix: prefix, Bucket: bucket})
+	}
+	if len(s.Routes) == 0 {
+		return d.Err("routes block must contain at least one route")
+	}
+	return nil
+}
@@ -16,7 +16,51 @@ func (s *S3Storage) s3ObjectKey(certMagicKey string) string {
 	return path.Join(s.Prefix, cleanCertMagicKey)
 }
 
+// defaultLockPrefix is the directory below the prefix holding lock objects, like the locks
+// directory of CertMagic's file system storage.
+const defaultLockPrefix = "locks"
+
+// lockNamespace returns the CertMagic-relative directory holding lock objects.
+func (s *S3Storage) lockNamespace() string {
+	if ns := strings.Trim(s.LockPrefix, "/"); ns != "" {
+		return ns
+	}
+	return defaultLockPrefix
+}
+
 // s3LockKey constructs the S3 key for a lock file corresponding to a CertMagic key.
 func (s *S3Storage) s3LockKey(certMagicKey string) string {
+	return s.s3ObjectKey(s.lockNamespace() + "/" + strings.TrimPrefix(certMagicKey, "/"))
+}
+
+// s3LegacyLockKey constructs the S3 key of a lock file as written by earlier versions, next to
+// the data object.
+func (s *S3Storage) s3LegacyLockKey(certMagicKey string) string {
 	return s.s3ObjectKey(certMagicKey) + ".lock"
 }
+
+// legacyLocks reports whether locks at the legacy location are honored and written as well,
+// for fleets in which older instances still run. Never with lock_bucket, as they live in the data bucket.
+func (s *S3Storage) legacyLocks() bool {
+	return s.LegacyLocks && s.LockBucket == ""
+}
+
+// lockBucket returns the bucket of the lock objects.
+func (s *S3Storage) lockBucket() string {
+	if s.LockBucket != "" {
+		return s.LockBucket
+	}
+	return s.Bucket
+}
+
+// isLockKey reports whether a CertMagic-relative key is a lock object.
+func (s *S3Storage) isLockKey(key string) bool {
+	ns := s.lockNamespace()
+	return key == ns || strings.HasPrefix(key, ns+"/") || (s.legacyLocks() && strings.HasSuffix(key, ".lock"))
+}
+
+// isHiddenKey reports whether a key is internal to this module and must not be listed to CertMagic.
+func (s *S3Storage) isHiddenKey(key string) bool {
+	return s.isLockKey(key) || isOCSPBaseKey(key) || strings.HasSuffix(key, rolloverSuffix) ||
+		key == manifestKey || key == expirationIndexKey || key == passphraseSaltKey || key == encryptionCheckKey || isTrashKey(key)
+}
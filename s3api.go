@@ -0,0 +1,39 @@
+package s3
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3API is the part of the S3 client API used by the storage. It is implemented by *s3.Client of
+// the AWS SDK and, for tests, by s3test.FakeClient.
+//
+// A client set in S3Storage.Client before Provision is used as it is; the storage then does not
+// create its own, so endpoint, credential, retry and transport options as well as the request
+// statistics, max_concurrent_requests, sse_customer_key and requester_pays do not apply to it.
+type S3API interface {
+	PutObject(ctx context.Context, params *awss3.PutObjectInput, optFns ...func(*awss3.Options)) (*awss3.PutObjectOutput, error)
+	GetObject(ctx context.Context, params *awss3.GetObjectInput, optFns ...func(*awss3.Options)) (*awss3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *awss3.HeadObjectInput, optFns ...func(*awss3.Options)) (*awss3.HeadObjectOutput, error)
+	CopyObject(ctx context.Context, params *awss3.CopyObjectInput, optFns ...func(*awss3.Options)) (*awss3.CopyObjectOutput, error)
+	DeleteObject(ctx context.Context, params *awss3.DeleteObjectInput, optFns ...func(*awss3.Options)) (*awss3.DeleteObjectOutput, error)
+	DeleteObjects(ctx context.Context, params *awss3.DeleteObjectsInput, optFns ...func(*awss3.Options)) (*awss3.DeleteObjectsOutput, error)
+	ListObjectsV2(ctx context.Context, params *awss3.ListObjectsV2Input, optFns ...func(*awss3.Options)) (*awss3.ListObjectsV2Output, error)
+	ListObjectVersions(ctx context.Context, params *awss3.ListObjectVersionsInput, optFns ...func(*awss3.Options)) (*awss3.ListObjectVersionsOutput, error)
+	HeadBucket(ctx context.Context, params *awss3.HeadBucketInput, optFns ...func(*awss3.Options)) (*awss3.HeadBucketOutput, error)
+	GetBucketVersioning(ctx context.Context, params *awss3.GetBucketVersioningInput, optFns ...func(*awss3.Options)) (*awss3.GetBucketVersioningOutput, error)
+	GetBucketAccelerateConfiguration(ctx context.Context, params *awss3.GetBucketAccelerateConfigurationInput, optFns ...func(*awss3.Options)) (*awss3.GetBucketAccelerateConfigurationOutput, error)
+
+	// Lifecycle rules of the prefix, see lifecycle.go
+	GetBucketLifecycleConfiguration(ctx context.Context, params *awss3.GetBucketLifecycleConfigurationInput, optFns ...func(*awss3.Options)) (*awss3.GetBucketLifecycleConfigurationOutput, error)
+	PutBucketLifecycleConfiguration(ctx context.Context, params *awss3.PutBucketLifecycleConfigurationInput, optFns ...func(*awss3.Options)) (*awss3.PutBucketLifecycleConfigurationOutput, error)
+	DeleteBucketLifecycle(ctx context.Context, params *awss3.DeleteBucketLifecycleInput, optFns ...func(*awss3.Options)) (*awss3.DeleteBucketLifecycleOutput, error)
+
+	// Multipart uploads, used by StoreStream
+	manager.UploadAPIClient
+}
+
+// Interface guard
+var _ S3API = (*awss3.Client)(nil)
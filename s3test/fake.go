@@ -0,0 +1,608 @@
+package s3test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/caddyserver/caddy/v2"
+
+	s3 "github.com/cvhome-saas/certmagic-s3"
+)
+
+// FakeClient is an in-memory implementation of s3.S3API for unit tests which don't need an S3
+// server. It supports the subset of S3 the storage uses: conditional writes (If-Match and
+// If-None-Match), ranged and conditional reads, paged listing with delimiters, copies, batch
+// deletes and multipart uploads. Versioning is not supported; every object is its own latest
+// version.
+//
+// Assign it to S3Storage.Client before provisioning, or use NewFakeStorage.
+type FakeClient struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]*fakeObject
+	uploads map[string]*fakeUpload
+	nextID  int
+
+	lifecycles map[string][]types.LifecycleRule
+	accelerate map[string]types.BucketAccelerateStatus
+}
+
+type fakeObject struct {
+	data     []byte
+	etag     string
+	modified time.Time
+	metadata map[string]string
+}
+
+type fakeUpload struct {
+	bucket, key string
+	metadata    map[string]string
+	parts       map[int32][]byte
+}
+
+// Interface guard
+var _ s3.S3API = (*FakeClient)(nil)
+
+// NewFakeClient returns an empty fake with the given buckets.
+func NewFakeClient(buckets ...string) *FakeClient {
+	c := &FakeClient{
+		buckets:    make(map[string]map[string]*fakeObject),
+		uploads:    make(map[string]*fakeUpload),
+		lifecycles: make(map[string][]types.LifecycleRule),
+		accelerate: make(map[string]types.BucketAccelerateStatus),
+	}
+	for _, b := range buckets {
+		c.buckets[b] = make(map[string]*fakeObject)
+	}
+	return c
+}
+
+// NewFakeStorage returns a storage provisioned with a FakeClient holding DefaultBucket. The
+// configure functions run before provisioning; the storage is cleaned up when the test finishes.
+func NewFakeStorage(t testing.TB, configure ...func(*s3.S3Storage)) (*s3.S3Storage, *FakeClient) {
+	t.Helper()
+	client := NewFakeClient(DefaultBucket)
+	storage := &s3.S3Storage{
+		Client: client,
+		Bucket: DefaultBucket,
+		Region: "us-east-1",
+		Prefix: "certmagic",
+	}
+	for _, fn := range configure {
+		fn(storage)
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	t.Cleanup(cancel)
+	if err := storage.Provision(ctx); err != nil {
+		t.Fatalf("s3test: provisioning storage: %v", err)
+	}
+	t.Cleanup(func() { _ = storage.Cleanup() })
+	return storage, client
+}
+
+// Keys returns the sorted keys of the objects in a bucket.
+func (c *FakeClient) Keys(bucket string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Sorted(maps.Keys(c.buckets[bucket]))
+}
+
+// fakeError returns err the way the SDK does for a response with the given status.
+func fakeError(op string, status int, err error) error {
+	return &smithy.OperationError{
+		ServiceID:     "S3",
+		OperationName: op,
+		Err: &awshttp.ResponseError{
+			ResponseError: &smithyhttp.ResponseError{
+				Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+				Err:      err,
+			},
+		},
+	}
+}
+
+func apiError(code, message string) error {
+	return &smithy.GenericAPIError{Code: code, Message: message}
+}
+
+// bucket returns the objects of a bucket; c.mu must be held.
+func (c *FakeClient) bucket(op string, name *string) (map[string]*fakeObject, error) {
+	objects, ok := c.buckets[aws.ToString(name)]
+	if !ok {
+		return nil, fakeError(op, http.StatusNotFound, &types.NoSuchBucket{Message: aws.String("The specified bucket does not exist")})
+	}
+	return objects, nil
+}
+
+// checkConditions evaluates If-Match and If-None-Match against obj, which may be nil.
+func checkConditions(op string, obj *fakeObject, ifMatch, ifNoneMatch *string, read bool) error {
+	if ifMatch != nil && (obj == nil || (*ifMatch != "*" && *ifMatch != obj.etag)) {
+		if obj == nil && !read {
+			return fakeError(op, http.StatusNotFound, &types.NoSuchKey{Message: aws.String("The specified key does not exist.")})
+		}
+		return fakeError(op, http.StatusPreconditionFailed, apiError("PreconditionFailed", "At least one of the pre-conditions you specified did not hold"))
+	}
+	if ifNoneMatch != nil && obj != nil && (*ifNoneMatch == "*" || *ifNoneMatch == obj.etag) {
+		if read {
+			return fakeError(op, http.StatusNotModified, apiError("NotModified", "Not Modified"))
+		}
+		return fakeError(op, http.StatusPreconditionFailed, apiError("PreconditionFailed", "At least one of the pre-conditions you specified did not hold"))
+	}
+	return nil
+}
+
+func newFakeObject(data []byte, metadata map[string]string) *fakeObject {
+	sum := md5.Sum(data)
+	return &fakeObject{
+		data:     data,
+		etag:     `"` + hex.EncodeToString(sum[:]) + `"`,
+		modified: time.Now().UTC().Truncate(time.Second),
+		metadata: maps.Clone(metadata),
+	}
+}
+
+// PutObject implements s3.S3API.
+func (c *FakeClient) PutObject(ctx context.Context, params *awss3.PutObjectInput, _ ...func(*awss3.Options)) (*awss3.PutObjectOutput, error) {
+	var data []byte
+	if params.Body != nil {
+		var err error
+		if data, err = io.ReadAll(params.Body); err != nil {
+			return nil, fakeError("PutObject", http.StatusBadRequest, err)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	objects, err := c.bucket("PutObject", params.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	key := aws.ToString(params.Key)
+	if err := checkConditions("PutObject", objects[key], params.IfMatch, params.IfNoneMatch, false); err != nil {
+		return nil, err
+	}
+	obj := newFakeObject(data, params.Metadata)
+	objects[key] = obj
+	return &awss3.PutObjectOutput{ETag: aws.String(obj.etag)}, nil
+}
+
+// GetObject implements s3.S3API.
+func (c *FakeClient) GetObject(ctx context.Context, params *awss3.GetObjectInput, _ ...func(*awss3.Options)) (*awss3.GetObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	objects, err := c.bucket("GetObject", params.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	obj := objects[aws.ToString(params.Key)]
+	if obj == nil {
+		return nil, fakeError("GetObject", http.StatusNotFound, &types.NoSuchKey{Message: aws.String("The specified key does not exist.")})
+	}
+	if err := checkConditions("GetObject", obj, params.IfMatch, params.IfNoneMatch, true); err != nil {
+		return nil, err
+	}
+	out := &awss3.GetObjectOutput{
+		ETag:         aws.String(obj.etag),
+		LastModified: aws.Time(obj.modified),
+		Metadata:     maps.Clone(obj.metadata),
+	}
+	data := obj.data
+	if rng := aws.ToString(params.Range); rng != "" && len(obj.data) > 0 {
+		start, end, ok := parseRange(rng, int64(len(obj.data)))
+		if !ok {
+			return nil, fakeError("GetObject", http.StatusRequestedRangeNotSatisfiable, apiError("InvalidRange", "The requested range is not satisfiable"))
+		}
+		data = obj.data[start : end+1]
+		out.ContentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end, len(obj.data)))
+	}
+	out.ContentLength = aws.Int64(int64(len(data)))
+	out.Body = io.NopCloser(bytes.NewReader(slices.Clone(data)))
+	return out, nil
+}
+
+// parseRange parses a single byte range of an object of the given size.
+func parseRange(rng string, size int64) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(rng, "bytes=")
+	first, last, dash := strings.Cut(spec, "-")
+	if !found || !dash {
+		return 0, 0, false
+	}
+	var err error
+	switch {
+	case first == "":
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		return max(size-n, 0), size - 1, true
+	case last == "":
+		end = size - 1
+	default:
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil || start >= size || start > end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// HeadObject implements s3.S3API.
+func (c *FakeClient) HeadObject(ctx context.Context, params *awss3.HeadObjectInput, _ ...func(*awss3.Options)) (*awss3.HeadObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	objects, err := c.bucket("HeadObject", params.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	obj := objects[aws.ToString(params.Key)]
+	if obj == nil {
+		return nil, fakeError("HeadObject", http.StatusNotFound, &types.NotFound{Message: aws.String("Not Found")})
+	}
+	if err := checkConditions("HeadObject", obj, params.IfMatch, params.IfNoneMatch, true); err != nil {
+		return nil, err
+	}
+	return &awss3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(obj.data))),
+		ETag:          aws.String(obj.etag),
+		LastModified:  aws.Time(obj.modified),
+		Metadata:      maps.Clone(obj.metadata),
+	}, nil
+}
+
+// CopyObject implements s3.S3API. The copy keeps the metadata of the source.
+func (c *FakeClient) CopyObject(ctx context.Context, params *awss3.CopyObjectInput, _ ...func(*awss3.Options)) (*awss3.CopyObjectOutput, error) {
+	srcBucket, srcKey, ok := strings.Cut(aws.ToString(params.CopySource), "/")
+	if !ok {
+		return nil, fakeError("CopyObject", http.StatusBadRequest, apiError("InvalidArgument", "Invalid copy source"))
+	}
+	srcKey, err := url.PathUnescape(srcKey)
+	if err != nil {
+		return nil, fakeError("CopyObject", http.StatusBadRequest, apiError("InvalidArgument", "Invalid copy source encoding"))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sources, err := c.bucket("CopyObject", &srcBucket)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := c.bucket("CopyObject", params.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	src, ok := sources[srcKey]
+	if !ok {
+		return nil, fakeError("CopyObject", http.StatusNotFound, &types.NoSuchKey{Message: aws.String("The specified key does not exist.")})
+	}
+	obj := newFakeObject(bytes.Clone(src.data), src.metadata)
+	objects[aws.ToString(params.Key)] = obj
+	return &awss3.CopyObjectOutput{CopyObjectResult: &types.CopyObjectResult{ETag: aws.String(obj.etag), LastModified: aws.Time(obj.modified)}}, nil
+}
+
+// DeleteObject implements s3.S3API. Deleting a missing key succeeds, as on S3.
+func (c *FakeClient) DeleteObject(ctx context.Context, params *awss3.DeleteObjectInput, _ ...func(*awss3.Options)) (*awss3.DeleteObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	objects, err := c.bucket("DeleteObject", params.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	key := aws.ToString(params.Key)
+	if params.IfMatch != nil {
+		if err := checkConditions("DeleteObject", objects[key], params.IfMatch, nil, false); err != nil {
+			return nil, err
+		}
+	}
+	delete(objects, key)
+	return &awss3.DeleteObjectOutput{}, nil
+}
+
+// DeleteObjects implements s3.S3API.
+func (c *FakeClient) DeleteObjects(ctx context.Context, params *awss3.DeleteObjectsInput, _ ...func(*awss3.Options)) (*awss3.DeleteObjectsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	objects, err := c.bucket("DeleteObjects", params.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	out := &awss3.DeleteObjectsOutput{}
+	if params.Delete == nil {
+		return out, nil
+	}
+	for _, id := range params.Delete.Objects {
+		delete(objects, aws.ToString(id.Key))
+		out.Deleted = append(out.Deleted, types.DeletedObject{Key: id.Key})
+	}
+	return out, nil
+}
+
+// ListObjectsV2 implements s3.S3API.
+func (c *FakeClient) ListObjectsV2(ctx context.Context, params *awss3.ListObjectsV2Input, _ ...func(*awss3.Options)) (*awss3.ListObjectsV2Output, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	objects, err := c.bucket("ListObjectsV2", params.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	prefix, delimiter := aws.ToString(params.Prefix), aws.ToString(params.Delimiter)
+	// The continuation token is the last key listed, or the last common prefix marked by a "/"
+	// before it
+	after, skipPrefix := aws.ToString(params.StartAfter), ""
+	if token := aws.ToString(params.ContinuationToken); token != "" {
+		after = token[1:]
+		if token[0] == '/' {
+			skipPrefix = after
+		}
+	}
+	maxKeys := int(aws.ToInt32(params.MaxKeys))
+	if maxKeys <= 0 || maxKeys > 1000 {
+		maxKeys = 1000
+	}
+
+	out := &awss3.ListObjectsV2Output{
+		Name:              params.Bucket,
+		Prefix:            params.Prefix,
+		Delimiter:         params.Delimiter,
+		MaxKeys:           aws.Int32(int32(maxKeys)),
+		ContinuationToken: params.ContinuationToken,
+		StartAfter:        params.StartAfter,
+	}
+	var count int32
+	token := ""
+	for _, key := range slices.Sorted(maps.Keys(objects)) {
+		if !strings.HasPrefix(key, prefix) || key <= after || (skipPrefix != "" && strings.HasPrefix(key, skipPrefix)) {
+			continue
+		}
+		if int(count) == maxKeys {
+			out.IsTruncated = aws.Bool(true)
+			out.NextContinuationToken = aws.String(token)
+			break
+		}
+		count++
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				common := key[:len(prefix)+i+len(delimiter)]
+				out.CommonPrefixes = append(out.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(common)})
+				skipPrefix, token = common, "/"+common
+				continue
+			}
+		}
+		obj := objects[key]
+		out.Contents = append(out.Contents, types.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(int64(len(obj.data))),
+			ETag:         aws.String(obj.etag),
+			LastModified: aws.Time(obj.modified),
+			StorageClass: types.ObjectStorageClassStandard,
+		})
+		token = "k" + key
+	}
+	out.KeyCount = aws.Int32(count)
+	if out.IsTruncated == nil {
+		out.IsTruncated = aws.Bool(false)
+	}
+	return out, nil
+}
+
+// ListObjectVersions implements s3.S3API, listing every object as its only, latest version.
+func (c *FakeClient) ListObjectVersions(ctx context.Context, params *awss3.ListObjectVersionsInput, _ ...func(*awss3.Options)) (*awss3.ListObjectVersionsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	objects, err := c.bucket("ListObjectVersions", params.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	out := &awss3.ListObjectVersionsOutput{Name: params.Bucket, Prefix: params.Prefix, IsTruncated: aws.Bool(false)}
+	for _, key := range slices.Sorted(maps.Keys(objects)) {
+		if !strings.HasPrefix(key, aws.ToString(params.Prefix)) {
+			continue
+		}
+		obj := objects[key]
+		out.Versions = append(out.Versions, types.ObjectVersion{
+			Key:          aws.String(key),
+			VersionId:    aws.String("null"),
+			IsLatest:     aws.Bool(true),
+			Size:         aws.Int64(int64(len(obj.data))),
+			ETag:         aws.String(obj.etag),
+			LastModified: aws.Time(obj.modified),
+		})
+	}
+	return out, nil
+}
+
+// HeadBucket implements s3.S3API.
+func (c *FakeClient) HeadBucket(ctx context.Context, params *awss3.HeadBucketInput, _ ...func(*awss3.Options)) (*awss3.HeadBucketOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.buckets[aws.ToString(params.Bucket)]; !ok {
+		return nil, fakeError("HeadBucket", http.StatusNotFound, &types.NotFound{Message: aws.String("Not Found")})
+	}
+	return &awss3.HeadBucketOutput{}, nil
+}
+
+// GetBucketVersioning implements s3.S3API; versioning is never enabled.
+func (c *FakeClient) GetBucketVersioning(ctx context.Context, params *awss3.GetBucketVersioningInput, _ ...func(*awss3.Options)) (*awss3.GetBucketVersioningOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.bucket("GetBucketVersioning", params.Bucket); err != nil {
+		return nil, err
+	}
+	return &awss3.GetBucketVersioningOutput{}, nil
+}
+
+// GetBucketAccelerateConfiguration implements s3.S3API.
+func (c *FakeClient) GetBucketAccelerateConfiguration(ctx context.Context, params *awss3.GetBucketAccelerateConfigurationInput, _ ...func(*awss3.Options)) (*awss3.GetBucketAccelerateConfigurationOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.bucket("GetBucketAccelerateConfiguration", params.Bucket); err != nil {
+		return nil, err
+	}
+	return &awss3.GetBucketAccelerateConfigurationOutput{Status: c.accelerate[aws.ToString(params.Bucket)]}, nil
+}
+
+// PutBucketAccelerateConfiguration sets the acceleration status of a bucket, as S3 does.
+func (c *FakeClient) PutBucketAccelerateConfiguration(ctx context.Context, params *awss3.PutBucketAccelerateConfigurationInput, _ ...func(*awss3.Options)) (*awss3.PutBucketAccelerateConfigurationOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.bucket("PutBucketAccelerateConfiguration", params.Bucket); err != nil {
+		return nil, err
+	}
+	c.accelerate[aws.ToString(params.Bucket)] = params.AccelerateConfiguration.Status
+	return &awss3.PutBucketAccelerateConfigurationOutput{}, nil
+}
+
+// GetBucketLifecycleConfiguration implements s3.S3API.
+func (c *FakeClient) GetBucketLifecycleConfiguration(ctx context.Context, params *awss3.GetBucketLifecycleConfigurationInput, _ ...func(*awss3.Options)) (*awss3.GetBucketLifecycleConfigurationOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.bucket("GetBucketLifecycleConfiguration", params.Bucket); err != nil {
+		return nil, err
+	}
+	rules, ok := c.lifecycles[aws.ToString(params.Bucket)]
+	if !ok {
+		return nil, fakeError("GetBucketLifecycleConfiguration", http.StatusNotFound,
+			apiError("NoSuchLifecycleConfiguration", "The lifecycle configuration does not exist"))
+	}
+	return &awss3.GetBucketLifecycleConfigurationOutput{Rules: slices.Clone(rules)}, nil
+}
+
+// PutBucketLifecycleConfiguration implements s3.S3API.
+func (c *FakeClient) PutBucketLifecycleConfiguration(ctx context.Context, params *awss3.PutBucketLifecycleConfigurationInput, _ ...func(*awss3.Options)) (*awss3.PutBucketLifecycleConfigurationOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.bucket("PutBucketLifecycleConfiguration", params.Bucket); err != nil {
+		return nil, err
+	}
+	if params.LifecycleConfiguration == nil || len(params.LifecycleConfiguration.Rules) == 0 {
+		return nil, fakeError("PutBucketLifecycleConfiguration", http.StatusBadRequest, apiError("MalformedXML", "The XML you provided was not well-formed"))
+	}
+	c.lifecycles[aws.ToString(params.Bucket)] = slices.Clone(params.LifecycleConfiguration.Rules)
+	return &awss3.PutBucketLifecycleConfigurationOutput{}, nil
+}
+
+// DeleteBucketLifecycle implements s3.S3API.
+func (c *FakeClient) DeleteBucketLifecycle(ctx context.Context, params *awss3.DeleteBucketLifecycleInput, _ ...func(*awss3.Options)) (*awss3.DeleteBucketLifecycleOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.bucket("DeleteBucketLifecycle", params.Bucket); err != nil {
+		return nil, err
+	}
+	delete(c.lifecycles, aws.ToString(params.Bucket))
+	return &awss3.DeleteBucketLifecycleOutput{}, nil
+}
+
+// LifecycleRules returns the lifecycle rules of a bucket.
+func (c *FakeClient) LifecycleRules(bucket string) []types.LifecycleRule {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.lifecycles[bucket])
+}
+
+// CreateMultipartUpload implements s3.S3API.
+func (c *FakeClient) CreateMultipartUpload(ctx context.Context, params *awss3.CreateMultipartUploadInput, _ ...func(*awss3.Options)) (*awss3.CreateMultipartUploadOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.bucket("CreateMultipartUpload", params.Bucket); err != nil {
+		return nil, err
+	}
+	c.nextID++
+	id := strconv.Itoa(c.nextID)
+	c.uploads[id] = &fakeUpload{
+		bucket:   aws.ToString(params.Bucket),
+		key:      aws.ToString(params.Key),
+		metadata: maps.Clone(params.Metadata),
+		parts:    make(map[int32][]byte),
+	}
+	return &awss3.CreateMultipartUploadOutput{Bucket: params.Bucket, Key: params.Key, UploadId: aws.String(id)}, nil
+}
+
+// UploadPart implements s3.S3API.
+func (c *FakeClient) UploadPart(ctx context.Context, params *awss3.UploadPartInput, _ ...func(*awss3.Options)) (*awss3.UploadPartOutput, error) {
+	var data []byte
+	if params.Body != nil {
+		var err error
+		if data, err = io.ReadAll(params.Body); err != nil {
+			return nil, fakeError("UploadPart", http.StatusBadRequest, err)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	upload, err := c.upload("UploadPart", params.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	upload.parts[aws.ToInt32(params.PartNumber)] = data
+	sum := md5.Sum(data)
+	return &awss3.UploadPartOutput{ETag: aws.String(`"` + hex.EncodeToString(sum[:]) + `"`)}, nil
+}
+
+// CompleteMultipartUpload implements s3.S3API, joining the listed parts in order.
+func (c *FakeClient) CompleteMultipartUpload(ctx context.Context, params *awss3.CompleteMultipartUploadInput, _ ...func(*awss3.Options)) (*awss3.CompleteMultipartUploadOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	upload, err := c.upload("CompleteMultipartUpload", params.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := c.bucket("CompleteMultipartUpload", params.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkConditions("CompleteMultipartUpload", objects[upload.key], params.IfMatch, params.IfNoneMatch, false); err != nil {
+		return nil, err
+	}
+	var data []byte
+	if params.MultipartUpload != nil {
+		for _, part := range params.MultipartUpload.Parts {
+			p, ok := upload.parts[aws.ToInt32(part.PartNumber)]
+			if !ok {
+				return nil, fakeError("CompleteMultipartUpload", http.StatusBadRequest, apiError("InvalidPart", "One or more of the specified parts could not be found"))
+			}
+			data = append(data, p...)
+		}
+	}
+	delete(c.uploads, aws.ToString(params.UploadId))
+	obj := newFakeObject(data, upload.metadata)
+	objects[upload.key] = obj
+	return &awss3.CompleteMultipartUploadOutput{Bucket: params.Bucket, Key: params.Key, ETag: aws.String(obj.etag)}, nil
+}
+
+// AbortMultipartUpload implements s3.S3API.
+func (c *FakeClient) AbortMultipartUpload(ctx context.Context, params *awss3.AbortMultipartUploadInput, _ ...func(*awss3.Options)) (*awss3.AbortMultipartUploadOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.upload("AbortMultipartUpload", params.UploadId); err != nil {
+		return nil, err
+	}
+	delete(c.uploads, aws.ToString(params.UploadId))
+	return &awss3.AbortMultipartUploadOutput{}, nil
+}
+
+// upload returns a multipart upload in progress; c.mu must be held.
+func (c *FakeClient) upload(op string, id *string) (*fakeUpload, error) {
+	upload, ok := c.uploads[aws.ToString(id)]
+	if !ok {
+		return nil, fakeError(op, http.StatusNotFound, &types.NoSuchUpload{Message: aws.String("The specified upload does not exist")})
+	}
+	return upload, nil
+}
@@ -0,0 +1,44 @@
+package s3
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// secret describes a credential which may be given literally or read from a file,
+// e.g. a Docker or Kubernetes secret mount.
+type secret struct {
+	name     string
+	value    *string
+	fileName string
+	filePath *string
+}
+
+// resolveSecrets fills in the credentials from their *_file options. Placeholders in both the
+// literal values and the file paths are resolved before, by resolvePlaceholders.
+func (s *S3Storage) resolveSecrets() error {
+	secrets := []secret{
+		{"access_key_id", &s.AccessKeyID, "access_key_id_file", &s.AccessKeyIDFile},
+		{"secret_access_key", &s.SecretAccessKey, "secret_access_key_file", &s.SecretAccessKeyFile},
+		{"session_token", &s.SessionToken, "session_token_file", &s.SessionTokenFile},
+		{"encryption_key", &s.EncryptionKey, "encryption_key_file", &s.EncryptionKeyFile},
+		{"encryption_passphrase", &s.EncryptionPassphrase, "encryption_passphrase_file", &s.EncryptionPassphraseFile},
+		{"sse_customer_key", &s.SSECustomerKey, "sse_customer_key_file", &s.SSECustomerKeyFile},
+	}
+	for _, sec := range secrets {
+		path := *sec.filePath
+		if path == "" {
+			continue
+		}
+		if *sec.value != "" {
+			return fmt.Errorf("%s and %s are mutually exclusive", sec.name, sec.fileName)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", sec.fileName, err)
+		}
+		*sec.value = strings.TrimRight(string(content), "\r\n")
+	}
+	return nil
+}
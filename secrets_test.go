@@ -0,0 +1,41 @@
+package s3
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "secret"), []byte("file-secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("S3TEST_SECRET_DIR", dir)
+	t.Setenv("S3TEST_KEY_ID", "env-key-id")
+	t.Setenv("S3TEST_SESSION_TOKEN", "env-session-token")
+
+	s := &S3Storage{
+		AccessKeyID:         "{env.S3TEST_KEY_ID}",
+		SecretAccessKeyFile: "{env.S3TEST_SECRET_DIR}/secret",
+		SessionToken:        "{env.S3TEST_SESSION_TOKEN}",
+	}
+	s.resolvePlaceholders()
+	if err := s.resolveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	if s.AccessKeyID != "env-key-id" {
+		t.Errorf("access key id = %q", s.AccessKeyID)
+	}
+	if s.SecretAccessKey != "file-secret" {
+		t.Errorf("secret access key = %q", s.SecretAccessKey)
+	}
+	if s.SessionToken != "env-session-token" {
+		t.Errorf("session token = %q", s.SessionToken)
+	}
+
+	s = &S3Storage{EncryptionKey: "literal", EncryptionKeyFile: filepath.Join(dir, "secret")}
+	if err := s.resolveSecrets(); err == nil {
+		t.Error("expected an error when both the value and the file are set")
+	}
+}
@@ -0,0 +1,166 @@
+package s3
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/caddyserver/certmagic"
+	"github.com/spf13/cobra"
+)
+
+// defaultSeedIssuer is the issuer key of Let's Encrypt's production directory, so seeded
+// certificates are picked up by a default Caddy setup.
+const defaultSeedIssuer = "acme-v02.api.letsencrypt.org-directory"
+
+func seedCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "seed --config <path> --domains <names> --self-signed [--issuer <key>] [--seed <string>] [--valid-from <date>] [--validity <duration>]",
+		Short: "Populates the storage with self-signed certificates",
+		Long: `
+Writes a certificate, private key and metadata object for every given domain
+into the storage, in the layout CertMagic uses for issued certificates. This
+lets staging environments and load tests run without live ACME issuance.
+
+Output is deterministic: keys are derived from the domain name and --seed and
+the validity window starts at --valid-from (default: today, 00:00 UTC), so
+running the command twice produces identical objects.
+`,
+		RunE: caddycmd.WrapCommandFuncForCobra(cmdSeed),
+	}
+	addConfigFlags(cmd)
+	cmd.Flags().String("domains", "", "Comma-separated list of domains (required)")
+	cmd.Flags().Bool("self-signed", false, "Generate self-signed certificates (required, the only supported mode)")
+	cmd.Flags().String("issuer", defaultSeedIssuer, "Issuer key to store the certificates under")
+	cmd.Flags().String("seed", "", "Additional input for deriving keys")
+	cmd.Flags().String("valid-from", "", "Start of the validity window as YYYY-MM-DD")
+	cmd.Flags().String("validity", "2160h", "Validity period of the certificates")
+	return cmd
+}
+
+func cmdSeed(fl caddycmd.Flags) (int, error) {
+	if !fl.Bool("self-signed") {
+		return caddy.ExitCodeFailedStartup, errors.New("--self-signed is required (no other mode is supported)")
+	}
+	var domains []string
+	for _, d := range strings.Split(fl.String("domains"), ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	if len(domains) == 0 {
+		return caddy.ExitCodeFailedStartup, errors.New("--domains is required")
+	}
+	validity, err := caddy.ParseDuration(fl.String("validity"))
+	if err != nil || validity <= 0 {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("invalid --validity '%s'", fl.String("validity"))
+	}
+	notBefore := time.Now().UTC().Truncate(24 * time.Hour)
+	if v := fl.String("valid-from"); v != "" {
+		if notBefore, err = time.Parse(time.DateOnly, v); err != nil {
+			return caddy.ExitCodeFailedStartup, fmt.Errorf("invalid --valid-from: %v", err)
+		}
+	}
+	issuer := fl.String("issuer")
+
+	s, cancel, err := loadStorageFromConfig(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	defer cancel()
+
+	ctx := context.Background()
+	for _, domain := range domains {
+		res, err := selfSignedResource(domain, fl.String("seed"), notBefore, notBefore.Add(validity))
+		if err != nil {
+			return caddy.ExitCodeFailedQuit, fmt.Errorf("generating certificate for %s: %v", domain, err)
+		}
+		if err := s.storeCertificateResource(ctx, issuer, domain, res); err != nil {
+			return caddy.ExitCodeFailedQuit, err
+		}
+		fmt.Printf("seeded %s\n", domain)
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+// storeCertificateResource writes the certificate, key and metadata objects the way CertMagic does.
+func (s *S3Storage) storeCertificateResource(ctx context.Context, issuer, domain string, res certmagic.CertificateResource) error {
+	meta, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encoding metadata for %s: %w", domain, err)
+	}
+	objects := []struct {
+		key   string
+		value []byte
+	}{
+		{certmagic.StorageKeys.SitePrivateKey(issuer, domain), res.PrivateKeyPEM},
+		{certmagic.StorageKeys.SiteCert(issuer, domain), res.CertificatePEM},
+		{certmagic.StorageKeys.SiteMeta(issuer, domain), meta},
+	}
+	for _, obj := range objects {
+		if err := s.Store(ctx, obj.key, obj.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selfSignedResource deterministically generates a self-signed ECDSA P-256 certificate for domain.
+func selfSignedResource(domain, seed string, notBefore, notAfter time.Time) (certmagic.CertificateResource, error) {
+	var res certmagic.CertificateResource
+
+	// Derive the private key scalar from the domain so reruns yield the same key.
+	scalar := sha256.Sum256([]byte("certmagic-s3 seed\x00" + seed + "\x00" + domain))
+	ecdhKey, err := ecdh.P256().NewPrivateKey(scalar[:])
+	if err != nil {
+		return res, fmt.Errorf("deriving key: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(ecdhKey)
+	if err != nil {
+		return res, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(keyDER)
+	if err != nil {
+		return res, err
+	}
+	key := parsed.(*ecdsa.PrivateKey)
+	ecKeyDER, err := x509.MarshalECPrivateKey(key) // CertMagic stores EC keys in SEC 1 form
+	if err != nil {
+		return res, err
+	}
+
+	serial := sha256.Sum256(append(scalar[:], notBefore.String()...))
+	tmpl := &x509.Certificate{
+		SerialNumber:          new(big.Int).SetBytes(serial[:16]),
+		Subject:               pkix.Name{CommonName: domain},
+		DNSNames:              []string{domain},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	// A nil random source makes ECDSA signatures deterministic (RFC 6979).
+	certDER, err := x509.CreateCertificate(nil, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return res, err
+	}
+
+	res.SANs = []string{domain}
+	res.CertificatePEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	res.PrivateKeyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecKeyDER})
+	res.IssuerData = json.RawMessage(`{"seeded":true}`)
+	return res, nil
+}
@@ -0,0 +1,36 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func TestSelfSignedResourceDeterministic(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	a, err := selfSignedResource("example.com", "", from, from.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("generating certificate failed: %v", err)
+	}
+	b, err := selfSignedResource("example.com", "", from, from.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("generating certificate failed: %v", err)
+	}
+	if !bytes.Equal(a.CertificatePEM, b.CertificatePEM) || !bytes.Equal(a.PrivateKeyPEM, b.PrivateKeyPEM) {
+		t.Errorf("expected identical output for identical input")
+	}
+
+	if _, err := tls.X509KeyPair(a.CertificatePEM, a.PrivateKeyPEM); err != nil {
+		t.Errorf("certificate and key do not match: %v", err)
+	}
+
+	c, err := selfSignedResource("example.com", "other", from, from.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("generating certificate failed: %v", err)
+	}
+	if bytes.Equal(a.PrivateKeyPEM, c.PrivateKeyPEM) {
+		t.Errorf("expected a different key for a different seed")
+	}
+}
@@ -0,0 +1,202 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"go.uber.org/zap"
+)
+
+// With shards, the site certificates (certificates/<issuer>/<name>/...) are spread over several
+// buckets by a hash of the name, so that very large fleets are not bound to the request rates,
+// IAM policies and blast radius of a single bucket. Every shard uses the storage's prefix; ACME
+// accounts, OCSP staples, locks and other keys stay in the storage's bucket, which may be one of
+// the shards as well. Like routes, the bucket of every request is chosen by a client middleware,
+// and listings above the site level fan out to all shards and merge their keys.
+//
+// The shard of a name depends on the number and order of the shards: changing the list moves
+// names to other buckets, whose objects have to be copied there before the change.
+
+// shardRouter chooses the shard bucket of a request.
+type shardRouter struct {
+	bucket string   // Bucket of the storage; requests for other buckets are left alone
+	prefix string   // Prefix of the storage
+	shards []string // Shard buckets in configured order
+}
+
+// shardCtxKey carries the shard bucket a fanned-out listing is addressed to.
+type shardCtxKey struct{}
+
+// withShard addresses the listings and batch deletions made with ctx to one shard.
+func withShard(ctx context.Context, bucket string) context.Context {
+	return context.WithValue(ctx, shardCtxKey{}, bucket)
+}
+
+// provisionShards validates the shards and sets up the router.
+func (s *S3Storage) provisionShards() error {
+	switch {
+	case s.Client != nil:
+		return errors.New("shards cannot be used with a client set before provisioning")
+	case len(s.Routes) > 0:
+		return errors.New("shards cannot be combined with routes")
+	case s.Manifest:
+		return errors.New("shards cannot be combined with manifest")
+	case isMultiRegionAccessPoint(s.Bucket):
+		return errors.New("shards are not supported with multi-region access points")
+	case s.SoftDelete != nil || s.Lifecycle != nil:
+		return errors.New("shards cannot be combined with soft_delete or lifecycle, which only apply to one bucket")
+	}
+	seen := make(map[string]bool)
+	for _, bucket := range s.Shards {
+		if bucket == "" || seen[bucket] {
+			return fmt.Errorf("shards: empty or duplicate bucket '%s'", bucket)
+		}
+		seen[bucket] = true
+	}
+	s.sharder = &shardRouter{bucket: s.Bucket, prefix: s.Prefix, shards: s.Shards}
+	s.logger.Info("sharding certificates over buckets", zap.Strings("shards", s.Shards))
+	return nil
+}
+
+// shardName returns the site name a CertMagic key (or list prefix) is sharded by, if it is at or
+// below a site directory.
+func shardName(key string) (string, bool) {
+	parts := strings.Split(strings.Trim(key, "/"), "/")
+	if len(parts) < 3 || parts[0] != "certificates" || parts[2] == "" {
+		return "", false
+	}
+	return parts[2], true
+}
+
+// shardFanOut reports whether a listing of the CertMagic key prefix spans several shards, i.e.
+// the prefix is above the site directories.
+func shardFanOut(prefix string) bool {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" || prefix == "certificates" {
+		return true
+	}
+	parts := strings.Split(prefix, "/")
+	return len(parts) == 2 && parts[0] == "certificates"
+}
+
+// shardOf returns the shard bucket of a site name.
+func (r *shardRouter) shardOf(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return r.shards[h.Sum32()%uint32(len(r.shards))]
+}
+
+// bucketFor returns the bucket of an S3 key or list prefix.
+func (r *shardRouter) bucketFor(s3Key string) string {
+	key := s3Key
+	if r.prefix != "" {
+		var ok bool
+		if key, ok = strings.CutPrefix(s3Key, r.prefix+"/"); !ok {
+			return r.bucket
+		}
+	}
+	if name, ok := shardName(key); ok {
+		return r.shardOf(name)
+	}
+	return r.bucket
+}
+
+// route returns the bucket for a request addressing key in bucket.
+func (r *shardRouter) route(bucket, key *string) *string {
+	if aws.ToString(bucket) != r.bucket {
+		return bucket
+	}
+	return aws.String(r.bucketFor(aws.ToString(key)))
+}
+
+// routeListing returns the bucket for a listing or batch deletion, which goes to the shard
+// addressed by ctx during a fan-out.
+func (r *shardRouter) routeListing(ctx context.Context, bucket, key *string) *string {
+	if shard, ok := ctx.Value(shardCtxKey{}).(string); ok && aws.ToString(bucket) == r.bucket {
+		return aws.String(shard)
+	}
+	return r.route(bucket, key)
+}
+
+// register adds the sharding middleware to a client's stack.
+func (r *shardRouter) register(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("ShardBucket",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			r.apply(ctx, in.Parameters)
+			return next.HandleInitialize(ctx, in)
+		}), middleware.Before)
+}
+
+// apply sets the bucket of the operations addressing objects. A batch deletion is routed by its
+// first key, as its keys come from one listing.
+func (r *shardRouter) apply(ctx context.Context, params any) {
+	switch in := params.(type) {
+	case *awss3.PutObjectInput:
+		in.Bucket = r.route(in.Bucket, in.Key)
+	case *awss3.GetObjectInput:
+		in.Bucket = r.route(in.Bucket, in.Key)
+	case *awss3.HeadObjectInput:
+		in.Bucket = r.route(in.Bucket, in.Key)
+	case *awss3.DeleteObjectInput:
+		in.Bucket = r.route(in.Bucket, in.Key)
+	case *awss3.CopyObjectInput: // The source bucket is part of CopySource, see copySource
+		in.Bucket = r.route(in.Bucket, in.Key)
+	case *awss3.CreateMultipartUploadInput:
+		in.Bucket = r.route(in.Bucket, in.Key)
+	case *awss3.UploadPartInput:
+		in.Bucket = r.route(in.Bucket, in.Key)
+	case *awss3.CompleteMultipartUploadInput:
+		in.Bucket = r.route(in.Bucket, in.Key)
+	case *awss3.AbortMultipartUploadInput:
+		in.Bucket = r.route(in.Bucket, in.Key)
+	case *awss3.ListObjectsV2Input:
+		in.Bucket = r.routeListing(ctx, in.Bucket, in.Prefix)
+	case *awss3.ListObjectVersionsInput:
+		in.Bucket = r.routeListing(ctx, in.Bucket, in.Prefix)
+	case *awss3.DeleteObjectsInput:
+		if in.Delete != nil && len(in.Delete.Objects) > 0 {
+			in.Bucket = r.routeListing(ctx, in.Bucket, in.Delete.Objects[0].Key)
+		}
+	}
+}
+
+// shardClientOptions adds the sharding middleware to a client, if shards are configured.
+func (s *S3Storage) shardClientOptions(o *awss3.Options) {
+	if s.sharder != nil {
+		o.APIOptions = append(o.APIOptions, s.sharder.register)
+	}
+}
+
+// shardedListing reports whether a listing of the CertMagic key prefix has to fan out to the
+// shards, as the prefix is above the site directories and ctx doesn't address a shard yet.
+func (s *S3Storage) shardedListing(ctx context.Context, prefix string) bool {
+	if s.sharder == nil || !shardFanOut(prefix) {
+		return false
+	}
+	_, addressed := ctx.Value(shardCtxKey{}).(string)
+	return !addressed
+}
+
+// fanOut calls fn once for the storage's bucket and once for every other shard, with a context
+// addressing the listings made with it to that bucket.
+func (s *S3Storage) fanOut(ctx context.Context, fn func(ctx context.Context) error) error {
+	buckets := []string{s.Bucket}
+	for _, shard := range s.Shards {
+		if !slices.Contains(buckets, shard) {
+			buckets = append(buckets, shard)
+		}
+	}
+	for _, bucket := range buckets {
+		if err := fn(withShard(ctx, bucket)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package s3
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// defaultSidecarListen is used when the sidecar block does not specify a listen address.
+const defaultSidecarListen = "127.0.0.1:9797"
+
+// maxSidecarValueSize bounds request bodies accepted by the sidecar; certmagic values are small.
+const maxSidecarValueSize = 10 << 20
+
+// SidecarConfig configures the optional HTTP endpoint which exposes the storage operations
+// (including encryption) to non-Go processes on the same host.
+type SidecarConfig struct {
+	// Listen is the address to serve on. It must be a loopback address unless AllowRemote is set.
+	Listen string `json:"listen,omitempty"`
+	// Token is the bearer token clients must present in the Authorization header.
+	Token string `json:"token,omitempty"`
+	// AllowRemote permits binding to non-loopback addresses.
+	AllowRemote bool `json:"allow_remote,omitempty"`
+}
+
+// sidecarKeyInfo is the JSON representation of certmagic.KeyInfo served by the sidecar.
+type sidecarKeyInfo struct {
+	Key        string    `json:"key"`
+	Modified   time.Time `json:"modified"`
+	Size       int64     `json:"size"`
+	IsTerminal bool      `json:"terminal"`
+}
+
+// startSidecar validates the sidecar configuration and starts serving the storage API.
+func (s *S3Storage) startSidecar(ctx caddy.Context) error {
+	cfg := s.Sidecar
+	listen := cfg.Listen
+	if listen == "" {
+		listen = defaultSidecarListen
+	}
+	if cfg.Token == "" {
+		return errors.New("sidecar token must be specified")
+	}
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return fmt.Errorf("invalid sidecar listen address '%s': %w", listen, err)
+	}
+	if !cfg.AllowRemote {
+		ip := net.ParseIP(host)
+		if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return fmt.Errorf("sidecar listen address '%s' is not a loopback address (set allow_remote to override)", listen)
+		}
+	}
+
+	// Listen through Caddy so the socket survives config reloads.
+	addr, err := caddy.ParseNetworkAddress(listen)
+	if err != nil {
+		return fmt.Errorf("invalid sidecar listen address '%s': %w", listen, err)
+	}
+	lnAny, err := addr.Listen(ctx, 0, net.ListenConfig{})
+	if err != nil {
+		return fmt.Errorf("listening for sidecar on %s: %w", listen, err)
+	}
+	ln, ok := lnAny.(net.Listener)
+	if !ok {
+		return fmt.Errorf("sidecar listen address '%s' is not a stream address", listen)
+	}
+
+	s.sidecarServer = &http.Server{
+		Handler:           s.sidecarHandler(cfg.Token),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := s.sidecarServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("sidecar server stopped", zap.Error(err))
+		}
+	}()
+	s.logger.Info("storage sidecar listening", zap.String("address", ln.Addr().String()))
+	return nil
+}
+
+// stopSidecar shuts the sidecar server down if it is running.
+func (s *S3Storage) stopSidecar() error {
+	if s.sidecarServer == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.sidecarServer.Shutdown(ctx)
+	s.sidecarServer = nil
+	return err
+}
+
+// sidecarHandler returns the HTTP handler serving the storage API:
+//
+//	GET    /v1/keys/{key}                  load a value
+//	PUT    /v1/keys/{key}                  store a value (request body)
+//	DELETE /v1/keys/{key}                  delete a value
+//	GET    /v1/stat/{key}                  key information as JSON
+//	GET    /v1/list?prefix=&recursive=     list keys as a JSON array
+func (s *S3Storage) sidecarHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /v1/keys/{key...}", func(w http.ResponseWriter, r *http.Request) {
+		key, ok := sidecarKey(w, r)
+		if !ok {
+			return
+		}
+		value, err := s.Load(r.Context(), key)
+		if err != nil {
+			writeSidecarError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(value)
+	})
+
+	mux.HandleFunc("PUT /v1/keys/{key...}", func(w http.ResponseWriter, r *http.Request) {
+		key, ok := sidecarKey(w, r)
+		if !ok {
+			return
+		}
+		value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSidecarValueSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.Store(r.Context(), key, value); err != nil {
+			writeSidecarError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("DELETE /v1/keys/{key...}", func(w http.ResponseWriter, r *http.Request) {
+		key, ok := sidecarKey(w, r)
+		if !ok {
+			return
+		}
+		if err := s.Delete(r.Context(), key); err != nil {
+			writeSidecarError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /v1/stat/{key...}", func(w http.ResponseWriter, r *http.Request) {
+		key, ok := sidecarKey(w, r)
+		if !ok {
+			return
+		}
+		ki, err := s.Stat(r.Context(), key)
+		if err != nil {
+			writeSidecarError(w, err)
+			return
+		}
+		writeSidecarJSON(w, sidecarKeyInfo{Key: ki.Key, Modified: ki.Modified, Size: ki.Size, IsTerminal: ki.IsTerminal})
+	})
+
+	mux.HandleFunc("GET /v1/list", func(w http.ResponseWriter, r *http.Request) {
+		recursive := false
+		if v := r.URL.Query().Get("recursive"); v != "" {
+			var err error
+			if recursive, err = strconv.ParseBool(v); err != nil {
+				http.Error(w, "invalid recursive parameter", http.StatusBadRequest)
+				return
+			}
+		}
+		keys, err := s.List(r.Context(), r.URL.Query().Get("prefix"), recursive)
+		if err != nil {
+			writeSidecarError(w, err)
+			return
+		}
+		if keys == nil {
+			keys = []string{}
+		}
+		writeSidecarJSON(w, keys)
+	})
+
+	// Every request must carry the bearer token.
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// sidecarKey returns the key of a request, answering 400 if it is empty: an empty key would mean
+// the whole storage, e.g. for DELETE.
+func sidecarKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	key := r.PathValue("key")
+	if strings.Trim(key, "/") == "" {
+		http.Error(w, "key must not be empty", http.StatusBadRequest)
+		return "", false
+	}
+	return key, true
+}
+
+func writeSidecarJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeSidecarError(w http.ResponseWriter, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrReadOnly) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
+
+// unmarshalSidecar parses the sidecar block:
+//
+//	sidecar {
+//		listen 127.0.0.1:9797
+//		token <secret>
+//		allow_remote
+//	}
+func (s *S3Storage) unmarshalSidecar(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	cfg := new(SidecarConfig)
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "listen":
+			if !d.AllArgs(&cfg.Listen) {
+				return d.ArgErr()
+			}
+		case "token":
+			if !d.AllArgs(&cfg.Token) {
+				return d.ArgErr()
+			}
+		case "allow_remote":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			cfg.AllowRemote = true
+		default:
+			return d.Errf("unrecognized sidecar subdirective '%s'", d.Val())
+		}
+	}
+	s.Sidecar = cfg
+	return nil
+}
@@ -0,0 +1,8 @@
+package s3
+
+import "net/http"
+
+// SidecarHandler exposes the sidecar API to the tests of package s3_test.
+func (s *S3Storage) SidecarHandler(token string) http.Handler {
+	return s.sidecarHandler(token)
+}
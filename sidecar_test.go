@@ -0,0 +1,88 @@
+package s3_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+func TestSidecar(t *testing.T) {
+	storage, fake := s3test.NewFakeStorage(t)
+	srv := httptest.NewServer(storage.SidecarHandler("secret"))
+	defer srv.Close()
+
+	do := func(method, path, body string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(data)
+	}
+
+	if code, _ := do(http.MethodPut, "/v1/keys/certificates/a.com/a.com.crt", "cert"); code != http.StatusNoContent {
+		t.Fatalf("PUT = %d", code)
+	}
+	if code, body := do(http.MethodPut, "/v1/keys/other", "value"); code != http.StatusNoContent {
+		t.Fatalf("PUT = %d %s", code, body)
+	}
+	if code, body := do(http.MethodGet, "/v1/keys/certificates/a.com/a.com.crt", ""); code != http.StatusOK || body != "cert" {
+		t.Errorf("GET = %d %q", code, body)
+	}
+	if code, _ := do(http.MethodGet, "/v1/keys/missing", ""); code != http.StatusNotFound {
+		t.Errorf("GET of a missing key = %d, want 404", code)
+	}
+	code, body := do(http.MethodGet, "/v1/stat/certificates/a.com/a.com.crt", "")
+	var info struct {
+		Key  string `json:"key"`
+		Size int64  `json:"size"`
+	}
+	if err := json.Unmarshal([]byte(body), &info); code != http.StatusOK || err != nil || info.Key != "certificates/a.com/a.com.crt" || info.Size != 4 {
+		t.Errorf("stat = %d %s", code, body)
+	}
+	if code, body := do(http.MethodGet, "/v1/list?prefix=certificates&recursive=true", ""); code != http.StatusOK || strings.TrimSpace(body) != `["certificates/a.com/a.com.crt"]` {
+		t.Errorf("list = %d %s", code, body)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		if code, _ := do(method, "/v1/keys/", "x"); code != http.StatusBadRequest {
+			t.Errorf("%s of the empty key = %d, want 400", method, code)
+		}
+	}
+	if code, _ := do(http.MethodGet, "/v1/stat/", ""); code != http.StatusBadRequest {
+		t.Errorf("stat of the empty key = %d, want 400", code)
+	}
+
+	if code, _ := do(http.MethodDelete, "/v1/keys/certificates/a.com/a.com.crt", ""); code != http.StatusNoContent {
+		t.Errorf("DELETE = %d", code)
+	}
+	if keys := fake.Keys(s3test.DefaultBucket); !slices.Equal(keys, []string{"certmagic/other"}) {
+		t.Errorf("remaining objects = %v", keys)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/v1/keys/other", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("request without token = %d, want 401", resp.StatusCode)
+	}
+}
@@ -0,0 +1,55 @@
+package s3
+
+import (
+	"context"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// When a popular certificate drops out of CertMagic's in-memory cache, many handshakes read it at
+// once. Concurrent identical reads (Load, Exists and Stat of the same key) share one S3 request.
+// The shared request is not canceled with the caller starting it, as others may be waiting for
+// it; it is still bounded by the read timeout, and every caller can give up on its own context.
+
+// Prefixes of the flight keys by operation; the results of different operations differ.
+const (
+	flightLoad   = "load:"
+	flightExists = "exists:"
+	flightStat   = "stat:"
+)
+
+// coalesce runs fn once for all concurrent callers with the same op and S3 key. shared reports
+// whether the result went to other callers as well, who must not observe modifications of it.
+func coalesce[T any](ctx context.Context, g *singleflight.Group, op, s3Key string, fn func(context.Context) (T, error)) (result T, shared bool, err error) {
+	ch := g.DoChan(op+s3Key, func() (any, error) {
+		return fn(context.WithoutCancel(ctx))
+	})
+	select {
+	case <-ctx.Done():
+		return result, false, ctx.Err()
+	case r := <-ch:
+		result, _ = r.Val.(T)
+		return result, r.Shared, r.Err
+	}
+}
+
+// forgetFlights makes reads of the given S3 key starting from now on send a new request instead
+// of joining one in flight, which may return the value from before a write.
+func (s *S3Storage) forgetFlights(s3Key string) {
+	for _, op := range []string{flightLoad, flightExists, flightStat} {
+		s.flights.Forget(op + s3Key)
+	}
+}
+
+// invalidateReads drops the cached values of a key and makes new reads of it send a new request.
+// Writes call it before and after changing S3, so that reads which started before the change
+// completed neither share nor cache the old value afterwards (see readCache.put).
+func (s *S3Storage) invalidateReads(key, s3Key string) {
+	if s.cache != nil {
+		s.cache.remove(key)
+	}
+	if s.existsCache != nil {
+		s.existsCache.remove(key)
+	}
+	s.forgetFlights(s3Key)
+}
@@ -0,0 +1,358 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// With soft_delete, values removed by Delete and DeleteAll can be brought back with
+// RestoreDeleted until PurgeDeleted removes them after the retention:
+//
+//   - In trash mode, objects are copied to the trash/ subtree of the prefix before they are
+//     deleted. The trash is hidden from List and maintenance tasks.
+//   - In versioning mode, the bucket must have versioning enabled; deletes only add delete
+//     markers, and the previous version is restored. Purging removes noncurrent versions.
+
+// Soft delete modes.
+const (
+	softDeleteTrash      = "trash"
+	softDeleteVersioning = "versioning"
+)
+
+const (
+	trashKeyPrefix         = "trash"             // CertMagic key of the trash subtree
+	defaultDeleteRetention = 30 * 24 * time.Hour // How long deleted values are kept by default
+)
+
+// SoftDeleteConfig configures soft deletes.
+type SoftDeleteConfig struct {
+	Mode      string         `json:"mode,omitempty"`      // "trash" (default) or "versioning"
+	Retention caddy.Duration `json:"retention,omitempty"` // Deleted values younger than this are kept by PurgeDeleted; default 30 days
+}
+
+// retention returns how long deleted values are kept.
+func (c *SoftDeleteConfig) retention() time.Duration {
+	if c.Retention > 0 {
+		return time.Duration(c.Retention)
+	}
+	return defaultDeleteRetention
+}
+
+// validateSoftDelete checks the soft_delete options.
+func (s *S3Storage) validateSoftDelete() error {
+	switch s.SoftDelete.Mode {
+	case "":
+		s.SoftDelete.Mode = softDeleteTrash
+	case softDeleteTrash, softDeleteVersioning:
+	default:
+		return fmt.Errorf("unsupported soft_delete mode '%s' (use %s or %s)", s.SoftDelete.Mode, softDeleteTrash, softDeleteVersioning)
+	}
+	if s.SoftDelete.Retention < 0 {
+		return errors.New("soft_delete retention must not be negative")
+	}
+	return nil
+}
+
+// checkSoftDeleteVersioning ensures that versioning is enabled on the bucket in versioning mode.
+func (s *S3Storage) checkSoftDeleteVersioning(ctx context.Context) error {
+	if s.SoftDelete.Mode != softDeleteVersioning {
+		return nil
+	}
+	ctx, cancel := s.opContext(ctx)
+	defer cancel()
+	out, err := s.Client.GetBucketVersioning(ctx, &awss3.GetBucketVersioningInput{Bucket: aws.String(s.Bucket)})
+	if err != nil {
+		return fmt.Errorf("soft_delete versioning: reading versioning of bucket %s: %w", s.Bucket, s3Error(err))
+	}
+	if out.Status != types.BucketVersioningStatusEnabled {
+		return fmt.Errorf("soft_delete versioning: versioning is not enabled on bucket %s", s.Bucket)
+	}
+	return nil
+}
+
+// trashing reports whether deleted objects are moved to the trash.
+func (s *S3Storage) trashing() bool {
+	return s.SoftDelete != nil && s.SoftDelete.Mode == softDeleteTrash
+}
+
+// isTrashKey reports whether a CertMagic key is in the trash.
+func isTrashKey(key string) bool {
+	return key == trashKeyPrefix || keyBelow(key, trashKeyPrefix)
+}
+
+// trashKey returns the key in the trash of a CertMagic key.
+func trashKey(key string) string {
+	return path.Join(trashKeyPrefix, key)
+}
+
+// moveToTrash copies the object of a CertMagic key to the trash before it is deleted. Missing
+// objects are ignored, as deleting them is.
+func (s *S3Storage) moveToTrash(ctx context.Context, key string) error {
+	if !s.trashing() || isTrashKey(key) {
+		return nil
+	}
+	_, err := s.copyObject(ctx, s.s3ObjectKey(key), s.s3ObjectKey(trashKey(key)))
+	if err != nil && !s.isNotFound(err) {
+		s.recordError("trash", key, err)
+		return fmt.Errorf("moving %s to the trash: %w", key, s3Error(err))
+	}
+	return nil
+}
+
+// RestoreDeleted brings back the value at the given CertMagic key removed by a soft delete. In
+// trash mode, everything below the key is restored as well and removed from the trash. It
+// returns fs.ErrNotExist if there is nothing to restore.
+func (s *S3Storage) RestoreDeleted(ctx context.Context, key string) error {
+	if s.SoftDelete == nil {
+		return errors.New("restore: soft_delete is not enabled")
+	}
+	key = strings.Trim(key, "/")
+	var err error
+	if s.SoftDelete.Mode == softDeleteVersioning {
+		err = s.restoreDeletedVersion(ctx, key)
+	} else {
+		err = s.restoreFromTrash(ctx, key)
+	}
+	s.auditOp(ctx, "restore", key, 0, err)
+	if err == nil {
+		s.opLogger(ctx, key).Info("restored deleted value", zap.String("key", key), zap.String("mode", s.SoftDelete.Mode))
+	}
+	return err
+}
+
+// restoreFromTrash copies the trashed objects of a key back and empties their place in the trash.
+func (s *S3Storage) restoreFromTrash(ctx context.Context, key string) error {
+	if err := s.copyTree(ctx, trashKey(key), key); err != nil {
+		return err
+	}
+	if isOCSPStapleKey(key) { // The delta base was trashed as a key of its own
+		if err := s.copyTree(ctx, trashKey(ocspBaseKey(key)), ocspBaseKey(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	trashed := s.s3ObjectKey(trashKey(key))
+	_, err := s.deleteTrash(ctx, trashed, func(obj types.Object) bool {
+		k := aws.ToString(obj.Key)
+		return k == trashed || strings.HasPrefix(k, trashed+"/") || k == trashed+rolloverSuffix ||
+			k == trashed+ocspBaseSuffix || strings.HasPrefix(k, trashed+ocspBaseSuffix+"/")
+	})
+	return err
+}
+
+// restoreDeletedVersion makes the newest version before the delete marker of a key current again.
+func (s *S3Storage) restoreDeletedVersion(ctx context.Context, key string) error {
+	versions, err := s.ListVersions(ctx, key)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 || !versions[0].DeleteMarker {
+		return fmt.Errorf("restoring %s: not deleted: %w", key, fs.ErrNotExist)
+	}
+	for _, v := range versions[1:] {
+		if !v.DeleteMarker {
+			return s.Restore(ctx, key, v.VersionID)
+		}
+	}
+	return fmt.Errorf("restoring %s: no version before the delete: %w", key, fs.ErrNotExist)
+}
+
+// PurgeDeleted permanently removes soft-deleted values which were deleted more than olderThan
+// ago, or the configured retention if olderThan is 0. It returns the number of removed objects
+// (in versioning mode, of removed versions).
+func (s *S3Storage) PurgeDeleted(ctx context.Context, olderThan time.Duration) (int, error) {
+	if s.SoftDelete == nil {
+		return 0, errors.New("purge: soft_delete is not enabled")
+	}
+	if err := s.checkWritable("purge", trashKeyPrefix); err != nil {
+		return 0, err
+	}
+	if olderThan <= 0 {
+		olderThan = s.SoftDelete.retention()
+	}
+	cutoff := time.Now().Add(-olderThan)
+
+	var purged int
+	var err error
+	if s.SoftDelete.Mode == softDeleteVersioning {
+		purged, err = s.purgeVersions(ctx, cutoff)
+	} else {
+		purged, err = s.deleteTrash(ctx, s.s3ObjectKey(trashKeyPrefix)+"/", func(obj types.Object) bool {
+			return aws.ToTime(obj.LastModified).Before(cutoff) // The copy to the trash is the time of the delete
+		})
+	}
+	s.logger.Info("purged deleted values", zap.String("mode", s.SoftDelete.Mode), zap.Duration("older_than", olderThan),
+		zap.Int("purged", purged), zap.Error(err))
+	return purged, err
+}
+
+// deleteTrash deletes the objects below an S3 key prefix of the trash that match.
+func (s *S3Storage) deleteTrash(ctx context.Context, s3Prefix string, match func(types.Object) bool) (int, error) {
+	paginator := awss3.NewListObjectsV2Paginator(s.Client, &awss3.ListObjectsV2Input{
+		Bucket:  aws.String(s.Bucket),
+		Prefix:  aws.String(s3Prefix),
+		MaxKeys: aws.Int32(deleteBatchSize), // One page fills at most one batch
+	})
+	deleted := 0
+	for paginator.HasMorePages() {
+		pageCtx, cancel := s.listContext(ctx)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			return deleted, fmt.Errorf("listing s3://%s/%s: %w", s.Bucket, s3Prefix, s3Error(err))
+		}
+		var objects []types.ObjectIdentifier
+		for _, obj := range page.Contents {
+			if match(obj) {
+				objects = append(objects, types.ObjectIdentifier{Key: obj.Key})
+			}
+		}
+		n, err := s.deleteBatch(ctx, objects)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// purgeVersions deletes the versions and delete markers below the prefix which became noncurrent
+// before the cutoff, i.e. whose next newer version was written before it.
+func (s *S3Storage) purgeVersions(ctx context.Context, cutoff time.Time) (int, error) {
+	type version struct {
+		id       *string
+		modified time.Time
+	}
+	s3Prefix := s.s3ObjectKey("")
+	if s3Prefix != "" {
+		s3Prefix += "/"
+	}
+	input := &awss3.ListObjectVersionsInput{Bucket: aws.String(s.Bucket), Prefix: aws.String(s3Prefix)}
+	byKey := make(map[string][]version)
+	for {
+		pageCtx, cancel := s.listContext(ctx)
+		page, err := s.Client.ListObjectVersions(pageCtx, input)
+		cancel()
+		if err != nil {
+			return 0, fmt.Errorf("listing versions of s3://%s/%s: %w", s.Bucket, s3Prefix, s3Error(err))
+		}
+		for _, v := range page.Versions {
+			k := aws.ToString(v.Key)
+			byKey[k] = append(byKey[k], version{v.VersionId, aws.ToTime(v.LastModified)})
+		}
+		for _, m := range page.DeleteMarkers {
+			k := aws.ToString(m.Key)
+			byKey[k] = append(byKey[k], version{m.VersionId, aws.ToTime(m.LastModified)})
+		}
+		if !aws.ToBool(page.IsTruncated) {
+			break
+		}
+		input.KeyMarker, input.VersionIdMarker = page.NextKeyMarker, page.NextVersionIdMarker
+	}
+
+	var expired []types.ObjectIdentifier
+	for _, k := range slices.Sorted(maps.Keys(byKey)) {
+		if s.isLockKey(s.certMagicKey(k)) {
+			continue
+		}
+		versions := byKey[k]
+		sort.SliceStable(versions, func(i, j int) bool { return versions[i].modified.After(versions[j].modified) })
+		for i := 1; i < len(versions); i++ {
+			if versions[i-1].modified.Before(cutoff) {
+				expired = append(expired, types.ObjectIdentifier{Key: aws.String(k), VersionId: versions[i].id})
+			}
+		}
+	}
+
+	purged := 0
+	for batch := range slices.Chunk(expired, deleteBatchSize) {
+		n, err := s.deleteBatch(ctx, batch)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// unmarshalSoftDelete parses the soft_delete subdirective:
+//
+//	soft_delete [trash|versioning] {
+//		retention <duration>
+//	}
+func (s *S3Storage) unmarshalSoftDelete(d *caddyfile.Dispenser) error {
+	cfg := new(SoftDeleteConfig)
+	if d.NextArg() {
+		cfg.Mode = d.Val()
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch key := d.Val(); key {
+		case "retention":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid soft_delete retention '%s': %v", d.Val(), err)
+			}
+			cfg.Retention = caddy.Duration(dur)
+		default:
+			return d.Errf("unrecognized soft_delete subdirective '%s'", key)
+		}
+	}
+	s.SoftDelete = cfg
+	return nil
+}
+
+func purgeDeletedCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "purge-deleted --config <path> [--older-than <duration>]",
+		Short: "Permanently removes soft-deleted values after their retention",
+		Long: `
+Permanently removes the values deleted by the configured storage with
+soft_delete enabled once they were deleted longer ago than --older-than,
+which defaults to the retention of the config. In trash mode, the objects in
+the trash/ subtree are removed; in versioning mode, the noncurrent versions
+and delete markers below the prefix.
+`,
+		RunE: caddycmd.WrapCommandFuncForCobra(cmdPurgeDeleted),
+	}
+	addConfigFlags(cmd)
+	cmd.Flags().Duration("older-than", 0, "Only purge values deleted longer ago than this (default: the retention)")
+	return cmd
+}
+
+func cmdPurgeDeleted(fl caddycmd.Flags) (int, error) {
+	s, cancel, err := loadStorageFromConfig(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	defer cancel()
+
+	purged, err := s.PurgeDeleted(context.Background(), fl.Duration("older-than"))
+	fmt.Printf("purged %d\n", purged)
+	if err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	return caddy.ExitCodeSuccess, nil
+}
@@ -0,0 +1,166 @@
+package s3
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Session ticket encryption keys (STEKs) shared through the bucket let every instance of a cluster
+// resume the TLS sessions of the others. Whichever instance first finds the keys missing or due
+// rotates them under a storage lock; the others pick the rotated keys up. The keys are only ever
+// stored encrypted, so this requires encryption_key.
+//
+// SessionTicketKeys and SessionTicketKeyUpdates match the Initialize/Next contract of Caddy's
+// session ticket key sources. The tls.stek module adapter is not part of this package: the
+// caddytls package of the Caddy release this module builds against does not compile with the
+// certmagic release it requires.
+
+const (
+	stekKey                     = "stek/keys.json"
+	stekLockName                = "stek_rotation"
+	defaultSTEKRotationInterval = 12 * time.Hour
+	defaultSTEKMaxKeys          = 4
+)
+
+// storedSTEK is the stored state of the session ticket keys, newest key first.
+type storedSTEK struct {
+	Keys         [][32]byte `json:"keys"`
+	LastRotation time.Time  `json:"last_rotation"`
+	NextRotation time.Time  `json:"next_rotation"`
+}
+
+// stekSettings returns the rotation interval and number of keys to keep.
+func (s *S3Storage) stekSettings() (time.Duration, int) {
+	interval, keys := time.Duration(s.STEKRotationInterval), s.STEKMaxKeys
+	if interval <= 0 {
+		interval = defaultSTEKRotationInterval
+	}
+	if keys <= 0 {
+		keys = defaultSTEKMaxKeys
+	}
+	return interval, keys
+}
+
+// SessionTicketKeys returns the current session ticket keys, newest first, and when they are due
+// for rotation. Missing or due keys are rotated first.
+func (s *S3Storage) SessionTicketKeys(ctx context.Context) ([][32]byte, time.Time, error) {
+	if isCleartext(s.iowrap) {
+		return nil, time.Time{}, errors.New("session ticket keys are never stored in the clear; set encryption_key")
+	}
+	if s.ReadOnly {
+		return s.readOnlySTEK(ctx)
+	}
+	if err := s.Lock(ctx, stekLockName); err != nil {
+		return nil, time.Time{}, fmt.Errorf("locking session ticket keys: %w", err)
+	}
+	defer func() {
+		if err := s.Unlock(context.WithoutCancel(ctx), stekLockName); err != nil {
+			s.logger.Warn("unlocking session ticket keys failed", zap.Error(err))
+		}
+	}()
+
+	stek, err := s.loadSTEK(ctx)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, time.Time{}, err
+	case time.Now().Before(stek.NextRotation):
+		return stek.Keys, stek.NextRotation, nil
+	}
+
+	interval, maxKeys := s.stekSettings()
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return nil, time.Time{}, fmt.Errorf("generating session ticket key: %w", err)
+	}
+	keys := append([][32]byte{key}, stek.Keys...)
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+	}
+	now := time.Now()
+	stek = storedSTEK{Keys: keys, LastRotation: now, NextRotation: now.Add(interval)}
+	data, err := json.Marshal(stek)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if err := s.Store(ctx, stekKey, data); err != nil {
+		return nil, time.Time{}, fmt.Errorf("storing session ticket keys: %w", err)
+	}
+	s.logger.Info("rotated session ticket keys", zap.Int("keys", len(keys)), zap.Time("next_rotation", stek.NextRotation))
+	return stek.Keys, stek.NextRotation, nil
+}
+
+// readOnlySTEK returns the stored session ticket keys without rotating them; rotation is left to
+// writable instances, so due keys are checked again after a while.
+func (s *S3Storage) readOnlySTEK(ctx context.Context) ([][32]byte, time.Time, error) {
+	stek, err := s.loadSTEK(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	next := stek.NextRotation
+	if recheck := time.Now().Add(time.Minute); next.Before(recheck) {
+		next = recheck
+	}
+	return stek.Keys, next, nil
+}
+
+// loadSTEK reads the stored session ticket keys.
+func (s *S3Storage) loadSTEK(ctx context.Context) (storedSTEK, error) {
+	var stek storedSTEK
+	data, err := s.Load(ctx, stekKey)
+	if err != nil {
+		return stek, err // Not wrapped, fs.ErrNotExist is checked
+	}
+	if err := json.Unmarshal(data, &stek); err != nil {
+		return stek, fmt.Errorf("decoding session ticket keys: %w", err)
+	}
+	return stek, nil
+}
+
+// SessionTicketKeyUpdates sends the session ticket keys after every rotation, whether this or
+// another instance rotated them, until done is closed.
+func (s *S3Storage) SessionTicketKeyUpdates(done <-chan struct{}) <-chan [][32]byte {
+	updates := make(chan [][32]byte)
+	go func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			<-done
+			cancel()
+		}()
+
+		var next time.Time
+		if stek, err := s.loadSTEK(ctx); err == nil {
+			next = stek.NextRotation
+		}
+		timer := time.NewTimer(time.Until(next))
+		defer timer.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+			}
+			keys, next, err := s.SessionTicketKeys(ctx)
+			if err != nil {
+				s.logger.Error("rotating session ticket keys failed", zap.Error(err))
+				timer.Reset(time.Minute)
+				continue
+			}
+			select {
+			case updates <- keys:
+			case <-done:
+				return
+			}
+			timer.Reset(max(time.Until(next), time.Second))
+		}
+	}()
+	return updates
+}
@@ -1,47 +1,356 @@
 package s3
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
-	"strings"
+	"net/http"
+	"strconv"
+	"sync/atomic"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	awsconfig "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/certmagic"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 )
 
 // S3Storage implements /.Storage using AWS S3.
 type S3Storage struct {
 	logger *zap.Logger
 
-	Client *awss3.Client
+	Client S3API  `json:"-"`
 	Bucket string `json:"bucket,omitempty"`
+
+	// APIOptions and ClientOptions customize every S3 client the storage creates, after its own options,
+	// e.g. to add middleware for request signing, headers or telemetry. They don't apply to a custom Client
+	APIOptions    []func(*middleware.Stack) error `json:"-"`
+	ClientOptions []func(*awss3.Options)          `json:"-"`
+
 	Region string `json:"region,omitempty"`
-	Prefix string `json:"prefix,omitempty"`
+	Prefix string `json:"prefix,omitempty"` // Default "certmagic"; may contain placeholders, e.g. certs/{env.TENANT}
+
+	// NoPrefix stores objects at the bucket root instead of below the default prefix
+	NoPrefix bool `json:"no_prefix,omitempty"`
+
+	// PrefixVars defines placeholders for the prefix template, e.g. {"tenant": "acme"} for certs/{tenant}
+	PrefixVars map[string]string `json:"prefix_vars,omitempty"`
+	config     []byte            // Configuration as given, for WithPrefix
+	goConfig   goConfig          // Options set from Go as given, for WithPrefix
 
 	AccessKeyID     string `json:"access_key_id,omitempty"`
 	SecretAccessKey string `json:"secret_access_key,omitempty"`
-	Endpoint        string `json:"endpoint,omitempty"` // For S3-compatible services
+	SessionToken    string `json:"session_token,omitempty"` // For temporary (STS) credentials
+	Endpoint        string `json:"endpoint,omitempty"`      // For S3-compatible services
+
+	// UseFIPSEndpoint and UseDualStackEndpoint select the FIPS 140 validated and the IPv6-capable AWS
+	// endpoints, for all AWS services the storage uses; neither works with a custom endpoint
+	UseFIPSEndpoint      bool `json:"use_fips_endpoint,omitempty"`
+	UseDualStackEndpoint bool `json:"use_dualstack_endpoint,omitempty"`
+
+	// UseAccelerate sends object requests through S3 Transfer Acceleration, which must be enabled on the bucket
+	UseAccelerate bool `json:"use_accelerate,omitempty"`
+
+	// RequesterPays sets RequestPayer on every request, as requester-pays buckets of other accounts require
+	RequesterPays bool `json:"requester_pays,omitempty"`
+
+	// AppName and UserAgentSuffix are added to the User-Agent of every request, which CloudTrail and
+	// access logs record; RequestHeaders are sent with every request. All support {env.*} placeholders
+	AppName         string            `json:"app_name,omitempty"`
+	UserAgentSuffix string            `json:"user_agent_suffix,omitempty"`
+	RequestHeaders  map[string]string `json:"request_headers,omitempty"`
+
+	// Credentials may also be read from files such as secret mounts
+	AccessKeyIDFile     string `json:"access_key_id_file,omitempty"`
+	SecretAccessKeyFile string `json:"secret_access_key_file,omitempty"`
+	SessionTokenFile    string `json:"session_token_file,omitempty"`
+	EncryptionKeyFile   string `json:"encryption_key_file,omitempty"`
+
+	// Profile selects a named profile of the shared AWS config and credentials files, which are
+	// read from SharedConfigFiles instead of ~/.aws/config if given
+	Profile           string   `json:"profile,omitempty"`
+	SharedConfigFiles []string `json:"shared_config_files,omitempty"`
+
+	// CredentialSource selects where credentials come from: default, static, sso or process (see
+	// credentials.go); empty uses static keys if given and the default chain otherwise
+	CredentialSource  string `json:"credential_source,omitempty"`
+	CredentialProcess string `json:"credential_process,omitempty"` // Command printing credentials, instead of the profile's
+	SSOCacheDir       string `json:"sso_cache_dir,omitempty"`      // Directory of cached SSO tokens instead of ~/.aws/sso/cache
 
 	EncryptionKey string `json:"encryption_key,omitempty"`
-	iowrap        IO
+	// EncryptionPassphrase derives the key with Argon2id instead, from a random salt stored in each
+	// object or from EncryptionSalt
+	EncryptionPassphrase     string `json:"encryption_passphrase,omitempty"`
+	EncryptionPassphraseFile string `json:"encryption_passphrase_file,omitempty"`
+	EncryptionSalt           string `json:"encryption_salt,omitempty"`
+	// EncryptionCipher is "secretbox" (default) or "aes-gcm" (AES-256-GCM); objects of either
+	// cipher are readable with the same key, so it can be switched at any time
+	EncryptionCipher string `json:"encryption_cipher,omitempty"`
+	iowrap           IO
+
+	// EncryptionKeySource fetches the key from "secretsmanager:<secret>", "ssm:<parameter>",
+	// "env:<variable>" or "file:<path>" instead, again every EncryptionKeyRefresh if set
+	EncryptionKeySource  string         `json:"encryption_key_source,omitempty"`
+	EncryptionKeyRefresh caddy.Duration `json:"encryption_key_refresh,omitempty"`
+	// KeyProvider supplies the key in Go, e.g. from an HSM, instead of the options above
+	KeyProvider KeyProvider `json:"-"`
+	keyRefresh  KeyProvider
+
+	// SSECustomerKey has S3 encrypt objects at rest with this key (SSE-C), sent with every request
+	SSECustomerKey     string `json:"sse_customer_key,omitempty"`
+	SSECustomerKeyFile string `json:"sse_customer_key_file,omitempty"`
+	ssec               *sseCustomerKey
+
+	// ObjectLock sets S3 Object Lock retention and legal hold on written values
+	ObjectLock *ObjectLockConfig `json:"object_lock,omitempty"`
+	objectLock *objectLock
+
+	// VaultTransit encrypts with data keys from HashiCorp Vault's transit engine instead of encryption_key
+	VaultTransit *VaultTransitConfig `json:"vault_transit,omitempty"`
+
+	// Compression of values before encryption: "gzip" or "zstd"; compressed values are always
+	// readable, so it can be turned on and off at any time
+	Compression string `json:"compression,omitempty"`
+
+	// Key rollover: until RolloverUntil (RFC 3339), objects are written with PreviousEncryptionKey
+	// plus a sibling encrypted with EncryptionKey; both keys are accepted for reading.
+	PreviousEncryptionKey string `json:"previous_encryption_key,omitempty"`
+	RolloverUntil         string `json:"rollover_until,omitempty"`
+	rollover              *RolloverIO
+
+	// Provider selects a compatibility profile: "aws" (default), "gcs" for Google Cloud Storage's XML API
+	// or "b2" for Backblaze B2
+	Provider string `json:"provider,omitempty"`
+
+	StorageClass string `json:"storage_class,omitempty"` // e.g. STANDARD_IA or INTELLIGENT_TIERING; empty uses the bucket default
+
+	// ChecksumAlgorithm ("CRC32C" or "SHA256") has S3 verify written objects and the SDK verify read
+	// ones; written objects also record their SHA-256 in metadata, which Load validates
+	ChecksumAlgorithm string `json:"checksum_algorithm,omitempty"`
+
+	// ObjectTags and ObjectMetadata are attached to all written objects; they support {env.*} placeholders
+	ObjectTags     map[string]string `json:"object_tags,omitempty"`
+	ObjectMetadata map[string]string `json:"object_metadata,omitempty"`
+	tagging        string
+
+	// ContentType of all written objects; defaults to application/octet-stream
+	ContentType string      `json:"content_type,omitempty"`
+	bodyChecked atomic.Bool // Whether the first write round-tripped unchanged
+
+	// EmptyValueSentinel writes empty values as 1-byte sentinel objects, for providers rejecting
+	// 0-byte PUTs; this also happens automatically once such a PUT fails
+	EmptyValueSentinel bool `json:"empty_value_sentinel,omitempty"`
+	emptySentinel      atomic.Bool
+
+	OCSPDelta bool `json:"ocsp_delta,omitempty"` // Store OCSP staples as deltas against a base version
+
+	// Manifest keeps an index of all keys in one object, so List doesn't page through the bucket
+	Manifest bool `json:"manifest,omitempty"`
+	manifest *manifestCache
+
+	// Retry configuration
+	MaxRetries       int            `json:"max_retries,omitempty"`       // Retries after the first attempt; 0 uses the SDK default
+	RetryMode        string         `json:"retry_mode,omitempty"`        // "standard" (default) or "adaptive"
+	OperationTimeout caddy.Duration `json:"operation_timeout,omitempty"` // Deadline for each individual S3 operation
+	ReadTimeout      caddy.Duration `json:"read_timeout,omitempty"`      // Overrides operation_timeout for GET and HEAD
+	ListTimeout      caddy.Duration `json:"list_timeout,omitempty"`      // Overrides operation_timeout for each listed page
+
+	// RetryErrorCodes overrides the SDK's classification of provider error codes: "retryable" or "fatal"
+	RetryErrorCodes map[string]string `json:"retry_error_codes,omitempty"`
+
+	// LogRedaction replaces hostnames (or other patterns) in logged keys and errors by stable hashes
+	LogRedaction *LogRedactionConfig `json:"log_redaction,omitempty"`
+
+	// Repeated S3 errors are summarized once per interval; details are logged at debug level
+	ErrorSummaryInterval caddy.Duration `json:"error_summary_interval,omitempty"`
+	errAgg               *errorAggregator
+
+	// VerifyWrites checks every stored object with a HeadObject and writes it again on a mismatch
+	VerifyWrites bool `json:"verify_writes,omitempty"`
+
+	// SoftDelete keeps deleted values restorable until they are purged after a retention
+	SoftDelete *SoftDeleteConfig `json:"soft_delete,omitempty"`
+
+	// ParallelDownload fetches objects above a size threshold in concurrent ranged parts
+	ParallelDownload *ParallelDownloadConfig `json:"parallel_download,omitempty"`
+
+	// Lifecycle installs lifecycle rules scoped to the prefix in the bucket during Provision
+	Lifecycle *LifecycleConfig `json:"lifecycle,omitempty"`
+
+	// ExistsOnError is what Exists reports when S3 cannot answer and no local fallback cache has the key
+	ExistsOnError bool `json:"exists_on_error,omitempty"`
+
+	// StrictDelete makes Delete return S3's errors, e.g. access denied, instead of only logging them;
+	// deleting a missing key succeeds either way
+	StrictDelete bool `json:"strict_delete,omitempty"`
+
+	// ListPageSize is the number of keys requested per list page (at most 1000, the default)
+	ListPageSize int `json:"list_page_size,omitempty"`
+
+	// ListConcurrency lists recursively with one listing per first-level directory, this many at a time
+	ListConcurrency int `json:"list_concurrency,omitempty"`
+
+	// Audit records every Store, Delete, Lock and Unlock to a log, file or S3 sink
+	Audit *AuditConfig `json:"audit,omitempty"`
+	audit *auditLog
+
+	// ReadOnly refuses writes, locks and deletes, for standby instances serving a shared bucket
+	ReadOnly bool `json:"read_only,omitempty"`
+
+	// ValidateOnStart checks bucket access with a write/read/delete probe during Provision
+	ValidateOnStart bool `json:"validate_on_start,omitempty"`
+
+	// VerifyOnStart fails Provision if the encryption doesn't decrypt the data in the bucket, see
+	// VerifyEncryption
+	VerifyOnStart bool `json:"verify_on_start,omitempty"`
+
+	// FallbackRegions are tried in order for reads once the primary region is impaired, i.e. failed
+	// FallbackThreshold (default 3) reads in a row; they must hold the same bucket name
+	FallbackRegions   []string `json:"fallback_regions,omitempty"`
+	FallbackThreshold int      `json:"fallback_threshold,omitempty"`
+	failover          *regionFailover
+
+	// Replica optionally mirrors writes to a secondary bucket used as read fallback
+	Replica       *ReplicaConfig `json:"replica,omitempty"`
+	replicaClient *awss3.Client
+
+	// ReadEndpoint, ReadBucket and ReadRegion send Load, Stat, Exists and List to another endpoint,
+	// bucket or region, e.g. the nearest replica; Store, Delete and locks stay on the primary bucket
+	ReadEndpoint string `json:"read_endpoint,omitempty"`
+	ReadBucket   string `json:"read_bucket,omitempty"`
+	ReadRegion   string `json:"read_region,omitempty"`
+	readSrc      *readSource
+	recentWrites *recentWrites // Keys read from the primary bucket, as the read endpoint may lag behind
+
+	// Caddy events; emit is emitCaddyEvent unless replaced by tests
+	caddyCtx caddy.Context
+	emit     func(name string, data map[string]any)
+
+	// Routes keep the keys below some prefixes in other buckets
+	Routes []RouteConfig `json:"routes,omitempty"`
+	router *bucketRouter
+
+	// Shards spreads the site certificates over these buckets by a hash of the site name
+	Shards  []string `json:"shards,omitempty"`
+	sharder *shardRouter
+
+	// In-memory cache of loaded values, bounded by their total size in bytes; 0 disables it
+	ReadCacheSize int64          `json:"read_cache_size,omitempty"`
+	ReadCacheTTL  caddy.Duration `json:"read_cache_ttl,omitempty"` // Defaults to 1m
+	cache         *readCache
+
+	// ExistsCacheTTL caches the results of Exists, positive and negative, for that long; 0 disables it
+	ExistsCacheTTL caddy.Duration `json:"exists_cache_ttl,omitempty"`
+	existsCache    *existsCache
+
+	// CacheInvalidation optionally drops cached values when bucket notifications report a change
+	CacheInvalidation  *CacheInvalidationConfig `json:"cache_invalidation,omitempty"`
+	invalidationServer *http.Server
+
+	// CertNotifications propagates stored certificates to the other instances through SNS and SQS
+	CertNotifications *CertNotificationConfig `json:"cert_notifications,omitempty"`
+	certNotifier      *certNotifier
+	// OnCertificateUpdate is called when another instance reports a stored certificate, e.g. to
+	// ReloadCertificate it
+	OnCertificateUpdate func(ctx context.Context, issuerKey, name string) `json:"-"`
+
+	// LocalCacheDir optionally mirrors all objects to local disk as read fallback during S3 outages
+	LocalCacheDir string `json:"local_cache_dir,omitempty"`
+	mirror        *localMirror
+
+	// JournalDir optionally journals writes on local disk first, so they survive S3 outages
+	JournalDir           string         `json:"journal_dir,omitempty"`
+	JournalFlushInterval caddy.Duration `json:"journal_flush_interval,omitempty"`
+	journal              *writeJournal
+
+	// LockBackend optionally delegates locking to an external lock service
+	LockBackend *LockBackendConfig `json:"lock_backend,omitempty"`
+	httpLock    *httpLocker
+
+	// RenewalPrefetch loads certificates this long before their renewal window opens, to warm caches
+	RenewalPrefetch caddy.Duration `json:"renewal_prefetch,omitempty"`
+	prefetcher      *renewalPrefetcher
+
+	// WarmStart loads selected certificates into the read cache or local mirror right after Provision
+	WarmStart *WarmStartConfig `json:"warm_start,omitempty"`
+
+	// Concurrent identical reads in flight
+	flights singleflight.Group
+
+	// Requests sent, by operation
+	requests *requestCounter
+
+	// MaxConcurrentRequests bounds the S3 requests in flight; further requests queue
+	MaxConcurrentRequests int `json:"max_concurrent_requests,omitempty"`
+	limiter               *requestLimiter
+
+	// Correlation IDs of held locks
+	traces *correlations
+	held   *heldLocks // Locks held by this instance, released by Cleanup
+
+	// Backend capabilities, probed by Provision
+	caps Capabilities
+
+	// Last result of Usage
+	usage usageCache
+
+	// Sidecar optionally serves the storage API over a local HTTP endpoint
+	Sidecar       *SidecarConfig `json:"sidecar,omitempty"`
+	sidecarServer *http.Server
+
+	// SharedTransport names an HTTP connection pool shared by all instances naming it;
+	// by default every instance has its own
+	SharedTransport string `json:"shared_transport,omitempty"`
+	sharedHTTP      *http.Client
+	ownHTTP         *http.Client
+
+	// Tuning of the HTTP transport; zero keeps the SDK's defaults (see transport.go)
+	ConnectTimeout        caddy.Duration `json:"connect_timeout,omitempty"`         // Dialing a connection
+	ResponseHeaderTimeout caddy.Duration `json:"response_header_timeout,omitempty"` // Waiting for response headers after the request
+	IdleConnTimeout       caddy.Duration `json:"idle_conn_timeout,omitempty"`       // Keeping an idle connection open
+	MaxIdleConnsPerHost   int            `json:"max_idle_conns_per_host,omitempty"` // Idle connections kept per host
+
+	// LockPrefix is the directory below prefix holding lock objects; defaults to "locks"
+	LockPrefix string `json:"lock_prefix,omitempty"`
+	// LockBucket keeps the lock objects in another bucket, so that lock churn stays out of the data
+	// bucket, e.g. one with Object Lock; all instances sharing the data must use the same lock bucket
+	LockBucket string `json:"lock_bucket,omitempty"`
+	// LegacyLocks also honors and writes "<key>.lock" objects next to the data, for as long as
+	// instances of an earlier version share the bucket; keys ending in .lock are hidden meanwhile
+	LegacyLocks bool `json:"legacy_locks,omitempty"`
+
+	// LockPollMaxInterval caps the exponential backoff between polls of a held lock; defaults to 10s
+	LockPollMaxInterval caddy.Duration `json:"lock_poll_max_interval,omitempty"`
+
+	// LockGCInterval enables a janitor deleting expired lock objects left by crashed instances
+	LockGCInterval caddy.Duration `json:"lock_gc_interval,omitempty"`
+	lockGC         *lockJanitor
+
+	// LockContentionWarnAfter is how long Lock waits for a held lock before warning, once per key; defaults to 10s
+	LockContentionWarnAfter caddy.Duration `json:"lock_contention_warn_after,omitempty"`
+	lockWarnAfter           time.Duration
+	lockStats               *lockContention
+
+	// Session ticket keys are rotated every STEKRotationInterval (default 12h), keeping the
+	// STEKMaxKeys (default 4) newest
+	STEKRotationInterval caddy.Duration `json:"stek_rotation_interval,omitempty"`
+	STEKMaxKeys          int            `json:"stek_max_keys,omitempty"`
 
 	// Lock configuration
-	lockExpiration   time.Duration
-	lockPollInterval time.Duration
-	lockTimeout      time.Duration
+	lockExpiration time.Duration
+	lockBackoff    lockBackoff
+	lockTimeout    time.Duration
 }
 
 // Interface guards
 var (
 	_ caddy.Provisioner      = (*S3Storage)(nil)
+	_ caddy.CleanerUpper     = (*S3Storage)(nil)
 	_ caddy.StorageConverter = (*S3Storage)(nil)
 	_ caddyfile.Unmarshaler  = (*S3Storage)(nil)
 	_ certmagic.Storage      = (*S3Storage)(nil)
@@ -64,68 +373,357 @@ func (s *S3Storage) CaddyModule() caddy.ModuleInfo {
 
 // Provision sets up the S3 storage module.
 func (s *S3Storage) Provision(ctx caddy.Context) error {
+	if err := s.saveConfig(); err != nil {
+		return fmt.Errorf("s3 storage: %w", err)
+	}
 	s.logger = ctx.Logger(s)
+	s.caddyCtx = ctx
+	s.emit = s.emitCaddyEvent
+	cli := cliProvisioning(ctx) // For a maintenance command: no background services, listeners or bucket changes
+	s.resolvePlaceholders()
+	defaultedPrefix := s.Prefix == "" && !s.NoPrefix
+	if err := s.resolvePrefix(); err != nil {
+		return fmt.Errorf("s3 storage: %w", err)
+	}
+	if s.LogRedaction != nil {
+		if err := s.provisionLogRedaction(); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
 
 	// Defaults for locking
 	s.lockExpiration = 2 * time.Minute
-	s.lockPollInterval = 1 * time.Second
+	s.lockBackoff = lockBackoff{base: defaultLockPollInterval, max: defaultLockPollMaxInterval}
+	if s.LockPollMaxInterval > 0 {
+		s.lockBackoff.max = max(time.Duration(s.LockPollMaxInterval), s.lockBackoff.base)
+	}
 	s.lockTimeout = 30 * time.Second
+	s.lockWarnAfter = defaultLockContentionWarnAfter
+	if s.LockContentionWarnAfter > 0 {
+		s.lockWarnAfter = time.Duration(s.LockContentionWarnAfter)
+	}
+	s.lockStats = newLockContention()
+
+	summaryInterval := time.Duration(s.ErrorSummaryInterval)
+	if summaryInterval <= 0 {
+		summaryInterval = defaultErrorSummaryInterval
+	}
+	s.errAgg = newErrorAggregator(s.logger, summaryInterval)
+	s.traces = newCorrelations()
+	s.held = newHeldLocks()
+	s.requests = newRequestCounter()
 
 	if s.Bucket == "" {
 		return fmt.Errorf("s3 storage: bucket must be specified")
 	}
-	if s.Region == "" && s.Endpoint == "" { // If not using a custom endpoint which might not need a region
-		s.logger.Warn("s3 storage: region not specified, relying on SDK discovery. Explicitly setting region is recommended for AWS S3.")
+	if err := s.applyProvider(); err != nil {
+		return fmt.Errorf("s3 storage: %w", err)
+	}
+	if s.MaxConcurrentRequests > 0 { // After applyProvider, which may set a default
+		s.limiter = newRequestLimiter(s.MaxConcurrentRequests)
+	}
+	if isMultiRegionAccessPoint(s.Bucket) {
+		if err := s.provisionMultiRegionAccessPoint(); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+	if err := s.validateEndpointVariants(); err != nil {
+		return fmt.Errorf("s3 storage: %w", err)
+	}
+	if err := s.validateAccelerate(); err != nil {
+		return fmt.Errorf("s3 storage: %w", err)
+	}
+	if err := s.resolveSecrets(); err != nil {
+		return fmt.Errorf("s3 storage: %w", err)
+	}
+	if err := s.validateCredentialSource(); err != nil {
+		return fmt.Errorf("s3 storage: %w", err)
+	}
+	if err := s.validateStorageClass(); err != nil {
+		return fmt.Errorf("s3 storage: %w", err)
+	}
+	if err := s.validateChecksumAlgorithm(); err != nil {
+		return fmt.Errorf("s3 storage: %w", err)
+	}
+	if err := s.provisionObjectMeta(); err != nil {
+		return fmt.Errorf("s3 storage: %w", err)
+	}
+	if err := s.provisionAttribution(); err != nil {
+		return fmt.Errorf("s3 storage: %w", err)
+	}
+	if err := s.validateRetryConfig(); err != nil {
+		return fmt.Errorf("s3 storage: %w", err)
+	}
+	if err := s.validateReadOnly(); err != nil {
+		return fmt.Errorf("s3 storage: %w", err)
+	}
+	if s.Lifecycle != nil {
+		if err := s.validateLifecycle(); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+	if s.SoftDelete != nil {
+		if err := s.validateSoftDelete(); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+	if s.WarmStart != nil {
+		if err := s.validateWarmStart(); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+	if s.ParallelDownload != nil {
+		if err := s.validateParallelDownload(); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+	if s.SSECustomerKey != "" {
+		if err := s.provisionSSECustomerKey(); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+	if s.ObjectLock != nil {
+		if err := s.provisionObjectLock(); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
 	}
 
-	awsCfg, err := awsconfig.LoadDefaultConfig(context.TODO(), // Use context.TODO() for one-time setup
-		awsconfig.WithRegion(s.Region),
-	)
-	if err != nil {
-		return fmt.Errorf("s3 storage: loading AWS config: %w", err)
+	if len(s.Routes) > 0 {
+		if err := s.provisionRoutes(); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+	if len(s.Shards) > 0 {
+		if err := s.provisionShards(); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
 	}
 
-	if s.AccessKeyID != "" && s.SecretAccessKey != "" {
-		awsCfg.Credentials = aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(s.AccessKeyID, s.SecretAccessKey, ""))
-		s.logger.Info("using explicit AWS credentials")
-	} else {
-		s.logger.Info("using default AWS credential chain (e.g., IAM role, env vars, or shared config)")
+	if s.shouldDetectRegion() {
+		if err := s.detectRegion(ctx); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+	if s.Client == nil {
+		client, err := s.newClient(s.Region, s.Endpoint, s.AccessKeyID, s.SecretAccessKey, s.SessionToken, s.accelerateClientOptions)
+		if err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+		s.Client = client
+	}
+	if defaultedPrefix && !cli {
+		go s.warnRootData(ctx)
+	}
+	if s.UseAccelerate {
+		if err := s.checkAccelerate(ctx); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
 	}
 
-	s3ClientOpts := []func(*awss3.Options){}
-	if s.Endpoint != "" {
-		s3ClientOpts = append(s3ClientOpts, func(o *awss3.Options) {
-			o.BaseEndpoint = aws.String(s.Endpoint)
-			// For many S3-compatible services, path-style addressing is needed.
-			o.UsePathStyle = true // Common for MinIO, Ceph, etc.
-			s.logger.Info("using custom S3 endpoint", zap.String("endpoint", s.Endpoint))
-		})
+	if len(s.FallbackRegions) > 0 {
+		if err := s.provisionFallbackRegions(); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
 	}
 
-	s.Client = awss3.NewFromConfig(awsCfg, s3ClientOpts...)
+	if s.Replica != nil {
+		if err := s.provisionReplica(); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+	if s.readEndpointConfigured() {
+		if err := s.provisionReadEndpoint(); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+
+	if s.Lifecycle != nil && !cli {
+		if err := s.applyLifecycle(ctx); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+	if s.SoftDelete != nil {
+		if err := s.checkSoftDeleteVersioning(ctx); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
 
 	// Initialize encryption wrapper
-	if len(s.EncryptionKey) == 0 {
-		s.logger.Info("clear text certificate storage active")
-		s.iowrap = &CleartextIO{}
-	} else if len(s.EncryptionKey) != 32 { // NaCl secretbox key size
-		return errors.New("encryption key must have exactly 32 bytes for NaCl secretbox")
+	if err := s.provisionKeyProvider(ctx); err != nil {
+		return fmt.Errorf("s3 storage: %w", err)
+	}
+	var (
+		iowrap IO
+		err    error
+	)
+	if s.VaultTransit != nil {
+		iowrap, err = s.provisionVaultTransit()
+	} else if s.EncryptionPassphrase != "" {
+		iowrap, err = s.provisionPassphrase(ctx, cli)
 	} else {
-		s.logger.Info("encrypted certificate storage active")
-		sb := &SecretBoxIO{}
-		copy(sb.SecretKey[:], []byte(s.EncryptionKey))
-		s.iowrap = sb
+		iowrap, err = NewCipherIO(s.EncryptionKey, s.EncryptionCipher)
+	}
+	if err != nil {
+		return fmt.Errorf("s3 storage: %w", err)
+	}
+	if s.keyRefresh != nil {
+		refreshing := &refreshingIO{current: iowrap}
+		s.startKeyRefresh(ctx, refreshing)
+		iowrap = refreshing
+	}
+	s.iowrap = iowrap
+	switch {
+	case s.VaultTransit != nil, s.EncryptionPassphrase != "": // Logged by provisionVaultTransit and provisionPassphrase
+	case len(s.EncryptionKey) == 0:
+		s.logger.Info("clear text certificate storage active")
+	default:
+		s.logger.Info("encrypted certificate storage active", zap.String("cipher", cmp.Or(s.EncryptionCipher, cipherSecretBox)))
+	}
+	if s.PreviousEncryptionKey != "" {
+		if err := s.provisionRollover(); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	} else if s.RolloverUntil != "" {
+		return errors.New("s3 storage: rollover_until requires previous_encryption_key")
+	}
+	compressing, err := newCompressingIO(s.iowrap, s.Compression) // Compressed values are always readable
+	if err != nil {
+		return fmt.Errorf("s3 storage: %w", err)
+	}
+	s.iowrap = compressing
+
+	if s.LockBackend != nil {
+		if err := s.provisionLockBackend(); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+	if err := s.validateLockBucket(); err != nil {
+		return fmt.Errorf("s3 storage: %w", err)
+	}
+
+	if s.Audit != nil {
+		if err := s.provisionAudit(); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+
+	if s.ValidateOnStart && !cli {
+		if err := s.validateAccess(ctx); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+	if s.VerifyOnStart {
+		if err := s.VerifyEncryption(ctx); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+
+	if s.Manifest {
+		s.manifest = &manifestCache{}
+	}
+
+	if s.ReadCacheSize > 0 {
+		s.cache = newReadCache(s.ReadCacheSize, time.Duration(s.ReadCacheTTL), s.logger)
+	}
+	if s.ExistsCacheTTL > 0 {
+		s.existsCache = newExistsCache(time.Duration(s.ExistsCacheTTL))
+	}
+	if s.CacheInvalidation != nil && !cli {
+		if err := s.startCacheInvalidation(ctx); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+	if s.CertNotifications != nil && !cli {
+		if err := s.startCertNotifications(ctx); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+
+	if s.LocalCacheDir != "" {
+		if err := s.provisionLocalMirror(); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+
+	if s.JournalDir != "" && !cli { // The server's pending writes are flushed by the server
+		if err := s.provisionJournal(ctx); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
 	}
 
+	if s.Sidecar != nil && !cli {
+		if err := s.startSidecar(ctx); err != nil {
+			return fmt.Errorf("s3 storage: %w", err)
+		}
+	}
+
+	if s.RenewalPrefetch > 0 && !cli {
+		s.startRenewalPrefetch(ctx)
+	}
+
+	if s.WarmStart != nil && !cli {
+		s.startWarmStart(ctx)
+	}
+
+	if s.LockGCInterval > 0 && !cli {
+		s.startLockGC(ctx, time.Duration(s.LockGCInterval))
+	}
+
+	if !cli {
+		s.probeCapabilities(ctx)
+	}
+
+	registerInstance(s)
+
 	s.logger.Info("s3 storage provisioned",
 		zap.String("bucket", s.Bucket),
 		zap.String("region", s.Region),
 		zap.String("prefix", s.Prefix),
-		zap.Bool("encryption_enabled", len(s.EncryptionKey) > 0),
+		zap.String("storage_class", s.StorageClass),
+		zap.Bool("encryption_enabled", !isCleartext(s.iowrap)),
+		zap.String("compression", s.Compression),
+		zap.Int("max_retries", s.MaxRetries),
+		zap.String("retry_mode", s.RetryMode),
+		zap.Duration("operation_timeout", time.Duration(s.OperationTimeout)),
+		zap.Duration("read_timeout", time.Duration(s.ReadTimeout)),
+		zap.Duration("list_timeout", time.Duration(s.ListTimeout)),
 	)
 	return nil
 }
 
+// validateStorageClass ensures the configured storage class is one known to S3.
+func (s *S3Storage) validateStorageClass() error {
+	if s.StorageClass == "" || s.isGCS() { // Validated by applyProvider
+		return nil
+	}
+	for _, sc := range types.StorageClass("").Values() {
+		if string(sc) == s.StorageClass {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported storage_class '%s' (expected one of %v)", s.StorageClass, types.StorageClass("").Values())
+}
+
+// Cleanup releases resources held by the storage module.
+func (s *S3Storage) Cleanup() error {
+	unregisterInstance(s)
+	s.releaseHeldLocks()
+	s.releaseTransport()
+	s.FlushManifest()
+	if s.errAgg != nil {
+		s.errAgg.flush() // Don't lose a pending summary
+	}
+	if s.audit != nil {
+		if err := s.audit.close(); err != nil {
+			s.logger.Error("closing audit log failed", zap.Error(err))
+		}
+	}
+	if err := s.stopInvalidationWebhook(); err != nil {
+		s.logger.Error("stopping cache invalidation endpoint failed", zap.Error(err))
+	}
+	return s.stopSidecar()
+}
+
 // UnmarshalCaddyfile parses the Caddyfile configuration.
 func (s *S3Storage) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	for d.Next() { // Consume directive name "s3"
@@ -134,6 +732,134 @@ func (s *S3Storage) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 		}
 		for d.NextBlock(0) { // Enter the block
 			key := d.Val()
+			switch key { // Subdirectives with their own block
+			case "sidecar":
+				if err := s.unmarshalSidecar(d); err != nil {
+					return err
+				}
+				continue
+			case "prefix_vars":
+				if err := unmarshalStringMap(d, &s.PrefixVars); err != nil {
+					return err
+				}
+				continue
+			case "no_prefix": // A bare flag, or with a boolean
+				s.NoPrefix = true
+				if d.NextArg() {
+					b, err := strconv.ParseBool(d.Val())
+					if err != nil {
+						return d.Errf("invalid no_prefix '%s': %v", d.Val(), err)
+					}
+					s.NoPrefix = b
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				continue
+			case "shared_config_files":
+				s.SharedConfigFiles = d.RemainingArgs()
+				if len(s.SharedConfigFiles) == 0 {
+					return d.ArgErr()
+				}
+				continue
+			case "shards":
+				s.Shards = d.RemainingArgs()
+				if len(s.Shards) == 0 {
+					return d.ArgErr()
+				}
+				continue
+			case "fallback_regions":
+				s.FallbackRegions = d.RemainingArgs()
+				if len(s.FallbackRegions) == 0 {
+					return d.ArgErr()
+				}
+				continue
+			case "log_redaction":
+				if err := s.unmarshalLogRedaction(d); err != nil {
+					return err
+				}
+				continue
+			case "lock_backend":
+				if err := s.unmarshalLockBackend(d); err != nil {
+					return err
+				}
+				continue
+			case "object_tags":
+				if err := unmarshalStringMap(d, &s.ObjectTags); err != nil {
+					return err
+				}
+				continue
+			case "object_metadata":
+				if err := unmarshalStringMap(d, &s.ObjectMetadata); err != nil {
+					return err
+				}
+				continue
+			case "request_headers":
+				if err := unmarshalStringMap(d, &s.RequestHeaders); err != nil {
+					return err
+				}
+				continue
+			case "retry_error_codes":
+				if err := s.unmarshalRetryErrorCodes(d); err != nil {
+					return err
+				}
+				continue
+			case "audit":
+				if err := s.unmarshalAudit(d); err != nil {
+					return err
+				}
+				continue
+			case "vault_transit":
+				if err := s.unmarshalVaultTransit(d); err != nil {
+					return err
+				}
+				continue
+			case "replica":
+				if err := s.unmarshalReplica(d); err != nil {
+					return err
+				}
+				continue
+			case "routes":
+				if err := s.unmarshalRoutes(d); err != nil {
+					return err
+				}
+				continue
+			case "warm_start":
+				if err := s.unmarshalWarmStart(d); err != nil {
+					return err
+				}
+				continue
+			case "object_lock":
+				if err := s.unmarshalObjectLock(d); err != nil {
+					return err
+				}
+				continue
+			case "cert_notifications":
+				if err := s.unmarshalCertNotifications(d); err != nil {
+					return err
+				}
+				continue
+			case "cache_invalidation":
+				if err := s.unmarshalCacheInvalidation(d); err != nil {
+					return err
+				}
+				continue
+			case "lifecycle":
+				if err := s.unmarshalLifecycle(d); err != nil {
+					return err
+				}
+				continue
+			case "soft_delete":
+				if err := s.unmarshalSoftDelete(d); err != nil {
+					return err
+				}
+				continue
+			case "parallel_download":
+				if err := s.unmarshalParallelDownload(d); err != nil {
+					return err
+				}
+				continue
+			}
 			var value string // Most subdirectives take one value
 			if !d.AllArgs(&value) {
 				return d.ArgErr()
@@ -153,14 +879,306 @@ func (s *S3Storage) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				s.Endpoint = value
 			case "encryption_key":
 				s.EncryptionKey = value
+			case "access_key_id_file":
+				s.AccessKeyIDFile = value
+			case "secret_access_key_file":
+				s.SecretAccessKeyFile = value
+			case "session_token":
+				s.SessionToken = value
+			case "session_token_file":
+				s.SessionTokenFile = value
+			case "profile":
+				s.Profile = value
+			case "credential_source":
+				s.CredentialSource = value
+			case "credential_process":
+				s.CredentialProcess = value
+			case "sso_cache_dir":
+				s.SSOCacheDir = value
+			case "encryption_key_file":
+				s.EncryptionKeyFile = value
+			case "encryption_passphrase":
+				s.EncryptionPassphrase = value
+			case "encryption_passphrase_file":
+				s.EncryptionPassphraseFile = value
+			case "encryption_salt":
+				s.EncryptionSalt = value
+			case "previous_encryption_key":
+				s.PreviousEncryptionKey = value
+			case "rollover_until":
+				s.RolloverUntil = value
+			case "sse_customer_key":
+				s.SSECustomerKey = value
+			case "sse_customer_key_file":
+				s.SSECustomerKeyFile = value
+			case "encryption_key_source":
+				s.EncryptionKeySource = value
+			case "encryption_key_refresh":
+				dur, err := caddy.ParseDuration(value)
+				if err != nil {
+					return d.Errf("invalid encryption_key_refresh '%s': %v", value, err)
+				}
+				s.EncryptionKeyRefresh = caddy.Duration(dur)
+			case "encryption_cipher":
+				s.EncryptionCipher = value
+			case "compression":
+				s.Compression = value
+			case "content_type":
+				s.ContentType = value
+			case "storage_class":
+				s.StorageClass = value
+			case "checksum_algorithm":
+				s.ChecksumAlgorithm = value
+			case "read_cache_size":
+				size, err := parseByteSize(value)
+				if err != nil {
+					return d.Errf("invalid read_cache_size: %v", err)
+				}
+				s.ReadCacheSize = size
+			case "read_cache_ttl":
+				dur, err := caddy.ParseDuration(value)
+				if err != nil {
+					return d.Errf("invalid read_cache_ttl '%s': %v", value, err)
+				}
+				s.ReadCacheTTL = caddy.Duration(dur)
+			case "exists_cache_ttl":
+				dur, err := caddy.ParseDuration(value)
+				if err != nil {
+					return d.Errf("invalid exists_cache_ttl '%s': %v", value, err)
+				}
+				s.ExistsCacheTTL = caddy.Duration(dur)
+			case "local_cache_dir":
+				s.LocalCacheDir = value
+			case "journal_dir":
+				s.JournalDir = value
+			case "journal_flush_interval":
+				dur, err := caddy.ParseDuration(value)
+				if err != nil {
+					return d.Errf("invalid journal_flush_interval '%s': %v", value, err)
+				}
+				s.JournalFlushInterval = caddy.Duration(dur)
+			case "ocsp_delta":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return d.Errf("invalid ocsp_delta '%s': %v", value, err)
+				}
+				s.OCSPDelta = b
+			case "renewal_prefetch":
+				dur, err := caddy.ParseDuration(value)
+				if err != nil {
+					return d.Errf("invalid renewal_prefetch '%s': %v", value, err)
+				}
+				s.RenewalPrefetch = caddy.Duration(dur)
+			case "lock_poll_max_interval":
+				dur, err := caddy.ParseDuration(value)
+				if err != nil {
+					return d.Errf("invalid lock_poll_max_interval '%s': %v", value, err)
+				}
+				s.LockPollMaxInterval = caddy.Duration(dur)
+			case "lock_gc_interval":
+				dur, err := caddy.ParseDuration(value)
+				if err != nil {
+					return d.Errf("invalid lock_gc_interval '%s': %v", value, err)
+				}
+				s.LockGCInterval = caddy.Duration(dur)
+			case "lock_contention_warn_after":
+				dur, err := caddy.ParseDuration(value)
+				if err != nil {
+					return d.Errf("invalid lock_contention_warn_after '%s': %v", value, err)
+				}
+				s.LockContentionWarnAfter = caddy.Duration(dur)
+			case "stek_rotation_interval":
+				dur, err := caddy.ParseDuration(value)
+				if err != nil {
+					return d.Errf("invalid stek_rotation_interval '%s': %v", value, err)
+				}
+				s.STEKRotationInterval = caddy.Duration(dur)
+			case "stek_max_keys":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 1 {
+					return d.Errf("invalid stek_max_keys '%s'", value)
+				}
+				s.STEKMaxKeys = n
+			case "provider":
+				s.Provider = value
+			case "lock_prefix":
+				s.LockPrefix = value
+			case "lock_bucket":
+				s.LockBucket = value
+			case "legacy_locks":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return d.Errf("invalid legacy_locks '%s': %v", value, err)
+				}
+				s.LegacyLocks = b
+			case "fallback_threshold":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 1 {
+					return d.Errf("invalid fallback_threshold '%s'", value)
+				}
+				s.FallbackThreshold = n
+			case "shared_transport":
+				s.SharedTransport = value
+			case "verify_writes":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return d.Errf("invalid verify_writes '%s': %v", value, err)
+				}
+				s.VerifyWrites = b
+			case "exists_on_error":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return d.Errf("invalid exists_on_error '%s': %v", value, err)
+				}
+				s.ExistsOnError = b
+			case "strict_delete":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return d.Errf("invalid strict_delete '%s': %v", value, err)
+				}
+				s.StrictDelete = b
+			case "max_concurrent_requests":
+				n, err := strconv.Atoi(value)
+				if err != nil || n <= 0 {
+					return d.Errf("invalid max_concurrent_requests '%s'", value)
+				}
+				s.MaxConcurrentRequests = n
+			case "max_idle_conns_per_host":
+				n, err := strconv.Atoi(value)
+				if err != nil || n <= 0 {
+					return d.Errf("invalid max_idle_conns_per_host '%s'", value)
+				}
+				s.MaxIdleConnsPerHost = n
+			case "list_page_size":
+				n, err := strconv.Atoi(value)
+				if err != nil || n <= 0 {
+					return d.Errf("invalid list_page_size '%s'", value)
+				}
+				s.ListPageSize = n
+			case "list_concurrency":
+				n, err := strconv.Atoi(value)
+				if err != nil || n <= 0 {
+					return d.Errf("invalid list_concurrency '%s'", value)
+				}
+				s.ListConcurrency = n
+			case "read_only":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return d.Errf("invalid read_only '%s': %v", value, err)
+				}
+				s.ReadOnly = b
+			case "validate_on_start":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return d.Errf("invalid validate_on_start '%s': %v", value, err)
+				}
+				s.ValidateOnStart = b
+			case "verify_on_start":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return d.Errf("invalid verify_on_start '%s': %v", value, err)
+				}
+				s.VerifyOnStart = b
+			case "empty_value_sentinel":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return d.Errf("invalid empty_value_sentinel '%s': %v", value, err)
+				}
+				s.EmptyValueSentinel = b
+			case "use_fips_endpoint":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return d.Errf("invalid use_fips_endpoint '%s': %v", value, err)
+				}
+				s.UseFIPSEndpoint = b
+			case "use_dualstack_endpoint":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return d.Errf("invalid use_dualstack_endpoint '%s': %v", value, err)
+				}
+				s.UseDualStackEndpoint = b
+			case "use_accelerate":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return d.Errf("invalid use_accelerate '%s': %v", value, err)
+				}
+				s.UseAccelerate = b
+			case "requester_pays":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return d.Errf("invalid requester_pays '%s': %v", value, err)
+				}
+				s.RequesterPays = b
+			case "read_endpoint":
+				s.ReadEndpoint = value
+			case "read_bucket":
+				s.ReadBucket = value
+			case "read_region":
+				s.ReadRegion = value
+			case "app_name":
+				s.AppName = value
+			case "user_agent_suffix":
+				s.UserAgentSuffix = value
+			case "manifest":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return d.Errf("invalid manifest '%s': %v", value, err)
+				}
+				s.Manifest = b
+			case "error_summary_interval":
+				dur, err := caddy.ParseDuration(value)
+				if err != nil {
+					return d.Errf("invalid error_summary_interval '%s': %v", value, err)
+				}
+				s.ErrorSummaryInterval = caddy.Duration(dur)
+			case "max_retries":
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					return d.Errf("invalid max_retries '%s': %v", value, err)
+				}
+				s.MaxRetries = n
+			case "retry_mode":
+				s.RetryMode = value
+			case "operation_timeout":
+				dur, err := caddy.ParseDuration(value)
+				if err != nil {
+					return d.Errf("invalid %s '%s': %v", key, value, err)
+				}
+				s.OperationTimeout = caddy.Duration(dur)
+			case "read_timeout":
+				dur, err := caddy.ParseDuration(value)
+				if err != nil {
+					return d.Errf("invalid %s '%s': %v", key, value, err)
+				}
+				s.ReadTimeout = caddy.Duration(dur)
+			case "list_timeout":
+				dur, err := caddy.ParseDuration(value)
+				if err != nil {
+					return d.Errf("invalid list_timeout '%s': %v", value, err)
+				}
+				s.ListTimeout = caddy.Duration(dur)
+			case "connect_timeout":
+				dur, err := caddy.ParseDuration(value)
+				if err != nil {
+					return d.Errf("invalid connect_timeout '%s': %v", value, err)
+				}
+				s.ConnectTimeout = caddy.Duration(dur)
+			case "response_header_timeout":
+				dur, err := caddy.ParseDuration(value)
+				if err != nil {
+					return d.Errf("invalid response_header_timeout '%s': %v", value, err)
+				}
+				s.ResponseHeaderTimeout = caddy.Duration(dur)
+			case "idle_conn_timeout":
+				dur, err := caddy.ParseDuration(value)
+				if err != nil {
+					return d.Errf("invalid idle_conn_timeout '%s': %v", value, err)
+				}
+				s.IdleConnTimeout = caddy.Duration(dur)
 			default:
 				return d.Errf("unrecognized s3 storage subdirective '%s'", key)
 			}
 		}
 	}
-	if s.Prefix == "" {
-		s.Prefix = "certmagic" // Default prefix
-	}
-	s.Prefix = strings.Trim(s.Prefix, "/") // Ensure no leading/trailing slashes
 	return nil
 }
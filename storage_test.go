@@ -0,0 +1,2253 @@
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/caddyserver/caddy/v2"
+	s3 "github.com/cvhome-saas/certmagic-s3"
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+func TestStorageRoundTrip(t *testing.T) {
+	storage := s3test.NewStorage(t, func(s *s3.S3Storage) {
+		s.EncryptionKey = "12345678123456781234567812345678"
+	})
+	ctx := context.Background()
+
+	key := "certificates/acme/example.com/example.com.crt"
+	if err := storage.Store(ctx, key, []byte("certificate")); err != nil {
+		t.Fatalf("storing failed: %v", err)
+	}
+	value, err := storage.Load(ctx, key)
+	if err != nil {
+		t.Fatalf("loading failed: %v", err)
+	}
+	if string(value) != "certificate" {
+		t.Errorf("unexpected value %q", value)
+	}
+	if !storage.Exists(ctx, key) {
+		t.Errorf("expected key to exist")
+	}
+
+	ki, err := storage.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+	if ki.Key != key || !ki.IsTerminal {
+		t.Errorf("unexpected key info %+v", ki)
+	}
+
+	keys, err := storage.List(ctx, "certificates/acme", false)
+	if err != nil {
+		t.Fatalf("listing failed: %v", err)
+	}
+	if !slices.Equal(keys, []string{"certificates/acme/example.com"}) {
+		t.Errorf("unexpected non-recursive listing %v", keys)
+	}
+	keys, err = storage.List(ctx, "certificates", true)
+	if err != nil {
+		t.Fatalf("listing failed: %v", err)
+	}
+	if !slices.Equal(keys, []string{key}) {
+		t.Errorf("unexpected recursive listing %v", keys)
+	}
+
+	if stats := storage.RequestStats(); stats.Requests["PutObject"] == 0 || stats.Requests["GetObject"] == 0 {
+		t.Errorf("requests not counted: %+v", stats)
+	}
+
+	// Deleting a directory removes everything below it.
+	if err := storage.Delete(ctx, "certificates/acme"); err != nil {
+		t.Fatalf("deleting failed: %v", err)
+	}
+	if _, err := storage.Load(ctx, key); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist after deletion, got %v", err)
+	}
+}
+
+func TestStorageLocking(t *testing.T) {
+	storage := s3test.NewStorage(t)
+	ctx := context.Background()
+
+	if err := storage.Lock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatalf("locking failed: %v", err)
+	}
+	locks, err := storage.ListLocks(ctx)
+	if err != nil {
+		t.Fatalf("listing locks failed: %v", err)
+	}
+	if len(locks) != 1 || locks[0].Key != "issue_cert_example.com" || locks[0].Owner == nil {
+		t.Errorf("unexpected locks %+v", locks)
+	}
+	li, err := storage.LockInfo(ctx, "issue_cert_example.com")
+	if err != nil {
+		t.Fatalf("LockInfo failed: %v", err)
+	}
+	if owner := li.Owner; owner == nil || owner.PID != os.Getpid() || owner.Hostname == "" ||
+		owner.Expires.Sub(owner.Acquired) != 2*time.Minute || li.Expired {
+		t.Errorf("LockInfo = %+v, owner %+v", li, li.Owner)
+	}
+	if _, err := storage.LockInfo(ctx, "issue_cert_other.com"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LockInfo of an unlocked key = %v, want fs.ErrNotExist", err)
+	}
+
+	keys, err := storage.List(ctx, "", true)
+	if err != nil {
+		t.Fatalf("listing failed: %v", err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("lock objects must not be listed, got %v", keys)
+	}
+
+	if err := storage.Unlock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatalf("unlocking failed: %v", err)
+	}
+}
+
+func TestStorageTryLock(t *testing.T) {
+	a, client := s3test.NewFakeStorage(t)
+	b, _ := s3test.NewFakeStorage(t, func(s *s3.S3Storage) { s.Client = client })
+	ctx := context.Background()
+	key := "issue_cert_example.com"
+
+	if acquired, err := a.TryLock(ctx, key); err != nil || !acquired {
+		t.Fatalf("TryLock of a free lock = %v, %v", acquired, err)
+	}
+	start := time.Now()
+	if acquired, err := b.TryLock(ctx, key); err != nil || acquired {
+		t.Errorf("TryLock of a held lock = %v, %v", acquired, err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("TryLock waited %v", waited)
+	}
+	if err := a.Unlock(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if acquired, err := b.TryLock(ctx, key); err != nil || !acquired {
+		t.Errorf("TryLock after Unlock = %v, %v", acquired, err)
+	}
+	if li, err := a.LockInfo(ctx, key); err != nil || li.Owner == nil {
+		t.Errorf("LockInfo = %+v, %v", li, err)
+	}
+	if err := b.Unlock(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageLockBucket(t *testing.T) {
+	srv := s3test.NewServer(t)
+	srv.CreateBucket(t, "locks")
+	storage := srv.Storage(t, func(s *s3.S3Storage) { s.LockBucket = "locks" })
+	ctx := context.Background()
+
+	key := "issue_cert_example.com"
+	if err := storage.Lock(ctx, key); err != nil {
+		t.Fatalf("locking failed: %v", err)
+	}
+	if err := storage.Store(ctx, "certificates/example.com", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	bucketKeys := func(bucket string) []string {
+		out, err := storage.Client.ListObjectsV2(ctx, &awss3.ListObjectsV2Input{Bucket: aws.String(bucket)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, obj := range out.Contents {
+			keys = append(keys, *obj.Key)
+		}
+		return keys
+	}
+	if keys := bucketKeys("locks"); !slices.Equal(keys, []string{"certmagic/locks/" + key}) {
+		t.Errorf("lock bucket holds %v", keys)
+	}
+	if keys := bucketKeys(srv.Bucket); !slices.Equal(keys, []string{"certmagic/certificates/example.com"}) {
+		t.Errorf("data bucket holds %v", keys)
+	}
+	if locks, err := storage.ListLocks(ctx); err != nil || len(locks) != 1 || locks[0].Key != key {
+		t.Errorf("ListLocks = %+v, %v", locks, err)
+	}
+	if li, err := storage.LockInfo(ctx, key); err != nil || li.Owner == nil {
+		t.Errorf("LockInfo = %+v, %v", li, err)
+	}
+	if err := storage.Unlock(ctx, key); err != nil {
+		t.Fatalf("unlocking failed: %v", err)
+	}
+	if keys := bucketKeys("locks"); len(keys) != 0 {
+		t.Errorf("lock bucket holds %v after Unlock", keys)
+	}
+
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	invalid := &s3.S3Storage{Bucket: srv.Bucket, Endpoint: srv.URL, Region: "us-east-1", LockBucket: srv.Bucket}
+	if err := invalid.Provision(caddyCtx); err == nil {
+		t.Error("expected lock_bucket equal to bucket to be rejected")
+	}
+}
+
+func TestStorageCleanupReleasesLocks(t *testing.T) {
+	srv := s3test.NewServer(t)
+	a, b := srv.Storage(t), srv.Storage(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"issue_cert_a.example.com", "issue_cert_b.example.com"} {
+		if err := a.Lock(ctx, key); err != nil {
+			t.Fatalf("locking failed: %v", err)
+		}
+	}
+	if err := a.Unlock(ctx, "issue_cert_a.example.com"); err != nil {
+		t.Fatalf("unlocking failed: %v", err)
+	}
+	if err := a.Cleanup(); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+
+	locks, err := b.ListLocks(ctx)
+	if err != nil {
+		t.Fatalf("listing locks failed: %v", err)
+	}
+	if len(locks) != 0 {
+		t.Errorf("locks left after cleanup: %+v", locks)
+	}
+	lockCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := b.Lock(lockCtx, "issue_cert_b.example.com"); err != nil {
+		t.Errorf("lock released on cleanup cannot be taken: %v", err)
+	}
+}
+
+func TestReencryptFromCleartext(t *testing.T) {
+	srv := s3test.NewServer(t)
+	ctx := context.Background()
+
+	cleartext := srv.Storage(t, func(s *s3.S3Storage) { s.EmptyValueSentinel = true })
+	if err := cleartext.Store(ctx, "acme/account.json", []byte("account")); err != nil {
+		t.Fatalf("storing failed: %v", err)
+	}
+	if err := cleartext.Store(ctx, "acme/empty", nil); err != nil {
+		t.Fatalf("storing failed: %v", err)
+	}
+
+	encrypted := srv.Storage(t, func(s *s3.S3Storage) {
+		s.EncryptionKey = "12345678123456781234567812345678"
+	})
+	stats, err := encrypted.Reencrypt(ctx, &s3.CleartextIO{}, false)
+	if err != nil {
+		t.Fatalf("re-encrypting failed: %v", err)
+	}
+	if stats.Reencrypted != 1 {
+		t.Errorf("expected 1 re-encrypted object, got %+v", stats)
+	}
+
+	value, err := encrypted.Load(ctx, "acme/account.json")
+	if err != nil || string(value) != "account" {
+		t.Errorf("expected to load the original value, got %q (%v)", value, err)
+	}
+	if value, err := encrypted.Load(ctx, "acme/empty"); err != nil || len(value) != 0 {
+		t.Errorf("expected the empty value to stay empty, got %q (%v)", value, err)
+	}
+
+	// A second run finds nothing left to do.
+	stats, err = encrypted.Reencrypt(ctx, &s3.CleartextIO{}, false)
+	if err != nil || stats.Reencrypted != 0 || stats.Skipped != 2 {
+		t.Errorf("expected the second run to skip everything, got %+v (%v)", stats, err)
+	}
+}
+
+func TestStorageCopyMove(t *testing.T) {
+	srv := s3test.NewServer(t)
+	ctx := context.Background()
+	storage := srv.Storage(t, func(s *s3.S3Storage) {
+		s.EncryptionKey = "12345678123456781234567812345678"
+	})
+	site := "certificates/acme/a.example.com/"
+	for key, value := range map[string]string{site + "a.example.com.crt": "cert", site + "a example.com.key": "key"} {
+		if err := storage.Store(ctx, key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := storage.Copy(ctx, site+"a.example.com.crt", "backup/a.crt"); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if value, err := storage.Load(ctx, "backup/a.crt"); err != nil || string(value) != "cert" {
+		t.Errorf("copied value = %q, %v", value, err)
+	}
+	if err := storage.Move(ctx, "certificates/acme/a.example.com", "certificates/other/a.example.com"); err != nil {
+		t.Fatalf("Move failed: %v", err)
+	}
+	if value, err := storage.Load(ctx, "certificates/other/a.example.com/a example.com.key"); err != nil || string(value) != "key" {
+		t.Errorf("moved value = %q, %v", value, err)
+	}
+	if storage.Exists(ctx, site+"a.example.com.crt") {
+		t.Error("moved value still exists at the old key")
+	}
+
+	if err := storage.Copy(ctx, "missing", "elsewhere"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Copy of a missing key = %v, want fs.ErrNotExist", err)
+	}
+	if err := storage.Move(ctx, "certificates", "certificates/nested"); err == nil {
+		t.Error("Move into its own subtree succeeded")
+	}
+}
+
+func TestMigratePrefix(t *testing.T) {
+	srv := s3test.NewServer(t)
+	ctx := context.Background()
+	old := srv.Storage(t, func(s *s3.S3Storage) { s.Prefix = "old" })
+	for _, key := range []string{"acme/account.json", "certificates/acme/a.example.com/a.example.com.crt"} {
+		if err := old.Store(ctx, key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := old.Lock(ctx, "issue_cert_a.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = old.Unlock(ctx, "issue_cert_a.example.com") }()
+
+	migrated := srv.Storage(t, func(s *s3.S3Storage) { s.Prefix = "new" })
+	stats, err := migrated.MigratePrefix(ctx, "old", "new", false, true)
+	if err != nil || stats.Copied != 2 || stats.Deleted != 0 || migrated.Exists(ctx, "acme/account.json") {
+		t.Fatalf("dry run = %+v, %v", stats, err)
+	}
+	stats, err = migrated.MigratePrefix(ctx, "old", "new", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Copied != 2 || stats.Deleted != 2 || stats.Skipped == 0 {
+		t.Errorf("stats = %+v, want 2 copied and deleted and the lock objects skipped", stats)
+	}
+	if value, err := migrated.Load(ctx, "acme/account.json"); err != nil || string(value) != "acme/account.json" {
+		t.Errorf("migrated value = %q, %v", value, err)
+	}
+	if old.Exists(ctx, "acme/account.json") {
+		t.Error("migrated object still exists below the old prefix")
+	}
+}
+
+func TestReencryptToAESGCM(t *testing.T) {
+	srv := s3test.NewServer(t)
+	ctx := context.Background()
+	key := "12345678123456781234567812345678"
+	secretbox := srv.Storage(t, func(s *s3.S3Storage) { s.EncryptionKey = key })
+	if err := secretbox.Store(ctx, "acme/account.json", []byte("account")); err != nil {
+		t.Fatal(err)
+	}
+
+	gcm := srv.Storage(t, func(s *s3.S3Storage) { s.EncryptionKey, s.EncryptionCipher = key, "aes-gcm" })
+	if value, err := gcm.Load(ctx, "acme/account.json"); err != nil || string(value) != "account" {
+		t.Fatalf("secretbox object not readable with aes-gcm: %q (%v)", value, err)
+	}
+	from, _ := s3.NewIO(key)
+	stats, err := gcm.Reencrypt(ctx, from, false)
+	if err != nil || stats.Reencrypted != 1 {
+		t.Fatalf("expected the object to be migrated, got %+v (%v)", stats, err)
+	}
+	if stats, err = gcm.Reencrypt(ctx, from, false); err != nil || stats.Skipped != 1 {
+		t.Errorf("expected the second run to skip everything, got %+v (%v)", stats, err)
+	}
+	if value, err := secretbox.Load(ctx, "acme/account.json"); err != nil || string(value) != "account" {
+		t.Errorf("aes-gcm object not readable with secretbox: %q (%v)", value, err)
+	}
+}
+
+func TestStorageStream(t *testing.T) {
+	storage := s3test.NewStorage(t, func(s *s3.S3Storage) {
+		s.EncryptionKey = "12345678123456781234567812345678"
+		s.EmptyValueSentinel = true
+	})
+	ctx := context.Background()
+
+	// Larger than one upload part, so it is uploaded and downloaded in several parts.
+	value := bytes.Repeat([]byte("0123456789abcdef"), 6<<20/16)
+	if err := storage.StoreStream(ctx, "backups/large", bytes.NewReader(value), int64(len(value))); err != nil {
+		t.Fatalf("storing stream failed: %v", err)
+	}
+	body, err := storage.LoadStream(ctx, "backups/large")
+	if err != nil {
+		t.Fatalf("loading stream failed: %v", err)
+	}
+	defer body.Close()
+	loaded, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("reading stream failed: %v", err)
+	}
+	if !bytes.Equal(loaded, value) {
+		t.Errorf("stream round trip changed the value (got %d bytes)", len(loaded))
+	}
+
+	// Values written by Store and StoreStream can be read by either API.
+	if loaded, err := storage.Load(ctx, "backups/large"); err != nil || !bytes.Equal(loaded, value) {
+		t.Errorf("Load of streamed value failed: %v", err)
+	}
+	if err := storage.Store(ctx, "small", []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	small, err := storage.LoadStream(ctx, "small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer small.Close()
+	if loaded, err := io.ReadAll(small); err != nil || string(loaded) != "small" {
+		t.Errorf("LoadStream of stored value: %q, %v", loaded, err)
+	}
+	if _, err := storage.LoadStream(ctx, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+
+	// Empty values are only written as sentinel objects without encryption.
+	cleartext := s3test.NewStorage(t, func(s *s3.S3Storage) { s.EmptyValueSentinel = true })
+	if err := cleartext.Store(ctx, "empty", nil); err != nil {
+		t.Fatal(err)
+	}
+	empty, err := cleartext.LoadStream(ctx, "empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.Close()
+	if loaded, err := io.ReadAll(empty); err != nil || len(loaded) != 0 {
+		t.Errorf("LoadStream of empty value: %q, %v", loaded, err)
+	}
+}
+
+// truncatingClient loses the last byte of the next writes, like a backend with a consistency bug.
+type truncatingClient struct {
+	*s3test.FakeClient
+	truncate int
+}
+
+func (c *truncatingClient) PutObject(ctx context.Context, params *awss3.PutObjectInput, optFns ...func(*awss3.Options)) (*awss3.PutObjectOutput, error) {
+	if c.truncate > 0 && params.Body != nil {
+		c.truncate--
+		body, err := io.ReadAll(params.Body)
+		if err != nil {
+			return nil, err
+		}
+		truncated := *params
+		truncated.Body = bytes.NewReader(body[:len(body)-1])
+		truncated.ContentLength = aws.Int64(int64(len(body) - 1))
+		params = &truncated
+	}
+	return c.FakeClient.PutObject(ctx, params, optFns...)
+}
+
+func TestStorageVerifyWrites(t *testing.T) {
+	client := &truncatingClient{FakeClient: s3test.NewFakeClient(s3test.DefaultBucket)}
+	storage, _ := s3test.NewFakeStorage(t, func(s *s3.S3Storage) {
+		s.Client = client
+		s.VerifyWrites = true
+	})
+	ctx := context.Background()
+
+	client.truncate = 1
+	if err := storage.Store(ctx, "acme/account.json", []byte("account")); err != nil {
+		t.Fatalf("Store with one lost write failed: %v", err)
+	}
+	if value, err := storage.Load(ctx, "acme/account.json"); err != nil || string(value) != "account" {
+		t.Errorf("Load = %q, %v", value, err)
+	}
+
+	client.truncate = 3
+	if err := storage.Store(ctx, "acme/account.json", []byte("changed")); !errors.Is(err, s3.ErrIntegrity) {
+		t.Errorf("Store with every write lost = %v, want ErrIntegrity", err)
+	}
+}
+
+// rangeCountingClient counts the ranged GetObject requests.
+type rangeCountingClient struct {
+	*s3test.FakeClient
+	ranged atomic.Int64
+}
+
+func (c *rangeCountingClient) GetObject(ctx context.Context, params *awss3.GetObjectInput, optFns ...func(*awss3.Options)) (*awss3.GetObjectOutput, error) {
+	if params.Range != nil {
+		c.ranged.Add(1)
+	}
+	return c.FakeClient.GetObject(ctx, params, optFns...)
+}
+
+func TestStorageParallelDownload(t *testing.T) {
+	client := &rangeCountingClient{FakeClient: s3test.NewFakeClient(s3test.DefaultBucket)}
+	storage, _ := s3test.NewFakeStorage(t, func(s *s3.S3Storage) {
+		s.Client = client
+		s.EncryptionKey = "12345678123456781234567812345678"
+		s.ParallelDownload = &s3.ParallelDownloadConfig{Threshold: 4096, PartSize: 1024, Concurrency: 3}
+	})
+	ctx := context.Background()
+
+	large := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	if err := storage.Store(ctx, "ocsp/bundle", large); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Store(ctx, "ocsp/small", []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := storage.Load(ctx, "ocsp/small"); err != nil || string(value) != "small" {
+		t.Errorf("Load small = %q, %v", value, err)
+	}
+	if n := client.ranged.Load(); n != 0 {
+		t.Errorf("small object downloaded in %d parts", n)
+	}
+	value, err := storage.Load(ctx, "ocsp/bundle")
+	if err != nil || !bytes.Equal(value, large) {
+		t.Fatalf("Load large = %d bytes, %v", len(value), err)
+	}
+	if n := client.ranged.Load(); n < 16 {
+		t.Errorf("large object downloaded in %d parts, want at least 16", n)
+	}
+
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	invalid := &s3.S3Storage{Client: s3test.NewFakeClient(s3test.DefaultBucket), Bucket: s3test.DefaultBucket, Region: "us-east-1",
+		ParallelDownload: &s3.ParallelDownloadConfig{PartSize: -1}}
+	if err := invalid.Provision(caddyCtx); err == nil {
+		t.Error("expected negative part_size to be rejected")
+	}
+}
+
+func TestStorageLifecycle(t *testing.T) {
+	client := s3test.NewFakeClient(s3test.DefaultBucket)
+	other := types.LifecycleRule{
+		ID:     aws.String("other"),
+		Status: types.ExpirationStatusEnabled,
+		Filter: &types.LifecycleRuleFilter{Prefix: aws.String("logs/")},
+	}
+	if _, err := client.PutBucketLifecycleConfiguration(context.Background(), &awss3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(s3test.DefaultBucket),
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: []types.LifecycleRule{other}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	provision := func(cfg s3.LifecycleConfig) {
+		s3test.NewFakeStorage(t, func(s *s3.S3Storage) {
+			s.Client = client
+			s.Lifecycle = &cfg
+		})
+	}
+	ids := func() []string {
+		var ids []string
+		for _, r := range client.LifecycleRules(s3test.DefaultBucket) {
+			ids = append(ids, aws.ToString(r.ID))
+		}
+		return ids
+	}
+
+	provision(s3.LifecycleConfig{AbortIncompleteUploadsDays: 1, ExpireOCSPDays: 7, TransitionDays: 30})
+	rules := client.LifecycleRules(s3test.DefaultBucket)
+	if got := ids(); !slices.Equal(got, []string{"other", "certmagic-s3:certmagic:abort-uploads", "certmagic-s3:certmagic:expire-ocsp", "certmagic-s3:certmagic:transition"}) {
+		t.Fatalf("rules = %v", got)
+	}
+	if prefix := aws.ToString(rules[2].Filter.Prefix); prefix != "certmagic/ocsp/" {
+		t.Errorf("expire-ocsp prefix = %q", prefix)
+	}
+	if class := rules[3].Transitions[0].StorageClass; class != types.TransitionStorageClassStandardIa {
+		t.Errorf("transition storage class = %s", class)
+	}
+
+	provision(s3.LifecycleConfig{ExpireOCSPDays: 14})
+	rules = client.LifecycleRules(s3test.DefaultBucket)
+	if got := ids(); !slices.Equal(got, []string{"other", "certmagic-s3:certmagic:expire-ocsp"}) {
+		t.Fatalf("rules after update = %v", got)
+	}
+	if days := aws.ToInt32(rules[1].Expiration.Days); days != 14 {
+		t.Errorf("expire-ocsp days = %d", days)
+	}
+
+	provision(s3.LifecycleConfig{})
+	if got := ids(); !slices.Equal(got, []string{"other"}) {
+		t.Errorf("rules after removal = %v", got)
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	for name, cfg := range map[string]*s3.S3Storage{
+		"read only":     {ReadOnly: true, Lifecycle: &s3.LifecycleConfig{ExpireOCSPDays: 7}},
+		"storage class": {Lifecycle: &s3.LifecycleConfig{TransitionDays: 30, TransitionStorageClass: "FAST"}},
+	} {
+		cfg.Client, cfg.Bucket, cfg.Region = client, s3test.DefaultBucket, "us-east-1"
+		if err := cfg.Provision(ctx); err == nil {
+			t.Errorf("%s: expected provisioning to fail", name)
+		}
+	}
+}
+
+func TestStorageSoftDelete(t *testing.T) {
+	storage, client := s3test.NewFakeStorage(t, func(s *s3.S3Storage) {
+		s.SoftDelete = &s3.SoftDeleteConfig{}
+	})
+	ctx := context.Background()
+	for _, key := range []string{"certificates/example.com/example.com.crt", "certificates/example.com/example.com.key", "acme/account.json"} {
+		if err := storage.Store(ctx, key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := storage.Delete(ctx, "certificates/example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Delete(ctx, "acme/account.json"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := storage.List(ctx, "", true); err != nil || len(keys) != 0 {
+		t.Errorf("List after delete = %v, %v", keys, err)
+	}
+	if !slices.Contains(client.Keys(s3test.DefaultBucket), "certmagic/trash/acme/account.json") {
+		t.Errorf("deleted value not in the trash: %v", client.Keys(s3test.DefaultBucket))
+	}
+
+	if err := storage.RestoreDeleted(ctx, "certificates/example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := storage.Load(ctx, "certificates/example.com/example.com.key"); err != nil || string(value) != "certificates/example.com/example.com.key" {
+		t.Errorf("restored value = %q, %v", value, err)
+	}
+	if err := storage.RestoreDeleted(ctx, "certificates/example.com"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("restoring twice = %v, want fs.ErrNotExist", err)
+	}
+
+	if n, err := storage.PurgeDeleted(ctx, 0); err != nil || n != 0 {
+		t.Errorf("purge within the retention = %d, %v", n, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n, err := storage.PurgeDeleted(ctx, time.Millisecond); err != nil || n != 1 {
+		t.Errorf("purge = %d, %v, want 1", n, err)
+	}
+	if err := storage.RestoreDeleted(ctx, "acme/account.json"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("restoring a purged value = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestStorageSoftDeleteVersioning(t *testing.T) {
+	srv := s3test.NewServer(t)
+	ctx := context.Background()
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	unversioned := &s3.S3Storage{Bucket: srv.Bucket, Endpoint: srv.URL, Region: "us-east-1", AccessKeyID: "s3test", SecretAccessKey: "s3test",
+		SoftDelete: &s3.SoftDeleteConfig{Mode: "versioning"}}
+	if err := unversioned.Provision(caddyCtx); err == nil {
+		t.Fatal("expected provisioning to fail without bucket versioning")
+	}
+
+	if _, err := srv.Storage(t).Client.(*awss3.Client).PutBucketVersioning(ctx, &awss3.PutBucketVersioningInput{
+		Bucket:                  aws.String(srv.Bucket),
+		VersioningConfiguration: &types.VersioningConfiguration{Status: types.BucketVersioningStatusEnabled},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	storage := srv.Storage(t, func(s *s3.S3Storage) { s.SoftDelete = &s3.SoftDeleteConfig{Mode: "versioning"} })
+
+	const key = "acme/account.json"
+	if err := storage.Store(ctx, key, []byte("account")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond) // Distinct modification times
+	if err := storage.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.RestoreDeleted(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := storage.Load(ctx, key); err != nil || string(value) != "account" {
+		t.Errorf("restored value = %q, %v", value, err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if n, err := storage.PurgeDeleted(ctx, time.Millisecond); err != nil || n != 2 {
+		t.Errorf("purge = %d, %v, want the old version and the delete marker", n, err)
+	}
+	if value, err := storage.Load(ctx, key); err != nil || string(value) != "account" {
+		t.Errorf("value after purge = %q, %v", value, err)
+	}
+}
+
+func TestStorageAccelerate(t *testing.T) {
+	client := s3test.NewFakeClient(s3test.DefaultBucket)
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	storage := &s3.S3Storage{Client: client, Bucket: s3test.DefaultBucket, Region: "us-east-1", UseAccelerate: true}
+	if err := storage.Provision(ctx); err == nil {
+		t.Fatal("expected provisioning to fail without transfer acceleration on the bucket")
+	}
+
+	if _, err := client.PutBucketAccelerateConfiguration(ctx, &awss3.PutBucketAccelerateConfigurationInput{
+		Bucket:                  aws.String(s3test.DefaultBucket),
+		AccelerateConfiguration: &types.AccelerateConfiguration{Status: types.BucketAccelerateStatusEnabled},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s3test.NewFakeStorage(t, func(s *s3.S3Storage) {
+		s.Client = client
+		s.UseAccelerate = true
+	})
+}
+
+func TestStorageFakeClient(t *testing.T) {
+	storage, client := s3test.NewFakeStorage(t, func(s *s3.S3Storage) {
+		s.EncryptionKey = "12345678123456781234567812345678"
+		s.ListPageSize = 2
+	})
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		if err := storage.Store(ctx, fmt.Sprintf("certificates/site%d/site.crt", i), []byte("certificate")); err != nil {
+			t.Fatalf("storing failed: %v", err)
+		}
+	}
+	if value, err := storage.Load(ctx, "certificates/site3/site.crt"); err != nil || string(value) != "certificate" {
+		t.Errorf("Load = %q, %v", value, err)
+	}
+	if _, err := storage.Load(ctx, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+	if keys, err := storage.List(ctx, "certificates", false); err != nil || len(keys) != 5 {
+		t.Errorf("non-recursive List = %v, %v", keys, err)
+	}
+	if keys, err := storage.List(ctx, "certificates", true); err != nil || len(keys) != 5 || keys[0] != "certificates/site0/site.crt" {
+		t.Errorf("recursive List = %v, %v", keys, err)
+	}
+
+	if err := storage.Lock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatalf("locking failed: %v", err)
+	}
+	if err := storage.Unlock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatalf("unlocking failed: %v", err)
+	}
+
+	value := bytes.Repeat([]byte("0123456789abcdef"), 6<<20/16)
+	if err := storage.StoreStream(ctx, "backups/large", bytes.NewReader(value), int64(len(value))); err != nil {
+		t.Fatalf("storing stream failed: %v", err)
+	}
+	body, err := storage.LoadStream(ctx, "backups/large")
+	if err != nil {
+		t.Fatalf("loading stream failed: %v", err)
+	}
+	defer body.Close()
+	if loaded, err := io.ReadAll(body); err != nil || !bytes.Equal(loaded, value) {
+		t.Errorf("stream round trip changed the value (got %d bytes, %v)", len(loaded), err)
+	}
+
+	if err := storage.Delete(ctx, "certificates"); err != nil {
+		t.Fatalf("deleting failed: %v", err)
+	}
+	if keys := client.Keys(s3test.DefaultBucket); !slices.Equal(keys, []string{"certmagic/backups/large"}) {
+		t.Errorf("objects left after deletion: %v", keys)
+	}
+}
+
+func TestStoragePassphrase(t *testing.T) {
+	srv := s3test.NewServer(t)
+	passphrase := func(s *s3.S3Storage) { s.EncryptionPassphrase = "correct horse battery staple" }
+	a, b := srv.Storage(t, passphrase), srv.Storage(t, passphrase)
+	ctx := context.Background()
+	if err := a.Store(ctx, "key", []byte("private key")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := b.Load(ctx, "key"); err != nil || string(value) != "private key" {
+		t.Errorf("Load by other instance = %q, %v", value, err)
+	}
+	if !a.Capabilities().EncryptionEnabled {
+		t.Error("encryption not reported as enabled")
+	}
+	// Instances and restarts share the salt stored by the first instance, so keys are derived once.
+	if restarted := srv.Storage(t, passphrase); !bytes.Equal(a.PassphraseSalt(), b.PassphraseSalt()) || !bytes.Equal(a.PassphraseSalt(), restarted.PassphraseSalt()) {
+		t.Error("instances use different salts")
+	}
+	if keys, err := a.List(ctx, "", true); err != nil || !slices.Equal(keys, []string{"key"}) {
+		t.Errorf("List = %v, %v; the stored salt must be hidden", keys, err)
+	}
+
+	short := &s3.S3Storage{Bucket: srv.Bucket, Endpoint: srv.URL, Region: "us-east-1", EncryptionKey: "short passphrase"}
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	if err := short.Provision(caddyCtx); err == nil || !strings.Contains(err.Error(), "encryption_passphrase") {
+		t.Errorf("Provision with short key = %v, want a hint at encryption_passphrase", err)
+	}
+}
+
+func TestStorageDefaultPrefix(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		noPrefix bool
+		want     string
+	}{{false, "certmagic/key"}, {true, "key"}} {
+		storage, client := s3test.NewFakeStorage(t, func(s *s3.S3Storage) {
+			s.Prefix = ""
+			s.NoPrefix = tc.noPrefix
+		})
+		if err := storage.Store(ctx, "key", []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		if keys := client.Keys(s3test.DefaultBucket); !slices.Equal(keys, []string{tc.want}) {
+			t.Errorf("no_prefix %t: keys = %v, want [%s]", tc.noPrefix, keys, tc.want)
+		}
+	}
+
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	for _, invalid := range []*s3.S3Storage{
+		{Prefix: "certs", NoPrefix: true},
+		{Prefix: "{tenant}", PrefixVars: map[string]string{"tenant": "/"}},
+	} {
+		invalid.Client, invalid.Bucket, invalid.Region = s3test.NewFakeClient(s3test.DefaultBucket), s3test.DefaultBucket, "us-east-1"
+		if err := invalid.Provision(caddyCtx); err == nil {
+			t.Errorf("expected prefix %q with no_prefix %t to be rejected", invalid.Prefix, invalid.NoPrefix)
+		}
+	}
+}
+
+func TestStorageRoutes(t *testing.T) {
+	srv := s3test.NewServer(t)
+	srv.CreateBucket(t, "accounts")
+	storage := srv.Storage(t, func(s *s3.S3Storage) {
+		s.Routes = []s3.RouteConfig{{Prefix: "acme/*", Bucket: "accounts"}}
+	})
+	ctx := context.Background()
+
+	account := "acme/acme-v02.api.letsencrypt.org-directory/users/admin/admin.key"
+	cert := "certificates/acme-v02.api.letsencrypt.org-directory/example.com/example.com.crt"
+	for _, key := range []string{account, cert} {
+		if err := storage.Store(ctx, key, []byte("value")); err != nil {
+			t.Fatalf("storing %s failed: %v", key, err)
+		}
+		if value, err := storage.Load(ctx, key); err != nil || string(value) != "value" {
+			t.Errorf("Load(%s) = %q, %v", key, value, err)
+		}
+	}
+
+	bucketKeys := func(bucket string) []string {
+		out, err := storage.Client.ListObjectsV2(ctx, &awss3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String("certmagic/")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, obj := range out.Contents {
+			if !strings.Contains(*obj.Key, "/locks/") {
+				keys = append(keys, *obj.Key)
+			}
+		}
+		return keys
+	}
+	if keys := bucketKeys("accounts"); !slices.Equal(keys, []string{"certmagic/" + account}) {
+		t.Errorf("accounts bucket holds %v", keys)
+	}
+	if keys := bucketKeys(srv.Bucket); !slices.Equal(keys, []string{"certmagic/" + cert}) {
+		t.Errorf("default bucket holds %v", keys)
+	}
+
+	if keys, err := storage.List(ctx, "", true); err != nil || !slices.Equal(keys, []string{cert, account}) {
+		t.Errorf("recursive List = %v, %v", keys, err)
+	}
+	if keys, err := storage.List(ctx, "", false); err != nil || !slices.Equal(keys, []string{"certificates", "acme"}) {
+		t.Errorf("non-recursive List = %v, %v", keys, err)
+	}
+	if keys, err := storage.List(ctx, "acme", true); err != nil || !slices.Equal(keys, []string{account}) {
+		t.Errorf("List of routed prefix = %v, %v", keys, err)
+	}
+
+	if n, err := storage.DeleteAll(ctx, ""); err != nil || n != 2 {
+		t.Errorf("DeleteAll = %d, %v", n, err)
+	}
+	if storage.Exists(ctx, account) {
+		t.Error("account key still exists")
+	}
+}
+
+func TestStorageShards(t *testing.T) {
+	srv := s3test.NewServer(t)
+	shards := []string{srv.Bucket, "shard-b", "shard-c"}
+	for _, bucket := range shards[1:] {
+		srv.CreateBucket(t, bucket)
+	}
+	storage := srv.Storage(t, func(s *s3.S3Storage) { s.Shards = shards })
+	ctx := context.Background()
+
+	issuer := "certificates/acme-v02.api.letsencrypt.org-directory"
+	account := "acme/acme-v02.api.letsencrypt.org-directory/users/admin/admin.key"
+	keys := []string{account}
+	var names []string
+	for i := range 12 {
+		name := fmt.Sprintf("site%d.example.com", i)
+		names = append(names, name)
+		keys = append(keys, issuer+"/"+name+"/"+name+".crt")
+	}
+	for _, key := range keys {
+		if err := storage.Store(ctx, key, []byte("value")); err != nil {
+			t.Fatalf("storing %s failed: %v", key, err)
+		}
+		if value, err := storage.Load(ctx, key); err != nil || string(value) != "value" {
+			t.Errorf("Load(%s) = %q, %v", key, value, err)
+		}
+	}
+
+	for _, bucket := range shards {
+		out, err := storage.Client.ListObjectsV2(ctx, &awss3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String("certmagic/" + issuer + "/")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out.Contents) == 0 {
+			t.Errorf("no certificates in shard %s", bucket)
+		}
+	}
+	if !storage.Exists(ctx, account) {
+		t.Error("account key missing")
+	}
+
+	listed, err := storage.List(ctx, "", true)
+	slices.Sort(listed)
+	if want := slices.Sorted(slices.Values(keys)); err != nil || !slices.Equal(listed, want) {
+		t.Errorf("recursive List = %v, %v", listed, err)
+	}
+	if dirs, err := storage.List(ctx, "", false); err != nil || len(dirs) != 2 {
+		t.Errorf("non-recursive List = %v, %v, want acme and certificates once", dirs, err)
+	}
+	sites, err := storage.List(ctx, issuer, false)
+	slices.Sort(sites)
+	var want []string
+	for _, name := range names {
+		want = append(want, issuer+"/"+name)
+	}
+	slices.Sort(want)
+	if err != nil || !slices.Equal(sites, want) {
+		t.Errorf("List of issuer = %v, %v", sites, err)
+	}
+	site := issuer + "/" + names[0]
+	if files, err := storage.List(ctx, site, true); err != nil || len(files) != 1 {
+		t.Errorf("List of site = %v, %v", files, err)
+	}
+
+	if n, err := storage.DeleteAll(ctx, ""); err != nil || n != len(keys) {
+		t.Errorf("DeleteAll = %d, %v, want %d", n, err, len(keys))
+	}
+	if remaining, err := storage.List(ctx, "", true); err != nil || len(remaining) != 0 {
+		t.Errorf("List after DeleteAll = %v, %v", remaining, err)
+	}
+
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	invalid := &s3.S3Storage{Bucket: srv.Bucket, Endpoint: srv.URL, Region: "us-east-1", Shards: []string{"a", "a"}}
+	if err := invalid.Provision(caddyCtx); err == nil {
+		t.Error("expected duplicate shards to be rejected")
+	}
+}
+
+func TestStorageManifest(t *testing.T) {
+	srv := s3test.NewServer(t)
+	storage := srv.Storage(t, func(s *s3.S3Storage) { s.Manifest = true })
+	ctx := context.Background()
+
+	for _, key := range []string{"certificates/acme/a.com/a.com.crt", "certificates/acme/b.com/b.com.crt", "acme/account.json"} {
+		if err := storage.Store(ctx, key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.Delete(ctx, "certificates/acme/b.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys, err := storage.List(ctx, "certificates", true); err != nil || !slices.Equal(keys, []string{"certificates/acme/a.com/a.com.crt"}) {
+		t.Errorf("listing of the writing instance = %v, %v", keys, err)
+	}
+
+	// A second instance reads the listing from the manifest written by the first one.
+	storage.FlushManifest()
+	other := srv.Storage(t, func(s *s3.S3Storage) { s.Manifest = true })
+	keys, err := other.List(ctx, "certificates", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(keys, []string{"certificates/acme/a.com/a.com.crt"}) {
+		t.Errorf("unexpected recursive listing %v", keys)
+	}
+	keys, err = other.List(ctx, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(keys, []string{"acme", "certificates"}) {
+		t.Errorf("unexpected top-level listing %v", keys)
+	}
+}
+
+func TestValidateOnStart(t *testing.T) {
+	srv := s3test.NewServer(t)
+	srv.Storage(t, func(s *s3.S3Storage) { s.ValidateOnStart = true })
+
+	storage := &s3.S3Storage{
+		Bucket:          "does-not-exist",
+		Region:          "us-east-1",
+		Endpoint:        srv.URL,
+		AccessKeyID:     "s3test",
+		SecretAccessKey: "s3test",
+		ValidateOnStart: true,
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	err := storage.Provision(ctx)
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("expected missing bucket to be reported, got %v", err)
+	}
+}
+
+func TestVerifyOnStart(t *testing.T) {
+	srv := s3test.NewServer(t)
+	key := "12345678901234567890123456789012"
+	srv.Storage(t, func(s *s3.S3Storage) { s.EncryptionKey, s.VerifyOnStart = key, true }) // Writes the sentinel
+	a := srv.Storage(t, func(s *s3.S3Storage) { s.EncryptionKey, s.VerifyOnStart = key, true })
+	ctx := context.Background()
+	if keys, err := a.List(ctx, "", true); err != nil || len(keys) != 0 {
+		t.Errorf("List = %v, %v; want the sentinel hidden", keys, err)
+	}
+
+	for name, configure := range map[string]func(*s3.S3Storage){
+		"other key": func(s *s3.S3Storage) { s.EncryptionKey = "abcdefghijklmnopqrstuvwxyz012345" },
+		"cleartext": func(*s3.S3Storage) {},
+	} {
+		storage := &s3.S3Storage{
+			Bucket:          srv.Bucket,
+			Region:          "us-east-1",
+			Endpoint:        srv.URL,
+			AccessKeyID:     "s3test",
+			SecretAccessKey: "s3test",
+			VerifyOnStart:   true,
+		}
+		configure(storage)
+		caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+		err := storage.Provision(caddyCtx)
+		cancel()
+		if !errors.Is(err, s3.ErrEncryptionMismatch) {
+			t.Errorf("%s: Provision = %v, want ErrEncryptionMismatch", name, err)
+		}
+	}
+}
+
+func TestStoreDetectsBodyTransformation(t *testing.T) {
+	srv := s3test.NewServer(t)
+	target, _ := url.Parse(srv.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	var contentTypes []string
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		if r.Method == http.MethodPut {
+			contentTypes = append(contentTypes, r.Header.Get("Content-Type"))
+		}
+		director(r)
+	}
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if resp.Request.Method != http.MethodGet || resp.StatusCode != http.StatusOK {
+			return nil
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		body = bytes.ToUpper(body) // A "helpful" gateway
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		return nil
+	}
+	mangling := httptest.NewServer(proxy)
+	defer mangling.Close()
+
+	storage := srv.Storage(t, func(s *s3.S3Storage) { s.Endpoint = mangling.URL })
+	err := storage.Store(context.Background(), "key", []byte("lowercase"))
+	if err == nil || !strings.Contains(err.Error(), "round-trip check") {
+		t.Errorf("expected round-trip check to fail, got %v", err)
+	}
+	if len(contentTypes) == 0 || contentTypes[0] != "application/octet-stream" {
+		t.Errorf("unexpected content types %v", contentTypes)
+	}
+}
+
+// overwritingClient writes another value to each object before it is first read, like another
+// instance writing the same key concurrently.
+type overwritingClient struct {
+	s3.S3API
+	overwritten map[string]bool
+}
+
+func (c *overwritingClient) GetObject(ctx context.Context, params *awss3.GetObjectInput, optFns ...func(*awss3.Options)) (*awss3.GetObjectOutput, error) {
+	if key := aws.ToString(params.Key); !c.overwritten[key] {
+		c.overwritten[key] = true
+		if _, err := c.S3API.PutObject(ctx, &awss3.PutObjectInput{Bucket: params.Bucket, Key: params.Key, Body: strings.NewReader("other")}); err != nil {
+			return nil, err
+		}
+	}
+	return c.S3API.GetObject(ctx, params, optFns...)
+}
+
+func TestStoreRoundTripConcurrentWrite(t *testing.T) {
+	storage, _ := s3test.NewFakeStorage(t)
+	storage.Client = &overwritingClient{S3API: storage.Client, overwritten: make(map[string]bool)}
+	if err := storage.Store(context.Background(), "key", []byte("value")); err != nil {
+		t.Errorf("Store overwritten concurrently = %v, want the round-trip check to be inconclusive", err)
+	}
+}
+
+func TestExistsOnError(t *testing.T) {
+	denying := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer denying.Close()
+
+	for _, existsOnError := range []bool{false, true} {
+		storage := s3test.NewStorage(t, func(s *s3.S3Storage) {
+			s.Endpoint = denying.URL
+			s.MaxRetries = 1
+			s.ExistsOnError = existsOnError
+		})
+		exists, err := storage.ExistsErr(context.Background(), "certificates/x.crt")
+		var ade *s3.AccessDeniedError
+		if exists || !errors.As(err, &ade) {
+			t.Errorf("expected access denied error, got %v, %v", exists, err)
+		}
+		if got := storage.Exists(context.Background(), "certificates/x.crt"); got != existsOnError {
+			t.Errorf("Exists = %v with exists_on_error %v", got, existsOnError)
+		}
+	}
+}
+
+func TestStorageEmptyValues(t *testing.T) {
+	srv := s3test.NewServer(t)
+	target, _ := url.Parse(srv.URL)
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && r.ContentLength == 0 && r.Header.Get("X-Amz-Copy-Source") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `<Error><Code>InvalidRequest</Code><Message>empty body</Message></Error>`)
+			return
+		}
+		httputil.NewSingleHostReverseProxy(target).ServeHTTP(w, r)
+	}))
+	defer rejecting.Close()
+
+	for name, configure := range map[string]func(*s3.S3Storage){
+		"cleartext": func(*s3.S3Storage) {},
+		"encrypted": func(s *s3.S3Storage) { s.EncryptionKey = "12345678123456781234567812345678" },
+		"sentinel":  func(s *s3.S3Storage) { s.EmptyValueSentinel = true },
+		"rejecting": func(s *s3.S3Storage) { s.Endpoint = rejecting.URL },
+	} {
+		t.Run(name, func(t *testing.T) {
+			storage := srv.Storage(t, configure)
+			ctx := context.Background()
+			key := "markers/" + name
+			if err := storage.Store(ctx, key, nil); err != nil {
+				t.Fatalf("storing empty value failed: %v", err)
+			}
+			value, err := storage.Load(ctx, key)
+			if err != nil || value == nil || len(value) != 0 {
+				t.Errorf("expected empty value, got %q, %v", value, err)
+			}
+			if ki, err := storage.Stat(ctx, key); err != nil || (ki.Size != 0 && name != "encrypted") {
+				t.Errorf("expected size 0, got %+v, %v", ki, err) // Encrypted sizes include nonce and tag
+			}
+		})
+	}
+}
+
+func TestStorageListIter(t *testing.T) {
+	storage := s3test.NewStorage(t, func(s *s3.S3Storage) { s.ListPageSize = 2 })
+	ctx := context.Background()
+	for i := 0; i < 7; i++ {
+		if err := storage.Store(ctx, fmt.Sprintf("certificates/site%d.crt", i), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := storage.List(ctx, "certificates", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for key, err := range storage.ListIter(ctx, "certificates", true) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, key)
+	}
+	if len(want) != 7 || !slices.Equal(got, want) {
+		t.Errorf("ListIter = %v, List = %v", got, want)
+	}
+
+	before := storage.RequestStats().Requests["ListObjectsV2"]
+	n := 0
+	for range storage.ListIter(ctx, "certificates", true) {
+		if n++; n == 3 {
+			break
+		}
+	}
+	if pages := storage.RequestStats().Requests["ListObjectsV2"] - before; pages != 2 {
+		t.Errorf("listed %d pages for 3 keys, want 2", pages)
+	}
+}
+
+func TestStorageListConcurrency(t *testing.T) {
+	srv := s3test.NewServer(t)
+	sequential := srv.Storage(t)
+	parallel := srv.Storage(t, func(s *s3.S3Storage) {
+		s.ListConcurrency = 3
+		s.ListPageSize = 2
+	})
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		for _, issuer := range []string{"acme", "zerossl", "internal", "other"} {
+			key := fmt.Sprintf("certificates/%s/site%d.com/site%d.com.crt", issuer, i, i)
+			if err := sequential.Store(ctx, key, []byte("value")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := sequential.Store(ctx, "certificates/top", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sequential.Lock(ctx, "certificates/acme/site0.com"); err != nil {
+		t.Fatal(err)
+	}
+	defer sequential.Unlock(ctx, "certificates/acme/site0.com")
+
+	want, err := sequential.List(ctx, "certificates", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parallel.List(ctx, "certificates", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(want)
+	slices.Sort(got)
+	if len(want) != 21 || !slices.Equal(got, want) {
+		t.Errorf("parallel List = %v, sequential List = %v", got, want)
+	}
+
+	n := 0
+	for _, err := range parallel.ListIter(ctx, "certificates", true) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n++; n == 5 {
+			break
+		}
+	}
+}
+
+func TestStorageMaxConcurrentRequests(t *testing.T) {
+	srv := s3test.NewServer(t)
+	target, _ := url.Parse(srv.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		proxy.ServeHTTP(w, r)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer slow.Close()
+	storage := srv.Storage(t, func(s *s3.S3Storage) {
+		s.Endpoint = slow.URL
+		s.MaxConcurrentRequests = 2
+	})
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := storage.Store(ctx, fmt.Sprintf("key%d", i), []byte("value")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxInFlight > 2 {
+		t.Errorf("%d requests in flight, limit 2", maxInFlight)
+	}
+	stats, ok := storage.ConcurrencyStats()
+	if !ok || stats.Limit != 2 || stats.InFlight != 0 || stats.Queued == 0 || stats.MaxWaitSeconds <= 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if _, ok := s3test.NewStorage(t).ConcurrencyStats(); ok {
+		t.Error("stats reported without max_concurrent_requests")
+	}
+}
+
+func TestStorageAudit(t *testing.T) {
+	srv := s3test.NewServer(t)
+	ctx := context.Background()
+	auditFile := filepath.Join(t.TempDir(), "audit.jsonl")
+	storage := srv.Storage(t, func(s *s3.S3Storage) { s.Audit = &s3.AuditConfig{Sink: "file", Path: auditFile} })
+	if err := storage.Lock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Store(ctx, "certificates/acme/example.com/example.com.key", []byte("private key")); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Unlock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Delete(ctx, "certificates/acme/example.com/example.com.key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var ev s3.AuditEvent
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Outcome != "ok" || ev.Instance == "" || ev.Storage == "" {
+			t.Errorf("unexpected event %+v", ev)
+		}
+		if ev.Op == "store" && ev.Size != int64(len("private key")) {
+			t.Errorf("store size = %d", ev.Size)
+		}
+		ops = append(ops, ev.Op)
+	}
+	if want := []string{"lock", "store", "unlock", "delete"}; !slices.Equal(ops, want) {
+		t.Errorf("audited %v, want %v", ops, want)
+	}
+
+	// The s3 sink writes its events outside the storage prefix on flush
+	storage = srv.Storage(t, func(s *s3.S3Storage) { s.Audit = &s3.AuditConfig{Sink: "s3"} })
+	if err := storage.Store(ctx, "key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := storage.List(ctx, "", true); len(keys) != 1 {
+		t.Errorf("listed %v", keys)
+	}
+	if err := storage.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	out, err := storage.Client.ListObjectsV2(ctx, &awss3.ListObjectsV2Input{Bucket: aws.String(srv.Bucket), Prefix: aws.String("certmagic-audit/")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Contents) != 1 {
+		t.Fatalf("found %d audit objects, want 1", len(out.Contents))
+	}
+}
+
+func TestStorageRequesterPays(t *testing.T) {
+	srv := s3test.NewServer(t)
+	target, _ := url.Parse(srv.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	var mu sync.Mutex
+	missing := map[string]bool{}
+	checking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Request-Payer") != "requester" && !r.URL.Query().Has("versioning") { // Probed by Provision, a bucket request
+			mu.Lock()
+			missing[r.Method+" "+r.URL.RawQuery] = true
+			mu.Unlock()
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer checking.Close()
+	storage := srv.Storage(t, func(s *s3.S3Storage) {
+		s.Endpoint = checking.URL
+		s.RequesterPays = true
+	})
+
+	ctx := context.Background()
+	if err := storage.Store(ctx, "certificates/key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := storage.Load(ctx, "certificates/key"); err != nil || string(value) != "value" {
+		t.Errorf("load = %q, %v", value, err)
+	}
+	if _, err := storage.Stat(ctx, "certificates/key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.List(ctx, "certificates", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Delete(ctx, "certificates"); err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) > 0 {
+		t.Errorf("requests without RequestPayer: %v", missing)
+	}
+}
+
+func TestStorageRequestAttribution(t *testing.T) {
+	srv := s3test.NewServer(t)
+	target, _ := url.Parse(srv.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	var mu sync.Mutex
+	var agents, clusters []string
+	checking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents = append(agents, r.Header.Get("User-Agent"))
+		clusters = append(clusters, r.Header.Get("X-Caddy-Cluster"))
+		mu.Unlock()
+		proxy.ServeHTTP(w, r)
+	}))
+	defer checking.Close()
+	t.Setenv("TEST_CLUSTER", "eu-1")
+	storage := srv.Storage(t, func(s *s3.S3Storage) {
+		s.Endpoint = checking.URL
+		s.AppName = "edge-{env.TEST_CLUSTER}"
+		s.UserAgentSuffix = "caddy-cluster/{env.TEST_CLUSTER} team/platform"
+		s.RequestHeaders = map[string]string{"X-Caddy-Cluster": "{env.TEST_CLUSTER}"}
+	})
+
+	ctx := context.Background()
+	if err := storage.Store(ctx, "certificates/key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Load(ctx, "certificates/key"); err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) == 0 {
+		t.Fatal("no requests seen")
+	}
+	for i, agent := range agents {
+		for _, want := range []string{"app/edge-eu-1", "caddy-cluster/eu-1", "team/platform"} {
+			if !strings.Contains(agent, want) {
+				t.Errorf("User-Agent %q lacks %q", agent, want)
+			}
+		}
+		if clusters[i] != "eu-1" {
+			t.Errorf("X-Caddy-Cluster = %q", clusters[i])
+		}
+	}
+}
+
+func TestStorageRequestHeadersReserved(t *testing.T) {
+	s := &s3.S3Storage{Bucket: "bucket", RequestHeaders: map[string]string{"authorization": "x"}}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := s.Provision(ctx); err == nil || !strings.Contains(err.Error(), "set by the SDK") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestStorageClientOptions(t *testing.T) {
+	srv := s3test.NewServer(t)
+	target, _ := url.Parse(srv.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	var mu sync.Mutex
+	missing := map[string]bool{}
+	checking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Corp-Signature") != "signed" {
+			mu.Lock()
+			missing[r.Method+" "+r.URL.RawQuery] = true
+			mu.Unlock()
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer checking.Close()
+	var operations atomic.Int32
+	var clients atomic.Int32
+	storage := srv.Storage(t, func(s *s3.S3Storage) {
+		s.Endpoint = checking.URL
+		s.APIOptions = []func(*middleware.Stack) error{
+			smithyhttp.AddHeaderValue("X-Corp-Signature", "signed"),
+			func(stack *middleware.Stack) error {
+				return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("CountOperations",
+					func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+						operations.Add(1)
+						return next.HandleInitialize(ctx, in)
+					}), middleware.After)
+			},
+		}
+		s.ClientOptions = []func(*awss3.Options){func(*awss3.Options) { clients.Add(1) }}
+	})
+
+	ctx := context.Background()
+	if err := storage.Store(ctx, "certificates/key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := storage.Load(ctx, "certificates/key"); err != nil || string(value) != "value" {
+		t.Errorf("load = %q, %v", value, err)
+	}
+	if len(missing) > 0 {
+		t.Errorf("requests without the custom header: %v", missing)
+	}
+	if operations.Load() < 2 {
+		t.Errorf("middleware saw %d operations", operations.Load())
+	}
+	if clients.Load() == 0 {
+		t.Error("client options were not applied")
+	}
+}
+
+func TestStorageSSECustomerKey(t *testing.T) {
+	srv := s3test.NewServer(t)
+	target, _ := url.Parse(srv.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	var mu sync.Mutex
+	missing := map[string]bool{}
+	checking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete && !r.URL.Query().Has("list-type") && !r.URL.Query().Has("versioning") &&
+			(r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != "AES256" ||
+				r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key") != "MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI=" ||
+				r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5") != "dnF5x6K/8ZZRzpfSlMMM+w==") {
+			mu.Lock()
+			missing[r.Method] = true
+			mu.Unlock()
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer checking.Close()
+	storage := srv.Storage(t, func(s *s3.S3Storage) {
+		s.Endpoint = checking.URL
+		s.SSECustomerKey = "12345678901234567890123456789012"
+	})
+
+	ctx := context.Background()
+	if err := storage.Store(ctx, "key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := storage.Load(ctx, "key"); err != nil || string(value) != "value" {
+		t.Errorf("load = %q, %v", value, err)
+	}
+	if _, err := storage.Stat(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.StoreStream(ctx, "stream", strings.NewReader("streamed"), 8); err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) > 0 {
+		t.Errorf("requests without SSE-C headers: %v", missing)
+	}
+
+	for _, key := range []string{"short", "bm90IDMyIGJ5dGVz"} {
+		s := &s3.S3Storage{Bucket: srv.Bucket, Endpoint: srv.URL, Region: "us-east-1", SSECustomerKey: key}
+		ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+		if err := s.Provision(ctx); err == nil {
+			t.Errorf("%q: expected an invalid key error", key)
+		}
+		cancel()
+	}
+}
+
+func TestStorageReadOnly(t *testing.T) {
+	srv := s3test.NewServer(t)
+	writer := srv.Storage(t)
+	reader := srv.Storage(t, func(s *s3.S3Storage) { s.ReadOnly = true })
+	ctx := context.Background()
+	key := "certificates/acme/example.com/example.com.crt"
+	if err := writer.Store(ctx, key, []byte("certificate")); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := reader.Load(ctx, key); err != nil || string(value) != "certificate" {
+		t.Errorf("load = %q, %v", value, err)
+	}
+	if keys, err := reader.List(ctx, "", true); err != nil || len(keys) != 1 {
+		t.Errorf("list = %v, %v", keys, err)
+	}
+	if _, err := reader.Stat(ctx, key); err != nil {
+		t.Errorf("stat failed: %v", err)
+	}
+
+	for op, err := range map[string]error{
+		"store":  reader.Store(ctx, key, []byte("overwritten")),
+		"delete": reader.Delete(ctx, key),
+		"lock":   reader.Lock(ctx, "issue_cert_example.com"),
+		"stream": reader.StoreStream(ctx, "stream", strings.NewReader("value"), 5),
+	} {
+		if !errors.Is(err, s3.ErrReadOnly) {
+			t.Errorf("%s: got %v, want ErrReadOnly", op, err)
+		}
+	}
+	if err := reader.Unlock(ctx, "issue_cert_example.com"); err != nil {
+		t.Errorf("unlock: %v", err)
+	}
+	if value, err := writer.Load(ctx, key); err != nil || string(value) != "certificate" {
+		t.Errorf("value changed by read-only instance: %q, %v", value, err)
+	}
+	if locks, _ := writer.ListLocks(ctx); len(locks) != 0 {
+		t.Errorf("read-only instance created locks %+v", locks)
+	}
+}
+
+func TestStorageSharedConfigProfile(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_PROFILE", "")
+	config := filepath.Join(t.TempDir(), "config")
+	profiles := "[default]\naws_access_key_id = default\naws_secret_access_key = default\n\n" +
+		"[profile caddy]\naws_access_key_id = caddy-key\naws_secret_access_key = caddy-secret\n"
+	if err := os.WriteFile(config, []byte(profiles), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	storage := s3test.NewStorage(t, func(s *s3.S3Storage) {
+		s.AccessKeyID, s.SecretAccessKey = "", ""
+		s.Profile, s.SharedConfigFiles = "caddy", []string{config}
+	})
+	creds, err := storage.Client.(*awss3.Client).Options().Credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "caddy-key" || creds.SecretAccessKey != "caddy-secret" {
+		t.Errorf("credentials = %s, want those of profile caddy", creds.AccessKeyID)
+	}
+	if err := storage.Store(context.Background(), "key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageCredentialSource(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_PROFILE", "")
+	ctx := context.Background()
+	srv := s3test.NewServer(t)
+	storage := srv.Storage(t, func(s *s3.S3Storage) {
+		s.AccessKeyID, s.SecretAccessKey = "", ""
+		s.CredentialProcess = `echo '{"Version": 1, "AccessKeyId": "process-key", "SecretAccessKey": "process-secret"}'`
+	})
+	creds, err := storage.Client.(*awss3.Client).Options().Credentials.Retrieve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "process-key" || creds.SecretAccessKey != "process-secret" {
+		t.Errorf("credentials = %s, want those of credential_process", creds.AccessKeyID)
+	}
+	if err := storage.Store(ctx, "key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	config := filepath.Join(t.TempDir(), "config")
+	profiles := "[profile sso]\nsso_start_url = https://example.awsapps.com/start\nsso_region = eu-west-1\n" +
+		"sso_account_id = 123456789012\nsso_role_name = caddy\n\n[profile static]\naws_access_key_id = key\n" +
+		"aws_secret_access_key = secret\n"
+	if err := os.WriteFile(config, []byte(profiles), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	srv.Storage(t, func(s *s3.S3Storage) {
+		s.AccessKeyID, s.SecretAccessKey = "", ""
+		s.CredentialSource, s.Profile, s.SharedConfigFiles = "sso", "sso", []string{config}
+		s.SSOCacheDir = t.TempDir()
+	})
+
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	for name, configure := range map[string]func(*s3.S3Storage){
+		"sso without sso profile":     func(s *s3.S3Storage) { s.CredentialSource, s.Profile = "sso", "static" },
+		"process without command":     func(s *s3.S3Storage) { s.CredentialSource, s.Profile = "process", "static" },
+		"sso with static keys":        func(s *s3.S3Storage) { s.CredentialSource, s.Profile, s.AccessKeyID = "sso", "sso", "key" },
+		"static without keys":         func(s *s3.S3Storage) { s.CredentialSource = "static" },
+		"sso_cache_dir without sso":   func(s *s3.S3Storage) { s.SSOCacheDir = t.TempDir() },
+		"unknown credential source":   func(s *s3.S3Storage) { s.CredentialSource = "instance" },
+		"credential_process with sso": func(s *s3.S3Storage) { s.CredentialSource, s.CredentialProcess = "sso", "true" },
+	} {
+		invalid := &s3.S3Storage{Bucket: srv.Bucket, Endpoint: srv.URL, Region: "us-east-1", SharedConfigFiles: []string{config}}
+		configure(invalid)
+		if err := invalid.Provision(caddyCtx); err == nil {
+			t.Errorf("%s: Provision succeeded", name)
+		}
+	}
+}
+
+func TestStorageInstanceIsolation(t *testing.T) {
+	srv := s3test.NewServer(t)
+	a := srv.Storage(t, func(s *s3.S3Storage) {
+		s.AccessKeyID, s.SecretAccessKey, s.SessionToken = "tenant-a", "secret-a", "token-a"
+	})
+	b := srv.Storage(t, func(s *s3.S3Storage) { s.AccessKeyID, s.SecretAccessKey = "tenant-b", "secret-b" })
+	optsA, optsB := a.Client.(*awss3.Client).Options(), b.Client.(*awss3.Client).Options()
+	if optsA.HTTPClient == optsB.HTTPClient {
+		t.Errorf("instances share an HTTP client without shared_transport")
+	}
+	credsA, err := optsA.Credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	credsB, err := optsB.Credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credsA.AccessKeyID != "tenant-a" || credsB.AccessKeyID != "tenant-b" {
+		t.Errorf("credentials leaked between instances: %s, %s", credsA.AccessKeyID, credsB.AccessKeyID)
+	}
+	if credsA.SessionToken != "token-a" || credsB.SessionToken != "" {
+		t.Errorf("session tokens = %q, %q", credsA.SessionToken, credsB.SessionToken)
+	}
+
+	shared := func(s *s3.S3Storage) { s.SharedTransport = "pool" }
+	c, d := srv.Storage(t, shared), srv.Storage(t, shared)
+	if c.Client.(*awss3.Client).Options().HTTPClient != d.Client.(*awss3.Client).Options().HTTPClient {
+		t.Errorf("instances naming the same shared_transport use different HTTP clients")
+	}
+	if err := d.Store(context.Background(), "key", []byte("value")); err != nil {
+		t.Errorf("storing through shared transport failed: %v", err)
+	}
+	tuned := &s3.S3Storage{Bucket: srv.Bucket, Endpoint: srv.URL, Region: "us-east-1", SharedTransport: "pool", MaxIdleConnsPerHost: 50}
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := tuned.Provision(caddyCtx); err == nil {
+		t.Error("expected a shared transport in use with other tuning to be rejected")
+	}
+}
+
+func TestStorageProviderGCS(t *testing.T) {
+	srv := s3test.NewServer(t)
+	target, _ := url.Parse(srv.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if resp.StatusCode == http.StatusNotFound { // No S3 error codes, as with GCS
+			body := `<Error><Code>ObjectNotFound</Code></Error>`
+			resp.Body = io.NopCloser(strings.NewReader(body))
+			resp.ContentLength = int64(len(body))
+			resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		}
+		return nil
+	}
+	gcs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Query().Has("delete") {
+			w.WriteHeader(http.StatusNotImplemented) // No multi-object delete
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer gcs.Close()
+
+	storage := srv.Storage(t, func(s *s3.S3Storage) {
+		s.Provider = "gcs"
+		s.Endpoint = gcs.URL
+	})
+	ctx := context.Background()
+	if _, err := storage.Load(ctx, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist for missing key, got %v", err)
+	}
+	for _, key := range []string{"certificates/a/a.crt", "certificates/a/a.key"} {
+		if err := storage.Store(ctx, key, []byte("value")); err != nil {
+			t.Fatalf("storing failed: %v", err)
+		}
+	}
+	if n, err := storage.DeleteAll(ctx, "certificates"); err != nil || n != 2 {
+		t.Errorf("DeleteAll = %d, %v", n, err)
+	}
+	if storage.Capabilities().SupportsConditionalPut {
+		t.Errorf("expected conditional puts to be reported unsupported")
+	}
+
+	plain := srv.Storage(t, func(s *s3.S3Storage) { s.Endpoint = gcs.URL })
+	if _, err := plain.Load(ctx, "missing"); errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("unexpected fs.ErrNotExist for plain 404 without provider gcs")
+	}
+}
+
+func TestStorageProviderB2(t *testing.T) {
+	srv := s3test.NewServer(t)
+	target, _ := url.Parse(srv.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if resp.StatusCode == http.StatusNotFound && resp.Request.Method == http.MethodGet { // A bare 404, as with B2
+			resp.Body = io.NopCloser(strings.NewReader(""))
+			resp.ContentLength = 0
+			resp.Header.Set("Content-Length", "0")
+		}
+		return nil
+	}
+	var throttled atomic.Bool
+	var mu sync.Mutex
+	var rejected []string
+	b2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name := range r.Header {
+			if name := strings.ToLower(name); name == "x-amz-storage-class" || name == "x-amz-tagging" || strings.HasPrefix(name, "x-amz-checksum-") {
+				mu.Lock()
+				rejected = append(rejected, name)
+				mu.Unlock()
+				http.Error(w, "unsupported header", http.StatusBadRequest)
+				return
+			}
+		}
+		if r.Method == http.MethodPut && throttled.CompareAndSwap(false, true) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer b2.Close()
+
+	storage := srv.Storage(t, func(s *s3.S3Storage) {
+		s.Provider = "b2"
+		s.Endpoint = b2.URL
+		s.StorageClass = "STANDARD_IA"
+		s.ObjectTags = map[string]string{"environment": "test"}
+	})
+	ctx := context.Background()
+	if err := storage.Store(ctx, "certificates/a/a.crt", []byte("value")); err != nil {
+		t.Fatalf("storing failed: %v", err)
+	}
+	if !throttled.Load() {
+		t.Errorf("expected the first PUT to be throttled")
+	}
+	if len(rejected) > 0 {
+		t.Errorf("unsupported headers sent: %v", rejected)
+	}
+	if value, err := storage.Load(ctx, "certificates/a/a.crt"); err != nil || string(value) != "value" {
+		t.Errorf("Load = %q, %v", value, err)
+	}
+	if _, err := storage.Load(ctx, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist for missing key, got %v", err)
+	}
+	if storage.MaxConcurrentRequests != 10 || storage.RetryMode != "adaptive" {
+		t.Errorf("defaults not applied: max_concurrent_requests %d, retry_mode %q", storage.MaxConcurrentRequests, storage.RetryMode)
+	}
+
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	for name, s := range map[string]*s3.S3Storage{
+		"no region": {Bucket: srv.Bucket, Provider: "b2"},
+		"manifest":  {Bucket: srv.Bucket, Provider: "b2", Region: "us-west-004", Manifest: true},
+	} {
+		if err := s.Provision(caddyCtx); err == nil {
+			t.Errorf("%s: expected provisioning to fail", name)
+		}
+	}
+	if s := (&s3.S3Storage{Bucket: srv.Bucket, Provider: "b2", Region: "us-west-004"}); s.Provision(caddyCtx) == nil &&
+		s.Endpoint != "https://s3.us-west-004.backblazeb2.com" {
+		t.Errorf("endpoint = %s", s.Endpoint)
+	}
+}
+
+func TestStorageLegacyLocks(t *testing.T) {
+	srv := s3test.NewServer(t)
+	storage := srv.Storage(t, func(s *s3.S3Storage) { s.LegacyLocks = true })
+	plain := srv.Storage(t)
+	ctx := context.Background()
+
+	// An instance of an earlier version holds the lock at the legacy location.
+	if err := plain.Store(ctx, "issue_cert_example.com.lock", []byte("2024-01-01T00:00:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	if err := storage.Lock(waitCtx, "issue_cert_example.com"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected lock held at the legacy location to be honored, got %v", err)
+	}
+
+	// Without the shim, which is opt-in, keys ending in .lock are ordinary data.
+	keys, err := plain.List(ctx, "", true)
+	if err != nil || !slices.Equal(keys, []string{"issue_cert_example.com.lock"}) {
+		t.Errorf("unexpected listing %v, %v", keys, err)
+	}
+	if err := plain.Lock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatalf("locking failed: %v", err)
+	}
+	locks, err := plain.ListLocks(ctx)
+	if err != nil || len(locks) != 1 || locks[0].S3Key != "certmagic/locks/issue_cert_example.com" {
+		t.Errorf("unexpected locks %+v, %v", locks, err)
+	}
+	if err := plain.Unlock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// With the shim, a lock is written to both locations and listed once.
+	if err := storage.Lock(ctx, "issue_cert_example.org"); err != nil {
+		t.Fatal(err)
+	}
+	locks, err = storage.ListLocks(ctx)
+	if err != nil || len(locks) != 1 || locks[0].Key != "issue_cert_example.org" {
+		t.Errorf("unexpected locks %+v, %v", locks, err)
+	}
+	if err := storage.Unlock(ctx, "issue_cert_example.org"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageFallbackRegions(t *testing.T) {
+	srv := s3test.NewServer(t)
+	target, _ := url.Parse(srv.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	regional := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Authorization"), "/us-east-1/s3/") { // Primary region is down
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, `<Error><Code>InternalError</Code></Error>`)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer regional.Close()
+
+	ctx := context.Background()
+	key := "certificates/example.com/example.com.crt"
+	if err := srv.Storage(t).Store(ctx, key, []byte("certificate")); err != nil {
+		t.Fatal(err)
+	}
+	storage := srv.Storage(t, func(s *s3.S3Storage) {
+		s.Endpoint = regional.URL
+		s.FallbackRegions = []string{"us-west-2"}
+		s.FallbackThreshold = 2
+		s.RetryErrorCodes = map[string]string{"InternalError": "fatal", "InternalServerError": "fatal"} // HEAD responses have no body
+	})
+
+	if _, err := storage.Load(ctx, key); !errors.Is(err, s3.ErrTransient) {
+		t.Fatalf("expected primary failure before the threshold, got %v", err)
+	}
+	value, err := storage.Load(ctx, key)
+	if err != nil || string(value) != "certificate" {
+		t.Fatalf("expected read from fallback region, got %q, %v", value, err)
+	}
+	if exists, err := storage.ExistsErr(ctx, key); err != nil || !exists {
+		t.Errorf("expected key to exist in fallback region, got %v, %v", exists, err)
+	}
+	if _, err := storage.Load(ctx, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist from fallback region, got %v", err)
+	}
+}
+
+func TestStorageOperationTimeouts(t *testing.T) {
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select { // A hung endpoint never answers
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer hung.Close()
+
+	storage := s3test.NewStorage(t, func(s *s3.S3Storage) {
+		s.Endpoint = hung.URL
+		s.MaxRetries = 1
+		s.OperationTimeout = caddy.Duration(time.Minute)
+		s.ReadTimeout = caddy.Duration(100 * time.Millisecond)
+		s.ListTimeout = caddy.Duration(100 * time.Millisecond)
+	})
+	ctx := context.Background()
+	for name, op := range map[string]func() error{
+		"load": func() error { _, err := storage.Load(ctx, "key"); return err },
+		"list": func() error { _, err := storage.List(ctx, "", true); return err },
+	} {
+		start := time.Now()
+		if err := op(); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: expected deadline to be exceeded, got %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s took %v despite its timeout", name, elapsed)
+		}
+	}
+}
+
+func TestStorageSessionTicketKeys(t *testing.T) {
+	srv := s3test.NewServer(t)
+	ctx := context.Background()
+	if _, _, err := srv.Storage(t).SessionTicketKeys(ctx); err == nil {
+		t.Errorf("session ticket keys stored without encryption")
+	}
+
+	configure := func(s *s3.S3Storage) {
+		s.EncryptionKey = "12345678123456781234567812345678"
+		s.STEKRotationInterval = caddy.Duration(time.Hour)
+		s.STEKMaxKeys = 2
+	}
+	a, b := srv.Storage(t, configure), srv.Storage(t, configure)
+	keysA, next, err := a.SessionTicketKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keysA) != 1 || time.Until(next) <= 59*time.Minute {
+		t.Errorf("expected one fresh key rotated in an hour, got %d keys rotated at %v", len(keysA), next)
+	}
+	keysB, _, err := b.SessionTicketKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(keysA, keysB) {
+		t.Errorf("instances use different session ticket keys")
+	}
+	raw, err := srv.Storage(t).Load(ctx, "stek/keys.json") // Cleartext storage returns the raw object
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("keys")) {
+		t.Errorf("session ticket keys stored in the clear")
+	}
+}
+
+func TestStorageVersions(t *testing.T) {
+	srv := s3test.NewServer(t)
+	storage := srv.Storage(t, func(s *s3.S3Storage) { s.EncryptionKey = "12345678123456781234567812345678" })
+	ctx := context.Background()
+	if _, err := storage.Client.(*awss3.Client).PutBucketVersioning(ctx, &awss3.PutBucketVersioningInput{
+		Bucket:                  aws.String(srv.Bucket),
+		VersioningConfiguration: &types.VersioningConfiguration{Status: types.BucketVersioningStatusEnabled},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	const key = "acme/account.key"
+	for _, value := range []string{"original", "overwritten"} {
+		if err := storage.Store(ctx, key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond) // Distinct modification times
+	}
+	if err := storage.Store(ctx, key+".other", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := storage.ListVersions(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || !versions[0].DeleteMarker {
+		t.Fatalf("expected a delete marker and two versions, got %+v", versions)
+	}
+	if err := storage.Restore(ctx, key, versions[2].VersionID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := storage.Load(ctx, key); err != nil || string(got) != "original" {
+		t.Errorf("restored value = %q, %v", got, err)
+	}
+	if err := storage.Restore(ctx, key, "no-such-version"); err == nil {
+		t.Errorf("restoring an unknown version succeeded")
+	}
+}
+
+func TestStorageReapExpiredLocks(t *testing.T) {
+	srv := s3test.NewServer(t)
+	target, _ := url.Parse(srv.URL)
+	const stale = "Mon, 02 Jan 2006 15:04:05 GMT"
+	staleEntry := regexp.MustCompile(`(<Key>[^<]*stale[^<]*</Key>\s*<LastModified>)[^<]*`)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ModifyResponse = func(resp *http.Response) error { // Ages the locks of "stale" keys
+		if strings.Contains(resp.Request.URL.Path, "stale") {
+			resp.Header.Set("Last-Modified", stale)
+		}
+		if resp.Request.Method != http.MethodGet || resp.Request.URL.Query().Get("list-type") == "" {
+			return nil
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		body = staleEntry.ReplaceAll(body, []byte("${1}2006-01-02T15:04:05Z"))
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		return nil
+	}
+	aging := httptest.NewServer(proxy)
+	t.Cleanup(aging.Close) // After the storage releases its locks
+
+	storage := srv.Storage(t, func(s *s3.S3Storage) { s.Endpoint = aging.URL })
+	ctx := context.Background()
+	for _, key := range []string{"issue_cert_stale.example", "issue_cert_fresh.example"} {
+		if err := storage.Lock(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reaped, err := storage.ReapExpiredLocks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reaped != 1 {
+		t.Errorf("reaped %d locks, expected 1", reaped)
+	}
+	locks, err := storage.ListLocks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 || locks[0].Key != "issue_cert_fresh.example" {
+		t.Errorf("expected only the fresh lock to remain, got %+v", locks)
+	}
+}
+
+func TestStorageCoalescesConcurrentReads(t *testing.T) {
+	srv := s3test.NewServer(t)
+	target, _ := url.Parse(srv.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond) // Keeps the first read in flight while the others start
+		proxy.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+
+	ctx := context.Background()
+	key := "certificates/example.com/example.com.crt"
+	if err := srv.Storage(t).Store(ctx, key, []byte("certificate")); err != nil {
+		t.Fatal(err)
+	}
+	storage := srv.Storage(t, func(s *s3.S3Storage) { s.Endpoint = slow.URL })
+
+	const readers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*readers)
+	for range readers {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			value, err := storage.Load(ctx, key)
+			if err == nil && string(value) != "certificate" {
+				err = fmt.Errorf("loaded %q", value)
+			}
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := storage.Stat(ctx, key)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	stats := storage.RequestStats()
+	if n := stats.Requests["GetObject"]; n != 1 {
+		t.Errorf("%d concurrent loads sent %d GetObject requests", readers, n)
+	}
+	if n := stats.Requests["HeadObject"]; n != 1 {
+		t.Errorf("%d concurrent stats sent %d HeadObject requests", readers, n)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := storage.Load(canceled, key); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a canceled load, got %v", err)
+	}
+}
+
+func TestStorageChecksum(t *testing.T) {
+	srv := s3test.NewServer(t)
+	target, _ := url.Parse(srv.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	var checksummed atomic.Bool
+	recording := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && r.Header.Get("X-Amz-Checksum-Sha256") != "" {
+			checksummed.Store(true)
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	t.Cleanup(recording.Close)
+
+	storage := srv.Storage(t, func(s *s3.S3Storage) {
+		s.Endpoint = recording.URL
+		s.ChecksumAlgorithm = "sha256"
+		s.EncryptionKey = "12345678901234567890123456789012"
+	})
+	ctx := context.Background()
+	if err := storage.Store(ctx, "key", []byte("private key")); err != nil {
+		t.Fatal(err)
+	}
+	if !checksummed.Load() {
+		t.Error("PutObject sent without SHA-256 checksum")
+	}
+	if value, err := storage.Load(ctx, "key"); err != nil || string(value) != "private key" {
+		t.Fatalf("Load = %q, %v", value, err)
+	}
+
+	// A backend corrupting the body while keeping the metadata
+	head, err := storage.Client.HeadObject(ctx, &awss3.HeadObjectInput{Bucket: aws.String(srv.Bucket), Key: aws.String("certmagic/key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Metadata["certmagic-sha256"] == "" {
+		t.Fatalf("metadata = %v, want certmagic-sha256", head.Metadata)
+	}
+	corrupted := []byte("corrupted body")
+	_, err = storage.Client.PutObject(ctx, &awss3.PutObjectInput{
+		Bucket:   aws.String(srv.Bucket),
+		Key:      aws.String("certmagic/key"),
+		Body:     bytes.NewReader(corrupted),
+		Metadata: head.Metadata,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Load(ctx, "key"); !errors.Is(err, s3.ErrIntegrity) {
+		t.Errorf("Load of corrupted object = %v, want ErrIntegrity", err)
+	}
+
+	invalid := &s3.S3Storage{Bucket: srv.Bucket, Endpoint: srv.URL, Region: "us-east-1", ChecksumAlgorithm: "md5"}
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	if err := invalid.Provision(caddyCtx); err == nil {
+		t.Error("expected unsupported checksum_algorithm to be rejected")
+	}
+}
+
+func TestStorageObjectTagsAndMetadata(t *testing.T) {
+	srv := s3test.NewServer(t)
+	target, _ := url.Parse(srv.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	var mu sync.Mutex
+	var taggings []string
+	recording := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			mu.Lock()
+			taggings = append(taggings, r.Header.Get("X-Amz-Tagging"))
+			mu.Unlock()
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer recording.Close()
+
+	t.Setenv("S3TEST_TENANT", "acme")
+	storage := srv.Storage(t, func(s *s3.S3Storage) {
+		s.Endpoint = recording.URL
+		s.ObjectTags = map[string]string{"tenant": "{env.S3TEST_TENANT}", "env": "test"}
+		s.ObjectMetadata = map[string]string{"owner": "{env.S3TEST_TENANT}"}
+	})
+	ctx := context.Background()
+	for _, value := range [][]byte{[]byte("value"), {}} {
+		if err := storage.Store(ctx, "key", value); err != nil {
+			t.Fatal(err)
+		}
+		head, err := storage.Client.HeadObject(ctx, &awss3.HeadObjectInput{Bucket: aws.String(srv.Bucket), Key: aws.String("certmagic/key")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if head.Metadata["owner"] != "acme" {
+			t.Errorf("metadata = %v", head.Metadata)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(taggings) == 0 {
+		t.Fatal("no PUT requests recorded")
+	}
+	for _, tagging := range taggings {
+		if tagging != "env=test&tenant=acme" {
+			t.Errorf("x-amz-tagging = %q", tagging)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.uber.org/zap"
+)
+
+var errStreamJournal = errors.New("StoreStream cannot be used with journal_dir")
+
+// StoreStream stores the content of r at the given key without holding it in memory as a whole.
+// Large values are uploaded in parts. size is the length of the content, or -1 if unknown.
+//
+// Unlike Store, values are not replicated, OCSP delta encoding does not apply, and a copy of the
+// key in the local fallback cache is dropped rather than updated. With encryption enabled, values
+// are written in a chunked format which versions without streaming support cannot read.
+// operation_timeout does not apply, since an upload may legitimately take long; use ctx instead.
+// With journal_dir, StoreStream fails: a stream cannot be journaled, and uploading it directly
+// would let a pending journaled write of the key overwrite it later.
+func (s *S3Storage) StoreStream(ctx context.Context, key string, r io.Reader, size int64) error {
+	err := s.storeStream(ctx, key, r, size)
+	s.auditOp(ctx, "store", key, size, err)
+	return err
+}
+
+func (s *S3Storage) storeStream(ctx context.Context, key string, r io.Reader, size int64) error {
+	if err := s.checkWritable("store", key); err != nil {
+		return err
+	}
+	if s.journal != nil {
+		return fmt.Errorf("storing stream %s: %w", key, errStreamJournal)
+	}
+	s.noteWrite(key)
+	s3Key := s.s3ObjectKey(key)
+	s.opLogger(ctx, key).Debug("storing stream", zap.String("key", key), zap.String("s3_key", s3Key), zap.Int64("size", size))
+
+	body, _, err := s.iowrap.StreamReader(r, size)
+	if err != nil {
+		return fmt.Errorf("preparing data for storing %s: %w", key, err)
+	}
+	out, err := manager.NewUploader(s.Client).Upload(ctx, &awss3.PutObjectInput{
+		Bucket:            aws.String(s.Bucket),
+		Key:               aws.String(s3Key),
+		Body:              body,
+		StorageClass:      types.StorageClass(s.StorageClass),
+		ContentType:       s.contentType(),
+		Tagging:           s.objectTagging(),
+		Metadata:          s.objectMetadata(nil),
+		ChecksumAlgorithm: s.checksumAlgorithm(),
+	})
+	if err != nil {
+		s.recordError("store", key, err)
+		return fmt.Errorf("storing %s (s3://%s/%s): %w", key, s.Bucket, s3Key, s3Error(err))
+	}
+	s.manifestStore(ctx, key, max(size, 0), out.ETag)
+	s.mirrorDelete(key)
+	if s.cache != nil {
+		s.cache.remove(key)
+	}
+	if s.existsCache != nil {
+		s.existsCache.stored(key)
+	}
+	return nil
+}
+
+// LoadStream returns a reader for the value at the given key, which is downloaded in ranged parts
+// as it is read. The caller must close it. Reading returns an error if the value cannot be decrypted.
+// Values written by Store are single encrypted boxes and are buffered in memory for decryption.
+func (s *S3Storage) LoadStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	s3Key := s.s3ObjectKey(key)
+	s.opLogger(ctx, key).Debug("loading stream", zap.String("key", key), zap.String("s3_key", s3Key))
+
+	headCtx, cancel := s.readContext(ctx)
+	head, err := s.Client.HeadObject(headCtx, &awss3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s3Key),
+	})
+	cancel()
+	if err != nil {
+		if s.isNotFound(err) {
+			return nil, fs.ErrNotExist
+		}
+		s.recordError("load", key, err)
+		return nil, fmt.Errorf("loading %s (s3://%s/%s): %w", key, s.Bucket, s3Key, s3Error(err))
+	}
+	switch {
+	case isTombstone(head.Metadata):
+		return nil, fs.ErrNotExist
+	case isEmptySentinel(head.Metadata):
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	ctx, cancel = context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	go func() {
+		// A single worker writes the parts in order, so they can be piped to the reader.
+		downloader := manager.NewDownloader(s.Client, func(d *manager.Downloader) { d.Concurrency = 1 })
+		_, err := downloader.Download(ctx, &sequentialWriterAt{w: pw}, &awss3.GetObjectInput{
+			Bucket: aws.String(s.Bucket),
+			Key:    aws.String(s3Key),
+		})
+		if err != nil {
+			s.recordError("load", key, err)
+			err = fmt.Errorf("loading %s (s3://%s/%s): %w", key, s.Bucket, s3Key, s3Error(err))
+		}
+		pw.CloseWithError(err) // A nil error ends the stream with io.EOF
+	}()
+	return &streamBody{Reader: s.iowrap.WrapReader(pr), pipe: pr, cancel: cancel}, nil
+}
+
+// sequentialWriterAt adapts a writer to io.WriterAt for writes that arrive in order.
+type sequentialWriterAt struct {
+	w   io.Writer
+	off int64
+}
+
+func (s *sequentialWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if off != s.off {
+		return 0, fmt.Errorf("out of order write at offset %d, expected %d", off, s.off)
+	}
+	n, err := s.w.Write(p)
+	s.off += int64(n)
+	return n, err
+}
+
+// streamBody is the reader returned by LoadStream; closing it aborts the download.
+type streamBody struct {
+	io.Reader
+	pipe   *io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (b *streamBody) Close() error {
+	b.cancel()
+	return b.pipe.Close()
+}
@@ -0,0 +1,123 @@
+package s3
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+// Correlation IDs tie together the storage operations of one certificate issuance
+// (lock → load → store → unlock), also across nodes when the caller propagates the ID.
+// The ID is taken from the context if present (see WithCorrelationID); otherwise Lock
+// generates one, which then applies to all operations on keys of the locked name until Unlock.
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a context carrying the given correlation ID for storage operations.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationIDFromContext returns the correlation ID carried by ctx, if any.
+func CorrelationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// lockSubjectPrefixes are the CertMagic lock name prefixes followed by the domain name.
+var lockSubjectPrefixes = []string{"issue_cert_", "renew_cert_"}
+
+// correlations tracks the IDs of held locks, by lock key and by the (storage-safe) subject name.
+type correlations struct {
+	mu        sync.Mutex
+	byLock    map[string]string
+	bySubject map[string]string
+}
+
+func newCorrelations() *correlations {
+	return &correlations{byLock: make(map[string]string), bySubject: make(map[string]string)}
+}
+
+// begin registers the ID for a lock key, generating one if id is empty. The ID of another attempt
+// of this process, e.g. the one holding the lock, is kept; begin then reports false, and the
+// attempt must neither end that trace nor begin its own until it acquired the lock.
+func (c *correlations) begin(lockKey, id string) (string, bool) {
+	if id == "" {
+		var b [8]byte
+		_, _ = rand.Read(b[:])
+		id = hex.EncodeToString(b[:])
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.byLock[lockKey]; ok {
+		return id, false
+	}
+	c.byLock[lockKey] = id
+	if subject := lockSubject(lockKey); subject != "" {
+		c.bySubject[subject] = id
+	}
+	return id, true
+}
+
+// end forgets the ID of a released lock.
+func (c *correlations) end(lockKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.byLock, lockKey)
+	if subject := lockSubject(lockKey); subject != "" {
+		delete(c.bySubject, subject)
+	}
+}
+
+// lookup returns the ID of a held lock matching key, either the lock key itself or a storage
+// key containing the locked name as path segment (e.g. certificates/<issuer>/<name>/<name>.crt).
+func (c *correlations) lookup(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id, ok := c.byLock[key]; ok {
+		return id
+	}
+	if len(c.bySubject) == 0 {
+		return ""
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if id, ok := c.bySubject[segment]; ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// lockSubject extracts the storage-safe domain name from a CertMagic lock key.
+func lockSubject(lockKey string) string {
+	for _, prefix := range lockSubjectPrefixes {
+		if name, ok := strings.CutPrefix(lockKey, prefix); ok && name != "" {
+			return certmagic.StorageKeys.Safe(name)
+		}
+	}
+	return ""
+}
+
+// correlationID returns the correlation ID of an operation on key.
+func (s *S3Storage) correlationID(ctx context.Context, key string) string {
+	if id := CorrelationIDFromContext(ctx); id != "" {
+		return id
+	}
+	if s.traces == nil {
+		return ""
+	}
+	return s.traces.lookup(key)
+}
+
+// opLogger returns the logger for an operation on key, annotated with its correlation ID.
+func (s *S3Storage) opLogger(ctx context.Context, key string) *zap.Logger {
+	if id := s.correlationID(ctx, key); id != "" {
+		return s.logger.With(zap.String("correlation_id", id))
+	}
+	return s.logger
+}
@@ -0,0 +1,47 @@
+package s3
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCorrelationLookup(t *testing.T) {
+	c := newCorrelations()
+	id, began := c.begin("issue_cert_*.Example.com", "")
+	if id == "" || !began {
+		t.Fatalf("expected a generated correlation ID to be registered, got %q, %v", id, began)
+	}
+	if other, began := c.begin("issue_cert_*.Example.com", ""); other == id || began {
+		t.Errorf("a second attempt must not replace the holder's ID, got %q, %v", other, began)
+	}
+
+	if got := c.lookup("issue_cert_*.Example.com"); got != id {
+		t.Errorf("expected lock key to resolve to %s, got %q", id, got)
+	}
+	certKey := "certificates/acme-v02.api.letsencrypt.org-directory/wildcard_.example.com/wildcard_.example.com.crt"
+	if got := c.lookup(certKey); got != id {
+		t.Errorf("expected certificate key to resolve to %s, got %q", id, got)
+	}
+	if got := c.lookup("certificates/acme/other.com/other.com.crt"); got != "" {
+		t.Errorf("expected no ID for unrelated key, got %q", got)
+	}
+
+	c.end("issue_cert_*.Example.com")
+	if got := c.lookup(certKey); got != "" {
+		t.Errorf("expected ID to be forgotten after unlock, got %q", got)
+	}
+
+	if got, _ := c.begin("issue_cert_example.com", "from-context"); got != "from-context" {
+		t.Errorf("expected given ID to be kept, got %q", got)
+	}
+}
+
+func TestCorrelationIDFromContext(t *testing.T) {
+	ctx := WithCorrelationID(context.Background(), "abc")
+	if got := CorrelationIDFromContext(ctx); got != "abc" {
+		t.Errorf("expected abc, got %q", got)
+	}
+	if got := CorrelationIDFromContext(context.Background()); got != "" {
+		t.Errorf("expected empty ID, got %q", got)
+	}
+}
@@ -0,0 +1,141 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// Every storage instance builds its own AWS config, credential cache and HTTP client, so
+// instances of different modules or tenants share no mutable state. With shared_transport,
+// instances naming the same pool deliberately share one HTTP client and its connection pool.
+// The idle connections of an instance's own client are closed by Cleanup.
+//
+// connect_timeout, response_header_timeout, idle_conn_timeout and max_idle_conns_per_host tune the
+// transport, e.g. to keep more connections to S3 open than the SDK's default of 10 per host during
+// renewal bursts. Instances sharing a transport must tune it alike.
+
+// transportPool holds the shared HTTP clients by name across config reloads.
+var transportPool = caddy.NewUsagePool()
+
+// transportTuning holds the transport options; zero values keep the SDK's defaults.
+type transportTuning struct {
+	connectTimeout        time.Duration
+	responseHeaderTimeout time.Duration
+	idleConnTimeout       time.Duration
+	maxIdleConnsPerHost   int
+}
+
+// transportTuning returns the transport options of the configuration.
+func (s *S3Storage) transportTuning() transportTuning {
+	return transportTuning{
+		connectTimeout:        time.Duration(s.ConnectTimeout),
+		responseHeaderTimeout: time.Duration(s.ResponseHeaderTimeout),
+		idleConnTimeout:       time.Duration(s.IdleConnTimeout),
+		maxIdleConnsPerHost:   s.MaxIdleConnsPerHost,
+	}
+}
+
+// sharedTransport is a pooled HTTP client, see newHTTPClient.
+type sharedTransport struct {
+	client *http.Client
+	tuning transportTuning
+}
+
+// Destruct closes the idle connections once the last instance using the pool is cleaned up.
+func (t sharedTransport) Destruct() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
+
+// newHTTPClient returns a plain *http.Client with the transport of the SDK's default config
+// (which includes e.g. AWS_CA_BUNDLE). Like the SDK's client, it doesn't follow redirects. The
+// SDK's *awshttp.BuildableClient can't be used, as it keeps copies of the transport to itself, so
+// its idle connections could not be closed.
+func newHTTPClient(tuning transportTuning) (*http.Client, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	bc, ok := cfg.HTTPClient.(*awshttp.BuildableClient)
+	if !ok {
+		bc = awshttp.NewBuildableClient()
+	}
+	transport := bc.GetTransport()
+	if tuning.connectTimeout > 0 {
+		dialer := bc.GetDialer()
+		dialer.Timeout = tuning.connectTimeout
+		transport.DialContext = dialer.DialContext
+	}
+	if tuning.responseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = tuning.responseHeaderTimeout
+	}
+	if tuning.idleConnTimeout > 0 {
+		transport.IdleConnTimeout = tuning.idleConnTimeout
+	}
+	if tuning.maxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = tuning.maxIdleConnsPerHost
+		transport.MaxIdleConns = max(transport.MaxIdleConns, tuning.maxIdleConnsPerHost)
+	}
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, nil
+}
+
+// httpClient returns the HTTP client for a new S3 client of this instance: its own client, or the
+// client of the shared_transport pool. Either is created or acquired once per instance.
+func (s *S3Storage) httpClient() (*http.Client, error) {
+	if s.SharedTransport == "" {
+		if s.ownHTTP == nil {
+			client, err := newHTTPClient(s.transportTuning())
+			if err != nil {
+				return nil, err
+			}
+			s.ownHTTP = client
+		}
+		return s.ownHTTP, nil
+	}
+	if s.sharedHTTP != nil {
+		return s.sharedHTTP, nil
+	}
+	tuning := s.transportTuning()
+	value, loaded, err := transportPool.LoadOrNew(s.SharedTransport, func() (caddy.Destructor, error) {
+		client, err := newHTTPClient(tuning)
+		if err != nil {
+			return nil, err
+		}
+		return sharedTransport{client: client, tuning: tuning}, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating shared transport %s: %w", s.SharedTransport, err)
+	}
+	if shared := value.(sharedTransport); shared.tuning != tuning {
+		_, _ = transportPool.Delete(s.SharedTransport)
+		return nil, fmt.Errorf("shared transport %s is in use with other connect_timeout, response_header_timeout, "+
+			"idle_conn_timeout or max_idle_conns_per_host", s.SharedTransport)
+	}
+	s.sharedHTTP = value.(sharedTransport).client
+	s.logger.Info("using shared HTTP transport", zap.String("pool", s.SharedTransport), zap.Bool("reused", loaded))
+	return s.sharedHTTP, nil
+}
+
+// releaseTransport closes the idle connections of this instance's own HTTP client, or gives up its
+// use of the shared one.
+func (s *S3Storage) releaseTransport() {
+	if s.ownHTTP != nil {
+		s.ownHTTP.CloseIdleConnections()
+	}
+	if s.sharedHTTP != nil {
+		_, _ = transportPool.Delete(s.SharedTransport)
+		s.sharedHTTP = nil
+	}
+}
@@ -0,0 +1,35 @@
+package s3
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPClientTuning(t *testing.T) {
+	client, err := newHTTPClient(transportTuning{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := client.Transport.(*http.Transport)
+
+	client, err = newHTTPClient(transportTuning{
+		connectTimeout:        time.Second,
+		responseHeaderTimeout: 2 * time.Second,
+		idleConnTimeout:       3 * time.Minute,
+		maxIdleConnsPerHost:   200,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tuned := client.Transport.(*http.Transport)
+	if tuned.ResponseHeaderTimeout != 2*time.Second || tuned.IdleConnTimeout != 3*time.Minute {
+		t.Errorf("timeouts = %v, %v", tuned.ResponseHeaderTimeout, tuned.IdleConnTimeout)
+	}
+	if tuned.MaxIdleConnsPerHost != 200 || tuned.MaxIdleConns < 200 {
+		t.Errorf("idle connections = %d per host, %d in total", tuned.MaxIdleConnsPerHost, tuned.MaxIdleConns)
+	}
+	if tuned.DialContext == nil || tuned.TLSClientConfig == nil || tuned.TLSHandshakeTimeout != defaults.TLSHandshakeTimeout {
+		t.Error("SDK defaults of the transport were lost")
+	}
+}
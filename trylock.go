@@ -0,0 +1,140 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// TryLock attempts to acquire the lock for the given CertMagic key once, without waiting, and
+// reports whether it was acquired, so callers can skip work another process is doing already.
+// A lock held by another process yields false and no error. Where the backend supports
+// conditional writes, the lock is created with If-None-Match and an expired lock is taken over
+// with If-Match on its ETag, so that two processes can't both acquire it.
+func (s *S3Storage) TryLock(ctx context.Context, key string) (bool, error) {
+	acquired, err := s.tryLock(ctx, key)
+	if acquired || err != nil {
+		s.auditOp(ctx, "lock", key, 0, err)
+	}
+	return acquired, err
+}
+
+func (s *S3Storage) tryLock(ctx context.Context, key string) (bool, error) {
+	if err := s.checkWritable("lock", key); err != nil {
+		return false, err
+	}
+	correlationID, began := s.traces.begin(key, CorrelationIDFromContext(ctx))
+	logger := s.logger.With(zap.String("correlation_id", correlationID))
+	var acquired bool
+	var err error
+	if s.httpLock != nil {
+		acquired, err = s.httpLock.tryLock(ctx, key)
+	} else {
+		acquired, err = s.tryLockObject(ctx, logger, key, correlationID)
+	}
+	if err != nil {
+		s.recordError("lock", key, err)
+	}
+	if !acquired {
+		if began {
+			s.traces.end(key)
+		}
+		logger.Debug("lock not acquired", zap.String("key", key), zap.Error(err))
+		return false, err
+	}
+	if !began {
+		s.traces.begin(key, correlationID)
+	}
+	s.lockStats.acquire(key, 0)
+	s.held.add(key)
+	logger.Info("lock acquired", zap.String("key", key))
+	return true, nil
+}
+
+// tryLockObject writes the lock object unless an unexpired lock exists.
+func (s *S3Storage) tryLockObject(ctx context.Context, logger *zap.Logger, key, correlationID string) (bool, error) {
+	lockS3Key := s.s3LockKey(key)
+	if s.legacyLocks() {
+		held, _, err := s.lockHeld(ctx, logger, key, s.s3LegacyLockKey(key)) // Held by an older instance
+		if err != nil || held {
+			return false, lockCheckError(key, err)
+		}
+	}
+	content := s.lockContent(time.Now(), correlationID)
+	if !s.Capabilities().SupportsConditionalPut {
+		held, expired, err := s.lockHeld(ctx, logger, key, lockS3Key)
+		if err != nil || held {
+			return false, lockCheckError(key, err)
+		}
+		if err := s.putLock(ctx, lockS3Key, content); err != nil {
+			return false, fmt.Errorf("writing lock for %s: %w", key, s3Error(err))
+		}
+		s.countTakeover(logger, key, correlationID, expired)
+	} else {
+		err := s.putLock(ctx, lockS3Key, content, func(in *awss3.PutObjectInput) { in.IfNoneMatch = aws.String("*") })
+		if isPreconditionFailed(err) {
+			return s.takeOverExpiredLock(ctx, logger, key, correlationID, content)
+		}
+		if err != nil {
+			return false, fmt.Errorf("writing lock for %s: %w", key, s3Error(err))
+		}
+	}
+	if s.legacyLocks() {
+		if err := s.putLock(ctx, s.s3LegacyLockKey(key), content); err != nil {
+			logger.Warn("writing legacy lock failed", zap.String("key", key), zap.Error(err))
+		}
+	}
+	return true, nil
+}
+
+// takeOverExpiredLock overwrites the existing lock object of a key if it has expired, on the
+// condition that nobody overwrote it first.
+func (s *S3Storage) takeOverExpiredLock(ctx context.Context, logger *zap.Logger, key, correlationID string, content []byte) (bool, error) {
+	lockS3Key := s.s3LockKey(key)
+	headCtx, cancel := s.readContext(ctx)
+	head, err := s.Client.HeadObject(headCtx, &awss3.HeadObjectInput{
+		Bucket: aws.String(s.lockBucket()),
+		Key:    aws.String(lockS3Key),
+	})
+	cancel()
+	if err != nil {
+		if s.isNotFound(err) {
+			return false, nil // Released just now; the caller may try again
+		}
+		return false, lockCheckError(key, err)
+	}
+	if head.LastModified == nil || time.Since(*head.LastModified) < s.lockExpiration {
+		return false, nil
+	}
+	err = s.putLock(ctx, lockS3Key, content, func(in *awss3.PutObjectInput) { in.IfMatch = head.ETag })
+	if isPreconditionFailed(err) {
+		return false, nil // Taken over by another process first
+	}
+	if err != nil {
+		return false, fmt.Errorf("writing lock for %s: %w", key, s3Error(err))
+	}
+	s.countTakeover(logger, key, correlationID, true)
+	return true, nil
+}
+
+// countTakeover records that an expired lock was taken over.
+func (s *S3Storage) countTakeover(logger *zap.Logger, key, correlationID string, expired bool) {
+	if !expired {
+		return
+	}
+	s.lockStats.takeovers.Add(1)
+	logger.Warn("took over expired lock", zap.String("key", key))
+	s.emitEvent(eventLockTakeover, key, map[string]any{"correlation_id": correlationID})
+}
+
+// lockCheckError wraps an error checking the lock of a key; nil stays nil.
+func lockCheckError(key string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("checking lock for %s: %w", key, s3Error(err))
+}
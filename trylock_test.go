@@ -0,0 +1,29 @@
+package s3_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+func TestTryLockTakesOverExpiredLock(t *testing.T) {
+	s, _ := s3test.NewFakeStorage(t)
+	ctx := context.Background()
+	key := "issue_cert_example.com"
+
+	if err := s.Lock(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if acquired, err := s.TryLock(ctx, key); err != nil || acquired {
+		t.Fatalf("TryLock of a held lock = %v, %v", acquired, err)
+	}
+	s.SetLockTimings(0, 30*time.Second) // The lock held above has expired
+	if acquired, err := s.TryLock(ctx, key); err != nil || !acquired {
+		t.Fatalf("TryLock of an expired lock = %v, %v", acquired, err)
+	}
+	if stats := s.LockStats(); stats.Takeovers != 1 || stats.Acquired != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
@@ -0,0 +1,82 @@
+package s3
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Subtrees of CertMagic's storage layout reported by Usage; everything else counts as "other".
+const (
+	usageACME         = "acme"         // ACME accounts
+	usageCertificates = "certificates" // Certificates and their keys and metadata
+	usageOCSP         = "ocsp"         // OCSP staples
+	usageOther        = "other"
+)
+
+// UsageStats describes the objects stored below the prefix, excluding locks and the manifest.
+type UsageStats struct {
+	Objects    int64                   `json:"objects"`
+	Bytes      int64                   `json:"bytes"`
+	Subtrees   map[string]SubtreeUsage `json:"subtrees"` // acme, certificates, ocsp and other
+	ComputedAt time.Time               `json:"computed_at"`
+}
+
+// SubtreeUsage is the part of UsageStats in one subtree.
+type SubtreeUsage struct {
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// usageCache keeps the last result of Usage. Its mutex is held while walking the bucket, so
+// concurrent callers share one walk.
+type usageCache struct {
+	mu    sync.Mutex
+	stats *UsageStats
+}
+
+// Usage walks the prefix and counts the objects and bytes of each subtree. A result computed
+// less than maxAge ago is returned without walking the bucket again; 0 always walks it.
+func (s *S3Storage) Usage(ctx context.Context, maxAge time.Duration) (UsageStats, error) {
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+	if cached := s.usage.stats; cached != nil && maxAge > 0 && time.Since(cached.ComputedAt) < maxAge {
+		return *cached, nil
+	}
+
+	stats := UsageStats{Subtrees: make(map[string]SubtreeUsage)}
+	for _, subtree := range []string{usageACME, usageCertificates, usageOCSP, usageOther} {
+		stats.Subtrees[subtree] = SubtreeUsage{}
+	}
+	err := s.walkObjects(ctx, func(obj types.Object) error {
+		size := aws.ToInt64(obj.Size)
+		subtree := usageSubtree(s.certMagicKey(aws.ToString(obj.Key)))
+		sub := stats.Subtrees[subtree]
+		sub.Objects++
+		sub.Bytes += size
+		stats.Subtrees[subtree] = sub
+		stats.Objects++
+		stats.Bytes += size
+		return nil
+	})
+	if err != nil {
+		return UsageStats{}, err
+	}
+	stats.ComputedAt = time.Now()
+	s.usage.stats = &stats
+	return stats, nil
+}
+
+// usageSubtree returns the subtree a CertMagic key counts towards.
+func usageSubtree(key string) string {
+	first, _, _ := strings.Cut(strings.TrimPrefix(key, "/"), "/")
+	switch first {
+	case usageACME, usageCertificates, usageOCSP:
+		return first
+	}
+	return usageOther
+}
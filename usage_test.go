@@ -0,0 +1,60 @@
+package s3_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	s3 "github.com/cvhome-saas/certmagic-s3"
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+func TestStorageUsage(t *testing.T) {
+	s, _ := s3test.NewFakeStorage(t)
+	ctx := context.Background()
+	values := map[string]string{
+		"acme/ca/users/me/me.key":                     "account",
+		"certificates/ca/example.com/example.com.crt": "certificate",
+		"certificates/ca/example.com/example.com.key": "key",
+		"ocsp/example.com-0123":                       "staple",
+		"last_clean.json":                             "{}",
+	}
+	for key, value := range values {
+		if err := s.Store(ctx, key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Lock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Unlock(ctx, "issue_cert_example.com")
+
+	usage, err := s.Usage(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Objects != 5 || usage.Bytes != 29 {
+		t.Errorf("total = %d objects, %d bytes; want 5, 29", usage.Objects, usage.Bytes)
+	}
+	want := map[string]s3.SubtreeUsage{
+		"acme":         {Objects: 1, Bytes: 7},
+		"certificates": {Objects: 2, Bytes: 14},
+		"ocsp":         {Objects: 1, Bytes: 6},
+		"other":        {Objects: 1, Bytes: 2},
+	}
+	for subtree, w := range want {
+		if got := usage.Subtrees[subtree]; got != w {
+			t.Errorf("%s = %+v, want %+v", subtree, got, w)
+		}
+	}
+
+	if err := s.Store(ctx, "acme/ca/users/you/you.key", []byte("account")); err != nil {
+		t.Fatal(err)
+	}
+	if cached, err := s.Usage(ctx, time.Hour); err != nil || cached.Objects != 5 {
+		t.Errorf("cached usage = %d objects, %v; want the previous result", cached.Objects, err)
+	}
+	if fresh, err := s.Usage(ctx, 0); err != nil || fresh.Objects != 6 {
+		t.Errorf("fresh usage = %d objects, %v; want 6", fresh.Objects, err)
+	}
+}
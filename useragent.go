@@ -0,0 +1,89 @@
+package s3
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/caddyserver/caddy/v2"
+)
+
+// app_name and user_agent_suffix extend the User-Agent of every SDK request, which CloudTrail
+// and S3 server access logs record, so storage traffic can be attributed to a Caddy cluster.
+// request_headers are sent along with every SDK request, e.g. for proxies or gateways in front
+// of an S3-compatible backend. Values support global placeholders such as {env.CLUSTER}.
+
+const maxAppNameLength = 50 // The SDK's limit for the app/ entry of the User-Agent
+
+// reservedRequestHeaders are set by the SDK or the signer and cannot be overridden.
+var reservedRequestHeaders = map[string]bool{
+	"Authorization":        true,
+	"Host":                 true,
+	"Content-Length":       true,
+	"Content-Type":         true,
+	"Content-Md5":          true,
+	"User-Agent":           true,
+	"X-Amz-Date":           true,
+	"X-Amz-Security-Token": true,
+	"X-Amz-Content-Sha256": true,
+	"X-Amz-User-Agent":     true,
+}
+
+// provisionAttribution resolves the placeholders of the attribution options and validates them.
+func (s *S3Storage) provisionAttribution() error {
+	repl := caddy.NewReplacer()
+	s.AppName = repl.ReplaceKnown(s.AppName, "")
+	s.UserAgentSuffix = repl.ReplaceKnown(s.UserAgentSuffix, "")
+	s.RequestHeaders = resolveMap(repl, s.RequestHeaders)
+	if len(s.AppName) > maxAppNameLength {
+		return fmt.Errorf("app_name: at most %d characters are allowed, got %d", maxAppNameLength, len(s.AppName))
+	}
+	for name, value := range s.RequestHeaders {
+		if !validHeaderName(name) {
+			return fmt.Errorf("request_headers: invalid header name '%s'", name)
+		}
+		if reservedRequestHeaders[http.CanonicalHeaderKey(name)] {
+			return fmt.Errorf("request_headers: header '%s' is set by the SDK", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("request_headers: value of '%s' contains a line break", name)
+		}
+	}
+	return nil
+}
+
+// validHeaderName reports whether name is an HTTP token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("!#$%&'*+-.^_`|~", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// attributionAPIOptions returns the stack options adding the User-Agent suffix and the request
+// headers. They go into the aws.Config, so that every client built from it carries them. Each word
+// of the suffix is an entry, name/version or just a name; the SDK replaces other disallowed characters.
+func (s *S3Storage) attributionAPIOptions() []func(*middleware.Stack) error {
+	var opts []func(*middleware.Stack) error
+	for _, entry := range strings.Fields(s.UserAgentSuffix) {
+		if key, value, ok := strings.Cut(entry, "/"); ok {
+			opts = append(opts, awsmiddleware.AddUserAgentKeyValue(key, value))
+		} else {
+			opts = append(opts, awsmiddleware.AddUserAgentKey(entry))
+		}
+	}
+	for name, value := range s.RequestHeaders {
+		opts = append(opts, smithyhttp.AddHeaderValue(name, value))
+	}
+	return opts
+}
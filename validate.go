@@ -0,0 +1,103 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.uber.org/zap"
+)
+
+const validationProbeKey = ".validate-probe"
+
+// permissionError reports a failed validation step along with the IAM permission it needs.
+type permissionError struct {
+	Permission string
+	Resource   string
+	Err        error
+}
+
+func (e *permissionError) Error() string {
+	var ade *AccessDeniedError
+	if errors.As(e.Err, &ade) {
+		return fmt.Sprintf("missing permission %s on %s: %v", e.Permission, e.Resource, e.Err)
+	}
+	return fmt.Sprintf("%s on %s failed: %v", e.Permission, e.Resource, e.Err)
+}
+
+func (e *permissionError) Unwrap() error {
+	return e.Err
+}
+
+// validateAccess checks that the bucket exists and that objects below the prefix can be
+// written, read and deleted, reporting every missing permission at once.
+func (s *S3Storage) validateAccess(ctx context.Context) error {
+	bucketARN := s.bucketARN()
+	probeKey := s.s3ObjectKey(validationProbeKey)
+	objectARN := s.objectARN(probeKey)
+	var errs []error
+	step := func(permission, resource string, op func(ctx context.Context) error) bool {
+		ctx, cancel := s.opContext(ctx)
+		defer cancel()
+		if err := op(ctx); err != nil {
+			errs = append(errs, &permissionError{Permission: permission, Resource: resource, Err: s3Error(err)})
+			return false
+		}
+		return true
+	}
+
+	step("s3:ListBucket", bucketARN, func(ctx context.Context) error {
+		_, err := s.Client.HeadBucket(ctx, &awss3.HeadBucketInput{Bucket: aws.String(s.Bucket)})
+		var nf *types.NotFound
+		var respErr *awshttp.ResponseError
+		switch {
+		case errors.As(err, &nf):
+			return fmt.Errorf("bucket %s does not exist; check the bucket name and endpoint", s.Bucket)
+		case errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusMovedPermanently:
+			return fmt.Errorf("bucket %s is in another region than %q; set region accordingly", s.Bucket, s.Region)
+		}
+		return err
+	})
+
+	probe := []byte("certmagic-s3 validation probe")
+	if !s.ReadOnly && step("s3:PutObject", objectARN, func(ctx context.Context) error {
+		_, err := s.Client.PutObject(ctx, &awss3.PutObjectInput{
+			Bucket:        aws.String(s.Bucket),
+			Key:           aws.String(probeKey),
+			Body:          bytes.NewReader(probe),
+			ContentLength: aws.Int64(int64(len(probe))),
+			StorageClass:  types.StorageClass(s.StorageClass),
+			ContentType:   s.contentType(),
+			Tagging:       s.objectTagging(),
+			Metadata:      s.objectMetadata(nil),
+		})
+		return err
+	}) {
+		step("s3:GetObject", objectARN, func(ctx context.Context) error {
+			data, err := s.getObjectBytes(ctx, probeKey)
+			if err == nil && !bytes.Equal(data, probe) {
+				err = errors.New("read back different content than written")
+			}
+			return err
+		})
+		step("s3:DeleteObject", objectARN, func(ctx context.Context) error {
+			_, err := s.Client.DeleteObject(ctx, &awss3.DeleteObjectInput{
+				Bucket: aws.String(s.Bucket),
+				Key:    aws.String(probeKey),
+			})
+			return err
+		})
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("validating access to s3://%s/%s: %w", s.Bucket, s.Prefix, errors.Join(errs...))
+	}
+	s.logger.Info("validated bucket access", zap.String("bucket", s.Bucket))
+	return nil
+}
@@ -0,0 +1,330 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// With vault_transit, values are encrypted with envelope encryption through the transit secrets
+// engine of HashiCorp Vault: every object gets its own data key from the datakey endpoint, is
+// encrypted locally with AES-256-GCM, and stores the data key wrapped by Vault next to the data.
+// Reading an object has Vault unwrap its data key, so the key never leaves Vault unencrypted and
+// rotating the transit key needs no migration.
+//
+//	vaultTransitMagic (8 bytes) | wrapped key length (2 bytes) | wrapped key | AESGCMIO object
+const (
+	vaultTransitMagic = "\x00CMS3VT1"
+
+	vaultDefaultMount  = "transit"
+	vaultKeyCacheSize  = 1024 // Unwrapped data keys kept to avoid a Vault call per read
+	vaultClientTimeout = 30 * time.Second
+)
+
+// VaultTransitConfig configures the Vault transit encryption backend. Authentication uses Token or,
+// if it is empty, AppRole with RoleID and SecretID; all of them support {env.*} placeholders.
+type VaultTransitConfig struct {
+	Address   string `json:"address,omitempty"`   // e.g. https://vault.example.com:8200
+	Mount     string `json:"mount,omitempty"`     // Mount path of the transit engine, "transit" by default
+	Key       string `json:"key,omitempty"`       // Name of the transit key
+	Namespace string `json:"namespace,omitempty"` // Vault Enterprise namespace
+	Token     string `json:"token,omitempty"`
+	RoleID    string `json:"role_id,omitempty"`
+	SecretID  string `json:"secret_id,omitempty"`
+}
+
+// VaultTransitIO provides IO operations with data keys from Vault's transit engine.
+type VaultTransitIO struct {
+	cfg    VaultTransitConfig
+	client *http.Client
+	logger *zap.Logger
+
+	mu    sync.Mutex
+	token string
+	keys  map[string][32]byte // Unwrapped data keys by wrapped key
+}
+
+// newVaultTransitIO validates the configuration and, for AppRole, logs in.
+func newVaultTransitIO(cfg VaultTransitConfig, logger *zap.Logger) (*VaultTransitIO, error) {
+	if cfg.Address == "" || cfg.Key == "" {
+		return nil, errors.New("vault_transit: address and key must be specified")
+	}
+	if cfg.Token == "" && (cfg.RoleID == "" || cfg.SecretID == "") {
+		return nil, errors.New("vault_transit: token or role_id and secret_id must be specified")
+	}
+	if cfg.Mount == "" {
+		cfg.Mount = vaultDefaultMount
+	}
+	cfg.Address = strings.TrimSuffix(cfg.Address, "/")
+	cfg.Mount = strings.Trim(cfg.Mount, "/")
+	v := &VaultTransitIO{
+		cfg:    cfg,
+		client: &http.Client{Timeout: vaultClientTimeout},
+		logger: logger,
+		token:  cfg.Token,
+		keys:   make(map[string][32]byte),
+	}
+	if v.token == "" {
+		if err := v.login(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// ByteReader encrypts plaintext with a new data key.
+func (v *VaultTransitIO) ByteReader(plaintext []byte) (io.Reader, int64, error) {
+	header, aead, err := v.newDataKey()
+	if err != nil {
+		return nil, 0, err
+	}
+	r, n, err := aead.ByteReader(plaintext)
+	if err != nil {
+		return nil, 0, err
+	}
+	return io.MultiReader(bytes.NewReader(header), r), int64(len(header)) + n, nil
+}
+
+// StreamReader encrypts the plaintext stream with a new data key, in the chunked format.
+func (v *VaultTransitIO) StreamReader(plaintext io.Reader, size int64) (io.Reader, int64, error) {
+	header, aead, err := v.newDataKey()
+	if err != nil {
+		return nil, 0, err
+	}
+	r, n, err := aead.StreamReader(plaintext, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n >= 0 {
+		n += int64(len(header))
+	}
+	return io.MultiReader(bytes.NewReader(header), r), n, nil
+}
+
+// WrapReader unwraps the data key of the object and decrypts it.
+func (v *VaultTransitIO) WrapReader(ciphertextReader io.Reader) io.Reader {
+	var header [len(vaultTransitMagic) + 2]byte
+	n, err := io.ReadFull(ciphertextReader, header[:])
+	if err == io.EOF {
+		return bytes.NewReader(nil) // An empty stream has nothing to decrypt
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return &errorReader{err: fmt.Errorf("failed to read header: %w", err)}
+	}
+	if n < len(header) || string(header[:len(vaultTransitMagic)]) != vaultTransitMagic {
+		return &errorReader{err: errors.New("failed to decrypt data: not encrypted with vault_transit")}
+	}
+	wrapped := make([]byte, binary.BigEndian.Uint16(header[len(vaultTransitMagic):]))
+	if _, err := io.ReadFull(ciphertextReader, wrapped); err != nil {
+		return &errorReader{err: fmt.Errorf("failed to read wrapped data key: %w", err)}
+	}
+	key, err := v.unwrapDataKey(string(wrapped))
+	if err != nil {
+		return &errorReader{err: err}
+	}
+	return (&AESGCMIO{Key: key}).WrapReader(ciphertextReader)
+}
+
+// newDataKey requests a data key and returns the object header and the IO encrypting with it.
+func (v *VaultTransitIO) newDataKey() ([]byte, *AESGCMIO, error) {
+	var out struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	}
+	if err := v.call(context.Background(), "datakey/plaintext/"+url.PathEscape(v.cfg.Key), map[string]any{"bits": 256}, &out); err != nil {
+		return nil, nil, fmt.Errorf("vault_transit: generating data key: %w", err)
+	}
+	key, err := decodeDataKey(out.Plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(out.Ciphertext) == 0 || len(out.Ciphertext) > 0xffff {
+		return nil, nil, fmt.Errorf("vault_transit: invalid wrapped data key of %d bytes", len(out.Ciphertext))
+	}
+	v.cacheDataKey(out.Ciphertext, key)
+	header := make([]byte, len(vaultTransitMagic)+2, len(vaultTransitMagic)+2+len(out.Ciphertext))
+	copy(header, vaultTransitMagic)
+	binary.BigEndian.PutUint16(header[len(vaultTransitMagic):], uint16(len(out.Ciphertext)))
+	return append(header, out.Ciphertext...), &AESGCMIO{Key: key}, nil
+}
+
+// unwrapDataKey has Vault decrypt a wrapped data key, unless it is cached.
+func (v *VaultTransitIO) unwrapDataKey(wrapped string) ([32]byte, error) {
+	v.mu.Lock()
+	key, ok := v.keys[wrapped]
+	v.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	var out struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := v.call(context.Background(), "decrypt/"+url.PathEscape(v.cfg.Key), map[string]any{"ciphertext": wrapped}, &out); err != nil {
+		return key, fmt.Errorf("vault_transit: unwrapping data key: %w", err)
+	}
+	key, err := decodeDataKey(out.Plaintext)
+	if err != nil {
+		return key, err
+	}
+	v.cacheDataKey(wrapped, key)
+	return key, nil
+}
+
+func (v *VaultTransitIO) cacheDataKey(wrapped string, key [32]byte) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.keys) >= vaultKeyCacheSize {
+		clear(v.keys)
+	}
+	v.keys[wrapped] = key
+}
+
+func decodeDataKey(encoded string) ([32]byte, error) {
+	var key [32]byte
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) != len(key) {
+		return key, errors.New("vault_transit: invalid data key in response")
+	}
+	copy(key[:], raw)
+	return key, nil
+}
+
+// call posts to a path below the transit mount and decodes the "data" of the response into out.
+// With AppRole, an expired token is renewed by logging in again once.
+func (v *VaultTransitIO) call(ctx context.Context, path string, in, out any) error {
+	status, err := v.post(ctx, "/v1/"+v.cfg.Mount+"/"+path, in, out, true)
+	if status == http.StatusForbidden && v.cfg.Token == "" {
+		if err := v.login(ctx); err != nil {
+			return err
+		}
+		_, err = v.post(ctx, "/v1/"+v.cfg.Mount+"/"+path, in, out, true)
+	}
+	return err
+}
+
+// login authenticates with AppRole.
+func (v *VaultTransitIO) login(ctx context.Context) error {
+	var out struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	body := map[string]string{"role_id": v.cfg.RoleID, "secret_id": v.cfg.SecretID}
+	if _, err := v.post(ctx, "/v1/auth/approle/login", body, &out, false); err != nil {
+		return fmt.Errorf("vault_transit: approle login: %w", err)
+	}
+	if out.Auth.ClientToken == "" {
+		return errors.New("vault_transit: approle login returned no token")
+	}
+	v.mu.Lock()
+	v.token = out.Auth.ClientToken
+	v.mu.Unlock()
+	v.logger.Debug("logged in to vault", zap.String("address", v.cfg.Address))
+	return nil
+}
+
+// post sends a JSON request to Vault. With data, out receives the "data" field of the response,
+// otherwise the whole response.
+func (v *VaultTransitIO) post(ctx context.Context, path string, in, out any, data bool) (int, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.cfg.Address+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	v.mu.Lock()
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	v.mu.Unlock()
+	if v.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.cfg.Namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if data {
+		var envelope struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+			return resp.StatusCode, fmt.Errorf("decoding response: %w", err)
+		}
+		return resp.StatusCode, json.Unmarshal(envelope.Data, out)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("decoding response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
+
+// unmarshalVaultTransit parses the vault_transit block.
+func (s *S3Storage) unmarshalVaultTransit(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	cfg := new(VaultTransitConfig)
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		key := d.Val()
+		var value string
+		if !d.AllArgs(&value) {
+			return d.ArgErr()
+		}
+		switch key {
+		case "address":
+			cfg.Address = value
+		case "mount":
+			cfg.Mount = value
+		case "key":
+			cfg.Key = value
+		case "namespace":
+			cfg.Namespace = value
+		case "token":
+			cfg.Token = value
+		case "role_id":
+			cfg.RoleID = value
+		case "secret_id":
+			cfg.SecretID = value
+		default:
+			return d.Errf("unrecognized vault_transit subdirective '%s'", key)
+		}
+	}
+	s.VaultTransit = cfg
+	return nil
+}
+
+// provisionVaultTransit returns the Vault transit IO, which replaces encryption_key.
+func (s *S3Storage) provisionVaultTransit() (IO, error) {
+	if s.EncryptionKey != "" || s.EncryptionKeySource != "" || s.EncryptionPassphrase != "" {
+		return nil, errors.New("vault_transit and encryption_key, encryption_key_source or encryption_passphrase are mutually exclusive")
+	}
+	v, err := newVaultTransitIO(*s.VaultTransit, s.logger)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Info("encrypting with vault transit data keys",
+		zap.String("address", v.cfg.Address), zap.String("mount", v.cfg.Mount), zap.String("key", v.cfg.Key))
+	return v, nil
+}
@@ -0,0 +1,159 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// fakeVault implements the AppRole login and the transit datakey and decrypt endpoints.
+type fakeVault struct {
+	mu       sync.Mutex
+	token    string
+	wrapped  map[string][]byte
+	decrypts int
+	logins   int
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, string) {
+	t.Helper()
+	fv := &fakeVault{token: "initial", wrapped: make(map[string][]byte)}
+	srv := httptest.NewServer(fv)
+	t.Cleanup(srv.Close)
+	return fv, srv.URL
+}
+
+func (fv *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	var in map[string]any
+	json.NewDecoder(r.Body).Decode(&in)
+	if r.URL.Path == "/v1/auth/approle/login" {
+		if in["role_id"] != "role" || in["secret_id"] != "secret" {
+			http.Error(w, "invalid credentials", http.StatusBadRequest)
+			return
+		}
+		fv.logins++
+		fv.token = fmt.Sprintf("approle-%d", fv.logins)
+		json.NewEncoder(w).Encode(map[string]any{"auth": map[string]any{"client_token": fv.token}})
+		return
+	}
+	if r.Header.Get("X-Vault-Token") != fv.token {
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+	switch r.URL.Path {
+	case "/v1/transit/datakey/plaintext/certs":
+		key := make([]byte, 32)
+		rand.Read(key)
+		wrapped := fmt.Sprintf("vault:v1:%d", len(fv.wrapped))
+		fv.wrapped[wrapped] = key
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+			"plaintext":  base64.StdEncoding.EncodeToString(key),
+			"ciphertext": wrapped,
+		}})
+	case "/v1/transit/decrypt/certs":
+		key, ok := fv.wrapped[fmt.Sprint(in["ciphertext"])]
+		if !ok {
+			http.Error(w, "invalid ciphertext", http.StatusBadRequest)
+			return
+		}
+		fv.decrypts++
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"plaintext": base64.StdEncoding.EncodeToString(key)}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestVaultTransitIO(t *testing.T) {
+	fv, addr := newFakeVault(t)
+	v, err := newVaultTransitIO(VaultTransitConfig{Address: addr, Key: "certs", Token: "initial"}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := []byte(strings.Repeat("certificate ", 100))
+	r, n, err := v.ByteReader(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, _ := io.ReadAll(r)
+	if int64(len(sealed)) != n || !bytes.HasPrefix(sealed, []byte(vaultTransitMagic)) {
+		t.Fatalf("sealed %d bytes with length %d", len(sealed), n)
+	}
+	sr, sn, err := v.StreamReader(bytes.NewReader(value), int64(len(value)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed, _ := io.ReadAll(sr)
+	if int64(len(streamed)) != sn {
+		t.Fatalf("streamed %d bytes with length %d", len(streamed), sn)
+	}
+
+	// Another instance has to unwrap the data keys through Vault, once per key
+	other, err := newVaultTransitIO(VaultTransitConfig{Address: addr + "/", Mount: "/transit/", Key: "certs", Token: "initial"}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		for _, ciphertext := range [][]byte{sealed, streamed} {
+			got, err := decryptWith(other, ciphertext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, value) {
+				t.Error("round trip mismatch")
+			}
+		}
+	}
+	if fv.decrypts != 2 {
+		t.Errorf("decrypts = %d, want 2 (one per data key)", fv.decrypts)
+	}
+
+	if _, err := decryptWith(other, []byte("not from vault")); err == nil {
+		t.Error("expected an error for an object not encrypted with vault_transit")
+	}
+}
+
+func TestVaultTransitAppRole(t *testing.T) {
+	fv, addr := newFakeVault(t)
+	cfg := VaultTransitConfig{Address: addr, Key: "certs", RoleID: "role", SecretID: "secret"}
+	v, err := newVaultTransitIO(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := v.ByteReader([]byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	fv.mu.Lock()
+	fv.token = "revoked" // The token expired
+	fv.mu.Unlock()
+	if _, _, err := v.ByteReader([]byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if fv.logins != 2 {
+		t.Errorf("logins = %d, want 2", fv.logins)
+	}
+
+	for _, bad := range []VaultTransitConfig{
+		{Key: "certs", Token: "t"},
+		{Address: addr, Token: "t"},
+		{Address: addr, Key: "certs", RoleID: "role"},
+		{Address: addr, Key: "certs", RoleID: "role", SecretID: "wrong"},
+	} {
+		if _, err := newVaultTransitIO(bad, zap.NewNop()); err == nil {
+			t.Errorf("%+v: expected an error", bad)
+		}
+	}
+}
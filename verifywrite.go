@@ -0,0 +1,82 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// Some S3-compatible backends acknowledge writes which they then don't serve, or serve a
+// previous or truncated version for a while. With verify_writes, every Store is followed by a
+// HeadObject comparing the size, the SHA-256 recorded in the metadata (see checksum.go) and the
+// ETag with what was written, and the object is written again on a mismatch.
+
+const (
+	verifyWriteAttempts = 3                      // Writes of an object before Store fails
+	verifyWriteDelay    = 200 * time.Millisecond // Pause before the second write, growing linearly
+)
+
+// verifyWrite verifies the object written by input with body, writing it again on a mismatch.
+// It returns the output of the last write.
+func (s *S3Storage) verifyWrite(ctx context.Context, key string, input *awss3.PutObjectInput, body []byte, out *awss3.PutObjectOutput) (*awss3.PutObjectOutput, error) {
+	sum := bodyChecksumMetadata(body)[bodySHA256Meta]
+	for attempt := 1; ; attempt++ {
+		mismatch, err := s.checkWritten(ctx, aws.ToString(input.Key), int64(len(body)), sum, out.ETag)
+		if err != nil {
+			return out, fmt.Errorf("verifying write: %w", err)
+		}
+		if mismatch == "" {
+			return out, nil
+		}
+		if attempt == verifyWriteAttempts {
+			return out, fmt.Errorf("%w: written object has %s after %d attempts", ErrIntegrity, mismatch, attempt)
+		}
+		s.logger.Warn("written object failed verification, writing it again", zap.String("key", key),
+			zap.String("mismatch", mismatch), zap.Int("attempt", attempt))
+		select {
+		case <-ctx.Done():
+			return out, ctx.Err()
+		case <-time.After(time.Duration(attempt) * verifyWriteDelay):
+		}
+
+		retry := *input
+		retry.Body = bytes.NewReader(body)
+		putCtx, cancel := s.opContext(ctx)
+		out, err = s.Client.PutObject(putCtx, &retry)
+		cancel()
+		if err != nil {
+			return out, err
+		}
+	}
+}
+
+// checkWritten describes how the stored object differs from the expected one, or returns "" if
+// it matches.
+func (s *S3Storage) checkWritten(ctx context.Context, s3Key string, size int64, sum string, etag *string) (string, error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+	head, err := s.Client.HeadObject(ctx, &awss3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s3Key),
+	})
+	if s.isNotFound(err) {
+		return "not been found", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case aws.ToInt64(head.ContentLength) != size:
+		return fmt.Sprintf("%d bytes instead of %d", aws.ToInt64(head.ContentLength), size), nil
+	case head.Metadata[bodySHA256Meta] != sum:
+		return fmt.Sprintf("SHA-256 %q instead of %s", head.Metadata[bodySHA256Meta], sum), nil
+	case etag != nil && head.ETag != nil && *head.ETag != *etag:
+		return fmt.Sprintf("ETag %s instead of %s", *head.ETag, *etag), nil
+	}
+	return "", nil
+}
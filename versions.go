@@ -0,0 +1,122 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// In versioned buckets, every Store keeps the previous value as a noncurrent version and Delete
+// only adds a delete marker. ListVersions and Restore make those versions recoverable, e.g. after
+// an account key was overwritten or a certificate deleted by mistake.
+
+// ObjectVersion describes one version of a stored value.
+type ObjectVersion struct {
+	VersionID    string    `json:"version_id"`
+	Modified     time.Time `json:"modified"`
+	Size         int64     `json:"size"`
+	IsLatest     bool      `json:"is_latest"`
+	DeleteMarker bool      `json:"delete_marker,omitempty"`
+}
+
+// ListVersions returns the versions of the value at the given CertMagic key, newest first,
+// including delete markers. It is empty if the bucket is not versioned and the key does not exist.
+func (s *S3Storage) ListVersions(ctx context.Context, key string) ([]ObjectVersion, error) {
+	s3Key := s.s3ObjectKey(key)
+	input := &awss3.ListObjectVersionsInput{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(s3Key),
+	}
+	versions := []ObjectVersion{}
+	for {
+		pageCtx, cancel := s.listContext(ctx)
+		page, err := s.Client.ListObjectVersions(pageCtx, input)
+		cancel()
+		if err != nil {
+			s.recordError("list_versions", key, err)
+			return nil, fmt.Errorf("listing versions of %s (s3://%s/%s): %w", key, s.Bucket, s3Key, s3Error(err))
+		}
+		for _, v := range page.Versions {
+			if aws.ToString(v.Key) == s3Key { // The prefix also matches longer keys
+				versions = append(versions, ObjectVersion{
+					VersionID: aws.ToString(v.VersionId),
+					Modified:  aws.ToTime(v.LastModified),
+					Size:      aws.ToInt64(v.Size),
+					IsLatest:  aws.ToBool(v.IsLatest),
+				})
+			}
+		}
+		for _, m := range page.DeleteMarkers {
+			if aws.ToString(m.Key) == s3Key {
+				versions = append(versions, ObjectVersion{
+					VersionID:    aws.ToString(m.VersionId),
+					Modified:     aws.ToTime(m.LastModified),
+					IsLatest:     aws.ToBool(m.IsLatest),
+					DeleteMarker: true,
+				})
+			}
+		}
+		if !aws.ToBool(page.IsTruncated) {
+			break
+		}
+		input.KeyMarker, input.VersionIdMarker = page.NextKeyMarker, page.NextVersionIdMarker
+	}
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].Modified.After(versions[j].Modified) })
+	return versions, nil
+}
+
+// Restore makes the given version of the value at the given CertMagic key current again by
+// storing its content as a new version, which also undoes a delete.
+func (s *S3Storage) Restore(ctx context.Context, key, versionID string) error {
+	if versionID == "" {
+		return errors.New("version ID is required")
+	}
+	value, err := s.loadVersion(ctx, key, versionID)
+	if err != nil {
+		return err
+	}
+	if err := s.Store(ctx, key, value); err != nil {
+		return err
+	}
+	s.opLogger(ctx, key).Info("restored version", zap.String("key", key), zap.String("version_id", versionID))
+	return nil
+}
+
+// loadVersion retrieves the (decrypted) value of one version.
+func (s *S3Storage) loadVersion(ctx context.Context, key, versionID string) ([]byte, error) {
+	s3Key := s.s3ObjectKey(key)
+	getCtx, cancel := s.readContext(ctx)
+	defer cancel()
+	result, err := s.Client.GetObject(getCtx, &awss3.GetObjectInput{
+		Bucket:    aws.String(s.Bucket),
+		Key:       aws.String(s3Key),
+		VersionId: aws.String(versionID),
+	})
+	if err != nil {
+		if s.isNotFound(err) {
+			return nil, fs.ErrNotExist
+		}
+		s.recordError("load_version", key, err)
+		return nil, fmt.Errorf("loading version %s of %s (s3://%s/%s): %w", versionID, key, s.Bucket, s3Key, s3Error(err))
+	}
+	defer result.Body.Close()
+	if isEmptySentinel(result.Metadata) {
+		return []byte{}, nil
+	}
+	data, err := io.ReadAll(s.iowrap.WrapReader(result.Body))
+	if err != nil {
+		return nil, fmt.Errorf("reading/decrypting version %s of %s: %w", versionID, key, err)
+	}
+	if isOCSPStapleKey(key) && isDeltaEnvelope(data) {
+		return s.decodeOCSPDelta(ctx, key, data)
+	}
+	return data, nil
+}
@@ -0,0 +1,167 @@
+package s3
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+// With warm_start, the certificates of configured domains and/or the most recently modified ones
+// are loaded in the background right after Provision, so that the first handshakes after a start
+// with a cold CertMagic cache are served from the read cache or local mirror instead of S3.
+
+// WarmStartConfig selects the certificates to load at startup.
+type WarmStartConfig struct {
+	// Domains whose certificates are loaded, from every issuer; wildcards as *.example.com
+	Domains []string `json:"domains,omitempty"`
+
+	// MostRecent additionally loads this many of the most recently modified other certificates
+	MostRecent int `json:"most_recent,omitempty"`
+}
+
+// validateWarmStart checks that warm_start selects certificates and has a cache to fill.
+func (s *S3Storage) validateWarmStart() error {
+	if len(s.WarmStart.Domains) == 0 && s.WarmStart.MostRecent <= 0 {
+		return errors.New("warm_start: domains or most_recent is required")
+	}
+	if s.ReadCacheSize <= 0 && s.LocalCacheDir == "" {
+		return errors.New("warm_start: requires read_cache_size or local_cache_dir, which are filled")
+	}
+	return nil
+}
+
+// startWarmStart loads the selected certificates in the background, until done or ctx is done.
+func (s *S3Storage) startWarmStart(ctx context.Context) {
+	go func() {
+		start := time.Now()
+		certKeys, err := s.warmStartKeys(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Warn("listing certificates for warm start failed", zap.Error(err))
+			}
+			return
+		}
+		for _, certKey := range certKeys {
+			base := strings.TrimSuffix(certKey, ".crt")
+			for _, key := range []string{certKey, base + ".key", base + ".json"} {
+				if _, err := s.Load(ctx, key); err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					s.logger.Debug("warm start load failed", zap.String("key", key), zap.Error(err))
+				}
+			}
+		}
+		s.logger.Info("warm start finished", zap.Int("certificates", len(certKeys)), zap.Duration("duration", time.Since(start)))
+	}()
+}
+
+// warmStartKeys returns the certificate keys to load: those of the configured domains, followed
+// by the most recently modified others. Only the most_recent newest others are kept while listing.
+func (s *S3Storage) warmStartKeys(ctx context.Context) ([]string, error) {
+	domains := make(map[string]bool, len(s.WarmStart.Domains))
+	for _, domain := range s.WarmStart.Domains {
+		domains[certmagic.StorageKeys.Safe(domain)] = true
+	}
+	var selected []string
+	newest := &recentObjects{n: max(s.WarmStart.MostRecent, 0)}
+	err := s.walkPrefix(ctx, "certificates", func(obj types.Object) error {
+		key := s.certMagicKey(aws.ToString(obj.Key))
+		switch {
+		case !isCertificateKey(key):
+		case domains[certDomain(key)]:
+			selected = append(selected, key)
+		default:
+			newest.offer(key, aws.ToTime(obj.LastModified))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(selected, newest.keys()...), nil
+}
+
+// recentObjects keeps the n most recently modified keys offered, in a min-heap by modification.
+type recentObjects struct {
+	n    int
+	objs []recentObject
+}
+
+type recentObject struct {
+	key      string
+	modified time.Time
+}
+
+func (r *recentObjects) Len() int           { return len(r.objs) }
+func (r *recentObjects) Less(i, j int) bool { return r.objs[i].modified.Before(r.objs[j].modified) }
+func (r *recentObjects) Swap(i, j int)      { r.objs[i], r.objs[j] = r.objs[j], r.objs[i] }
+func (r *recentObjects) Push(x any)         { r.objs = append(r.objs, x.(recentObject)) }
+func (r *recentObjects) Pop() any {
+	last := r.objs[len(r.objs)-1]
+	r.objs = r.objs[:len(r.objs)-1]
+	return last
+}
+
+// offer adds a key if it is among the n most recently modified so far.
+func (r *recentObjects) offer(key string, modified time.Time) {
+	switch {
+	case r.n == 0:
+	case len(r.objs) < r.n:
+		heap.Push(r, recentObject{key, modified})
+	case modified.After(r.objs[0].modified):
+		r.objs[0] = recentObject{key, modified}
+		heap.Fix(r, 0)
+	}
+}
+
+// keys returns the kept keys, most recently modified first.
+func (r *recentObjects) keys() []string {
+	objs := slices.Clone(r.objs)
+	slices.SortStableFunc(objs, func(a, b recentObject) int { return b.modified.Compare(a.modified) })
+	keys := make([]string, len(objs))
+	for i, obj := range objs {
+		keys[i] = obj.key
+	}
+	return keys
+}
+
+func (s *S3Storage) unmarshalWarmStart(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	cfg := new(WarmStartConfig)
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch key := d.Val(); key {
+		case "domains":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			cfg.Domains = append(cfg.Domains, args...)
+		case "most_recent":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil || n < 0 {
+				return d.Errf("invalid warm_start most_recent '%s': must be a non-negative integer", d.Val())
+			}
+			cfg.MostRecent = n
+		default:
+			return d.Errf("unrecognized warm_start subdirective '%s'", key)
+		}
+	}
+	s.WarmStart = cfg
+	return nil
+}
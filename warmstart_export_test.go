@@ -0,0 +1,8 @@
+package s3
+
+import "context"
+
+// WarmStartKeys exposes the certificates selected by warm_start to the tests of package s3_test.
+func (s *S3Storage) WarmStartKeys(ctx context.Context) ([]string, error) {
+	return s.warmStartKeys(ctx)
+}
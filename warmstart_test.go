@@ -0,0 +1,42 @@
+package s3_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	s3 "github.com/cvhome-saas/certmagic-s3"
+	"github.com/cvhome-saas/certmagic-s3/s3test"
+)
+
+func TestStorageWarmStartKeys(t *testing.T) {
+	storage := s3test.NewServer(t).Storage(t)
+	ctx := context.Background()
+	for _, name := range []string{"wildcard_.example.net", "a-old.com", "m-mid.com", "z-new.com"} {
+		key := "certificates/acme/" + name + "/" + name
+		for _, ext := range []string{".crt", ".key", ".json"} {
+			if err := storage.Store(ctx, key+ext, []byte(name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := storage.Store(ctx, "acme/ca/users/me/me.json", []byte("account")); err != nil {
+		t.Fatal(err)
+	}
+
+	storage.WarmStart = &s3.WarmStartConfig{Domains: []string{"*.Example.net"}, MostRecent: 2}
+	keys, err := storage.WarmStartKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"certificates/acme/wildcard_.example.net/wildcard_.example.net.crt",
+		"certificates/acme/z-new.com/z-new.com.crt",
+		"certificates/acme/m-mid.com/m-mid.com.crt",
+	}
+	if !slices.Equal(keys, want) {
+		t.Errorf("keys = %v, want %v", keys, want)
+	}
+}